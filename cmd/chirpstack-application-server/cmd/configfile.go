@@ -223,10 +223,21 @@ id="{{ .ApplicationServer.ID }}"
     assume_email_verified={{ .ApplicationServer.UserAuthentication.OpenIDConnect.AssumeEmailVerified }}
 
   # JavaScript codec settings.
+  #
+  # The codec scripts are executed by an ES2020 capable JavaScript engine.
+  # Scripts must implement either the Decode(fPort, bytes, variables) and
+  # Encode(fPort, obj, variables) functions or the TTN-style
+  # decodeUplink(input) and encodeDownlink(input) functions.
   [application_server.codec.js]
   # Maximum execution time.
   max_execution_time="{{ .ApplicationServer.Codec.JS.MaxExecutionTime }}"
 
+  # Maximum call stack size.
+  #
+  # This limits the recursion depth of the scripts. Every execution uses a
+  # new JavaScript runtime.
+  max_call_stack_size={{ .ApplicationServer.Codec.JS.MaxCallStackSize }}
+
 
   # Integration configures the data integration.
   #
@@ -255,6 +266,11 @@ id="{{ .ApplicationServer.ID }}"
   # * azure_service_bus - Azure Service-Bus
   # * gcp_pub_sub       - Google Cloud Pub/Sub
   # * kafka             - Kafka distributed streaming platform
+  # * nats              - NATS / JetStream
+  # * redis_streams     - Redis Streams
+  # * sparkplug_b       - MQTT Sparkplug B
+  # * home_assistant    - Home Assistant MQTT discovery
+  # * s3_archive        - Object-storage (S3 compatible) archive
   # * postgresql        - PostgreSQL database
   enabled=[{{ if .ApplicationServer.Integration.Enabled|len }}"{{ end }}{{ range $index, $elm := .ApplicationServer.Integration.Enabled }}{{ if $index }}", "{{ end }}{{ $elm }}{{ end }}{{ if .ApplicationServer.Integration.Enabled|len }}"{{ end }}]
 
@@ -274,6 +290,21 @@ id="{{ .ApplicationServer.ID }}"
   # last published message.
   retain_events={{ .ApplicationServer.Integration.MQTT.RetainEvents }}
 
+  # Payload template (optional).
+  #
+  # When set, the events are rendered using this Go template instead of the
+  # configured marshaler. The template is executed with .Type (the event type,
+  # e.g. "up"), .Event (the fields of the event, e.g. the UplinkEvent) and
+  # .Object (the decoded object, if any). Besides the Go template builtins,
+  # the following functions are available: base64, hex, json, timestamp,
+  # unix, now, field (e.g. field .Object "sensor.temperature") and default.
+  # The output size, the number of range iterations and the execution time
+  # of the template are limited.
+  #
+  # Example:
+  # payload_template='''{"devEUI":"{{ "{{" }} hex .Event.DevEui {{ "}}" }}","fPort":{{ "{{" }} .Event.FPort {{ "}}" }}}'''
+  payload_template='''{{ .ApplicationServer.Integration.MQTT.PayloadTemplate }}'''
+
   # MQTT server (e.g. scheme://host:port where scheme is tcp, ssl or ws)
   server="{{ .ApplicationServer.Integration.MQTT.Server }}"
 
@@ -355,6 +386,263 @@ id="{{ .ApplicationServer.ID }}"
   # events. Messages will be published to the "amq.topic" exchange.
   event_routing_key_template="{{ .ApplicationServer.Integration.AMQP.EventRoutingKeyTemplate }}"
 
+  # Command queue name (optional).
+  #
+  # When set, this queue is declared and bound to the "amq.topic" exchange
+  # using the command binding-key template. Downlink commands published to
+  # this queue are enqueued for the device.
+  command_queue_name="{{ .ApplicationServer.Integration.AMQP.CommandQueueName }}"
+
+  # Command binding-key template.
+  #
+  # The routing-key of a command must match this template, as the application
+  # ID and DevEUI are retrieved from it. The payload uses the same (JSON)
+  # format as the downlink payload of the MQTT integration.
+  command_binding_key_template="{{ .ApplicationServer.Integration.AMQP.CommandBindingKeyTemplate }}"
+
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.AMQP.PayloadTemplate }}'''
+
+
+  # NATS / JetStream.
+  [application_server.integration.nats]
+  # Server URL.
+  #
+  # Multiple (comma separated) server URLs can be given, e.g. when connecting
+  # to a NATS cluster.
+  server="{{ .ApplicationServer.Integration.NATS.Server }}"
+
+  # Username and password (optional).
+  username="{{ .ApplicationServer.Integration.NATS.Username }}"
+  password="{{ .ApplicationServer.Integration.NATS.Password }}"
+
+  # Event subject template.
+  #
+  # This is the subject template used when publishing device events.
+  event_subject_template="{{ .ApplicationServer.Integration.NATS.EventSubjectTemplate }}"
+
+  # Publish using JetStream.
+  #
+  # When enabled, events are published using JetStream and the publish is
+  # only considered successful after it has been acknowledged by the stream.
+  # A stream capturing the event subjects must exist.
+  jetstream={{ .ApplicationServer.Integration.NATS.JetStream }}
+
+  # Command subject template (optional).
+  #
+  # When set, the integration subscribes to the downlink commands published
+  # to the subjects matching this template. The application ID and DevEUI
+  # are retrieved from the subject. The payload uses the same (JSON) format
+  # as the downlink payload of the MQTT integration.
+  command_subject_template="{{ .ApplicationServer.Integration.NATS.CommandSubjectTemplate }}"
+
+  # Command queue group.
+  #
+  # Each command is delivered to only one of the application-server
+  # instances subscribed using the same queue group.
+  command_queue_group="{{ .ApplicationServer.Integration.NATS.CommandQueueGroup }}"
+
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.NATS.PayloadTemplate }}'''
+
+
+  # Redis Streams.
+  [application_server.integration.redis_streams]
+  # Redis server (optional).
+  #
+  # When not set, the Redis instance of the application-server is used (see
+  # the [redis] section). In this case, the stream keys are prefixed by the
+  # configured Redis key prefix.
+  server="{{ .ApplicationServer.Integration.RedisStreams.Server }}"
+
+  # Password, database and TLS of the Redis server (optional).
+  password="{{ .ApplicationServer.Integration.RedisStreams.Password }}"
+  database={{ .ApplicationServer.Integration.RedisStreams.Database }}
+  tls_enabled={{ .ApplicationServer.Integration.RedisStreams.TLSEnabled }}
+
+  # Stream key template.
+  #
+  # This is the key template of the stream to which the device events are
+  # added. Each entry contains the event, application_id, dev_eui and
+  # payload fields. Consumers can read the stream using consumer groups.
+  # The stream key of the per-application integrations must not contain the
+  # DevEUI, as each stream is trimmed individually.
+  stream_key_template="{{ .ApplicationServer.Integration.RedisStreams.StreamKeyTemplate }}"
+
+  # Max. stream length.
+  #
+  # Streams are (approximately) trimmed to this number of entries. Set to 0
+  # to disable trimming.
+  max_len={{ .ApplicationServer.Integration.RedisStreams.MaxLen }}
+
+  # Max. stream length limit.
+  #
+  # The max. stream length of the per-application Redis Streams integrations
+  # must be between 1 and this limit.
+  max_len_limit={{ .ApplicationServer.Integration.RedisStreams.MaxLenLimit }}
+
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.RedisStreams.PayloadTemplate }}'''
+
+
+  # MQTT Sparkplug B.
+  #
+  # Each application is published as Sparkplug edge node, using the
+  # application ID as edge node ID. Each device is published as Sparkplug
+  # device, using the DevEUI as device ID. The device metrics are derived
+  # from the decoded object of the uplink (nested keys are joined by a "/").
+  #
+  # Topics: spBv1.0/[group_id]/[NBIRTH|NDEATH|DBIRTH|DDATA|DDEATH]/[application_id]/[dev_eui]
+  #
+  # Note: as all edge nodes share the same MQTT connection, the NDEATH
+  # messages are published on shutdown, not as MQTT will message.
+  [application_server.integration.sparkplug_b]
+  # MQTT server (e.g. scheme://host:port where scheme is tcp, ssl or ws)
+  server="{{ .ApplicationServer.Integration.SparkplugB.Server }}"
+
+  # Connect with the given username and password (optional)
+  username="{{ .ApplicationServer.Integration.SparkplugB.Username }}"
+  password="{{ .ApplicationServer.Integration.SparkplugB.Password }}"
+
+  # Maximum interval that will be waited between reconnection attempts when connection is lost.
+  max_reconnect_interval="{{ .ApplicationServer.Integration.SparkplugB.MaxReconnectInterval }}"
+
+  # Client ID (optional)
+  #
+  # When left blank, a random id will be generated.
+  client_id="{{ .ApplicationServer.Integration.SparkplugB.ClientID }}"
+
+  # CA certificate, TLS certificate and TLS key files (optional)
+  ca_cert="{{ .ApplicationServer.Integration.SparkplugB.CACert }}"
+  tls_cert="{{ .ApplicationServer.Integration.SparkplugB.TLSCert }}"
+  tls_key="{{ .ApplicationServer.Integration.SparkplugB.TLSKey }}"
+
+  # Sparkplug group ID.
+  group_id="{{ .ApplicationServer.Integration.SparkplugB.GroupID }}"
+
+  # Device timeout.
+  #
+  # A DDEATH is published for devices from which no uplink has been received
+  # within this duration. Set this to a value greater than the uplink
+  # interval of the devices. Set to 0 to disable.
+  device_timeout="{{ .ApplicationServer.Integration.SparkplugB.DeviceTimeout }}"
+
+  # Downlink fPort.
+  #
+  # The integration subscribes to the DCMD (and NCMD) messages. The metrics
+  # of a DCMD are encoded using the codec of the device-profile and enqueued
+  # using this fPort. The fPort and confirmed metrics can be used to
+  # override the fPort and to send a confirmed downlink. A DCMD must contain
+  # the payload timestamp, which is used to handle each command only once
+  # when running multiple instances.
+  downlink_fport={{ .ApplicationServer.Integration.SparkplugB.DownlinkFPort }}
+
+
+  # Home Assistant MQTT discovery.
+  #
+  # On the first uplink of a device, a sensor discovery message is published
+  # for each field of the decoded object. The decoded object is published to
+  # the state topic of the device. The sensors are grouped by device, using
+  # the device-profile name as model.
+  #
+  # The unit and device class of a sensor can be set using the device-profile
+  # tags "unit.[field]" and "device_class.[field]", e.g.
+  # "unit.sensor.temperature" = "°C".
+  #
+  # This integration uses the connection settings of the MQTT integration
+  # (see the [application_server.integration.mqtt] section).
+  [application_server.integration.home_assistant]
+  # Discovery prefix.
+  #
+  # This must match the discovery prefix configured in Home Assistant.
+  discovery_prefix="{{ .ApplicationServer.Integration.HomeAssistant.DiscoveryPrefix }}"
+
+  # State topic template.
+  state_topic_template="{{ .ApplicationServer.Integration.HomeAssistant.StateTopicTemplate }}"
+
+  # Retain state messages.
+  #
+  # When enabled, Home Assistant receives the last state of the devices
+  # after a restart.
+  retain_state={{ .ApplicationServer.Integration.HomeAssistant.RetainState }}
+
+
+  # Object-storage (S3 compatible) archive.
+  #
+  # The events are buffered per application and hour and are periodically
+  # written as gzip compressed NDJSON objects (one event per line) to the
+  # configured bucket. The object keys are partitioned as:
+  # [key_prefix]application_id=[ID]/date=[YYYY-MM-DD]/hour=[HH]/[timestamp]-[ID].ndjson.gz
+  #
+  # The events are only kept in memory. Objects which fail to upload are
+  # retried on the next flush, while at most 4x max_buffer_size of these are
+  # kept. On shutdown, the buffered events are written once more. Thus when
+  # the storage is unavailable, events up to 4x max_buffer_size are lost on
+  # a shutdown, as are the older objects once this size is exceeded. Dropped
+  # objects are reported as failed in the integration health of the
+  # application. On a crash, the events buffered since the last flush (up to
+  # flush_interval) are lost as well.
+  [application_server.integration.s3_archive]
+  # Endpoint (optional).
+  #
+  # Leave this blank when using AWS S3. For other S3 compatible storage
+  # (e.g. MinIO), set this to the endpoint URL, e.g. "http://localhost:9000".
+  endpoint="{{ .ApplicationServer.Integration.S3Archive.Endpoint }}"
+
+  # Region.
+  region="{{ .ApplicationServer.Integration.S3Archive.Region }}"
+
+  # Access Key ID.
+  access_key_id="{{ .ApplicationServer.Integration.S3Archive.AccessKeyID }}"
+
+  # Secret Access Key.
+  secret_access_key="{{ .ApplicationServer.Integration.S3Archive.SecretAccessKey }}"
+
+  # Bucket.
+  bucket="{{ .ApplicationServer.Integration.S3Archive.Bucket }}"
+
+  # Force path-style addressing.
+  #
+  # This is required by most S3 compatible storage, e.g. MinIO.
+  force_path_style={{ .ApplicationServer.Integration.S3Archive.ForcePathStyle }}
+
+  # Key prefix (optional).
+  #
+  # Example: "chirpstack/".
+  key_prefix="{{ .ApplicationServer.Integration.S3Archive.KeyPrefix }}"
+
+  # Flush interval.
+  #
+  # The interval after which the buffered events are written.
+  flush_interval="{{ .ApplicationServer.Integration.S3Archive.FlushInterval }}"
+
+  # Max buffer size.
+  #
+  # The (uncompressed) size in bytes of the buffered events of all
+  # applications, after which these are written before the flush interval
+  # has expired. The buffered events are also written when the events of 64
+  # application and hour combinations are buffered.
+  max_buffer_size={{ .ApplicationServer.Integration.S3Archive.MaxBufferSize }}
+
+  # Part size.
+  #
+  # Objects larger than the part size are uploaded using a multipart upload.
+  # The minimum part size is 5MB.
+  part_size={{ .ApplicationServer.Integration.S3Archive.PartSize }}
+
+  # Max retries.
+  #
+  # The number of times a failed upload is retried, using an exponential
+  # backoff. Objects that could not be uploaded are kept in memory and
+  # retried on the next flush.
+  max_retries={{ .ApplicationServer.Integration.S3Archive.MaxRetries }}
+
 
   # AWS Simple Notification Service (SNS)
   [application_server.integration.aws_sns]
@@ -373,6 +661,11 @@ id="{{ .ApplicationServer.ID }}"
   # Topic ARN (SNS).
   topic_arn="{{ .ApplicationServer.Integration.AWSSNS.TopicARN }}"
 
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.AWSSNS.PayloadTemplate }}'''
+
 
   # Azure Service-Bus integration.
   [application_server.integration.azure_service_bus]
@@ -392,6 +685,11 @@ id="{{ .ApplicationServer.ID }}"
   # The name of the topic or queue.
   publish_name="{{ .ApplicationServer.Integration.AzureServiceBus.PublishName }}"
 
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.AzureServiceBus.PayloadTemplate }}'''
+
 
   # Google Cloud Pub/Sub integration.
   [application_server.integration.gcp_pub_sub]
@@ -407,6 +705,11 @@ id="{{ .ApplicationServer.ID }}"
   # Pub/Sub topic name.
   topic_name="{{ .ApplicationServer.Integration.GCPPubSub.TopicName }}"
 
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.GCPPubSub.PayloadTemplate }}'''
+
 
   # Kafka integration.
   [application_server.integration.kafka]
@@ -429,6 +732,30 @@ id="{{ .ApplicationServer.ID }}"
   # message. There is no need to parse it from the key.
   event_key_template="{{ .ApplicationServer.Integration.Kafka.EventKeyTemplate }}"
 
+  # Payload template (optional).
+  #
+  # See the payload_template option of the MQTT integration.
+  payload_template='''{{ .ApplicationServer.Integration.Kafka.PayloadTemplate }}'''
+
+  # Topic for downlink commands (optional).
+  #
+  # When set, downlink commands are consumed from this topic. The command must
+  # contain the application ID and DevEUI, either as part of the JSON payload
+  # or as "application_id" and "dev_eui" message headers. When the protobuf
+  # marshaler is used, the payload must be a DeviceQueueItem message and the
+  # headers are required.
+  command_topic="{{ .ApplicationServer.Integration.Kafka.CommandTopic }}"
+
+  # Consumer group ID used for consuming the downlink commands.
+  command_group_id="{{ .ApplicationServer.Integration.Kafka.CommandGroupID }}"
+
+  # Topic for downlink command replies (optional).
+  #
+  # When set, the enqueue result (frame-counter) or error of each downlink
+  # command is published to this topic, using the key of the command message.
+  # A "correlation_id" header of the command is copied to the reply.
+  reply_topic="{{ .ApplicationServer.Integration.Kafka.ReplyTopic }}"
+
   # Username (optional).
   username="{{ .ApplicationServer.Integration.Kafka.Username }}"
 
@@ -458,6 +785,65 @@ id="{{ .ApplicationServer.ID }}"
   max_idle_connections={{ .ApplicationServer.Integration.PostgreSQL.MaxIdleConnections }}
 
 
+  # Integration outbox.
+  #
+  # When enabled, events are first written to a Redis Stream (the outbox)
+  # before they are delivered to the integrations. Failed deliveries are
+  # retried per integration, using an exponential backoff. Events that could
+  # not be delivered within the configured max. age are moved to the
+  # dead-letter store of the application, from where they can be listed,
+  # replayed or purged using the API.
+  [application_server.integration.outbox]
+  # Enable the outbox.
+  enabled={{ .ApplicationServer.Integration.Outbox.Enabled }}
+
+  # Max. age of an event.
+  #
+  # Events that could not be delivered within this duration are moved
+  # to the dead-letter store.
+  max_age="{{ .ApplicationServer.Integration.Outbox.MaxAge }}"
+
+  # Initial retry interval.
+  #
+  # This interval is doubled after every failed delivery attempt.
+  retry_initial_interval="{{ .ApplicationServer.Integration.Outbox.RetryInitialInterval }}"
+
+  # Max. retry interval.
+  retry_max_interval="{{ .ApplicationServer.Integration.Outbox.RetryMaxInterval }}"
+
+  # Max. number of dead-lettered events to keep per application.
+  dead_letter_max_history={{ .ApplicationServer.Integration.Outbox.DeadLetterMaxHistory }}
+
+  # Max. number of events that are delivered concurrently.
+  workers={{ .ApplicationServer.Integration.Outbox.Workers }}
+
+
+  # Integration worker-pool.
+  #
+  # Events are handled by a bounded worker-pool per integration (each
+  # global integration and each application integration has its own pool),
+  # so that a slow integration can not delay the other integrations or
+  # exhaust the resources of the application-server.
+  [application_server.integration.worker_pool]
+  # Number of workers per integration.
+  #
+  # When set to 0, each event will be handled in its own Go-routine
+  # (unbounded).
+  workers={{ .ApplicationServer.Integration.WorkerPool.Workers }}
+
+  # Queue size per integration.
+  queue_size={{ .ApplicationServer.Integration.WorkerPool.QueueSize }}
+
+  # Full policy.
+  #
+  # This defines what happens when the queue of an integration is full:
+  # * drop:   drop the event
+  # * block:  wait until the queue has space (this will slow down the
+  #           handling of uplinks for all integrations, and thus apply
+  #           backpressure)
+  full_policy="{{ .ApplicationServer.Integration.WorkerPool.FullPolicy }}"
+
+
   # Settings for the "internal api"
   #
   # This is the API used by ChirpStack Network Server to communicate with ChirpStack Application Server
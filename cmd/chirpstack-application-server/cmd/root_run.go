@@ -19,6 +19,12 @@ import (
 	"github.com/brocaar/chirpstack-application-server/internal/downlink"
 	"github.com/brocaar/chirpstack-application-server/internal/gwping"
 	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/amqp"
+	httpint "github.com/brocaar/chirpstack-application-server/internal/integration/http"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/kafka"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/nats"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/sparkplug"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
 	"github.com/brocaar/chirpstack-application-server/internal/migrations/code"
 	"github.com/brocaar/chirpstack-application-server/internal/monitoring"
 	"github.com/brocaar/chirpstack-application-server/internal/storage"
@@ -122,6 +128,12 @@ func setupNetworkServer() error {
 func handleDataDownPayloads() error {
 	downChan := integration.ForApplicationID(0).DataDownChan()
 	go downlink.HandleDataDownPayloads(downChan)
+	go downlink.HandleDataDownPayloads(httpint.DownlinkChan())
+	go downlink.HandleDataDownPayloads(kafka.DownlinkChan())
+	go downlink.HandleDataDownPayloads(amqp.DownlinkChan())
+	go downlink.HandleDataDownPayloads(nats.DownlinkChan())
+	go downlink.HandleDataDownPayloads(sparkplug.DownlinkChan())
+	go downlink.HandleDataDownPayloads(thingsboard.DownlinkChan())
 	return nil
 }
 
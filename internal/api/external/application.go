@@ -3,6 +3,7 @@ package external
 import (
 	"encoding/json"
 	"strings"
+	"text/template"
 
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes"
@@ -20,12 +21,15 @@ import (
 	"github.com/brocaar/chirpstack-application-server/internal/codec"
 	"github.com/brocaar/chirpstack-application-server/internal/config"
 	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/elasticsearch"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/health"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/http"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/influxdb"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/loracloud"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/mqtt"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/mydevices"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/pilotthings"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/redisstreams"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
 	"github.com/brocaar/chirpstack-application-server/internal/storage"
 )
@@ -150,6 +154,11 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 	app.Name = req.Application.Name
 	app.Description = req.Application.Description
 	app.ServiceProfileID = spID
+	// the codec specific settings are set through the codec API and do
+	// not apply to a different codec
+	if app.PayloadCodec != codec.Type(req.Application.PayloadCodec) {
+		app.PayloadCodecSettings = nil
+	}
 	app.PayloadCodec = codec.Type(req.Application.PayloadCodec)
 	app.PayloadEncoderScript = req.Application.PayloadEncoderScript
 	app.PayloadDecoderScript = req.Application.PayloadDecoderScript
@@ -181,6 +190,8 @@ func (a *ApplicationAPI) Delete(ctx context.Context, req *pb.DeleteApplicationRe
 		return nil, err
 	}
 
+	invalidateIntegrations(ctx, req.Id)
+
 	return &empty.Empty{}, nil
 }
 
@@ -297,12 +308,15 @@ func (a *ApplicationAPI) CreateHTTPIntegration(ctx context.Context, in *pb.Creat
 	integration := storage.Integration{
 		ApplicationID: in.Integration.ApplicationId,
 		Kind:          integration.HTTP,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err = storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -375,10 +389,24 @@ func (a *ApplicationAPI) UpdateHTTPIntegration(ctx context.Context, in *pb.Updat
 		headers[h.Key] = h.Value
 	}
 
+	// The downlink secret is managed through a separate endpoint. The
+	// payload template, signing secret and TLS settings are not part of the
+	// per-kind API.
+	var current http.Config
+	if err := json.Unmarshal(integration.Settings, &current); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	conf := http.Config{
 		Headers:          headers,
 		EventEndpointURL: in.Integration.EventEndpointUrl,
 		Marshaler:        in.Integration.Marshaler.String(),
+		DownlinkSecret:   current.DownlinkSecret,
+		PayloadTemplate:  current.PayloadTemplate,
+		SigningSecret:    current.SigningSecret,
+		TLSCert:          current.TLSCert,
+		TLSKey:           current.TLSKey,
+		CACert:           current.CACert,
 
 		// Backwards compatibility.
 		DataUpURL:                  in.Integration.UplinkDataUrl,
@@ -404,6 +432,8 @@ func (a *ApplicationAPI) UpdateHTTPIntegration(ctx context.Context, in *pb.Updat
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -424,6 +454,8 @@ func (a *ApplicationAPI) DeleteHTTPIntegration(ctx context.Context, in *pb.Delet
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -468,12 +500,15 @@ func (a *ApplicationAPI) CreateInfluxDBIntegration(ctx context.Context, in *pb.C
 	integration := storage.Integration{
 		ApplicationID: in.Integration.ApplicationId,
 		Kind:          integration.InfluxDB,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -567,6 +602,8 @@ func (a *ApplicationAPI) UpdateInfluxDBIntegration(ctx context.Context, in *pb.U
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -587,6 +624,8 @@ func (a *ApplicationAPI) DeleteInfluxDBIntegration(ctx context.Context, in *pb.D
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -617,12 +656,15 @@ func (a *ApplicationAPI) CreateThingsBoardIntegration(ctx context.Context, in *p
 	integration := storage.Integration{
 		ApplicationID: in.Integration.ApplicationId,
 		Kind:          integration.ThingsBoard,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -669,8 +711,18 @@ func (a *ApplicationAPI) UpdateThingsBoardIntegration(ctx context.Context, in *p
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	// The provisioning and RPC settings are not part of the per-kind API.
+	var current thingsboard.Config
+	if err := json.Unmarshal(integration.Settings, &current); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	conf := thingsboard.Config{
-		Server: in.Integration.Server,
+		Server:                in.Integration.Server,
+		ProvisionDeviceKey:    current.ProvisionDeviceKey,
+		ProvisionDeviceSecret: current.ProvisionDeviceSecret,
+		RPCEnabled:            current.RPCEnabled,
+		RPCFPort:              current.RPCFPort,
 	}
 	if err := conf.Validate(); err != nil {
 		return nil, helpers.ErrToRPCError(err)
@@ -686,6 +738,8 @@ func (a *ApplicationAPI) UpdateThingsBoardIntegration(ctx context.Context, in *p
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -706,6 +760,8 @@ func (a *ApplicationAPI) DeleteThingsBoardIntegration(ctx context.Context, in *p
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -732,12 +788,15 @@ func (a *ApplicationAPI) CreateMyDevicesIntegration(ctx context.Context, in *pb.
 	integration := storage.Integration{
 		ApplicationID: in.Integration.ApplicationId,
 		Kind:          integration.MyDevices,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -798,6 +857,8 @@ func (a *ApplicationAPI) UpdateMyDevicesIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -818,6 +879,8 @@ func (a *ApplicationAPI) DeleteMyDevicesIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -860,12 +923,15 @@ func (a *ApplicationAPI) CreateLoRaCloudIntegration(ctx context.Context, in *pb.
 	integration := storage.Integration{
 		ApplicationID: in.GetIntegration().ApplicationId,
 		Kind:          integration.LoRaCloud,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -962,6 +1028,8 @@ func (a *ApplicationAPI) UpdateLoRaCloudIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -982,6 +1050,8 @@ func (a *ApplicationAPI) DeleteLoRaCloudIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1011,12 +1081,15 @@ func (a *ApplicationAPI) CreateGCPPubSubIntegration(ctx context.Context, in *pb.
 	integration := storage.Integration{
 		ApplicationID: in.GetIntegration().ApplicationId,
 		Kind:          integration.GCPPubSub,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1066,11 +1139,18 @@ func (a *ApplicationAPI) UpdateGCPPubSubIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	// keep the payload template, it is not part of the per-kind API
+	var current config.IntegrationGCPConfig
+	if err := json.Unmarshal(integration.Settings, &current); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	conf := config.IntegrationGCPConfig{
 		Marshaler:            in.GetIntegration().Marshaler.String(),
 		CredentialsFileBytes: []byte(in.GetIntegration().CredentialsFile),
 		TopicName:            in.GetIntegration().TopicName,
 		ProjectID:            in.GetIntegration().ProjectId,
+		PayloadTemplate:      current.PayloadTemplate,
 	}
 	confJSON, err := json.Marshal(conf)
 	if err != nil {
@@ -1083,6 +1163,8 @@ func (a *ApplicationAPI) UpdateGCPPubSubIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1103,6 +1185,8 @@ func (a *ApplicationAPI) DeleteGCPPubSubIntegration(ctx context.Context, in *pb.
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1133,12 +1217,15 @@ func (a *ApplicationAPI) CreateAWSSNSIntegration(ctx context.Context, in *pb.Cre
 	integration := storage.Integration{
 		ApplicationID: in.GetIntegration().ApplicationId,
 		Kind:          integration.AWSSNS,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err = storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1189,12 +1276,19 @@ func (a *ApplicationAPI) UpdateAWSSNSIntegration(ctx context.Context, in *pb.Upd
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	// keep the payload template, it is not part of the per-kind API
+	var current config.IntegrationAWSSNSConfig
+	if err := json.Unmarshal(integration.Settings, &current); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	conf := config.IntegrationAWSSNSConfig{
 		Marshaler:          in.GetIntegration().Marshaler.String(),
 		AWSRegion:          in.GetIntegration().Region,
 		AWSAccessKeyID:     in.GetIntegration().AccessKeyId,
 		AWSSecretAccessKey: in.GetIntegration().SecretAccessKey,
 		TopicARN:           in.GetIntegration().TopicArn,
+		PayloadTemplate:    current.PayloadTemplate,
 	}
 	confJSON, err := json.Marshal(conf)
 	if err != nil {
@@ -1206,6 +1300,8 @@ func (a *ApplicationAPI) UpdateAWSSNSIntegration(ctx context.Context, in *pb.Upd
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1226,6 +1322,8 @@ func (a *ApplicationAPI) DeleteAWSSNSIntegration(ctx context.Context, in *pb.Del
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1254,12 +1352,15 @@ func (a *ApplicationAPI) CreateAzureServiceBusIntegration(ctx context.Context, i
 	integration := storage.Integration{
 		ApplicationID: in.GetIntegration().ApplicationId,
 		Kind:          integration.AzureServiceBus,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err = storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1308,10 +1409,17 @@ func (a *ApplicationAPI) UpdateAzureServiceBusIntegration(ctx context.Context, i
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	// keep the payload template, it is not part of the per-kind API
+	var current config.IntegrationAzureConfig
+	if err := json.Unmarshal(integration.Settings, &current); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	conf := config.IntegrationAzureConfig{
 		Marshaler:        in.GetIntegration().Marshaler.String(),
 		ConnectionString: in.GetIntegration().ConnectionString,
 		PublishName:      in.GetIntegration().PublishName,
+		PayloadTemplate:  current.PayloadTemplate,
 	}
 	confJSON, err := json.Marshal(conf)
 	if err != nil {
@@ -1323,6 +1431,8 @@ func (a *ApplicationAPI) UpdateAzureServiceBusIntegration(ctx context.Context, i
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1343,6 +1453,8 @@ func (a *ApplicationAPI) DeleteAzureServiceBusIntegration(ctx context.Context, i
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1374,12 +1486,15 @@ func (a *ApplicationAPI) CreatePilotThingsIntegration(ctx context.Context, in *p
 	integration := storage.Integration{
 		ApplicationID: in.GetIntegration().ApplicationId,
 		Kind:          integration.PilotThings,
+		Enabled:       true,
 		Settings:      confJSON,
 	}
 	if err = storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1445,6 +1560,8 @@ func (a *ApplicationAPI) UpdatePilotThingsIntegration(ctx context.Context, in *p
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
@@ -1465,100 +1582,970 @@ func (a *ApplicationAPI) DeletePilotThingsIntegration(ctx context.Context, in *p
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
 	return &empty.Empty{}, nil
 }
 
-// GenerateMQTTIntegrationClientCertificate generates an application ID specific TLS certificate
-// to connect to the MQTT broker.
-func (a *ApplicationAPI) GenerateMQTTIntegrationClientCertificate(ctx context.Context, in *pb.GenerateMQTTIntegrationClientCertificateRequest) (*pb.GenerateMQTTIntegrationClientCertificateResponse, error) {
+// KafkaIntegration defines the Kafka application-integration.
+type KafkaIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	config.IntegrationKafkaConfig
+}
+
+// KafkaIntegrationRequest defines the create or update Kafka
+// application-integration request.
+type KafkaIntegrationRequest struct {
+	Integration *KafkaIntegration `json:"integration"`
+}
+
+// AMQPIntegration defines the AMQP application-integration.
+type AMQPIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	config.IntegrationAMQPConfig
+}
+
+// AMQPIntegrationRequest defines the create or update AMQP
+// application-integration request.
+type AMQPIntegrationRequest struct {
+	Integration *AMQPIntegration `json:"integration"`
+}
+
+// NATSIntegration defines the NATS application-integration.
+type NATSIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	config.IntegrationNATSConfig
+}
+
+// NATSIntegrationRequest defines the create or update NATS
+// application-integration request.
+type NATSIntegrationRequest struct {
+	Integration *NATSIntegration `json:"integration"`
+}
+
+// RedisStreamsIntegration defines the Redis Streams application-integration.
+// When no server is set, the stream keys are prefixed by
+// "lora:as:integration:stream:<application id>:".
+type RedisStreamsIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	config.IntegrationRedisStreamsConfig
+}
+
+// RedisStreamsIntegrationRequest defines the create or update Redis Streams
+// application-integration request.
+type RedisStreamsIntegrationRequest struct {
+	Integration *RedisStreamsIntegration `json:"integration"`
+}
+
+// ElasticsearchIntegration defines the Elasticsearch / OpenSearch
+// application-integration.
+type ElasticsearchIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	elasticsearch.Config
+}
+
+// ElasticsearchIntegrationRequest defines the create or update Elasticsearch
+// application-integration request.
+type ElasticsearchIntegrationRequest struct {
+	Integration *ElasticsearchIntegration `json:"integration"`
+}
+
+// PostgreSQLIntegration defines the PostgreSQL application-integration.
+type PostgreSQLIntegration struct {
+	ApplicationID int64 `json:"applicationID,string"`
+	config.IntegrationPostgreSQLConfig
+}
+
+// PostgreSQLIntegrationRequest defines the create or update PostgreSQL
+// application-integration request.
+type PostgreSQLIntegrationRequest struct {
+	Integration *PostgreSQLIntegration `json:"integration"`
+}
+
+// CreateKafkaIntegration creates a Kafka application-integration.
+func (a *ApplicationAPI) CreateKafkaIntegration(ctx context.Context, in *KafkaIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
 	if err := a.validator.Validate(ctx,
-		auth.ValidateApplicationAccess(in.ApplicationId, auth.Update),
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	expiresAt, ca, cert, key, err := mqtt.GenerateClientCertificate(in.ApplicationId)
+	if err := validateKafkaIntegration(in.Integration.IntegrationKafkaConfig); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.IntegrationKafkaConfig)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	app, err := storage.GetApplication(ctx, storage.DB(), in.ApplicationId)
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.Kafka,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetKafkaIntegration returns the Kafka application-integration.
+func (a *ApplicationAPI) GetKafkaIntegration(ctx context.Context, applicationID int64) (*KafkaIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.Kafka)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	app.MQTTTLSCert = cert
+	var conf config.IntegrationKafkaConfig
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
 
-	if err := storage.UpdateApplication(ctx, storage.DB(), app); err != nil {
+	return &KafkaIntegrationRequest{
+		Integration: &KafkaIntegration{
+			ApplicationID:          integration.ApplicationID,
+			IntegrationKafkaConfig: conf,
+		},
+	}, nil
+}
+
+// UpdateKafkaIntegration updates the Kafka application-integration.
+func (a *ApplicationAPI) UpdateKafkaIntegration(ctx context.Context, in *KafkaIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.Kafka)
+	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	expiresAtPB, err := ptypes.TimestampProto(expiresAt)
+	if err := validateKafkaIntegration(in.Integration.IntegrationKafkaConfig); err != nil {
+		return nil, err
+	}
+
+	integration.Settings, err = json.Marshal(in.Integration.IntegrationKafkaConfig)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	return &pb.GenerateMQTTIntegrationClientCertificateResponse{
-		TlsCert:   string(cert),
-		TlsKey:    string(key),
-		CaCert:    string(ca),
-		ExpiresAt: expiresAtPB,
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeleteKafkaIntegration deletes the Kafka application-integration.
+func (a *ApplicationAPI) DeleteKafkaIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.Kafka)
+}
+
+// CreateAMQPIntegration creates an AMQP application-integration.
+func (a *ApplicationAPI) CreateAMQPIntegration(ctx context.Context, in *AMQPIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateAMQPIntegration(in.Integration.IntegrationAMQPConfig); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.IntegrationAMQPConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.AMQP,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetAMQPIntegration returns the AMQP application-integration.
+func (a *ApplicationAPI) GetAMQPIntegration(ctx context.Context, applicationID int64) (*AMQPIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.AMQP)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var conf config.IntegrationAMQPConfig
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &AMQPIntegrationRequest{
+		Integration: &AMQPIntegration{
+			ApplicationID:         integration.ApplicationID,
+			IntegrationAMQPConfig: conf,
+		},
 	}, nil
 }
 
-// ListIntegrations lists all configured integrations.
-func (a *ApplicationAPI) ListIntegrations(ctx context.Context, in *pb.ListIntegrationRequest) (*pb.ListIntegrationResponse, error) {
+// UpdateAMQPIntegration updates the AMQP application-integration.
+func (a *ApplicationAPI) UpdateAMQPIntegration(ctx context.Context, in *AMQPIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
 	if err := a.validator.Validate(ctx,
-		auth.ValidateApplicationAccess(in.ApplicationId, auth.Update),
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	// Application integrations
-	integrations, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), in.ApplicationId)
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.AMQP)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
-	out := pb.ListIntegrationResponse{
-		TotalCount: int64(len(integrations)),
+
+	if err := validateAMQPIntegration(in.Integration.IntegrationAMQPConfig); err != nil {
+		return nil, err
 	}
 
-	for _, intgr := range integrations {
-		switch intgr.Kind {
-		case integration.HTTP:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_HTTP})
-		case integration.InfluxDB:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_INFLUXDB})
-		case integration.ThingsBoard:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_THINGSBOARD})
-		case integration.MyDevices:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_MYDEVICES})
-		case integration.LoRaCloud:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_LORACLOUD})
-		case integration.GCPPubSub:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_GCP_PUBSUB})
-		case integration.AWSSNS:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_AWS_SNS})
-		case integration.AzureServiceBus:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_AZURE_SERVICE_BUS})
-		case integration.PilotThings:
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_PILOT_THINGS})
-		default:
-			log.WithFields(log.Fields{
-				"kind": intgr.Kind,
-			}).Warning("api/external: unknown integration kind")
-		}
+	integration.Settings, err = json.Marshal(in.Integration.IntegrationAMQPConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
 	}
 
-	// Global integrations
-	conf := config.Get()
-	for _, name := range conf.ApplicationServer.Integration.Enabled {
-		switch name {
-		case "mqtt":
-			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_MQTT_GLOBAL})
-		}
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
 
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeleteAMQPIntegration deletes the AMQP application-integration.
+func (a *ApplicationAPI) DeleteAMQPIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.AMQP)
+}
+
+// CreateNATSIntegration creates a NATS application-integration.
+func (a *ApplicationAPI) CreateNATSIntegration(ctx context.Context, in *NATSIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
 	}
 
-	return &out, nil
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateNATSIntegration(in.Integration.IntegrationNATSConfig); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.IntegrationNATSConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.NATS,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetNATSIntegration returns the NATS application-integration.
+func (a *ApplicationAPI) GetNATSIntegration(ctx context.Context, applicationID int64) (*NATSIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.NATS)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var conf config.IntegrationNATSConfig
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &NATSIntegrationRequest{
+		Integration: &NATSIntegration{
+			ApplicationID:         integration.ApplicationID,
+			IntegrationNATSConfig: conf,
+		},
+	}, nil
+}
+
+// UpdateNATSIntegration updates the NATS application-integration.
+func (a *ApplicationAPI) UpdateNATSIntegration(ctx context.Context, in *NATSIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.NATS)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := validateNATSIntegration(in.Integration.IntegrationNATSConfig); err != nil {
+		return nil, err
+	}
+
+	integration.Settings, err = json.Marshal(in.Integration.IntegrationNATSConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeleteNATSIntegration deletes the NATS application-integration.
+func (a *ApplicationAPI) DeleteNATSIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.NATS)
+}
+
+// CreateRedisStreamsIntegration creates a Redis Streams application-integration.
+func (a *ApplicationAPI) CreateRedisStreamsIntegration(ctx context.Context, in *RedisStreamsIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateRedisStreamsIntegration(in.Integration.IntegrationRedisStreamsConfig); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.IntegrationRedisStreamsConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.RedisStreams,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetRedisStreamsIntegration returns the Redis Streams application-integration.
+func (a *ApplicationAPI) GetRedisStreamsIntegration(ctx context.Context, applicationID int64) (*RedisStreamsIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.RedisStreams)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var conf config.IntegrationRedisStreamsConfig
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &RedisStreamsIntegrationRequest{
+		Integration: &RedisStreamsIntegration{
+			ApplicationID:                 integration.ApplicationID,
+			IntegrationRedisStreamsConfig: conf,
+		},
+	}, nil
+}
+
+// UpdateRedisStreamsIntegration updates the Redis Streams application-integration.
+func (a *ApplicationAPI) UpdateRedisStreamsIntegration(ctx context.Context, in *RedisStreamsIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.RedisStreams)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := validateRedisStreamsIntegration(in.Integration.IntegrationRedisStreamsConfig); err != nil {
+		return nil, err
+	}
+
+	integration.Settings, err = json.Marshal(in.Integration.IntegrationRedisStreamsConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeleteRedisStreamsIntegration deletes the Redis Streams application-integration.
+func (a *ApplicationAPI) DeleteRedisStreamsIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.RedisStreams)
+}
+
+// CreateElasticsearchIntegration creates an Elasticsearch application-integration.
+func (a *ApplicationAPI) CreateElasticsearchIntegration(ctx context.Context, in *ElasticsearchIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateElasticsearchIntegration(in.Integration.Config); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.Config)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.Elasticsearch,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetElasticsearchIntegration returns the Elasticsearch application-integration.
+func (a *ApplicationAPI) GetElasticsearchIntegration(ctx context.Context, applicationID int64) (*ElasticsearchIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.Elasticsearch)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var conf elasticsearch.Config
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &ElasticsearchIntegrationRequest{
+		Integration: &ElasticsearchIntegration{
+			ApplicationID: integration.ApplicationID,
+			Config:        conf,
+		},
+	}, nil
+}
+
+// UpdateElasticsearchIntegration updates the Elasticsearch application-integration.
+func (a *ApplicationAPI) UpdateElasticsearchIntegration(ctx context.Context, in *ElasticsearchIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.Elasticsearch)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := validateElasticsearchIntegration(in.Integration.Config); err != nil {
+		return nil, err
+	}
+
+	integration.Settings, err = json.Marshal(in.Integration.Config)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeleteElasticsearchIntegration deletes the Elasticsearch application-integration.
+func (a *ApplicationAPI) DeleteElasticsearchIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.Elasticsearch)
+}
+
+// CreatePostgreSQLIntegration creates a PostgreSQL application-integration.
+func (a *ApplicationAPI) CreatePostgreSQLIntegration(ctx context.Context, in *PostgreSQLIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validatePostgreSQLIntegration(in.Integration.IntegrationPostgreSQLConfig); err != nil {
+		return nil, err
+	}
+
+	confJSON, err := json.Marshal(in.Integration.IntegrationPostgreSQLConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	integration := storage.Integration{
+		ApplicationID: in.Integration.ApplicationID,
+		Kind:          integration.PostgreSQL,
+		Enabled:       true,
+		Settings:      confJSON,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// GetPostgreSQLIntegration returns the PostgreSQL application-integration.
+func (a *ApplicationAPI) GetPostgreSQLIntegration(ctx context.Context, applicationID int64) (*PostgreSQLIntegrationRequest, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, integration.PostgreSQL)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var conf config.IntegrationPostgreSQLConfig
+	if err = json.Unmarshal(integration.Settings, &conf); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &PostgreSQLIntegrationRequest{
+		Integration: &PostgreSQLIntegration{
+			ApplicationID:               integration.ApplicationID,
+			IntegrationPostgreSQLConfig: conf,
+		},
+	}, nil
+}
+
+// UpdatePostgreSQLIntegration updates the PostgreSQL application-integration.
+func (a *ApplicationAPI) UpdatePostgreSQLIntegration(ctx context.Context, in *PostgreSQLIntegrationRequest) (*struct{}, error) {
+	if in.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), in.Integration.ApplicationID, integration.PostgreSQL)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := validatePostgreSQLIntegration(in.Integration.IntegrationPostgreSQLConfig); err != nil {
+		return nil, err
+	}
+
+	integration.Settings, err = json.Marshal(in.Integration.IntegrationPostgreSQLConfig)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err = storage.UpdateIntegration(ctx, storage.DB(), &integration); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// DeletePostgreSQLIntegration deletes the PostgreSQL application-integration.
+func (a *ApplicationAPI) DeletePostgreSQLIntegration(ctx context.Context, applicationID int64) (*struct{}, error) {
+	return a.deleteIntegration(ctx, applicationID, integration.PostgreSQL)
+}
+
+func (a *ApplicationAPI) deleteIntegration(ctx context.Context, applicationID int64, kind string) (*struct{}, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integration, err := storage.GetIntegrationByApplicationID(ctx, storage.DB(), applicationID, kind)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err = storage.DeleteIntegration(ctx, storage.DB(), integration.ID); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, integration.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+func validateKafkaIntegration(conf config.IntegrationKafkaConfig) error {
+	if len(conf.Brokers) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "brokers must not be empty")
+	}
+	if conf.Topic == "" {
+		return grpc.Errorf(codes.InvalidArgument, "topic must not be empty")
+	}
+	if _, err := template.New("key").Parse(conf.EventKeyTemplate); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "parse event key template error: %s", err)
+	}
+	if conf.Username != "" || conf.Password != "" {
+		switch conf.Mechanism {
+		case "plain":
+		case "scram":
+			if conf.Algorithm != "SHA-256" && conf.Algorithm != "SHA-512" {
+				return grpc.Errorf(codes.InvalidArgument, "algorithm must be SHA-256 or SHA-512")
+			}
+		default:
+			return grpc.Errorf(codes.InvalidArgument, "mechanism must be plain or scram")
+		}
+	}
+	return validatePayloadTemplate(conf.PayloadTemplate)
+}
+
+func validateAMQPIntegration(conf config.IntegrationAMQPConfig) error {
+	if conf.URL == "" {
+		return grpc.Errorf(codes.InvalidArgument, "url must not be empty")
+	}
+	if conf.EventRoutingKeyTemplate == "" {
+		return grpc.Errorf(codes.InvalidArgument, "event routing-key template must not be empty")
+	}
+	if _, err := template.New("event").Parse(conf.EventRoutingKeyTemplate); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "parse event routing-key template error: %s", err)
+	}
+	return validatePayloadTemplate(conf.PayloadTemplate)
+}
+
+func validateNATSIntegration(conf config.IntegrationNATSConfig) error {
+	if conf.Server == "" {
+		return grpc.Errorf(codes.InvalidArgument, "server must not be empty")
+	}
+	if conf.EventSubjectTemplate == "" {
+		return grpc.Errorf(codes.InvalidArgument, "event subject template must not be empty")
+	}
+	if _, err := template.New("event").Parse(conf.EventSubjectTemplate); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "parse event subject template error: %s", err)
+	}
+	return validatePayloadTemplate(conf.PayloadTemplate)
+}
+
+func validateRedisStreamsIntegration(conf config.IntegrationRedisStreamsConfig) error {
+	if conf.StreamKeyTemplate == "" {
+		return grpc.Errorf(codes.InvalidArgument, "stream key template must not be empty")
+	}
+	if err := redisstreams.ValidateApplicationStreamKeyTemplate(conf.StreamKeyTemplate); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	// per-application streams must always be trimmed, as they are stored
+	// in the Redis instance of the server by default
+	limit := config.Get().ApplicationServer.Integration.RedisStreams.MaxLenLimit
+	if conf.MaxLen <= 0 || conf.MaxLen > limit {
+		return grpc.Errorf(codes.InvalidArgument, "max length must be between 1 and %d", limit)
+	}
+	return validatePayloadTemplate(conf.PayloadTemplate)
+}
+
+func validateElasticsearchIntegration(conf elasticsearch.Config) error {
+	if err := conf.Validate(); err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+	return nil
+}
+
+func validatePostgreSQLIntegration(conf config.IntegrationPostgreSQLConfig) error {
+	if conf.DSN == "" {
+		return grpc.Errorf(codes.InvalidArgument, "dsn must not be empty")
+	}
+	return nil
+}
+
+// GenerateMQTTIntegrationClientCertificate generates an application ID specific TLS certificate
+// to connect to the MQTT broker.
+func (a *ApplicationAPI) GenerateMQTTIntegrationClientCertificate(ctx context.Context, in *pb.GenerateMQTTIntegrationClientCertificateRequest) (*pb.GenerateMQTTIntegrationClientCertificateResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.ApplicationId, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	expiresAt, ca, cert, key, err := mqtt.GenerateClientCertificate(in.ApplicationId)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), in.ApplicationId)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	app.MQTTTLSCert = cert
+
+	if err := storage.UpdateApplication(ctx, storage.DB(), app); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	expiresAtPB, err := ptypes.TimestampProto(expiresAt)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &pb.GenerateMQTTIntegrationClientCertificateResponse{
+		TlsCert:   string(cert),
+		TlsKey:    string(key),
+		CaCert:    string(ca),
+		ExpiresAt: expiresAtPB,
+	}, nil
+}
+
+// ListIntegrations lists all configured integrations.
+func (a *ApplicationAPI) ListIntegrations(ctx context.Context, in *pb.ListIntegrationRequest) (*pb.ListIntegrationResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(in.ApplicationId, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	// Application integrations
+	integrations, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), in.ApplicationId)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	out := pb.ListIntegrationResponse{
+		TotalCount: int64(len(integrations)),
+	}
+
+	for _, intgr := range integrations {
+		switch intgr.Kind {
+		case integration.HTTP:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_HTTP})
+		case integration.InfluxDB:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_INFLUXDB})
+		case integration.ThingsBoard:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_THINGSBOARD})
+		case integration.MyDevices:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_MYDEVICES})
+		case integration.LoRaCloud:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_LORACLOUD})
+		case integration.GCPPubSub:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_GCP_PUBSUB})
+		case integration.AWSSNS:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_AWS_SNS})
+		case integration.AzureServiceBus:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_AZURE_SERVICE_BUS})
+		case integration.PilotThings:
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_PILOT_THINGS})
+		case integration.Kafka, integration.AMQP, integration.NATS, integration.RedisStreams, integration.Elasticsearch, integration.PostgreSQL:
+			// These kinds are managed through the JSON API and can not be
+			// represented by the IntegrationKind enum.
+			out.TotalCount--
+		default:
+			log.WithFields(log.Fields{
+				"kind": intgr.Kind,
+			}).Warning("api/external: unknown integration kind")
+		}
+	}
+
+	// Global integrations
+	conf := config.Get()
+	for _, name := range conf.ApplicationServer.Integration.Enabled {
+		switch name {
+		case "mqtt":
+			out.Result = append(out.Result, &pb.IntegrationListItem{Kind: pb.IntegrationKind_MQTT_GLOBAL})
+		}
+
+	}
+
+	return &out, nil
+}
+
+// IntegrationHealth defines the delivery status of an integration. For
+// global integrations, the ID is not set and the kind equals the name of the
+// global integration.
+type IntegrationHealth struct {
+	ID     int64  `json:"id,string,omitempty"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Global bool   `json:"global"`
+	health.Status
+}
+
+// ListIntegrationHealthResponse defines the list integration health
+// response.
+type ListIntegrationHealthResponse struct {
+	Result []IntegrationHealth `json:"result"`
+}
+
+// ListIntegrationHealth lists the delivery status of all the integrations
+// (application and global) of the given application.
+func (a *ApplicationAPI) ListIntegrationHealth(ctx context.Context, applicationID int64) (*ListIntegrationHealthResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	integrations, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), applicationID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	out := ListIntegrationHealthResponse{
+		Result: make([]IntegrationHealth, 0, len(integrations)),
+	}
+
+	for _, intgr := range integrations {
+		s, err := health.Get(ctx, applicationID, integration.ApplicationIntegrationName(intgr))
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+
+		out.Result = append(out.Result, IntegrationHealth{
+			ID:     intgr.ID,
+			Kind:   intgr.Kind,
+			Name:   intgr.Name,
+			Status: s,
+		})
+	}
+
+	conf := config.Get()
+	for _, name := range conf.ApplicationServer.Integration.Enabled {
+		s, err := health.Get(ctx, applicationID, name)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+
+		out.Result = append(out.Result, IntegrationHealth{
+			Kind:   name,
+			Name:   name,
+			Global: true,
+			Status: s,
+		})
+	}
+
+	return &out, nil
+}
+
+// invalidateIntegrations invalidates the cached integrations of the given
+// application ID. As the integration change has already been persisted, an
+// error is logged but not returned.
+func invalidateIntegrations(ctx context.Context, applicationID int64) {
+	if err := integration.InvalidateApplicationIntegrations(ctx, applicationID); err != nil {
+		log.WithError(err).WithField("application_id", applicationID).Error("api/external: invalidate integrations error")
+	}
 }
@@ -0,0 +1,291 @@
+package external
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// registerHTTPHandlers registers the JSON API endpoints for the
+// application-integrations which are not covered by the gRPC API.
+func (a *ApplicationAPI) registerHTTPHandlers(r *mux.Router) {
+	// Health
+	r.Handle("/api/applications/{application_id}/integrations/health", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.ListIntegrationHealth(ctx, applicationID)
+	})).Methods("GET")
+
+	// Kafka
+	r.Handle("/api/applications/{application_id}/integrations/kafka", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req KafkaIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreateKafkaIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/kafka", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetKafkaIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/kafka", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req KafkaIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdateKafkaIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/kafka", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteKafkaIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+
+	// AMQP
+	r.Handle("/api/applications/{application_id}/integrations/amqp", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req AMQPIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreateAMQPIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/amqp", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetAMQPIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/amqp", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req AMQPIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdateAMQPIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/amqp", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteAMQPIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+
+	// NATS
+	r.Handle("/api/applications/{application_id}/integrations/nats", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req NATSIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreateNATSIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/nats", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetNATSIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/nats", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req NATSIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdateNATSIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/nats", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteNATSIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+
+	// Redis Streams
+	r.Handle("/api/applications/{application_id}/integrations/redis-streams", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req RedisStreamsIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreateRedisStreamsIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/redis-streams", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetRedisStreamsIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/redis-streams", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req RedisStreamsIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdateRedisStreamsIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/redis-streams", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteRedisStreamsIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+
+	// Elasticsearch / OpenSearch
+	r.Handle("/api/applications/{application_id}/integrations/elasticsearch", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req ElasticsearchIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreateElasticsearchIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/elasticsearch", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetElasticsearchIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/elasticsearch", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req ElasticsearchIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdateElasticsearchIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/elasticsearch", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeleteElasticsearchIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+
+	// PostgreSQL
+	r.Handle("/api/applications/{application_id}/integrations/postgresql", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req PostgreSQLIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.CreatePostgreSQLIntegration(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/postgresql", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.GetPostgreSQLIntegration(ctx, applicationID)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/postgresql", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req PostgreSQLIntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+				return nil, err
+			}
+		}
+		return a.UpdatePostgreSQLIntegration(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{application_id}/integrations/postgresql", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+		return a.DeletePostgreSQLIntegration(ctx, applicationID)
+	})).Methods("DELETE")
+}
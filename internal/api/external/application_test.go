@@ -15,6 +15,8 @@ import (
 	pb "github.com/brocaar/chirpstack-api/go/v3/as/external/api"
 	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver"
 	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/elasticsearch"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/mqtt"
 	"github.com/brocaar/chirpstack-application-server/internal/storage"
 	"github.com/brocaar/chirpstack-application-server/internal/test"
@@ -925,6 +927,325 @@ func (ts *APITestSuite) TestApplication() {
 			})
 		})
 
+		t.Run("Kafka", func(t *testing.T) {
+			t.Run("Create", func(t *testing.T) {
+				assert := require.New(t)
+
+				createReq := KafkaIntegrationRequest{
+					Integration: &KafkaIntegration{
+						ApplicationID: createResp.Id,
+						IntegrationKafkaConfig: config.IntegrationKafkaConfig{
+							Brokers:          []string{"localhost:9092"},
+							Topic:            "chirpstack_as",
+							EventKeyTemplate: "application.{{ .ApplicationID }}",
+						},
+					},
+				}
+				_, err := api.CreateKafkaIntegration(context.Background(), &createReq)
+				assert.NoError(err)
+
+				t.Run("Get", func(t *testing.T) {
+					assert := require.New(t)
+
+					i, err := api.GetKafkaIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(createReq.Integration, i.Integration)
+				})
+
+				t.Run("List", func(t *testing.T) {
+					assert := require.New(t)
+
+					resp, err := api.ListIntegrations(context.Background(), &pb.ListIntegrationRequest{ApplicationId: createResp.Id})
+					assert.NoError(err)
+					assert.EqualValues(0, resp.TotalCount)
+				})
+
+				t.Run("Update", func(t *testing.T) {
+					assert := require.New(t)
+
+					updateReq := KafkaIntegrationRequest{
+						Integration: &KafkaIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationKafkaConfig: config.IntegrationKafkaConfig{
+								Brokers:   []string{"localhost:9093"},
+								Topic:     "chirpstack_as_2",
+								Username:  "user",
+								Password:  "secret",
+								Mechanism: "plain",
+							},
+						},
+					}
+					_, err := api.UpdateKafkaIntegration(context.Background(), &updateReq)
+					assert.NoError(err)
+
+					i, err := api.GetKafkaIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(updateReq.Integration, i.Integration)
+				})
+
+				t.Run("Update invalid mechanism", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.UpdateKafkaIntegration(context.Background(), &KafkaIntegrationRequest{
+						Integration: &KafkaIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationKafkaConfig: config.IntegrationKafkaConfig{
+								Brokers:  []string{"localhost:9093"},
+								Topic:    "chirpstack_as",
+								Username: "user",
+							},
+						},
+					})
+					assert.Equal(codes.InvalidArgument, grpc.Code(err))
+				})
+
+				t.Run("Delete", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.DeleteKafkaIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+
+					_, err = api.GetKafkaIntegration(context.Background(), createResp.Id)
+					assert.Equal(codes.NotFound, grpc.Code(err))
+				})
+			})
+		})
+
+		t.Run("NATS", func(t *testing.T) {
+			t.Run("Create", func(t *testing.T) {
+				assert := require.New(t)
+
+				createReq := NATSIntegrationRequest{
+					Integration: &NATSIntegration{
+						ApplicationID: createResp.Id,
+						IntegrationNATSConfig: config.IntegrationNATSConfig{
+							Server:               "nats://localhost:4222",
+							EventSubjectTemplate: "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}",
+						},
+					},
+				}
+				_, err := api.CreateNATSIntegration(context.Background(), &createReq)
+				assert.NoError(err)
+
+				t.Run("Get", func(t *testing.T) {
+					assert := require.New(t)
+
+					i, err := api.GetNATSIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(createReq.Integration, i.Integration)
+				})
+
+				t.Run("Update", func(t *testing.T) {
+					assert := require.New(t)
+
+					updateReq := NATSIntegrationRequest{
+						Integration: &NATSIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationNATSConfig: config.IntegrationNATSConfig{
+								Server:               "nats://localhost:4223",
+								EventSubjectTemplate: "events.{{ .EventType }}",
+								JetStream:            true,
+							},
+						},
+					}
+					_, err := api.UpdateNATSIntegration(context.Background(), &updateReq)
+					assert.NoError(err)
+
+					i, err := api.GetNATSIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(updateReq.Integration, i.Integration)
+				})
+
+				t.Run("Update invalid subject template", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.UpdateNATSIntegration(context.Background(), &NATSIntegrationRequest{
+						Integration: &NATSIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationNATSConfig: config.IntegrationNATSConfig{
+								Server:               "nats://localhost:4222",
+								EventSubjectTemplate: "events.{{ .EventType",
+							},
+						},
+					})
+					assert.Equal(codes.InvalidArgument, grpc.Code(err))
+				})
+
+				t.Run("Delete", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.DeleteNATSIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+
+					_, err = api.GetNATSIntegration(context.Background(), createResp.Id)
+					assert.Equal(codes.NotFound, grpc.Code(err))
+				})
+			})
+		})
+
+		t.Run("Redis Streams", func(t *testing.T) {
+			t.Run("Create", func(t *testing.T) {
+				assert := require.New(t)
+
+				createReq := RedisStreamsIntegrationRequest{
+					Integration: &RedisStreamsIntegration{
+						ApplicationID: createResp.Id,
+						IntegrationRedisStreamsConfig: config.IntegrationRedisStreamsConfig{
+							StreamKeyTemplate: "application:{{ .ApplicationID }}:events",
+							MaxLen:            1000,
+						},
+					},
+				}
+				_, err := api.CreateRedisStreamsIntegration(context.Background(), &createReq)
+				assert.NoError(err)
+
+				t.Run("Get", func(t *testing.T) {
+					assert := require.New(t)
+
+					i, err := api.GetRedisStreamsIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(createReq.Integration, i.Integration)
+				})
+
+				t.Run("Update", func(t *testing.T) {
+					assert := require.New(t)
+
+					updateReq := RedisStreamsIntegrationRequest{
+						Integration: &RedisStreamsIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationRedisStreamsConfig: config.IntegrationRedisStreamsConfig{
+								Server:            "localhost:6380",
+								StreamKeyTemplate: "events:{{ .EventType }}",
+								MaxLen:            100,
+							},
+						},
+					}
+					_, err := api.UpdateRedisStreamsIntegration(context.Background(), &updateReq)
+					assert.NoError(err)
+
+					i, err := api.GetRedisStreamsIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(updateReq.Integration, i.Integration)
+				})
+
+				t.Run("Update invalid max length", func(t *testing.T) {
+					assert := require.New(t)
+
+					for _, maxLen := range []int64{-1, 0, config.Get().ApplicationServer.Integration.RedisStreams.MaxLenLimit + 1} {
+						_, err := api.UpdateRedisStreamsIntegration(context.Background(), &RedisStreamsIntegrationRequest{
+							Integration: &RedisStreamsIntegration{
+								ApplicationID: createResp.Id,
+								IntegrationRedisStreamsConfig: config.IntegrationRedisStreamsConfig{
+									StreamKeyTemplate: "events",
+									MaxLen:            maxLen,
+								},
+							},
+						})
+						assert.Equal(codes.InvalidArgument, grpc.Code(err), "max length %d", maxLen)
+					}
+				})
+
+				t.Run("Update per-device stream key", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.UpdateRedisStreamsIntegration(context.Background(), &RedisStreamsIntegrationRequest{
+						Integration: &RedisStreamsIntegration{
+							ApplicationID: createResp.Id,
+							IntegrationRedisStreamsConfig: config.IntegrationRedisStreamsConfig{
+								StreamKeyTemplate: "device:{{ .DevEUI }}",
+								MaxLen:            100,
+							},
+						},
+					})
+					assert.Equal(codes.InvalidArgument, grpc.Code(err))
+				})
+
+				t.Run("Delete", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.DeleteRedisStreamsIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+
+					_, err = api.GetRedisStreamsIntegration(context.Background(), createResp.Id)
+					assert.Equal(codes.NotFound, grpc.Code(err))
+				})
+			})
+		})
+
+		t.Run("Elasticsearch", func(t *testing.T) {
+			t.Run("Create", func(t *testing.T) {
+				assert := require.New(t)
+
+				createReq := ElasticsearchIntegrationRequest{
+					Integration: &ElasticsearchIntegration{
+						ApplicationID: createResp.Id,
+						Config: elasticsearch.Config{
+							Endpoint:  "https://localhost:9200",
+							BatchSize: 100,
+						},
+					},
+				}
+				_, err := api.CreateElasticsearchIntegration(context.Background(), &createReq)
+				assert.NoError(err)
+
+				t.Run("Get", func(t *testing.T) {
+					assert := require.New(t)
+
+					i, err := api.GetElasticsearchIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(createReq.Integration, i.Integration)
+				})
+
+				t.Run("Update", func(t *testing.T) {
+					assert := require.New(t)
+
+					updateReq := ElasticsearchIntegrationRequest{
+						Integration: &ElasticsearchIntegration{
+							ApplicationID: createResp.Id,
+							Config: elasticsearch.Config{
+								Endpoint:             "https://opensearch:9200",
+								Username:             "admin",
+								Password:             "admin",
+								IndexTemplate:        `events-{{ .Time.Format "2006.01" }}`,
+								FlushIntervalSeconds: 10,
+								MaxRetries:           5,
+							},
+						},
+					}
+					_, err := api.UpdateElasticsearchIntegration(context.Background(), &updateReq)
+					assert.NoError(err)
+
+					i, err := api.GetElasticsearchIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+					assert.Equal(updateReq.Integration, i.Integration)
+				})
+
+				t.Run("Update invalid endpoint", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.UpdateElasticsearchIntegration(context.Background(), &ElasticsearchIntegrationRequest{
+						Integration: &ElasticsearchIntegration{
+							ApplicationID: createResp.Id,
+							Config: elasticsearch.Config{
+								Endpoint: "localhost:9200",
+							},
+						},
+					})
+					assert.Equal(codes.InvalidArgument, grpc.Code(err))
+				})
+
+				t.Run("Delete", func(t *testing.T) {
+					assert := require.New(t)
+
+					_, err := api.DeleteElasticsearchIntegration(context.Background(), createResp.Id)
+					assert.NoError(err)
+
+					_, err = api.GetElasticsearchIntegration(context.Background(), createResp.Id)
+					assert.Equal(codes.NotFound, grpc.Code(err))
+				})
+			})
+		})
+
 		t.Run("MQTT", func(t *testing.T) {
 			t.Run("Generate certificate", func(t *testing.T) {
 				assert := require.New(t)
@@ -0,0 +1,255 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/api/external/auth"
+	"github.com/brocaar/chirpstack-application-server/internal/api/helpers"
+	"github.com/brocaar/chirpstack-application-server/internal/codec"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+// PayloadCodec defines a registered payload codec.
+type PayloadCodec struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Scripts is set when the codec uses the payload encoder and decoder
+	// scripts.
+	Scripts bool `json:"scripts"`
+
+	// SettingsSchema contains the JSON Schema of the codec settings.
+	SettingsSchema json.RawMessage `json:"settingsSchema"`
+}
+
+// ListPayloadCodecsResponse defines the list payload codecs response.
+type ListPayloadCodecsResponse struct {
+	Result []PayloadCodec `json:"result"`
+}
+
+// PayloadCodecSettings defines the payload codec settings of a
+// device-profile or application.
+type PayloadCodecSettings struct {
+	PayloadCodec         string          `json:"payloadCodec"`
+	PayloadEncoderScript string          `json:"payloadEncoderScript"`
+	PayloadDecoderScript string          `json:"payloadDecoderScript"`
+	Settings             json.RawMessage `json:"settings"`
+}
+
+// PayloadCodecAPI exports the payload codec related functions.
+type PayloadCodecAPI struct {
+	validator auth.Validator
+}
+
+// NewPayloadCodecAPI creates a new PayloadCodecAPI.
+func NewPayloadCodecAPI(validator auth.Validator) *PayloadCodecAPI {
+	return &PayloadCodecAPI{
+		validator: validator,
+	}
+}
+
+// List lists the registered payload codecs.
+func (a *PayloadCodecAPI) List(ctx context.Context) (*ListPayloadCodecsResponse, error) {
+	if _, err := a.validator.GetSubject(ctx); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	resp := ListPayloadCodecsResponse{
+		Result: []PayloadCodec{},
+	}
+
+	for _, info := range codec.List() {
+		resp.Result = append(resp.Result, PayloadCodec{
+			Name:           string(info.Name),
+			Description:    info.Description,
+			Scripts:        info.Scripts,
+			SettingsSchema: info.SettingsSchema,
+		})
+	}
+
+	return &resp, nil
+}
+
+// GetDeviceProfileSettings returns the payload codec settings of the given
+// device-profile.
+func (a *PayloadCodecAPI) GetDeviceProfileSettings(ctx context.Context, id uuid.UUID) (*PayloadCodecSettings, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, id),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return getPayloadCodecSettings(dp.PayloadCodec, dp.CodecSettings()), nil
+}
+
+// UpdateDeviceProfileSettings updates the payload codec settings of the
+// given device-profile.
+func (a *PayloadCodecAPI) UpdateDeviceProfileSettings(ctx context.Context, id uuid.UUID, req *PayloadCodecSettings) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Update, id),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validatePayloadCodecSettings(req); err != nil {
+		return nil, err
+	}
+
+	// As this also performs a remote call to update the device-profile
+	// on the network-server, wrap it in a transaction.
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		dp, err := storage.GetDeviceProfile(ctx, tx, id, true, false)
+		if err != nil {
+			return err
+		}
+
+		dp.PayloadCodec = codec.Type(req.PayloadCodec)
+		dp.PayloadEncoderScript = req.PayloadEncoderScript
+		dp.PayloadDecoderScript = req.PayloadDecoderScript
+		dp.PayloadCodecSettings = req.Settings
+
+		return storage.UpdateDeviceProfile(ctx, tx, &dp)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetApplicationSettings returns the payload codec settings of the given
+// application.
+func (a *PayloadCodecAPI) GetApplicationSettings(ctx context.Context, id int64) (*PayloadCodecSettings, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(id, auth.Read),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return getPayloadCodecSettings(app.PayloadCodec, app.CodecSettings()), nil
+}
+
+// UpdateApplicationSettings updates the payload codec settings of the given
+// application.
+func (a *PayloadCodecAPI) UpdateApplicationSettings(ctx context.Context, id int64, req *PayloadCodecSettings) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(id, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validatePayloadCodecSettings(req); err != nil {
+		return nil, err
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	app.PayloadCodec = codec.Type(req.PayloadCodec)
+	app.PayloadEncoderScript = req.PayloadEncoderScript
+	app.PayloadDecoderScript = req.PayloadDecoderScript
+	app.PayloadCodecSettings = req.Settings
+
+	if err := storage.UpdateApplication(ctx, storage.DB(), app); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// registerHTTPHandlers registers the JSON API endpoints.
+func (a *PayloadCodecAPI) registerHTTPHandlers(r *mux.Router) {
+	r.Handle("/api/payload-codecs", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		return a.List(ctx)
+	})).Methods("GET")
+
+	r.Handle("/api/device-profiles/{id}/payload-codec", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := uuidVar(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.GetDeviceProfileSettings(ctx, id)
+	})).Methods("GET")
+
+	r.Handle("/api/device-profiles/{id}/payload-codec", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := uuidVar(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		var req PayloadCodecSettings
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		return a.UpdateDeviceProfileSettings(ctx, id, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/applications/{id}/payload-codec", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := int64Var(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.GetApplicationSettings(ctx, id)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{id}/payload-codec", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := int64Var(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		var req PayloadCodecSettings
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		return a.UpdateApplicationSettings(ctx, id, &req)
+	})).Methods("PUT")
+}
+
+// validatePayloadCodecSettings validates the given settings against the
+// codec, so that the validation error can be returned to the client.
+func validatePayloadCodecSettings(req *PayloadCodecSettings) error {
+	err := codec.Validate(codec.Type(req.PayloadCodec), codec.Settings{
+		EncoderScript: req.PayloadEncoderScript,
+		DecoderScript: req.PayloadDecoderScript,
+		Config:        req.Settings,
+	})
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	return nil
+}
+
+func getPayloadCodecSettings(t codec.Type, settings codec.Settings) *PayloadCodecSettings {
+	return &PayloadCodecSettings{
+		PayloadCodec:         string(t),
+		PayloadEncoderScript: settings.EncoderScript,
+		PayloadDecoderScript: settings.DecoderScript,
+		Settings:             settings.Config,
+	}
+}
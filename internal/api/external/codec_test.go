@@ -0,0 +1,107 @@
+package external
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver"
+	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/brocaar/chirpstack-application-server/internal/codec"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+func (ts *APITestSuite) TestPayloadCodec() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	validator := &TestValidator{}
+	api := NewPayloadCodecAPI(validator)
+
+	n := storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+
+	app := storage.Application{
+		Name:           "test-app",
+		OrganizationID: org.ID,
+	}
+	copy(app.ServiceProfileID[:], sp.ServiceProfile.Id)
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	ts.T().Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		resp, err := api.List(context.Background())
+		assert.NoError(err)
+		assert.Len(resp.Result, len(codec.List()))
+
+		var names []string
+		for _, c := range resp.Result {
+			names = append(names, c.Name)
+		}
+		assert.Contains(names, string(codec.CayenneLPPType))
+		assert.Contains(names, string(codec.CustomJSType))
+	})
+
+	ts.T().Run("Update with unknown codec", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := api.UpdateApplicationSettings(context.Background(), app.ID, &PayloadCodecSettings{
+			PayloadCodec: "FOO",
+		})
+		assert.Equal(codes.InvalidArgument, grpc.Code(err))
+	})
+
+	ts.T().Run("Update with invalid settings", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := api.UpdateApplicationSettings(context.Background(), app.ID, &PayloadCodecSettings{
+			PayloadCodec: string(codec.CayenneLPPType),
+			Settings:     json.RawMessage(`{"foo":"bar"}`),
+		})
+		assert.Equal(codes.InvalidArgument, grpc.Code(err))
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := api.UpdateApplicationSettings(context.Background(), app.ID, &PayloadCodecSettings{
+			PayloadCodec:         string(codec.CustomJSType),
+			PayloadEncoderScript: "Encode() {}",
+			PayloadDecoderScript: "Decode() {}",
+		})
+		assert.NoError(err)
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := api.GetApplicationSettings(context.Background(), app.ID)
+			assert.NoError(err)
+			assert.Equal(string(codec.CustomJSType), resp.PayloadCodec)
+			assert.Equal("Encode() {}", resp.PayloadEncoderScript)
+			assert.Equal("Decode() {}", resp.PayloadDecoderScript)
+			assert.JSONEq(`{}`, string(resp.Settings))
+		})
+	})
+}
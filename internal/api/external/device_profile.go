@@ -217,6 +217,9 @@ func (a *DeviceProfileServiceAPI) Update(ctx context.Context, req *pb.UpdateDevi
 		}
 
 		dp.Name = req.DeviceProfile.Name
+		if dp.PayloadCodec != codec.Type(req.DeviceProfile.PayloadCodec) {
+			dp.PayloadCodecSettings = nil
+		}
 		dp.PayloadCodec = codec.Type(req.DeviceProfile.PayloadCodec)
 		dp.PayloadEncoderScript = req.DeviceProfile.PayloadEncoderScript
 		dp.PayloadDecoderScript = req.DeviceProfile.PayloadDecoderScript
@@ -0,0 +1,152 @@
+package external
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq/hstore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/api/external/auth"
+	"github.com/brocaar/chirpstack-application-server/internal/codec"
+)
+
+// The logs returned by TestPayloadCodec are limited in number of lines and
+// total size. When exceeded, the remaining lines are dropped.
+const (
+	maxTestPayloadCodecLogLines = 100
+	maxTestPayloadCodecLogSize  = 16 * 1024
+)
+
+// TestPayloadCodecRequest defines the test payload codec request. Either
+// the bytes (base64 encoded), the hexBytes or the jsonObject must be set.
+// Bytes are decoded to a JSON object, a JSON object is encoded to bytes.
+type TestPayloadCodecRequest struct {
+	OrganizationID       int64             `json:"organizationID,string"`
+	PayloadCodec         string            `json:"payloadCodec"`
+	PayloadEncoderScript string            `json:"payloadEncoderScript"`
+	PayloadDecoderScript string            `json:"payloadDecoderScript"`
+	Settings             json.RawMessage   `json:"settings"`
+	FPort                uint8             `json:"fPort"`
+	Variables            map[string]string `json:"variables"`
+	Bytes                []byte            `json:"bytes"`
+	HexBytes             string            `json:"hexBytes"`
+	JSONObject           string            `json:"jsonObject"`
+}
+
+// TestPayloadCodecResponse defines the test payload codec response. The
+// error line and column are set when the codec error contains the position
+// within the script. LogsTruncated is set when log lines have been dropped.
+type TestPayloadCodecResponse struct {
+	JSONObject    string   `json:"jsonObject,omitempty"`
+	Bytes         []byte   `json:"bytes,omitempty"`
+	HexBytes      string   `json:"hexBytes,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	ErrorLine     int      `json:"errorLine,omitempty"`
+	ErrorColumn   int      `json:"errorColumn,omitempty"`
+	ExecutionTime string   `json:"executionTime"`
+	Logs          []string `json:"logs"`
+	LogsTruncated bool     `json:"logsTruncated,omitempty"`
+}
+
+// TestPayloadCodec runs the given (not yet stored) payload codec against
+// the given payload, without enqueueing or forwarding anything.
+func (a *DeviceProfileServiceAPI) TestPayloadCodec(ctx context.Context, req *TestPayloadCodecRequest) (*TestPayloadCodecResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfilesAccess(auth.Create, req.OrganizationID, 0),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	t := codec.Type(req.PayloadCodec)
+	if t == codec.None {
+		return nil, grpc.Errorf(codes.InvalidArgument, "payloadCodec must be set")
+	}
+
+	settings := codec.Settings{
+		EncoderScript: req.PayloadEncoderScript,
+		DecoderScript: req.PayloadDecoderScript,
+		Config:        req.Settings,
+	}
+	if err := codec.Validate(t, settings); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	b := req.Bytes
+	if req.HexBytes != "" {
+		var err error
+		b, err = hex.DecodeString(req.HexBytes)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "decode hexBytes error: %s", err)
+		}
+	}
+
+	if (len(b) != 0) == (req.JSONObject != "") {
+		return nil, grpc.Errorf(codes.InvalidArgument, "either bytes, hexBytes or jsonObject must be set")
+	}
+
+	vars := hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range req.Variables {
+		vars.Map[k] = sql.NullString{String: v, Valid: true}
+	}
+
+	resp := TestPayloadCodecResponse{
+		Logs: []string{},
+	}
+	var logSize int
+	ctx = codec.WithLogFunc(ctx, func(msg string) {
+		if len(resp.Logs) >= maxTestPayloadCodecLogLines || logSize+len(msg) > maxTestPayloadCodecLogSize {
+			resp.LogsTruncated = true
+			return
+		}
+		logSize += len(msg)
+		resp.Logs = append(resp.Logs, msg)
+	})
+
+	var out []byte
+	var err error
+	start := time.Now()
+	if req.JSONObject != "" {
+		out, err = codec.JSONToBinary(ctx, t, req.FPort, vars, settings, []byte(req.JSONObject))
+	} else {
+		out, err = codec.BinaryToJSON(ctx, t, req.FPort, vars, settings, b)
+	}
+	resp.ExecutionTime = strconv.FormatFloat(time.Since(start).Seconds(), 'f', -1, 64) + "s"
+
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorLine, resp.ErrorColumn, _ = codec.ErrorPosition(err)
+		return &resp, nil
+	}
+
+	if req.JSONObject != "" {
+		resp.Bytes = out
+		resp.HexBytes = hex.EncodeToString(out)
+	} else {
+		resp.JSONObject = string(out)
+	}
+
+	return &resp, nil
+}
+
+// registerHTTPHandlers registers the JSON API endpoints for the
+// device-profiles which are not covered by the gRPC API.
+func (a *DeviceProfileServiceAPI) registerHTTPHandlers(r *mux.Router) {
+	r.Handle("/api/device-profiles/test-payload-codec", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req TestPayloadCodecRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		return a.TestPayloadCodec(ctx, &req)
+	})).Methods("POST")
+}
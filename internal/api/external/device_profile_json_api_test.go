@@ -0,0 +1,194 @@
+package external
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/codec"
+)
+
+func TestTestPayloadCodec(t *testing.T) {
+	api := NewDeviceProfileServiceAPI(&TestValidator{})
+
+	var maxLines []string
+	for i := 0; i < maxTestPayloadCodecLogLines; i++ {
+		maxLines = append(maxLines, "line")
+	}
+
+	var maxSize []string
+	for i := 0; i < maxTestPayloadCodecLogSize/1024; i++ {
+		maxSize = append(maxSize, strings.Repeat("x", 1024))
+	}
+
+	tests := []struct {
+		Name             string
+		Request          TestPayloadCodecRequest
+		ExpectedCode     codes.Code
+		ExpectedResponse TestPayloadCodecResponse
+	}{
+		{
+			Name: "no codec",
+			Request: TestPayloadCodecRequest{
+				HexBytes: "01",
+			},
+			ExpectedCode: codes.InvalidArgument,
+		},
+		{
+			Name: "unknown codec",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: "FOO",
+				HexBytes:     "01",
+			},
+			ExpectedCode: codes.InvalidArgument,
+		},
+		{
+			Name: "no payload",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CayenneLPPType),
+			},
+			ExpectedCode: codes.InvalidArgument,
+		},
+		{
+			Name: "bytes and json object",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CayenneLPPType),
+				HexBytes:     "01",
+				JSONObject:   "{}",
+			},
+			ExpectedCode: codes.InvalidArgument,
+		},
+		{
+			Name: "decode",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CustomJSType),
+				PayloadDecoderScript: `
+function Decode(fPort, bytes, variables) {
+	console.log("decoding", bytes.length, "bytes");
+	return {"fPort": fPort, "value": bytes[0], "foo": variables.foo};
+}
+`,
+				FPort:     10,
+				Variables: map[string]string{"foo": "bar"},
+				HexBytes:  "05",
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				JSONObject: `{"fPort":10,"foo":"bar","value":5}`,
+				Logs:       []string{"decoding 1 bytes"},
+			},
+		},
+		{
+			Name: "encode",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec:         string(codec.CustomJSType),
+				PayloadEncoderScript: `function Encode(fPort, obj) { return [fPort, obj.value]; }`,
+				FPort:                10,
+				JSONObject:           `{"value":5}`,
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				Bytes:    []byte{10, 5},
+				HexBytes: "0a05",
+				Logs:     []string{},
+			},
+		},
+		{
+			Name: "binary layout",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.BinaryLayoutType),
+				Settings:     json.RawMessage(`{"schema":"fields: [{name: temperature, length: 2, type: int, scale: 0.1}]"}`),
+				HexBytes:     "00eb",
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				JSONObject: `{"temperature":23.5}`,
+				Logs:       []string{},
+			},
+		},
+		{
+			Name: "binary layout without schema",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.BinaryLayoutType),
+				HexBytes:     "00eb",
+			},
+			ExpectedCode: codes.InvalidArgument,
+		},
+		{
+			Name: "log lines truncated",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CustomJSType),
+				PayloadDecoderScript: `
+function Decode(fPort, bytes) {
+	for (var i = 0; i < 1000; i++) {
+		console.log("line");
+	}
+	return {};
+}
+`,
+				Bytes: []byte{1},
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				JSONObject:    `{}`,
+				Logs:          maxLines,
+				LogsTruncated: true,
+			},
+		},
+		{
+			Name: "log size truncated",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CustomJSType),
+				PayloadDecoderScript: `
+function Decode(fPort, bytes) {
+	for (var i = 0; i < 20; i++) {
+		console.log("x".repeat(1024));
+	}
+	return {};
+}
+`,
+				Bytes: []byte{1},
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				JSONObject:    `{}`,
+				Logs:          maxSize,
+				LogsTruncated: true,
+			},
+		},
+		{
+			Name: "script error",
+			Request: TestPayloadCodecRequest{
+				PayloadCodec: string(codec.CustomJSType),
+				PayloadDecoderScript: `
+function Decode(fPort, bytes) {
+	throw new Error("invalid payload");
+}
+`,
+				Bytes: []byte{1},
+			},
+			ExpectedResponse: TestPayloadCodecResponse{
+				Error:       "execute js error: js vm error: Error: invalid payload at Decode (codec.js:3:8(3))",
+				ErrorLine:   3,
+				ErrorColumn: 8,
+				Logs:        []string{},
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := api.TestPayloadCodec(context.Background(), &tst.Request)
+			assert.Equal(tst.ExpectedCode, grpc.Code(err))
+			if err != nil {
+				return
+			}
+
+			assert.NotEmpty(resp.ExecutionTime)
+			resp.ExecutionTime = ""
+			assert.Equal(tst.ExpectedResponse, *resp)
+		})
+	}
+}
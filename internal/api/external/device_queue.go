@@ -76,14 +76,14 @@ func (d *DeviceQueueAPI) Enqueue(ctx context.Context, req *pb.EnqueueDeviceQueue
 			// TODO: in the next major release, remove this and always use the
 			// device-profile codec fields.
 			payloadCodec := app.PayloadCodec
-			payloadEncoderScript := app.PayloadEncoderScript
+			codecSettings := app.CodecSettings()
 
 			if dp.PayloadCodec != "" {
 				payloadCodec = dp.PayloadCodec
-				payloadEncoderScript = dp.PayloadEncoderScript
+				codecSettings = dp.CodecSettings()
 			}
 
-			req.DeviceQueueItem.Data, err = codec.JSONToBinary(payloadCodec, uint8(req.DeviceQueueItem.FPort), dev.Variables, payloadEncoderScript, []byte(req.DeviceQueueItem.JsonObject))
+			req.DeviceQueueItem.Data, err = codec.JSONToBinary(ctx, payloadCodec, uint8(req.DeviceQueueItem.FPort), dev.Variables, codecSettings, []byte(req.DeviceQueueItem.JsonObject))
 			if err != nil {
 				return helpers.ErrToRPCError(err)
 			}
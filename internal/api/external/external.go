@@ -150,7 +150,7 @@ func setupAPI(conf config.Config) error {
 	time.Sleep(time.Millisecond * 100)
 
 	// setup the HTTP handler
-	clientHTTPHandler, err = setupHTTPAPI(conf)
+	clientHTTPHandler, err = setupHTTPAPI(conf, validator)
 	if err != nil {
 		return err
 	}
@@ -158,9 +158,17 @@ func setupAPI(conf config.Config) error {
 	return nil
 }
 
-func setupHTTPAPI(conf config.Config) (http.Handler, error) {
+func setupHTTPAPI(conf config.Config, validator auth.Validator) (http.Handler, error) {
 	r := mux.NewRouter()
 
+	// setup the json api endpoints which are not covered by the grpc-gateway
+	NewIntegrationOutboxAPI(validator).registerHTTPHandlers(r)
+	NewHTTPIntegrationAPI(validator).registerHTTPHandlers(r)
+	NewApplicationAPI(validator).registerHTTPHandlers(r)
+	NewDeviceProfileServiceAPI(validator).registerHTTPHandlers(r)
+	NewIntegrationAPI(validator).registerHTTPHandlers(r)
+	NewPayloadCodecAPI(validator).registerHTTPHandlers(r)
+
 	// setup json api handler
 	jsonHandler, err := getJSONGateway(context.Background())
 	if err != nil {
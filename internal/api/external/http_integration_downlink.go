@@ -0,0 +1,292 @@
+package external
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/api/external/auth"
+	"github.com/brocaar/chirpstack-application-server/internal/api/helpers"
+	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	httpint "github.com/brocaar/chirpstack-application-server/internal/integration/http"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+// HTTPIntegrationDownlinkRequest defines the request for enqueueing a
+// downlink through the HTTP integration. Either the Data or the Object
+// (which will be encoded using the device codec) must be set.
+type HTTPIntegrationDownlinkRequest struct {
+	ApplicationID int64           `json:"-"`
+	DevEUI        lorawan.EUI64   `json:"-"`
+	Confirmed     bool            `json:"confirmed"`
+	FPort         uint8           `json:"fPort"`
+	Data          []byte          `json:"data"`
+	Object        json.RawMessage `json:"object"`
+
+	// Method, Body and Signature contain the request method, the raw request
+	// body and the value of the signature header. When the signature is set,
+	// it is validated using the downlink secrets of the enabled HTTP
+	// integrations of the application instead of the API token.
+	Method    string `json:"-"`
+	Body      []byte `json:"-"`
+	Signature string `json:"-"`
+}
+
+// maxHTTPIntegrationDownlinkBodySize defines the max. size of the downlink
+// request body.
+const maxHTTPIntegrationDownlinkBodySize = 64 * 1024
+
+// httpIntegrationDownlinkSignatureKey defines the key used to store the used
+// downlink signatures, so that these can only be used once.
+const httpIntegrationDownlinkSignatureKey = "lora:as:http:downlink:signature:%d:%s"
+
+// HTTPIntegrationDownlinkResponse defines the response for enqueueing a
+// downlink through the HTTP integration.
+type HTTPIntegrationDownlinkResponse struct {
+	FCnt uint32 `json:"fCnt"`
+}
+
+// HTTPIntegrationDownlinkSecretResponse defines the downlink secret response.
+type HTTPIntegrationDownlinkSecretResponse struct {
+	DownlinkSecret string `json:"downlinkSecret"`
+}
+
+// HTTPIntegrationAPI exports the HTTP integration related functions which
+// are not covered by the ApplicationAPI.
+type HTTPIntegrationAPI struct {
+	validator auth.Validator
+}
+
+// NewHTTPIntegrationAPI creates a new HTTPIntegrationAPI.
+func NewHTTPIntegrationAPI(validator auth.Validator) *HTTPIntegrationAPI {
+	return &HTTPIntegrationAPI{
+		validator: validator,
+	}
+}
+
+// Downlink enqueues the given downlink payload through the same path as
+// the downlink payloads received by the MQTT integration. It returns after
+// the payload has been encoded and enqueued, so that codec and enqueue
+// errors are returned to the caller.
+func (a *HTTPIntegrationAPI) Downlink(ctx context.Context, req *HTTPIntegrationDownlinkRequest) (*HTTPIntegrationDownlinkResponse, error) {
+	if req.Signature != "" {
+		if err := validateHTTPIntegrationDownlinkSignature(ctx, req); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := a.validator.Validate(ctx,
+			auth.ValidateDeviceQueueAccess(req.DevEUI, auth.Create),
+		); err != nil {
+			return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+		}
+	}
+
+	if req.FPort == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "fPort must be > 0")
+	}
+
+	d, err := storage.GetDevice(ctx, storage.DB(), req.DevEUI, false, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if d.ApplicationID != req.ApplicationID {
+		return nil, grpc.Errorf(codes.NotFound, "device does not exist for given application")
+	}
+
+	pl := models.DataDownPayload{
+		ApplicationID: req.ApplicationID,
+		DevEUI:        req.DevEUI,
+		Confirmed:     req.Confirmed,
+		FPort:         req.FPort,
+		Data:          req.Data,
+		Object:        req.Object,
+		ResultChan:    make(chan models.DataDownResult, 1),
+	}
+
+	select {
+	case httpint.DownlinkChan() <- pl:
+	case <-ctx.Done():
+		return nil, grpc.Errorf(codes.DeadlineExceeded, "enqueue downlink payload: %s", ctx.Err())
+	}
+
+	var res models.DataDownResult
+	select {
+	case res = <-pl.ResultChan:
+	case <-ctx.Done():
+		return nil, grpc.Errorf(codes.DeadlineExceeded, "enqueue downlink payload: %s", ctx.Err())
+	}
+
+	if res.Error != nil {
+		return nil, helpers.ErrToRPCError(res.Error)
+	}
+
+	return &HTTPIntegrationDownlinkResponse{FCnt: res.FCnt}, nil
+}
+
+// CreateDownlinkSecret generates a new downlink secret for the HTTP
+// integration with the given name (empty for the default integration) of
+// the given application, replacing the existing one.
+func (a *HTTPIntegrationAPI) CreateDownlinkSecret(ctx context.Context, applicationID int64, name string) (*HTTPIntegrationDownlinkSecretResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	secret, err := httpint.NewDownlinkSecret()
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := updateHTTPIntegrationDownlinkSecret(ctx, applicationID, name, secret); err != nil {
+		return nil, err
+	}
+
+	return &HTTPIntegrationDownlinkSecretResponse{DownlinkSecret: secret}, nil
+}
+
+// DeleteDownlinkSecret removes the downlink secret of the HTTP integration
+// with the given name (empty for the default integration) of the given
+// application.
+func (a *HTTPIntegrationAPI) DeleteDownlinkSecret(ctx context.Context, applicationID int64, name string) (*struct{}, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(applicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := updateHTTPIntegrationDownlinkSecret(ctx, applicationID, name, ""); err != nil {
+		return nil, err
+	}
+
+	return &struct{}{}, nil
+}
+
+// registerHTTPHandlers registers the JSON API endpoints.
+func (a *HTTPIntegrationAPI) registerHTTPHandlers(r *mux.Router) {
+	r.Handle("/api/applications/{application_id}/devices/{dev_eui}/downlink", maxBytesHandler(maxHTTPIntegrationDownlinkBodySize, jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "read body error: %s", err)
+		}
+
+		var req HTTPIntegrationDownlinkRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "decode json body error: %s", err)
+		}
+		req.Method = r.Method
+		req.Body = body
+		req.Signature = r.Header.Get(httpint.DownlinkSignatureHeader)
+
+		if req.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+			return nil, err
+		}
+		if err := req.DevEUI.UnmarshalText([]byte(mux.Vars(r)["dev_eui"])); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "dev_eui: %s", err)
+		}
+
+		return a.Downlink(ctx, &req)
+	}))).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/http/downlink-secret", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.CreateDownlinkSecret(ctx, applicationID, r.URL.Query().Get("name"))
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/http/downlink-secret", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		applicationID, err := int64Var(r, "application_id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.DeleteDownlinkSecret(ctx, applicationID, r.URL.Query().Get("name"))
+	})).Methods("DELETE")
+}
+
+// maxBytesHandler limits the size of the request body to n bytes.
+func maxBytesHandler(n int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// validateHTTPIntegrationDownlinkSignature validates the signature of the
+// given request against the downlink secrets of the enabled HTTP integrations
+// of the application. A signature can only be used once.
+func validateHTTPIntegrationDownlinkSignature(ctx context.Context, req *HTTPIntegrationDownlinkRequest) error {
+	ints, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), req.ApplicationID)
+	if err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+
+	now := time.Now()
+	for _, appint := range ints {
+		if appint.Kind != integration.HTTP || !appint.Enabled {
+			continue
+		}
+
+		var conf httpint.Config
+		if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+			return grpc.Errorf(codes.Internal, "unmarshal http integration settings error: %s", err)
+		}
+
+		id, err := conf.ValidateDownlinkSignature(req.Method, req.ApplicationID, req.DevEUI, req.Body, req.Signature, now)
+		if err != nil {
+			continue
+		}
+
+		// The signature is valid for DefaultSignatureTolerance before and
+		// after its timestamp.
+		key := storage.GetRedisKey(httpIntegrationDownlinkSignatureKey, req.ApplicationID, id)
+		set, err := storage.RedisClient().SetNX(ctx, key, "used", 2*httpint.DefaultSignatureTolerance).Result()
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "store downlink signature error: %s", err)
+		}
+		if !set {
+			return grpc.Errorf(codes.Unauthenticated, "authentication failed: downlink signature has already been used")
+		}
+
+		return nil
+	}
+
+	return grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", httpint.ErrInvalidDownlinkSignature)
+}
+
+func updateHTTPIntegrationDownlinkSecret(ctx context.Context, applicationID int64, name, secret string) error {
+	appint, err := storage.GetIntegrationByName(ctx, storage.DB(), applicationID, integration.HTTP, name)
+	if err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+
+	var conf httpint.Config
+	if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+		return grpc.Errorf(codes.Internal, "unmarshal http integration settings error: %s", err)
+	}
+	conf.DownlinkSecret = secret
+
+	appint.Settings, err = json.Marshal(conf)
+	if err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+
+	if err := storage.UpdateIntegration(ctx, storage.DB(), &appint); err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, applicationID)
+
+	return nil
+}
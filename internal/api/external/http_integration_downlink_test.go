@@ -0,0 +1,260 @@
+package external
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver"
+	"github.com/brocaar/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	httpint "github.com/brocaar/chirpstack-application-server/internal/integration/http"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+func TestHTTPIntegrationDownlinkHandler(t *testing.T) {
+	tests := []struct {
+		Name           string
+		ValidatorError error
+		Body           string
+		ExpectedStatus int
+	}{
+		{
+			Name:           "authentication failed",
+			ValidatorError: errors.New("invalid token"),
+			Body:           `{"fPort":10,"data":"AQID"}`,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		{
+			Name:           "invalid json",
+			Body:           `{"fPort":`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "invalid fPort",
+			Body:           `{"fPort":0,"data":"AQID"}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+		{
+			Name:           "body too large",
+			Body:           `{"fPort":10,"data":"` + strings.Repeat("A", maxHTTPIntegrationDownlinkBodySize) + `"}`,
+			ExpectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r := mux.NewRouter()
+			NewHTTPIntegrationAPI(&TestValidator{returnError: tst.ValidatorError}).registerHTTPHandlers(r)
+
+			req := httptest.NewRequest("POST", "/api/applications/1/devices/0102030405060708/downlink", bytes.NewBufferString(tst.Body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(tst.ExpectedStatus, w.Code)
+		})
+	}
+}
+
+func (ts *APITestSuite) TestHTTPIntegrationDownlink() {
+	assert := require.New(ts.T())
+
+	networkserver.SetPool(mock.NewPool(mock.NewClient()))
+	api := NewHTTPIntegrationAPI(&TestValidator{})
+
+	org := storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org))
+
+	n := storage.NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), storage.DB(), &n))
+
+	sp := storage.ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{
+		Name:            "test-dp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	assert.NoError(err)
+
+	app := storage.Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &app))
+
+	d := storage.Device{
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-node",
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+
+	assert.NoError(storage.CreateIntegration(context.Background(), storage.DB(), &storage.Integration{
+		ApplicationID: app.ID,
+		Kind:          integration.HTTP,
+		Name:          "downlinks",
+		Enabled:       true,
+		Settings:      json.RawMessage(`{}`),
+	}))
+	assert.NoError(storage.CreateIntegration(context.Background(), storage.DB(), &storage.Integration{
+		ApplicationID: app.ID,
+		Kind:          integration.HTTP,
+		Name:          "disabled",
+		Settings:      json.RawMessage(`{}`),
+	}))
+
+	secret, err := api.CreateDownlinkSecret(context.Background(), app.ID, "downlinks")
+	assert.NoError(err)
+	disabledSecret, err := api.CreateDownlinkSecret(context.Background(), app.ID, "disabled")
+	assert.NoError(err)
+
+	body := []byte(`{"fPort":10,"data":"AQID"}`)
+
+	ts.T().Run("Invalid signature", func(t *testing.T) {
+		tests := []struct {
+			Name      string
+			Signature string
+		}{
+			{
+				Name:      "invalid secret",
+				Signature: httpint.SignDownlink("other-secret", time.Now(), "POST", app.ID, d.DevEUI, body),
+			},
+			{
+				Name:      "disabled integration",
+				Signature: httpint.SignDownlink(disabledSecret.DownlinkSecret, time.Now(), "POST", app.ID, d.DevEUI, body),
+			},
+			{
+				Name:      "other device",
+				Signature: httpint.SignDownlink(secret.DownlinkSecret, time.Now(), "POST", app.ID, lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}, body),
+			},
+			{
+				Name:      "expired timestamp",
+				Signature: httpint.SignDownlink(secret.DownlinkSecret, time.Now().Add(-2*httpint.DefaultSignatureTolerance), "POST", app.ID, d.DevEUI, body),
+			},
+			{
+				Name:      "invalid header",
+				Signature: "zz",
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.Downlink(context.Background(), &HTTPIntegrationDownlinkRequest{
+					ApplicationID: app.ID,
+					DevEUI:        d.DevEUI,
+					FPort:         10,
+					Data:          []byte{1, 2, 3},
+					Method:        "POST",
+					Body:          body,
+					Signature:     tst.Signature,
+				})
+				assert.Equal(codes.Unauthenticated, grpc.Code(err))
+			})
+		}
+	})
+
+	ts.T().Run("Enqueue", func(t *testing.T) {
+		tests := []struct {
+			Name         string
+			Result       models.DataDownResult
+			ExpectedCode codes.Code
+			ExpectedFCnt uint32
+		}{
+			{
+				Name:         "enqueued",
+				Result:       models.DataDownResult{FCnt: 12},
+				ExpectedFCnt: 12,
+			},
+			{
+				Name:         "encode error",
+				Result:       models.DataDownResult{Error: grpc.Errorf(codes.InvalidArgument, "encode object error")},
+				ExpectedCode: codes.InvalidArgument,
+			},
+		}
+
+		for i, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+
+				go func() {
+					pl := <-httpint.DownlinkChan()
+					pl.ResultChan <- tst.Result
+				}()
+
+				resp, err := api.Downlink(context.Background(), &HTTPIntegrationDownlinkRequest{
+					ApplicationID: app.ID,
+					DevEUI:        d.DevEUI,
+					FPort:         10,
+					Data:          []byte{1, 2, 3},
+					Method:        "POST",
+					Body:          body,
+					Signature:     httpint.SignDownlink(secret.DownlinkSecret, time.Now().Add(time.Duration(i)*time.Second), "POST", app.ID, d.DevEUI, body),
+				})
+				assert.Equal(tst.ExpectedCode, grpc.Code(err))
+				if err != nil {
+					return
+				}
+				assert.Equal(tst.ExpectedFCnt, resp.FCnt)
+			})
+		}
+	})
+
+	ts.T().Run("Replayed signature", func(t *testing.T) {
+		assert := require.New(t)
+
+		req := HTTPIntegrationDownlinkRequest{
+			ApplicationID: app.ID,
+			DevEUI:        d.DevEUI,
+			FPort:         10,
+			Data:          []byte{1, 2, 3},
+			Method:        "POST",
+			Body:          body,
+			Signature:     httpint.SignDownlink(secret.DownlinkSecret, time.Now().Add(-time.Minute), "POST", app.ID, d.DevEUI, body),
+		}
+
+		go func() {
+			pl := <-httpint.DownlinkChan()
+			pl.ResultChan <- models.DataDownResult{FCnt: 1}
+		}()
+
+		_, err := api.Downlink(context.Background(), &req)
+		assert.NoError(err)
+
+		_, err = api.Downlink(context.Background(), &req)
+		assert.Equal(codes.Unauthenticated, grpc.Code(err))
+	})
+}
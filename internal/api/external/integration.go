@@ -0,0 +1,667 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/api/external/auth"
+	"github.com/brocaar/chirpstack-application-server/internal/api/helpers"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/elasticsearch"
+	httpint "github.com/brocaar/chirpstack-application-server/internal/integration/http"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/influxdb"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/loracloud"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/multi"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/mydevices"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/pilotthings"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+// Integration defines an application-integration. The settings are
+// formatted as the settings of the per-kind application-integration API.
+// The filters define which events are forwarded to the integration.
+type Integration struct {
+	ID            int64           `json:"id,string"`
+	ApplicationID int64           `json:"applicationID,string"`
+	Kind          string          `json:"kind"`
+	Name          string          `json:"name"`
+	Enabled       bool            `json:"enabled"`
+	Settings      json.RawMessage `json:"settings"`
+	Filters       multi.Filter    `json:"filters"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+// redactedSetting replaces the value of the secret settings in the API
+// responses.
+const redactedSetting = "********"
+
+// secretSettings contains per integration kind the settings which are not
+// returned by the API.
+var secretSettings = map[string][]string{
+	integration.HTTP:            {"downlinkSecret", "signingSecret", "tlsKey"},
+	integration.ThingsBoard:     {"provisionDeviceSecret"},
+	integration.InfluxDB:        {"password", "token"},
+	integration.LoRaCloud:       {"geolocationToken", "dasToken"},
+	integration.GCPPubSub:       {"credentialsFile"},
+	integration.AWSSNS:          {"secretAccessKey"},
+	integration.AzureServiceBus: {"connectionString"},
+	integration.PilotThings:     {"token"},
+	integration.Kafka:           {"password"},
+	integration.NATS:            {"password"},
+	integration.RedisStreams:    {"password"},
+	integration.Elasticsearch:   {"password"},
+}
+
+// secretURLSettings contains per integration kind the URL settings of which
+// the credentials are not returned by the API. Values which can not be
+// parsed as URL (e.g. a key / value PostgreSQL DSN) are redacted as a whole.
+var secretURLSettings = map[string][]string{
+	integration.AMQP:          {"url"},
+	integration.Elasticsearch: {"endpoint"},
+	integration.PostgreSQL:    {"dsn"},
+}
+
+// IntegrationRequest defines the create or update application-integration
+// request.
+type IntegrationRequest struct {
+	Integration *Integration `json:"integration"`
+}
+
+// CreateIntegrationResponse defines the create application-integration
+// response.
+type CreateIntegrationResponse struct {
+	ID int64 `json:"id,string"`
+}
+
+// ListIntegrationsRequest defines the list application-integrations request.
+type ListIntegrationsRequest struct {
+	ApplicationID int64
+	Kind          string
+}
+
+// ListIntegrationsResponse defines the list application-integrations
+// response.
+type ListIntegrationsResponse struct {
+	TotalCount int           `json:"totalCount,string"`
+	Result     []Integration `json:"result"`
+}
+
+// TestFireIntegrationResponse defines the test-fire application-integration
+// response. The status code is 0 when the integration is not HTTP based or
+// when no HTTP response was received.
+type TestFireIntegrationResponse struct {
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error"`
+	Latency    string `json:"latency"`
+}
+
+// IntegrationAPI exports the application-integration functions which
+// operate on the integration ID, allowing multiple (named) integrations of
+// the same kind per application.
+type IntegrationAPI struct {
+	validator auth.Validator
+}
+
+// NewIntegrationAPI creates a new IntegrationAPI.
+func NewIntegrationAPI(validator auth.Validator) *IntegrationAPI {
+	return &IntegrationAPI{
+		validator: validator,
+	}
+}
+
+// Create creates the given application-integration.
+func (a *IntegrationAPI) Create(ctx context.Context, req *IntegrationRequest) (*CreateIntegrationResponse, error) {
+	if req.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateIntegrationSettings(req.Integration.Kind, req.Integration.Settings); err != nil {
+		return nil, err
+	}
+
+	filters, err := marshalIntegrationFilters(req.Integration.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	intgr := storage.Integration{
+		ApplicationID: req.Integration.ApplicationID,
+		Kind:          req.Integration.Kind,
+		Name:          req.Integration.Name,
+		Enabled:       req.Integration.Enabled,
+		Settings:      req.Integration.Settings,
+		Filters:       filters,
+	}
+	if err := storage.CreateIntegration(ctx, storage.DB(), &intgr); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, intgr.ApplicationID)
+
+	return &CreateIntegrationResponse{ID: intgr.ID}, nil
+}
+
+// Get returns the application-integration for the given ID.
+func (a *IntegrationAPI) Get(ctx context.Context, id int64) (*IntegrationRequest, error) {
+	intgr, err := storage.GetIntegration(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(intgr.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	out, err := integrationFromStorage(intgr)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &IntegrationRequest{Integration: &out}, nil
+}
+
+// List lists the application-integrations of the given application,
+// optionally filtered by kind.
+func (a *IntegrationAPI) List(ctx context.Context, req *ListIntegrationsRequest) (*ListIntegrationsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	ints, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), req.ApplicationID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListIntegrationsResponse{
+		Result: make([]Integration, 0, len(ints)),
+	}
+	for _, intgr := range ints {
+		if req.Kind != "" && req.Kind != intgr.Kind {
+			continue
+		}
+		out, err := integrationFromStorage(intgr)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		resp.Result = append(resp.Result, out)
+	}
+	resp.TotalCount = len(resp.Result)
+
+	return &resp, nil
+}
+
+// Update updates the name, enabled flag, settings and filters of the given
+// application-integration. The kind and application can not be changed.
+func (a *IntegrationAPI) Update(ctx context.Context, req *IntegrationRequest) (*struct{}, error) {
+	if req.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	intgr, err := storage.GetIntegration(ctx, storage.DB(), req.Integration.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(intgr.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	// Secret settings are redacted by Get and List. When they are sent
+	// back redacted or empty, the stored values are kept.
+	settings, err := mergeSecretSettings(intgr.Kind, intgr.Settings, req.Integration.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateIntegrationSettings(intgr.Kind, settings); err != nil {
+		return nil, err
+	}
+
+	filters, err := marshalIntegrationFilters(req.Integration.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// The downlink secret is managed through a separate endpoint.
+	if intgr.Kind == integration.HTTP {
+		var current, conf httpint.Config
+		if err := json.Unmarshal(intgr.Settings, &current); err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		if err := json.Unmarshal(settings, &conf); err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		conf.DownlinkSecret = current.DownlinkSecret
+
+		if settings, err = json.Marshal(conf); err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+	}
+
+	intgr.Name = req.Integration.Name
+	intgr.Enabled = req.Integration.Enabled
+	intgr.Settings = settings
+	intgr.Filters = filters
+
+	if err := storage.UpdateIntegration(ctx, storage.DB(), &intgr); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, intgr.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// Delete deletes the application-integration for the given ID.
+func (a *IntegrationAPI) Delete(ctx context.Context, id int64) (*struct{}, error) {
+	intgr, err := storage.GetIntegration(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(intgr.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := storage.DeleteIntegration(ctx, storage.DB(), intgr.ID); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	invalidateIntegrations(ctx, intgr.ApplicationID)
+
+	return &struct{}{}, nil
+}
+
+// TestFire sends a synthetic uplink event to the given (not yet stored)
+// application-integration and returns the outcome.
+func (a *IntegrationAPI) TestFire(ctx context.Context, req *IntegrationRequest) (*TestFireIntegrationResponse, error) {
+	if req.Integration == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "integration must not be nil")
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.Integration.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validateIntegrationSettings(req.Integration.Kind, req.Integration.Settings); err != nil {
+		return nil, err
+	}
+
+	res, err := integration.TestFire(ctx, req.Integration.ApplicationID, req.Integration.Kind, req.Integration.Settings)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "new integration error: %s", err)
+	}
+
+	return &TestFireIntegrationResponse{
+		StatusCode: res.StatusCode,
+		Error:      res.Error,
+		Latency:    strconv.FormatFloat(res.Latency.Seconds(), 'f', -1, 64) + "s",
+	}, nil
+}
+
+// registerHTTPHandlers registers the JSON API endpoints.
+func (a *IntegrationAPI) registerHTTPHandlers(r *mux.Router) {
+	r.Handle("/api/integrations", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req IntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		return a.Create(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/integrations/test", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req IntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		return a.TestFire(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/integrations", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		req := ListIntegrationsRequest{
+			Kind: r.URL.Query().Get("kind"),
+		}
+
+		var err error
+		if req.ApplicationID, err = int64Query(r, "applicationID"); err != nil {
+			return nil, err
+		}
+
+		return a.List(ctx, &req)
+	})).Methods("GET")
+
+	r.Handle("/api/integrations/{id}", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := int64Var(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.Get(ctx, id)
+	})).Methods("GET")
+
+	r.Handle("/api/integrations/{id}", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req IntegrationRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		if req.Integration != nil {
+			var err error
+			if req.Integration.ID, err = int64Var(r, "id"); err != nil {
+				return nil, err
+			}
+		}
+
+		return a.Update(ctx, &req)
+	})).Methods("PUT")
+
+	r.Handle("/api/integrations/{id}", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		id, err := int64Var(r, "id")
+		if err != nil {
+			return nil, err
+		}
+
+		return a.Delete(ctx, id)
+	})).Methods("DELETE")
+}
+
+func integrationFromStorage(i storage.Integration) (Integration, error) {
+	settings, err := redactSecretSettings(i.Kind, i.Settings)
+	if err != nil {
+		return Integration{}, err
+	}
+
+	out := Integration{
+		ID:            i.ID,
+		ApplicationID: i.ApplicationID,
+		Kind:          i.Kind,
+		Name:          i.Name,
+		Enabled:       i.Enabled,
+		Settings:      settings,
+		CreatedAt:     i.CreatedAt,
+		UpdatedAt:     i.UpdatedAt,
+	}
+
+	if len(i.Filters) != 0 {
+		if err := json.Unmarshal(i.Filters, &out.Filters); err != nil {
+			return out, errors.Wrap(err, "unmarshal filters error")
+		}
+	}
+
+	return out, nil
+}
+
+// redactSecretSettings replaces the non-empty secret settings of the given
+// integration kind by redactedSetting.
+func redactSecretSettings(kind string, settings json.RawMessage) (json.RawMessage, error) {
+	fields, urlFields := secretSettings[kind], secretURLSettings[kind]
+	if len(fields)+len(urlFields) == 0 || len(settings) == 0 {
+		return settings, nil
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(settings, &m); err != nil {
+		return nil, errors.Wrap(err, "unmarshal settings error")
+	}
+
+	redacted, _ := json.Marshal(redactedSetting)
+	for _, f := range fields {
+		if v, ok := m[f]; ok && string(v) != `""` && string(v) != "null" {
+			m[f] = redacted
+		}
+	}
+
+	for _, f := range urlFields {
+		var v string
+		if raw, ok := m[f]; ok && string(raw) != "null" {
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, errors.Wrapf(err, "unmarshal %s error", f)
+			}
+		}
+		if v == "" {
+			continue
+		}
+		m[f], _ = json.Marshal(redactURLCredentials(v))
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal settings error")
+	}
+	return b, nil
+}
+
+// redactURLCredentials returns the given URL, with the password redacted
+// (replaced by "xxxxx").
+// When the value is not an URL, or contains the password as query parameter,
+// the value is redacted as a whole.
+func redactURLCredentials(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return redactedSetting
+	}
+
+	for k := range u.Query() {
+		if strings.EqualFold(k, "password") {
+			return redactedSetting
+		}
+	}
+
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// mergeSecretSettings returns the given settings, in which the secret
+// settings which are redacted or empty are replaced by the current values.
+func mergeSecretSettings(kind string, current, settings json.RawMessage) (json.RawMessage, error) {
+	fields, urlFields := secretSettings[kind], secretURLSettings[kind]
+	if len(fields)+len(urlFields) == 0 || len(settings) == 0 {
+		return settings, nil
+	}
+
+	var cur, m map[string]json.RawMessage
+	if len(current) != 0 {
+		if err := json.Unmarshal(current, &cur); err != nil {
+			return nil, helpers.ErrToRPCError(errors.Wrap(err, "unmarshal settings error"))
+		}
+	}
+	if err := json.Unmarshal(settings, &m); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "decode settings error: %s", err)
+	}
+
+	decode := func(m map[string]json.RawMessage, f string) (string, error) {
+		var v string
+		if raw, ok := m[f]; ok && string(raw) != "null" {
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return "", grpc.Errorf(codes.InvalidArgument, "decode settings error: %s: %s", f, err)
+			}
+		}
+		return v, nil
+	}
+
+	restore := func(f string) {
+		if raw, ok := cur[f]; ok {
+			m[f] = raw
+		} else {
+			delete(m, f)
+		}
+	}
+
+	for _, f := range fields {
+		v, err := decode(m, f)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" && v != redactedSetting {
+			continue
+		}
+		restore(f)
+	}
+
+	// the URL settings are only restored when these are unchanged, as
+	// these are required
+	for _, f := range urlFields {
+		v, err := decode(m, f)
+		if err != nil {
+			return nil, err
+		}
+		c, err := decode(cur, f)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		if c != "" && (v == redactedSetting || v == redactURLCredentials(c)) {
+			restore(f)
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	return b, nil
+}
+
+// marshalIntegrationFilters validates and marshals the given filters.
+func marshalIntegrationFilters(f multi.Filter) (json.RawMessage, error) {
+	if err := f.Validate(); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "invalid filters: %s", err)
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return b, nil
+}
+
+// validateIntegrationSettings validates that the given settings can be
+// decoded into the configuration of the given kind and, when the kind
+// implements validation, that the configuration is valid.
+func validateIntegrationSettings(kind string, settings json.RawMessage) error {
+	if len(settings) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "settings must not be empty")
+	}
+
+	var conf interface{}
+
+	switch kind {
+	case integration.HTTP:
+		conf = &httpint.Config{}
+	case integration.InfluxDB:
+		conf = &influxdb.Config{}
+	case integration.ThingsBoard:
+		conf = &thingsboard.Config{}
+	case integration.MyDevices:
+		conf = &mydevices.Config{}
+	case integration.LoRaCloud:
+		conf = &loracloud.Config{}
+	case integration.GCPPubSub:
+		conf = &config.IntegrationGCPConfig{}
+	case integration.AWSSNS:
+		conf = &config.IntegrationAWSSNSConfig{}
+	case integration.AzureServiceBus:
+		conf = &config.IntegrationAzureConfig{}
+	case integration.PilotThings:
+		conf = &pilotthings.Config{}
+	case integration.Kafka:
+		conf = &config.IntegrationKafkaConfig{}
+	case integration.AMQP:
+		conf = &config.IntegrationAMQPConfig{}
+	case integration.NATS:
+		conf = &config.IntegrationNATSConfig{}
+	case integration.RedisStreams:
+		conf = &config.IntegrationRedisStreamsConfig{}
+	case integration.Elasticsearch:
+		conf = &elasticsearch.Config{}
+	case integration.PostgreSQL:
+		conf = &config.IntegrationPostgreSQLConfig{}
+	default:
+		return grpc.Errorf(codes.InvalidArgument, "unknown integration kind: %s", kind)
+	}
+
+	if err := json.Unmarshal(settings, conf); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "decode settings error: %s", err)
+	}
+
+	var err error
+	switch v := conf.(type) {
+	case *httpint.Config:
+		err = v.Validate()
+	case *influxdb.Config:
+		err = v.Validate()
+	case *thingsboard.Config:
+		err = v.Validate()
+	case *elasticsearch.Config:
+		err = v.Validate()
+	case *config.IntegrationGCPConfig:
+		return validatePayloadTemplate(v.PayloadTemplate)
+	case *config.IntegrationAWSSNSConfig:
+		return validatePayloadTemplate(v.PayloadTemplate)
+	case *config.IntegrationAzureConfig:
+		return validatePayloadTemplate(v.PayloadTemplate)
+	case *config.IntegrationKafkaConfig:
+		return validateKafkaIntegration(*v)
+	case *config.IntegrationAMQPConfig:
+		return validateAMQPIntegration(*v)
+	case *config.IntegrationNATSConfig:
+		return validateNATSIntegration(*v)
+	case *config.IntegrationRedisStreamsConfig:
+		return validateRedisStreamsIntegration(*v)
+	case *config.IntegrationPostgreSQLConfig:
+		return validatePostgreSQLIntegration(*v)
+	}
+	if err != nil {
+		return helpers.ErrToRPCError(err)
+	}
+
+	return nil
+}
+
+// validatePayloadTemplate validates the (optional) payload template of an
+// integration.
+func validatePayloadTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+
+	if err := marshaler.ValidateTemplate(text); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "invalid payload template: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package external
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/chirpstack-application-server/internal/api/external/auth"
+	"github.com/brocaar/chirpstack-application-server/internal/api/helpers"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/outbox"
+	"github.com/brocaar/lorawan"
+)
+
+// DeadLetterEvent defines a dead-lettered integration event.
+type DeadLetterEvent struct {
+	ID             string        `json:"id"`
+	ApplicationID  int64         `json:"applicationID,string"`
+	DevEUI         lorawan.EUI64 `json:"devEUI"`
+	Type           string        `json:"type"`
+	Integration    string        `json:"integration"`
+	Attempts       int           `json:"attempts"`
+	Error          string        `json:"error"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	DeadLetteredAt *time.Time    `json:"deadLetteredAt"`
+}
+
+// ListDeadLetterEventsRequest defines the request for listing the
+// dead-lettered events of an application.
+type ListDeadLetterEventsRequest struct {
+	ApplicationID int64
+	Limit         int
+}
+
+// ListDeadLetterEventsResponse defines the list dead-lettered events response.
+type ListDeadLetterEventsResponse struct {
+	TotalCount int               `json:"totalCount,string"`
+	Result     []DeadLetterEvent `json:"result"`
+}
+
+// DeadLetterEventsRequest defines the request for replaying or purging
+// dead-lettered events. When no IDs are given, all the dead-lettered events
+// of the application are replayed or purged.
+type DeadLetterEventsRequest struct {
+	ApplicationID int64    `json:"-"`
+	IDs           []string `json:"ids"`
+}
+
+// ReplayDeadLetterEventsResponse defines the replay dead-lettered events
+// response.
+type ReplayDeadLetterEventsResponse struct {
+	Count int `json:"count"`
+}
+
+// IntegrationOutboxAPI exports the integration outbox related functions.
+type IntegrationOutboxAPI struct {
+	validator auth.Validator
+}
+
+// NewIntegrationOutboxAPI creates a new IntegrationOutboxAPI.
+func NewIntegrationOutboxAPI(validator auth.Validator) *IntegrationOutboxAPI {
+	return &IntegrationOutboxAPI{
+		validator: validator,
+	}
+}
+
+// ListDeadLetterEvents lists the dead-lettered events of the given
+// application, most recent first.
+func (a *IntegrationOutboxAPI) ListDeadLetterEvents(ctx context.Context, req *ListDeadLetterEventsRequest) (*ListDeadLetterEventsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	count, err := outbox.GetDeadLetterEventCount(ctx, req.ApplicationID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	events, err := outbox.GetDeadLetterEvents(ctx, req.ApplicationID, req.Limit)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListDeadLetterEventsResponse{
+		TotalCount: count,
+		Result:     make([]DeadLetterEvent, 0, len(events)),
+	}
+	for _, e := range events {
+		resp.Result = append(resp.Result, DeadLetterEvent{
+			ID:             e.StreamID,
+			ApplicationID:  e.ApplicationID,
+			DevEUI:         e.DevEUI,
+			Type:           e.Type,
+			Integration:    e.Integration,
+			Attempts:       e.Attempts,
+			Error:          e.Error,
+			CreatedAt:      e.CreatedAt,
+			DeadLetteredAt: e.DeadLetteredAt,
+		})
+	}
+
+	return &resp, nil
+}
+
+// ReplayDeadLetterEvents schedules the given dead-lettered events for
+// re-delivery to their integration.
+func (a *IntegrationOutboxAPI) ReplayDeadLetterEvents(ctx context.Context, req *DeadLetterEventsRequest) (*ReplayDeadLetterEventsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	n, err := outbox.ReplayDeadLetterEvents(ctx, req.ApplicationID, req.IDs)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &ReplayDeadLetterEventsResponse{Count: n}, nil
+}
+
+// PurgeDeadLetterEvents deletes the given dead-lettered events.
+func (a *IntegrationOutboxAPI) PurgeDeadLetterEvents(ctx context.Context, req *DeadLetterEventsRequest) (*struct{}, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ApplicationID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := outbox.PurgeDeadLetterEvents(ctx, req.ApplicationID, req.IDs); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &struct{}{}, nil
+}
+
+// registerHTTPHandlers registers the JSON API endpoints.
+func (a *IntegrationOutboxAPI) registerHTTPHandlers(r *mux.Router) {
+	r.Handle("/api/applications/{application_id}/integrations/dead-letters", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		req := ListDeadLetterEventsRequest{
+			Limit: 100,
+		}
+
+		var err error
+		if req.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+			return nil, err
+		}
+
+		if v := r.URL.Query().Get("limit"); v != "" {
+			if req.Limit, err = strconv.Atoi(v); err != nil {
+				return nil, grpc.Errorf(codes.InvalidArgument, "limit: %s", err)
+			}
+		}
+
+		return a.ListDeadLetterEvents(ctx, &req)
+	})).Methods("GET")
+
+	r.Handle("/api/applications/{application_id}/integrations/dead-letters/replay", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req DeadLetterEventsRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if req.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+			return nil, err
+		}
+
+		return a.ReplayDeadLetterEvents(ctx, &req)
+	})).Methods("POST")
+
+	r.Handle("/api/applications/{application_id}/integrations/dead-letters/purge", jsonAPIHandler(func(ctx context.Context, r *http.Request) (interface{}, error) {
+		var req DeadLetterEventsRequest
+		if err := decodeJSONBody(r, &req); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if req.ApplicationID, err = int64Var(r, "application_id"); err != nil {
+			return nil, err
+		}
+
+		return a.PurgeDeadLetterEvents(ctx, &req)
+	})).Methods("POST")
+}
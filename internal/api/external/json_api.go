@@ -0,0 +1,102 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// jsonAPIHandlerFunc implements a JSON API endpoint which is not (yet)
+// covered by the gRPC API. The returned value is JSON encoded in the
+// response body.
+type jsonAPIHandlerFunc func(ctx context.Context, r *http.Request) (interface{}, error)
+
+// jsonAPIError implements the same error format as the grpc-gateway.
+type jsonAPIError struct {
+	Error   string        `json:"error"`
+	Code    int32         `json:"code"`
+	Message string        `json:"message"`
+	Details []interface{} `json:"details"`
+}
+
+// jsonAPIHandler returns a http.Handler for the given JSON API function.
+// Like the grpc-gateway, the Authorization (or Grpc-Metadata-Authorization)
+// header is forwarded as gRPC metadata so that the auth.Validator can be
+// used. gRPC errors are mapped to the corresponding HTTP status code.
+func jsonAPIHandler(f jsonAPIHandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		md := metadata.MD{}
+		if v := r.Header.Get("Grpc-Metadata-Authorization"); v != "" {
+			md.Set("authorization", v)
+		} else if v := r.Header.Get("Authorization"); v != "" {
+			md.Set("authorization", v)
+		}
+		ctx := metadata.NewIncomingContext(r.Context(), md)
+
+		resp, err := f(ctx, r)
+		if err != nil {
+			s, _ := status.FromError(err)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
+			json.NewEncoder(w).Encode(jsonAPIError{
+				Error:   s.Message(),
+				Code:    int32(s.Code()),
+				Message: s.Message(),
+				Details: []interface{}{},
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.WithError(err).Error("api/external: encode json response error")
+		}
+	})
+}
+
+// decodeJSONBody decodes the JSON request body into the given value.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "decode json body error: %s", err)
+	}
+	return nil
+}
+
+// int64Var returns the given path variable as int64.
+func int64Var(r *http.Request, name string) (int64, error) {
+	i, err := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "%s", errors.Wrap(err, name))
+	}
+	return i, nil
+}
+
+// int64Query returns the given query parameter as int64.
+func int64Query(r *http.Request, name string) (int64, error) {
+	i, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+	if err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "%s", errors.Wrap(err, name))
+	}
+	return i, nil
+}
+
+// uuidVar returns the given path variable as UUID.
+func uuidVar(r *http.Request, name string) (uuid.UUID, error) {
+	id, err := uuid.FromString(mux.Vars(r)[name])
+	if err != nil {
+		return uuid.Nil, grpc.Errorf(codes.InvalidArgument, "%s", errors.Wrap(err, name))
+	}
+	return id, nil
+}
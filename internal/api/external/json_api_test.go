@@ -0,0 +1,66 @@
+package external
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestJSONAPIHandler(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Header         http.Header
+		Func           jsonAPIHandlerFunc
+		ExpectedStatus int
+		ExpectedBody   string
+	}{
+		{
+			Name: "authorization header is forwarded as metadata",
+			Header: http.Header{
+				"Grpc-Metadata-Authorization": []string{"Bearer token"},
+			},
+			Func: func(ctx context.Context, r *http.Request) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				return map[string]string{"authorization": md.Get("authorization")[0]}, nil
+			},
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   `{"authorization":"Bearer token"}`,
+		},
+		{
+			Name: "grpc error is mapped to http status",
+			Func: func(ctx context.Context, r *http.Request) (interface{}, error) {
+				return nil, grpc.Errorf(codes.NotFound, "object does not exist")
+			},
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":"object does not exist","code":5,"message":"object does not exist","details":[]}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			r := httptest.NewRequest("GET", "/api/test", nil)
+			r.Header = tst.Header
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			w := httptest.NewRecorder()
+
+			jsonAPIHandler(tst.Func).ServeHTTP(w, r)
+			assert.Equal(tst.ExpectedStatus, w.Code)
+
+			var expected, body interface{}
+			assert.NoError(json.Unmarshal([]byte(tst.ExpectedBody), &expected))
+			assert.NoError(json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(expected, body)
+		})
+	}
+}
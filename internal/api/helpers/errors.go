@@ -8,8 +8,11 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/brocaar/chirpstack-application-server/internal/codec"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/elasticsearch"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/http"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/influxdb"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
 	"github.com/brocaar/chirpstack-application-server/internal/storage"
 )
 
@@ -34,7 +37,15 @@ var errToCode = map[error]codes.Code{
 	storage.ErrNetworkServerInvalidName:        codes.InvalidArgument,
 	storage.ErrAPIKeyInvalidName:               codes.InvalidArgument,
 	http.ErrInvalidHeaderName:                  codes.InvalidArgument,
+	http.ErrInvalidPayloadTemplate:             codes.InvalidArgument,
+	http.ErrInvalidTLSConfig:                   codes.InvalidArgument,
 	influxdb.ErrInvalidPrecision:               codes.InvalidArgument,
+	elasticsearch.ErrInvalidEndpoint:           codes.InvalidArgument,
+	elasticsearch.ErrInvalidIndexTemplate:      codes.InvalidArgument,
+	elasticsearch.ErrInvalidBatchConfig:        codes.InvalidArgument,
+	thingsboard.ErrInvalidProvisionConfig:      codes.InvalidArgument,
+	codec.ErrUnknownCodec:                      codes.InvalidArgument,
+	codec.ErrInvalidSettings:                   codes.InvalidArgument,
 }
 
 // ErrToRPCError converts the given error into a gRPC error.
@@ -0,0 +1,588 @@
+// Package binarylayout implements a payload codec driven by a declarative
+// (YAML or JSON) schema, describing the binary layout of the payload per
+// fPort.
+//
+// Example schema:
+//
+//	fPorts:
+//	  "1":
+//	    fields:
+//	      - name: temperature
+//	        length: 2
+//	        type: int
+//	        scale: 0.01
+//	      - name: status
+//	        length: 1
+//	        enum: {0: ok, 1: low_battery}
+//	      - length: 1
+//	        bits:
+//	          - {name: moving, bit: 0, type: bool}
+//	          - {name: mode, bit: 1, length: 3}
+//	      - name: readings
+//	        repeat: {}
+//	        fields:
+//	          - {name: value, length: 2, endianness: little}
+//
+// Fields are read in order, each field starts directly after the previous
+// field unless an offset is set. Offsets within a repeated group are
+// relative to the start of the group item. Payloads are limited to 255
+// bytes, the max. LoRaWAN payload size.
+package binarylayout
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxCachedSchemas defines the max. number of compiled schemas which are
+	// cached. When exceeded, the cache is cleared.
+	maxCachedSchemas = 1024
+
+	// maxPayloadSize defines the max. size of the encoded payload, which is
+	// also the max. offset and length of a field. This is the max. LoRaWAN
+	// payload size.
+	maxPayloadSize = 255
+)
+
+var (
+	schemasMu sync.RWMutex
+	schemas   = make(map[[sha256.Size]byte]*Schema)
+)
+
+// BinaryToJSON decodes the given binary payload to JSON, using the given
+// schema.
+func BinaryToJSON(schema string, fPort uint8, b []byte) ([]byte, error) {
+	s, err := getSchema(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "compile schema error")
+	}
+
+	return s.Decode(fPort, b)
+}
+
+// JSONToBinary encodes the given JSON object to binary, using the given
+// schema.
+func JSONToBinary(schema string, fPort uint8, jsonB []byte) ([]byte, error) {
+	s, err := getSchema(schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "compile schema error")
+	}
+
+	return s.Encode(fPort, jsonB)
+}
+
+// Decode decodes the given binary payload to JSON.
+func (s *Schema) Decode(fPort uint8, b []byte) ([]byte, error) {
+	fields, err := s.getFields(fPort)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, _, err := decodeFields(fields, b)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal json error")
+	}
+	return out, nil
+}
+
+// Encode encodes the given JSON object to binary.
+func (s *Schema) Encode(fPort uint8, jsonB []byte) ([]byte, error) {
+	fields, err := s.getFields(fPort)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(jsonB))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, errors.Wrap(err, "unmarshal json error")
+	}
+
+	return encodeFields(fields, obj)
+}
+
+func (s *Schema) getFields(fPort uint8) ([]field, error) {
+	if fields, ok := s.fPorts[fPort]; ok {
+		return fields, nil
+	}
+	if len(s.fields) != 0 {
+		return s.fields, nil
+	}
+	return nil, fmt.Errorf("no layout defined for fPort %d", fPort)
+}
+
+// getSchema returns the compiled schema for the given text. Compiled
+// schemas are cached by the hash of the text.
+func getSchema(text string) (*Schema, error) {
+	key := sha256.Sum256([]byte(text))
+
+	schemasMu.RLock()
+	s, ok := schemas[key]
+	schemasMu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := Compile(text)
+	if err != nil {
+		return nil, err
+	}
+
+	schemasMu.Lock()
+	if len(schemas) >= maxCachedSchemas {
+		schemas = make(map[[sha256.Size]byte]*Schema)
+	}
+	schemas[key] = s
+	schemasMu.Unlock()
+
+	return s, nil
+}
+
+// decodeFields decodes the given fields and returns the decoded object and
+// the number of bytes used.
+func decodeFields(fields []field, b []byte) (map[string]interface{}, int, error) {
+	obj := make(map[string]interface{})
+	var pos, end int
+
+	for _, f := range fields {
+		if f.offset >= 0 {
+			pos = f.offset
+		}
+
+		if f.repeated {
+			items, n, err := decodeGroup(f, obj, b, pos)
+			if err != nil {
+				return nil, 0, errors.Wrapf(err, "field %s", f.name)
+			}
+			obj[f.name] = items
+			pos += n
+		} else {
+			if pos+f.length > len(b) {
+				if len(f.bits) == 0 {
+					return nil, 0, fmt.Errorf("field %s: payload too short", f.name)
+				}
+				return nil, 0, fmt.Errorf("bitfield at offset %d: payload too short", pos)
+			}
+
+			if err := decodeField(f, obj, b[pos:pos+f.length]); err != nil {
+				return nil, 0, err
+			}
+			pos += f.length
+		}
+
+		if pos > end {
+			end = pos
+		}
+	}
+
+	return obj, end, nil
+}
+
+func decodeGroup(f field, obj map[string]interface{}, b []byte, pos int) ([]interface{}, int, error) {
+	count := -1
+	switch {
+	case f.count != 0:
+		count = f.count
+	case f.countField != "":
+		c, err := countValue(obj[f.countField])
+		if err != nil {
+			return nil, 0, err
+		}
+		count = c
+	}
+
+	items := []interface{}{}
+	start := pos
+	for i := 0; count == -1 || i < count; i++ {
+		if count == -1 && pos >= len(b) {
+			break
+		}
+
+		item, n, err := decodeFields(f.fields, b[pos:])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "item %d", i)
+		}
+		if n == 0 {
+			return nil, 0, errors.New("group item must not be empty")
+		}
+
+		items = append(items, item)
+		pos += n
+	}
+
+	return items, pos - start, nil
+}
+
+func decodeField(f field, obj map[string]interface{}, b []byte) error {
+	if f.typ == typeBytes {
+		obj[f.name] = hex.EncodeToString(b)
+		return nil
+	}
+
+	raw := readUint(b, f.order)
+
+	if f.typ == typeFloat {
+		var v float64
+		if f.length == 4 {
+			v = float64(math.Float32frombits(uint32(raw)))
+		} else {
+			v = math.Float64frombits(raw)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("field %s: invalid float value", f.name)
+		}
+		obj[f.name] = v*f.scale + f.valueOffset
+		return nil
+	}
+
+	if len(f.bits) == 0 {
+		obj[f.name] = f.value.decode(raw)
+		return nil
+	}
+
+	for _, bf := range f.bits {
+		obj[bf.name] = bf.value.decode((raw >> uint(bf.bit)) & mask(bf.bits))
+	}
+	return nil
+}
+
+// decode converts the given raw value. The raw value must be masked to the
+// size of the value.
+func (v value) decode(raw uint64) interface{} {
+	if v.typ == typeBool {
+		return raw != 0
+	}
+
+	var i int64
+	var isInt bool
+	if v.typ == typeInt {
+		i = signExtend(raw, v.bits)
+		isInt = true
+	}
+
+	if v.enum != nil {
+		if !isInt {
+			i = int64(raw)
+		}
+		if name, ok := v.enum[i]; ok {
+			return name
+		}
+	}
+
+	if v.scaled {
+		if isInt {
+			return float64(i)*v.scale + v.valueOffset
+		}
+		return float64(raw)*v.scale + v.valueOffset
+	}
+
+	if isInt {
+		return i
+	}
+	return raw
+}
+
+// encodeFields encodes the given fields of the object.
+func encodeFields(fields []field, obj map[string]interface{}) ([]byte, error) {
+	// count fields are set from the number of group items
+	var counted bool
+	for _, f := range fields {
+		if f.repeated && f.countField != "" {
+			if !counted {
+				obj = copyObject(obj)
+				counted = true
+			}
+
+			items, err := groupItems(obj[f.name])
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", f.name)
+			}
+			obj[f.countField] = json.Number(strconv.Itoa(len(items)))
+		}
+	}
+
+	var out []byte
+	var pos int
+
+	for _, f := range fields {
+		if f.offset >= 0 {
+			pos = f.offset
+		}
+
+		var b []byte
+		var err error
+		if f.repeated {
+			b, err = encodeGroup(f, obj[f.name])
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", f.name)
+			}
+		} else {
+			b, err = encodeField(f, obj)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		n := pos + len(b)
+		if n > maxPayloadSize {
+			return nil, fmt.Errorf("payload exceeds the max. size of %d bytes", maxPayloadSize)
+		}
+		if n > len(out) {
+			out = append(out, make([]byte, n-len(out))...)
+		}
+		copy(out[pos:], b)
+		pos += len(b)
+	}
+
+	return out, nil
+}
+
+func encodeGroup(f field, v interface{}) ([]byte, error) {
+	items, err := groupItems(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.count != 0 && len(items) != f.count {
+		return nil, fmt.Errorf("expected %d items, got %d", f.count, len(items))
+	}
+
+	var out []byte
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d: expected object", i)
+		}
+
+		b, err := encodeFields(f.fields, obj)
+		if err != nil {
+			return nil, errors.Wrapf(err, "item %d", i)
+		}
+		out = append(out, b...)
+		if len(out) > maxPayloadSize {
+			return nil, fmt.Errorf("payload exceeds the max. size of %d bytes", maxPayloadSize)
+		}
+	}
+
+	return out, nil
+}
+
+func encodeField(f field, obj map[string]interface{}) ([]byte, error) {
+	b := make([]byte, f.length)
+
+	if len(f.bits) != 0 {
+		var raw uint64
+		for _, bf := range f.bits {
+			v, ok := obj[bf.name]
+			if !ok {
+				return nil, fmt.Errorf("field %s: value must be set", bf.name)
+			}
+
+			r, err := bf.value.encode(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", bf.name)
+			}
+			raw |= r << uint(bf.bit)
+		}
+
+		putUint(b, f.order, raw)
+		return b, nil
+	}
+
+	v, ok := obj[f.name]
+	if !ok {
+		return nil, fmt.Errorf("field %s: value must be set", f.name)
+	}
+
+	switch f.typ {
+	case typeBytes:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("field %s: expected hex string", f.name)
+		}
+		hb, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", f.name)
+		}
+		if len(hb) != f.length {
+			return nil, fmt.Errorf("field %s: expected %d bytes, got %d", f.name, f.length, len(hb))
+		}
+		return hb, nil
+	case typeFloat:
+		x, err := toFloat(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", f.name)
+		}
+		x = (x - f.valueOffset) / f.scale
+		if f.length == 4 {
+			putUint(b, f.order, uint64(math.Float32bits(float32(x))))
+		} else {
+			putUint(b, f.order, math.Float64bits(x))
+		}
+		return b, nil
+	}
+
+	raw, err := f.value.encode(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "field %s", f.name)
+	}
+	putUint(b, f.order, raw)
+	return b, nil
+}
+
+// encode returns the raw value, masked to the size of the value.
+func (v value) encode(val interface{}) (uint64, error) {
+	if v.typ == typeBool {
+		b, ok := val.(bool)
+		if !ok {
+			return 0, errors.New("expected bool")
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if s, ok := val.(string); ok && v.enum != nil {
+		i, ok := v.enumValues[s]
+		if !ok {
+			return 0, fmt.Errorf("unknown enum value: %s", s)
+		}
+		val = json.Number(strconv.FormatInt(i, 10))
+	}
+
+	if v.scaled {
+		x, err := toFloat(val)
+		if err != nil {
+			return 0, err
+		}
+		x = math.Round((x - v.valueOffset) / v.scale)
+
+		// the range is [min, max)
+		min, max := 0.0, math.Pow(2, float64(v.bits))
+		if v.typ == typeInt {
+			min, max = -max/2, max/2
+		}
+		if x < min || x >= max {
+			return 0, errors.New("value out of range")
+		}
+
+		if v.typ == typeInt {
+			return uint64(int64(x)) & mask(v.bits), nil
+		}
+		return uint64(x), nil
+	}
+
+	n, ok := val.(json.Number)
+	if !ok {
+		return 0, errors.New("expected number")
+	}
+
+	if v.typ == typeInt {
+		i, err := strconv.ParseInt(n.String(), 10, v.bits)
+		if err != nil {
+			return 0, errors.New("value out of range")
+		}
+		return uint64(i) & mask(v.bits), nil
+	}
+
+	u, err := strconv.ParseUint(n.String(), 10, v.bits)
+	if err != nil {
+		return 0, errors.New("value out of range")
+	}
+	return u, nil
+}
+
+func groupItems(v interface{}) ([]interface{}, error) {
+	if v == nil {
+		return nil, errors.New("value must be set")
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.New("expected array")
+	}
+	return items, nil
+}
+
+func countValue(v interface{}) (int, error) {
+	switch c := v.(type) {
+	case uint64:
+		return int(c), nil
+	case int64:
+		if c < 0 {
+			return 0, errors.New("count must not be negative")
+		}
+		return int(c), nil
+	default:
+		return 0, errors.New("invalid count")
+	}
+}
+
+func copyObject(obj map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		out[k] = v
+	}
+	return out
+}
+
+func toFloat(v interface{}) (float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, errors.New("expected number")
+	}
+	return n.Float64()
+}
+
+func readUint(b []byte, order binary.ByteOrder) uint64 {
+	var out uint64
+	for i := range b {
+		if order == binary.LittleEndian {
+			out |= uint64(b[i]) << (8 * uint(i))
+		} else {
+			out = out<<8 | uint64(b[i])
+		}
+	}
+	return out
+}
+
+func putUint(b []byte, order binary.ByteOrder, v uint64) {
+	for i := range b {
+		if order == binary.LittleEndian {
+			b[i] = byte(v >> (8 * uint(i)))
+		} else {
+			b[len(b)-1-i] = byte(v >> (8 * uint(i)))
+		}
+	}
+}
+
+func mask(bits int) uint64 {
+	if bits >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<uint(bits) - 1
+}
+
+func signExtend(v uint64, bits int) int64 {
+	if bits >= 64 {
+		return int64(v)
+	}
+	shift := uint(64 - bits)
+	return int64(v<<shift) >> shift
+}
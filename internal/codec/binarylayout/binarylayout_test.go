@@ -0,0 +1,337 @@
+package binarylayout
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `
+fields:
+  - name: value
+    length: 1
+
+fPorts:
+  "1":
+    fields:
+      - name: temperature
+        length: 2
+        type: int
+        scale: 0.01
+      - name: humidity
+        length: 1
+        scale: 0.5
+      - name: status
+        length: 1
+        enum: {0: ok, 1: low_battery}
+      - length: 1
+        bits:
+          - {name: moving, bit: 0, type: bool}
+          - {name: mode, bit: 1, length: 3}
+          - {name: delta, bit: 4, length: 4, type: int}
+      - name: counter
+        length: 4
+        endianness: little
+      - name: readings
+        repeat: {}
+        fields:
+          - {name: value, length: 2, endianness: little}
+  "2":
+    fields:
+      - name: count
+        length: 1
+      - name: sensors
+        repeat: {countField: count}
+        fields:
+          - {name: id, length: 1}
+          - {name: value, length: 2, type: int, scale: 0.1, valueOffset: -10}
+      - name: crc
+        length: 2
+        type: bytes
+  "3":
+    fields:
+      - name: pressure
+        length: 4
+        type: float
+      - name: battery
+        offset: 6
+        length: 1
+      - name: pairs
+        offset: 8
+        repeat: {count: 2}
+        fields:
+          - {name: a, length: 1}
+          - {name: b, length: 1}
+`
+
+func TestBinaryLayout(t *testing.T) {
+	tests := []struct {
+		Name  string
+		FPort uint8
+		Bytes []byte
+		JSON  string
+	}{
+		{
+			Name:  "default layout",
+			FPort: 10,
+			Bytes: []byte{5},
+			JSON:  `{"value":5}`,
+		},
+		{
+			Name:  "values, enum, bitfield and repeat until end",
+			FPort: 1,
+			Bytes: []byte{0xf8, 0x30, 0x51, 0x01, 0xe7, 0x01, 0x02, 0x00, 0x00, 0x0a, 0x00, 0x14, 0x00},
+			JSON:  `{"temperature":-20,"humidity":40.5,"status":"low_battery","moving":true,"mode":3,"delta":-2,"counter":513,"readings":[{"value":10},{"value":20}]}`,
+		},
+		{
+			Name:  "unknown enum value",
+			FPort: 1,
+			Bytes: []byte{0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00},
+			JSON:  `{"temperature":0,"humidity":0,"status":5,"moving":false,"mode":0,"delta":0,"counter":0,"readings":[]}`,
+		},
+		{
+			Name:  "count field",
+			FPort: 2,
+			Bytes: []byte{0x02, 0x01, 0x00, 0x64, 0x02, 0x00, 0x00, 0xab, 0xcd},
+			JSON:  `{"count":2,"sensors":[{"id":1,"value":0},{"id":2,"value":-10}],"crc":"abcd"}`,
+		},
+		{
+			Name:  "float, offsets and fixed count",
+			FPort: 3,
+			Bytes: []byte{0x3f, 0xc0, 0x00, 0x00, 0x00, 0x00, 0x64, 0x00, 0x01, 0x02, 0x03, 0x04},
+			JSON:  `{"pressure":1.5,"battery":100,"pairs":[{"a":1,"b":2},{"a":3,"b":4}]}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			jsonB, err := BinaryToJSON(testSchema, tst.FPort, tst.Bytes)
+			assert.NoError(err)
+			assert.JSONEq(tst.JSON, string(jsonB))
+
+			b, err := JSONToBinary(testSchema, tst.FPort, []byte(tst.JSON))
+			assert.NoError(err)
+			assert.Equal(tst.Bytes, b)
+		})
+	}
+}
+
+func TestBinaryLayoutJSONSchema(t *testing.T) {
+	assert := require.New(t)
+
+	schema := `{"fPorts": {"1": {"fields": [{"name": "value", "length": 2, "endianness": "little"}]}}}`
+
+	jsonB, err := BinaryToJSON(schema, 1, []byte{0x01, 0x02})
+	assert.NoError(err)
+	assert.Equal(`{"value":513}`, string(jsonB))
+
+	_, err = BinaryToJSON(schema, 2, []byte{0x01, 0x02})
+	assert.EqualError(err, "no layout defined for fPort 2")
+}
+
+func TestBinaryLayoutErrors(t *testing.T) {
+	t.Run("Decode", func(t *testing.T) {
+		tests := []struct {
+			Name          string
+			FPort         uint8
+			Bytes         []byte
+			ExpectedError string
+		}{
+			{
+				Name:          "payload too short",
+				FPort:         2,
+				Bytes:         []byte{0x01, 0x01, 0x00},
+				ExpectedError: "field sensors: item 0: field value: payload too short",
+			},
+			{
+				Name:          "bitfield payload too short",
+				FPort:         1,
+				Bytes:         []byte{0x00, 0x00, 0x00, 0x00},
+				ExpectedError: "bitfield at offset 4: payload too short",
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+				_, err := BinaryToJSON(testSchema, tst.FPort, tst.Bytes)
+				assert.EqualError(err, tst.ExpectedError)
+			})
+		}
+	})
+
+	t.Run("Encode", func(t *testing.T) {
+		tests := []struct {
+			Name          string
+			FPort         uint8
+			JSON          string
+			ExpectedError string
+		}{
+			{
+				Name:          "missing value",
+				FPort:         10,
+				JSON:          `{}`,
+				ExpectedError: "field value: value must be set",
+			},
+			{
+				Name:          "out of range",
+				FPort:         10,
+				JSON:          `{"value":256}`,
+				ExpectedError: "field value: value out of range",
+			},
+			{
+				Name:          "scaled value out of range",
+				FPort:         2,
+				JSON:          `{"sensors":[{"id":1,"value":3300}],"crc":"0000"}`,
+				ExpectedError: "field sensors: item 0: field value: value out of range",
+			},
+			{
+				Name:          "unknown enum value",
+				FPort:         1,
+				JSON:          `{"temperature":0,"humidity":0,"status":"foo","moving":false,"mode":0,"delta":0,"counter":0,"readings":[]}`,
+				ExpectedError: "field status: unknown enum value: foo",
+			},
+			{
+				Name:          "bitfield value out of range",
+				FPort:         1,
+				JSON:          `{"temperature":0,"humidity":0,"status":0,"moving":false,"mode":8,"delta":0,"counter":0,"readings":[]}`,
+				ExpectedError: "field mode: value out of range",
+			},
+			{
+				Name:          "invalid item count",
+				FPort:         3,
+				JSON:          `{"pressure":1.5,"battery":100,"pairs":[]}`,
+				ExpectedError: "field pairs: expected 2 items, got 0",
+			},
+			{
+				Name:          "payload too large",
+				FPort:         1,
+				JSON:          `{"temperature":0,"humidity":0,"status":0,"moving":false,"mode":0,"delta":0,"counter":0,"readings":[` + strings.Repeat(`{"value":1},`, 127) + `{"value":1}]}`,
+				ExpectedError: "field readings: payload exceeds the max. size of 255 bytes",
+			},
+			{
+				Name:          "invalid bytes length",
+				FPort:         2,
+				JSON:          `{"sensors":[],"crc":"00"}`,
+				ExpectedError: "field crc: expected 2 bytes, got 1",
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+				_, err := JSONToBinary(testSchema, tst.FPort, []byte(tst.JSON))
+				assert.EqualError(err, tst.ExpectedError)
+			})
+		}
+	})
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Schema        string
+		ExpectedError string
+	}{
+		{
+			Name:          "empty",
+			Schema:        ``,
+			ExpectedError: "fields or fPorts must be set",
+		},
+		{
+			Name:          "invalid fPort",
+			Schema:        `fPorts: {"foo": {fields: [{name: a, length: 1}]}}`,
+			ExpectedError: "invalid fPort: foo",
+		},
+		{
+			Name:          "no name",
+			Schema:        `fields: [{length: 1}]`,
+			ExpectedError: "field 0: name must be set",
+		},
+		{
+			Name:          "invalid length",
+			Schema:        `fields: [{name: a, length: 9}]`,
+			ExpectedError: "field a: length must be between 1 and 8",
+		},
+		{
+			Name:          "invalid float length",
+			Schema:        `fields: [{name: a, length: 2, type: float}]`,
+			ExpectedError: "field a: length of a float must be 4 or 8",
+		},
+		{
+			Name:          "invalid type",
+			Schema:        `fields: [{name: a, length: 1, type: string}]`,
+			ExpectedError: "field a: invalid type: string",
+		},
+		{
+			Name:          "invalid endianness",
+			Schema:        `fields: [{name: a, length: 1, endianness: middle}]`,
+			ExpectedError: "field a: invalid endianness: middle",
+		},
+		{
+			Name:          "duplicate name",
+			Schema:        `fields: [{name: a, length: 1}, {length: 1, bits: [{name: a, bit: 0}]}]`,
+			ExpectedError: "duplicate field name: a",
+		},
+		{
+			Name:          "bits outside bitfield",
+			Schema:        `fields: [{length: 1, bits: [{name: a, bit: 6, length: 3}]}]`,
+			ExpectedError: "field 0: bit a: bits must be within the bitfield",
+		},
+		{
+			Name:          "enum with scale",
+			Schema:        `fields: [{name: a, length: 1, scale: 0.1, enum: {0: off}}]`,
+			ExpectedError: "field a: enum can not be used with scale or valueOffset",
+		},
+		{
+			Name:          "group without count not last",
+			Schema:        `fields: [{name: a, repeat: {}, fields: [{name: b, length: 1}]}, {name: c, length: 1}]`,
+			ExpectedError: "field a: a group without count must be the last field",
+		},
+		{
+			Name:          "undefined count field",
+			Schema:        `fields: [{name: a, repeat: {countField: n}, fields: [{name: b, length: 1}]}]`,
+			ExpectedError: "field a: countField n must be an uint field defined before the group",
+		},
+		{
+			Name:          "offset too large",
+			Schema:        `fields: [{name: a, length: 1, offset: 1000000000}]`,
+			ExpectedError: "field a: offset must be between 0 and 255",
+		},
+		{
+			Name:          "field exceeds payload size",
+			Schema:        `fields: [{name: a, length: 2, offset: 254}]`,
+			ExpectedError: "field a: field exceeds the max. payload size of 255 bytes",
+		},
+		{
+			Name:          "bytes length too large",
+			Schema:        `fields: [{name: a, length: 1000000000, type: bytes}]`,
+			ExpectedError: "field a: length must be between 1 and 255",
+		},
+		{
+			Name:          "count too large",
+			Schema:        `fields: [{name: a, repeat: {count: 1000000000}, fields: [{name: b, length: 1}]}]`,
+			ExpectedError: "field a: count must be between 0 and 255",
+		},
+		{
+			Name:   "valid",
+			Schema: testSchema,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := Compile(tst.Schema)
+			if tst.ExpectedError == "" {
+				assert.NoError(err)
+			} else {
+				assert.EqualError(err, tst.ExpectedError)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package binarylayout
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Field types.
+const (
+	typeUint  = "uint"
+	typeInt   = "int"
+	typeFloat = "float"
+	typeBool  = "bool"
+	typeBytes = "bytes"
+)
+
+// schemaDoc defines the (YAML or JSON) schema document.
+type schemaDoc struct {
+	// Fields defines the layout of fPorts which are not defined by FPorts.
+	Fields []fieldDoc `yaml:"fields"`
+
+	// FPorts defines the layout per fPort.
+	FPorts map[string]layoutDoc `yaml:"fPorts"`
+}
+
+type layoutDoc struct {
+	Fields []fieldDoc `yaml:"fields"`
+}
+
+// fieldDoc defines a field. A field is either a value, a bitfield (Bits)
+// or a repeated group of fields (Repeat).
+type fieldDoc struct {
+	Name        string            `yaml:"name"`
+	Offset      *int              `yaml:"offset"`
+	Length      int               `yaml:"length"`
+	Type        string            `yaml:"type"`
+	Endianness  string            `yaml:"endianness"`
+	Scale       *float64          `yaml:"scale"`
+	ValueOffset float64           `yaml:"valueOffset"`
+	Enum        map[string]string `yaml:"enum"`
+	Bits        []bitDoc          `yaml:"bits"`
+	Repeat      *repeatDoc        `yaml:"repeat"`
+	Fields      []fieldDoc        `yaml:"fields"`
+}
+
+// bitDoc defines a value within a bitfield. Bit is the position of the
+// least significant bit, counting from the least significant bit of the
+// bitfield.
+type bitDoc struct {
+	Name        string            `yaml:"name"`
+	Bit         int               `yaml:"bit"`
+	Length      int               `yaml:"length"`
+	Type        string            `yaml:"type"`
+	Scale       *float64          `yaml:"scale"`
+	ValueOffset float64           `yaml:"valueOffset"`
+	Enum        map[string]string `yaml:"enum"`
+}
+
+// repeatDoc defines how many times a group is repeated. When neither Count
+// nor CountField is set, the group is repeated until the end of the
+// payload.
+type repeatDoc struct {
+	Count      int    `yaml:"count"`
+	CountField string `yaml:"countField"`
+}
+
+// Schema contains a compiled schema.
+type Schema struct {
+	fields []field
+	fPorts map[uint8][]field
+}
+
+// value defines how a raw value is converted.
+type value struct {
+	name        string
+	typ         string
+	bits        int
+	scaled      bool
+	scale       float64
+	valueOffset float64
+	enum        map[int64]string
+	enumValues  map[string]int64
+}
+
+type field struct {
+	value
+
+	offset int
+	length int
+	order  binary.ByteOrder
+
+	bits []bitField
+
+	repeated   bool
+	count      int
+	countField string
+	fields     []field
+}
+
+type bitField struct {
+	value
+
+	bit int
+}
+
+// Compile parses and validates the given YAML or JSON schema.
+func Compile(text string) (*Schema, error) {
+	var doc schemaDoc
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, errors.Wrap(err, "parse schema error")
+	}
+
+	s := Schema{
+		fPorts: make(map[uint8][]field),
+	}
+
+	if len(doc.Fields) == 0 && len(doc.FPorts) == 0 {
+		return nil, errors.New("fields or fPorts must be set")
+	}
+
+	var err error
+	if len(doc.Fields) != 0 {
+		s.fields, err = compileFields(doc.Fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for k, l := range doc.FPorts {
+		fPort, err := strconv.ParseUint(k, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fPort: %s", k)
+		}
+
+		s.fPorts[uint8(fPort)], err = compileFields(l.Fields)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fPort %d", fPort)
+		}
+	}
+
+	return &s, nil
+}
+
+func compileFields(docs []fieldDoc) ([]field, error) {
+	if len(docs) == 0 {
+		return nil, errors.New("fields must not be empty")
+	}
+
+	var out []field
+	names := make(map[string]struct{})
+	counts := make(map[string]struct{})
+
+	for i, d := range docs {
+		f, err := compileField(d)
+		if err != nil {
+			if d.Name != "" {
+				return nil, errors.Wrapf(err, "field %s", d.Name)
+			}
+			return nil, errors.Wrapf(err, "field %d", i)
+		}
+
+		if f.repeated && f.count == 0 && f.countField == "" && i != len(docs)-1 {
+			return nil, fmt.Errorf("field %s: a group without count must be the last field", f.name)
+		}
+
+		if f.countField != "" {
+			if _, ok := counts[f.countField]; !ok {
+				return nil, fmt.Errorf("field %s: countField %s must be an uint field defined before the group", f.name, f.countField)
+			}
+		}
+
+		for _, n := range f.names() {
+			if _, ok := names[n]; ok {
+				return nil, fmt.Errorf("duplicate field name: %s", n)
+			}
+			names[n] = struct{}{}
+		}
+
+		for _, v := range f.values() {
+			if v.typ == typeUint && !v.scaled && v.enum == nil {
+				counts[v.name] = struct{}{}
+			}
+		}
+
+		out = append(out, f)
+	}
+
+	return out, nil
+}
+
+func compileField(d fieldDoc) (field, error) {
+	f := field{
+		offset: -1,
+		length: d.Length,
+		order:  binary.BigEndian,
+	}
+
+	if d.Offset != nil {
+		if *d.Offset < 0 || *d.Offset > maxPayloadSize {
+			return f, fmt.Errorf("offset must be between 0 and %d", maxPayloadSize)
+		}
+		f.offset = *d.Offset
+
+		if d.Length > maxPayloadSize-f.offset {
+			return f, fmt.Errorf("field exceeds the max. payload size of %d bytes", maxPayloadSize)
+		}
+	}
+
+	switch d.Endianness {
+	case "", "big":
+	case "little":
+		f.order = binary.LittleEndian
+	default:
+		return f, fmt.Errorf("invalid endianness: %s", d.Endianness)
+	}
+
+	// repeated group
+	if d.Repeat != nil {
+		if d.Name == "" {
+			return f, errors.New("name must be set")
+		}
+		if d.Repeat.Count < 0 || d.Repeat.Count > maxPayloadSize {
+			return f, fmt.Errorf("count must be between 0 and %d", maxPayloadSize)
+		}
+		if d.Repeat.Count != 0 && d.Repeat.CountField != "" {
+			return f, errors.New("count and countField are mutually exclusive")
+		}
+
+		fields, err := compileFields(d.Fields)
+		if err != nil {
+			return f, err
+		}
+		for _, ff := range fields {
+			if ff.repeated && ff.count == 0 && ff.countField == "" {
+				return f, errors.New("a nested group must have a count or countField")
+			}
+		}
+
+		f.name = d.Name
+		f.repeated = true
+		f.count = d.Repeat.Count
+		f.countField = d.Repeat.CountField
+		f.fields = fields
+		return f, nil
+	}
+
+	if len(d.Fields) != 0 {
+		return f, errors.New("fields can only be set for repeated groups")
+	}
+
+	// bitfield
+	if len(d.Bits) != 0 {
+		if d.Length < 1 || d.Length > 8 {
+			return f, errors.New("length must be between 1 and 8")
+		}
+
+		for _, bd := range d.Bits {
+			b := bitField{
+				bit: bd.Bit,
+			}
+
+			length := bd.Length
+			if length == 0 {
+				length = 1
+			}
+
+			var err error
+			b.value, err = compileValue(bd.Name, bd.Type, length, bd.Scale, bd.ValueOffset, bd.Enum)
+			if err != nil {
+				return f, errors.Wrapf(err, "bit %s", bd.Name)
+			}
+			if b.typ == typeFloat || b.typ == typeBytes {
+				return f, fmt.Errorf("bit %s: type must be uint, int or bool", bd.Name)
+			}
+			if bd.Bit < 0 || bd.Bit+length > d.Length*8 {
+				return f, fmt.Errorf("bit %s: bits must be within the bitfield", bd.Name)
+			}
+
+			f.bits = append(f.bits, b)
+		}
+
+		return f, nil
+	}
+
+	var err error
+	f.value, err = compileValue(d.Name, d.Type, d.Length*8, d.Scale, d.ValueOffset, d.Enum)
+	if err != nil {
+		return f, err
+	}
+
+	switch f.typ {
+	case typeFloat:
+		if d.Length != 4 && d.Length != 8 {
+			return f, errors.New("length of a float must be 4 or 8")
+		}
+	case typeBytes:
+		if d.Length < 1 || d.Length > maxPayloadSize {
+			return f, fmt.Errorf("length must be between 1 and %d", maxPayloadSize)
+		}
+	default:
+		if d.Length < 1 || d.Length > 8 {
+			return f, errors.New("length must be between 1 and 8")
+		}
+	}
+
+	return f, nil
+}
+
+func compileValue(name, typ string, bits int, scale *float64, valueOffset float64, enum map[string]string) (value, error) {
+	v := value{
+		name:        name,
+		typ:         typ,
+		bits:        bits,
+		scale:       1,
+		valueOffset: valueOffset,
+	}
+
+	if v.name == "" {
+		return v, errors.New("name must be set")
+	}
+
+	switch v.typ {
+	case "":
+		v.typ = typeUint
+	case typeUint, typeInt, typeFloat, typeBool, typeBytes:
+	default:
+		return v, fmt.Errorf("invalid type: %s", v.typ)
+	}
+
+	if scale != nil {
+		if *scale == 0 {
+			return v, errors.New("scale must not be 0")
+		}
+		v.scale = *scale
+	}
+	v.scaled = scale != nil || valueOffset != 0
+
+	if v.scaled && (v.typ == typeBool || v.typ == typeBytes) {
+		return v, fmt.Errorf("scale and valueOffset can not be used with type %s", v.typ)
+	}
+
+	if len(enum) != 0 {
+		if v.typ != typeUint && v.typ != typeInt {
+			return v, errors.New("enum can only be used with type uint or int")
+		}
+		if v.scaled {
+			return v, errors.New("enum can not be used with scale or valueOffset")
+		}
+
+		v.enum = make(map[int64]string)
+		v.enumValues = make(map[string]int64)
+		for k, name := range enum {
+			i, err := strconv.ParseInt(k, 0, 64)
+			if err != nil {
+				return v, fmt.Errorf("invalid enum value: %s", k)
+			}
+			if _, ok := v.enumValues[name]; ok {
+				return v, fmt.Errorf("duplicate enum name: %s", name)
+			}
+			v.enum[i] = name
+			v.enumValues[name] = i
+		}
+	}
+
+	return v, nil
+}
+
+// names returns the names which the field adds to the object.
+func (f field) names() []string {
+	if len(f.bits) == 0 {
+		return []string{f.name}
+	}
+
+	var out []string
+	for _, b := range f.bits {
+		out = append(out, b.name)
+	}
+	return out
+}
+
+// values returns the values which the field adds to the object.
+func (f field) values() []value {
+	if f.repeated {
+		return nil
+	}
+	if len(f.bits) == 0 {
+		return []value{f.value}
+	}
+
+	var out []value
+	for _, b := range f.bits {
+		out = append(out, b.value)
+	}
+	return out
+}
@@ -0,0 +1,133 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/brocaar/chirpstack-application-server/internal/codec/binarylayout"
+	"github.com/brocaar/chirpstack-application-server/internal/codec/cayennelpp"
+	"github.com/brocaar/chirpstack-application-server/internal/codec/js"
+)
+
+func init() {
+	Register(binaryLayoutCodec{})
+	Register(cayenneLPPCodec{})
+	Register(customJSCodec{})
+}
+
+var (
+	errNoSettings = errors.New("codec does not have settings")
+	errNoSchema   = errors.New("schema must be set")
+)
+
+// cayenneLPPCodec implements the Cayenne LPP codec.
+type cayenneLPPCodec struct{}
+
+func (cayenneLPPCodec) Info() Info {
+	return Info{
+		Name:           CayenneLPPType,
+		Description:    "Cayenne LPP",
+		SettingsSchema: emptySettingsSchema,
+	}
+}
+
+func (cayenneLPPCodec) Decode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, b []byte) ([]byte, error) {
+	return cayennelpp.BinaryToJSON(b)
+}
+
+func (cayenneLPPCodec) Encode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, jsonB []byte) ([]byte, error) {
+	return cayennelpp.JSONToBinary(jsonB)
+}
+
+func (cayenneLPPCodec) Validate(settings Settings) error {
+	if !IsEmptyConfig(settings.Config) {
+		return errNoSettings
+	}
+	return nil
+}
+
+// customJSCodec implements the custom JavaScript codec, using the encoder
+// and decoder scripts.
+type customJSCodec struct{}
+
+func (customJSCodec) Info() Info {
+	return Info{
+		Name:           CustomJSType,
+		Description:    "Custom JavaScript codec functions",
+		Scripts:        true,
+		SettingsSchema: emptySettingsSchema,
+	}
+}
+
+func (customJSCodec) Decode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, b []byte) ([]byte, error) {
+	return js.BinaryToJSON(fPort, variables, settings.DecoderScript, b, js.Console(LogFuncFromContext(ctx)))
+}
+
+func (customJSCodec) Encode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, jsonB []byte) ([]byte, error) {
+	return js.JSONToBinary(fPort, variables, settings.EncoderScript, jsonB, js.Console(LogFuncFromContext(ctx)))
+}
+
+// Validate does not compile the scripts, as a script can be stored before
+// it is complete.
+func (customJSCodec) Validate(settings Settings) error {
+	if !IsEmptyConfig(settings.Config) {
+		return errNoSettings
+	}
+	return nil
+}
+
+// binaryLayoutCodec implements the declarative binary-layout codec. The
+// layout is defined by the (YAML or JSON) schema in the codec settings.
+type binaryLayoutCodec struct{}
+
+type binaryLayoutConfig struct {
+	Schema string `json:"schema"`
+}
+
+func (binaryLayoutCodec) Info() Info {
+	return Info{
+		Name:           BinaryLayoutType,
+		Description:    "Declarative binary layout (YAML or JSON schema)",
+		SettingsSchema: json.RawMessage(`{"type":"object","properties":{"schema":{"type":"string","title":"Schema","description":"YAML or JSON schema, defining the fields per fPort."}},"required":["schema"],"additionalProperties":false}`),
+	}
+}
+
+func (c binaryLayoutCodec) Decode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, b []byte) ([]byte, error) {
+	conf, err := c.config(settings)
+	if err != nil {
+		return nil, err
+	}
+	return binarylayout.BinaryToJSON(conf.Schema, fPort, b)
+}
+
+func (c binaryLayoutCodec) Encode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, jsonB []byte) ([]byte, error) {
+	conf, err := c.config(settings)
+	if err != nil {
+		return nil, err
+	}
+	return binarylayout.JSONToBinary(conf.Schema, fPort, jsonB)
+}
+
+func (c binaryLayoutCodec) Validate(settings Settings) error {
+	conf, err := c.config(settings)
+	if err != nil {
+		return err
+	}
+	_, err = binarylayout.Compile(conf.Schema)
+	return err
+}
+
+func (binaryLayoutCodec) config(settings Settings) (binaryLayoutConfig, error) {
+	var conf binaryLayoutConfig
+	if IsEmptyConfig(settings.Config) {
+		return conf, errNoSchema
+	}
+	if err := json.Unmarshal(settings.Config, &conf); err != nil {
+		return conf, err
+	}
+	if conf.Schema == "" {
+		return conf, errNoSchema
+	}
+	return conf, nil
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"sort"
 
 	"github.com/pkg/errors"
@@ -17,14 +18,29 @@ const (
 	lppDigitalOutput     byte = 1
 	lppAnalogInput       byte = 2
 	lppAnalogOutput      byte = 3
+	lppGenericSensor     byte = 100
 	lppIlluminanceSensor byte = 101
 	lppPresenseSensor    byte = 102
 	lppTemperatureSensor byte = 103
 	lppHumiditySensor    byte = 104
 	lppAccelerometer     byte = 113
 	lppBarometer         byte = 115
+	lppVoltage           byte = 116
+	lppCurrent           byte = 117
+	lppFrequency         byte = 118
+	lppPercentage        byte = 120
+	lppAltitude          byte = 121
+	lppLoad              byte = 122
+	lppConcentration     byte = 125
+	lppPower             byte = 128
+	lppDistance          byte = 130
+	lppEnergy            byte = 131
+	lppDirection         byte = 132
+	lppUnixTime          byte = 133
 	lppGyrometer         byte = 134
+	lppColour            byte = 135
 	lppGPSLocation       byte = 136
+	lppSwitch            byte = 142
 )
 
 type accelerometer struct {
@@ -45,6 +61,12 @@ type gpsLocation struct {
 	Altitude  float64 `json:"altitude"`
 }
 
+type colour struct {
+	R uint8 `json:"r"`
+	G uint8 `json:"g"`
+	B uint8 `json:"b"`
+}
+
 type cayenneLPP struct {
 	DigitalInput      map[byte]uint8         `json:"digitalInput,omitempty" influxdb:"digital_input"`
 	DigitalOutput     map[byte]uint8         `json:"digitalOutput,omitempty" influxdb:"digital_output"`
@@ -58,6 +80,21 @@ type cayenneLPP struct {
 	Barometer         map[byte]float64       `json:"barometer,omitempty" influxdb:"barometer"`
 	Gyrometer         map[byte]gyrometer     `json:"gyrometer,omitempty" influxdb:"gyrometer"`
 	GPSLocation       map[byte]gpsLocation   `json:"gpsLocation,omitempty" influxdb:"gps_location"`
+	GenericSensor     map[byte]uint32        `json:"genericSensor,omitempty" influxdb:"generic_sensor"`
+	Voltage           map[byte]float64       `json:"voltage,omitempty" influxdb:"voltage"`
+	Current           map[byte]float64       `json:"current,omitempty" influxdb:"current"`
+	Frequency         map[byte]uint32        `json:"frequency,omitempty" influxdb:"frequency"`
+	Percentage        map[byte]uint8         `json:"percentage,omitempty" influxdb:"percentage"`
+	Altitude          map[byte]int16         `json:"altitude,omitempty" influxdb:"altitude"`
+	Load              map[byte]float64       `json:"load,omitempty" influxdb:"load"`
+	Concentration     map[byte]uint16        `json:"concentration,omitempty" influxdb:"concentration"`
+	Power             map[byte]uint16        `json:"power,omitempty" influxdb:"power"`
+	Distance          map[byte]float64       `json:"distance,omitempty" influxdb:"distance"`
+	Energy            map[byte]float64       `json:"energy,omitempty" influxdb:"energy"`
+	Direction         map[byte]uint16        `json:"direction,omitempty" influxdb:"direction"`
+	UnixTime          map[byte]uint32        `json:"unixTime,omitempty" influxdb:"unix_time"`
+	Colour            map[byte]colour        `json:"colour,omitempty" influxdb:"colour"`
+	Switch            map[byte]uint8         `json:"switch,omitempty" influxdb:"switch"`
 }
 
 // BinaryToJSON encodes the given binary payload to JSON.
@@ -102,6 +139,36 @@ func BinaryToJSON(b []byte) ([]byte, error) {
 			err = lppGyrometerDecode(buf[0], r, &lpp)
 		case lppGPSLocation:
 			err = lppGPSLocationDecode(buf[0], r, &lpp)
+		case lppGenericSensor:
+			err = lppGenericSensorDecode(buf[0], r, &lpp)
+		case lppVoltage:
+			err = lppVoltageDecode(buf[0], r, &lpp)
+		case lppCurrent:
+			err = lppCurrentDecode(buf[0], r, &lpp)
+		case lppFrequency:
+			err = lppFrequencyDecode(buf[0], r, &lpp)
+		case lppPercentage:
+			err = lppPercentageDecode(buf[0], r, &lpp)
+		case lppAltitude:
+			err = lppAltitudeDecode(buf[0], r, &lpp)
+		case lppLoad:
+			err = lppLoadDecode(buf[0], r, &lpp)
+		case lppConcentration:
+			err = lppConcentrationDecode(buf[0], r, &lpp)
+		case lppPower:
+			err = lppPowerDecode(buf[0], r, &lpp)
+		case lppDistance:
+			err = lppDistanceDecode(buf[0], r, &lpp)
+		case lppEnergy:
+			err = lppEnergyDecode(buf[0], r, &lpp)
+		case lppDirection:
+			err = lppDirectionDecode(buf[0], r, &lpp)
+		case lppUnixTime:
+			err = lppUnixTimeDecode(buf[0], r, &lpp)
+		case lppColour:
+			err = lppColourDecode(buf[0], r, &lpp)
+		case lppSwitch:
+			err = lppSwitchDecode(buf[0], r, &lpp)
 		default:
 			return nil, fmt.Errorf("invalid data type: %d", buf[1])
 		}
@@ -271,6 +338,186 @@ func JSONToBinary(b []byte) ([]byte, error) {
 		}
 	}
 
+	// GenericSensor
+	channels = make([]uint8, 0, len(lpp.GenericSensor))
+	for k := range lpp.GenericSensor {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppGenericSensorEncode(c, w, lpp.GenericSensor[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Voltage
+	channels = make([]uint8, 0, len(lpp.Voltage))
+	for k := range lpp.Voltage {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppVoltageEncode(c, w, lpp.Voltage[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Current
+	channels = make([]uint8, 0, len(lpp.Current))
+	for k := range lpp.Current {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppCurrentEncode(c, w, lpp.Current[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Frequency
+	channels = make([]uint8, 0, len(lpp.Frequency))
+	for k := range lpp.Frequency {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppFrequencyEncode(c, w, lpp.Frequency[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Percentage
+	channels = make([]uint8, 0, len(lpp.Percentage))
+	for k := range lpp.Percentage {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppPercentageEncode(c, w, lpp.Percentage[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Altitude
+	channels = make([]uint8, 0, len(lpp.Altitude))
+	for k := range lpp.Altitude {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppAltitudeEncode(c, w, lpp.Altitude[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load
+	channels = make([]uint8, 0, len(lpp.Load))
+	for k := range lpp.Load {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppLoadEncode(c, w, lpp.Load[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Concentration
+	channels = make([]uint8, 0, len(lpp.Concentration))
+	for k := range lpp.Concentration {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppConcentrationEncode(c, w, lpp.Concentration[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Power
+	channels = make([]uint8, 0, len(lpp.Power))
+	for k := range lpp.Power {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppPowerEncode(c, w, lpp.Power[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Distance
+	channels = make([]uint8, 0, len(lpp.Distance))
+	for k := range lpp.Distance {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppDistanceEncode(c, w, lpp.Distance[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Energy
+	channels = make([]uint8, 0, len(lpp.Energy))
+	for k := range lpp.Energy {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppEnergyEncode(c, w, lpp.Energy[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Direction
+	channels = make([]uint8, 0, len(lpp.Direction))
+	for k := range lpp.Direction {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppDirectionEncode(c, w, lpp.Direction[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// UnixTime
+	channels = make([]uint8, 0, len(lpp.UnixTime))
+	for k := range lpp.UnixTime {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppUnixTimeEncode(c, w, lpp.UnixTime[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Colour
+	channels = make([]uint8, 0, len(lpp.Colour))
+	for k := range lpp.Colour {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppColourEncode(c, w, lpp.Colour[c]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Switch
+	channels = make([]uint8, 0, len(lpp.Switch))
+	for k := range lpp.Switch {
+		channels = append(channels, k)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	for _, c := range channels {
+		if err := lppSwitchEncode(c, w, lpp.Switch[c]); err != nil {
+			return nil, err
+		}
+	}
+
 	return w.Bytes(), nil
 }
 
@@ -560,3 +807,340 @@ func lppGPSLocationEncode(channel uint8, w io.Writer, data gpsLocation) error {
 	}
 	return nil
 }
+
+func lppGenericSensorDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint32
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.GenericSensor == nil {
+		out.GenericSensor = make(map[uint8]uint32)
+	}
+	out.GenericSensor[channel] = val
+	return nil
+}
+
+func lppGenericSensorEncode(channel uint8, w io.Writer, data uint32) error {
+	w.Write([]byte{channel, lppGenericSensor})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppVoltageDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Voltage == nil {
+		out.Voltage = make(map[uint8]float64)
+	}
+	out.Voltage[channel] = float64(val) / 100
+	return nil
+}
+
+func lppVoltageEncode(channel uint8, w io.Writer, data float64) error {
+	val, err := scaleUint(data, 100, math.MaxUint16)
+	if err != nil {
+		return errors.Wrap(err, "encode voltage error")
+	}
+	w.Write([]byte{channel, lppVoltage})
+	if err := binary.Write(w, binary.BigEndian, uint16(val)); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppCurrentDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Current == nil {
+		out.Current = make(map[uint8]float64)
+	}
+	out.Current[channel] = float64(val) / 1000
+	return nil
+}
+
+func lppCurrentEncode(channel uint8, w io.Writer, data float64) error {
+	val, err := scaleUint(data, 1000, math.MaxUint16)
+	if err != nil {
+		return errors.Wrap(err, "encode current error")
+	}
+	w.Write([]byte{channel, lppCurrent})
+	if err := binary.Write(w, binary.BigEndian, uint16(val)); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppFrequencyDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint32
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.Frequency == nil {
+		out.Frequency = make(map[uint8]uint32)
+	}
+	out.Frequency[channel] = val
+	return nil
+}
+
+func lppFrequencyEncode(channel uint8, w io.Writer, data uint32) error {
+	w.Write([]byte{channel, lppFrequency})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppPercentageDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint8
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint8 error")
+	}
+	if out.Percentage == nil {
+		out.Percentage = make(map[uint8]uint8)
+	}
+	out.Percentage[channel] = val
+	return nil
+}
+
+func lppPercentageEncode(channel uint8, w io.Writer, data uint8) error {
+	if data > 100 {
+		return fmt.Errorf("encode percentage error: value must be in range (0 - 100), got: %d", data)
+	}
+	w.Write([]byte{channel, lppPercentage})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint8 error")
+	}
+	return nil
+}
+
+func lppAltitudeDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val int16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read int16 error")
+	}
+	if out.Altitude == nil {
+		out.Altitude = make(map[uint8]int16)
+	}
+	out.Altitude[channel] = val
+	return nil
+}
+
+func lppAltitudeEncode(channel uint8, w io.Writer, data int16) error {
+	w.Write([]byte{channel, lppAltitude})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write int16 error")
+	}
+	return nil
+}
+
+func lppLoadDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return errors.Wrap(err, "read error")
+	}
+	if out.Load == nil {
+		out.Load = make(map[uint8]float64)
+	}
+	out.Load[channel] = float64(uint32(buf[0])<<16|uint32(buf[1])<<8|uint32(buf[2])) / 1000
+	return nil
+}
+
+func lppLoadEncode(channel uint8, w io.Writer, data float64) error {
+	val, err := scaleUint(data, 1000, 0xFFFFFF)
+	if err != nil {
+		return errors.Wrap(err, "encode load error")
+	}
+	w.Write([]byte{channel, lppLoad})
+	if _, err := w.Write([]byte{byte(val >> 16), byte(val >> 8), byte(val)}); err != nil {
+		return errors.Wrap(err, "write error")
+	}
+	return nil
+}
+
+func lppConcentrationDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Concentration == nil {
+		out.Concentration = make(map[uint8]uint16)
+	}
+	out.Concentration[channel] = val
+	return nil
+}
+
+func lppConcentrationEncode(channel uint8, w io.Writer, data uint16) error {
+	w.Write([]byte{channel, lppConcentration})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppPowerDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Power == nil {
+		out.Power = make(map[uint8]uint16)
+	}
+	out.Power[channel] = val
+	return nil
+}
+
+func lppPowerEncode(channel uint8, w io.Writer, data uint16) error {
+	w.Write([]byte{channel, lppPower})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppDistanceDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint32
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.Distance == nil {
+		out.Distance = make(map[uint8]float64)
+	}
+	out.Distance[channel] = float64(val) / 1000
+	return nil
+}
+
+func lppDistanceEncode(channel uint8, w io.Writer, data float64) error {
+	val, err := scaleUint(data, 1000, math.MaxUint32)
+	if err != nil {
+		return errors.Wrap(err, "encode distance error")
+	}
+	w.Write([]byte{channel, lppDistance})
+	if err := binary.Write(w, binary.BigEndian, uint32(val)); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppEnergyDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint32
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.Energy == nil {
+		out.Energy = make(map[uint8]float64)
+	}
+	out.Energy[channel] = float64(val) / 1000
+	return nil
+}
+
+func lppEnergyEncode(channel uint8, w io.Writer, data float64) error {
+	val, err := scaleUint(data, 1000, math.MaxUint32)
+	if err != nil {
+		return errors.Wrap(err, "encode energy error")
+	}
+	w.Write([]byte{channel, lppEnergy})
+	if err := binary.Write(w, binary.BigEndian, uint32(val)); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppDirectionDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint16
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint16 error")
+	}
+	if out.Direction == nil {
+		out.Direction = make(map[uint8]uint16)
+	}
+	out.Direction[channel] = val
+	return nil
+}
+
+func lppDirectionEncode(channel uint8, w io.Writer, data uint16) error {
+	w.Write([]byte{channel, lppDirection})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint16 error")
+	}
+	return nil
+}
+
+func lppUnixTimeDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint32
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint32 error")
+	}
+	if out.UnixTime == nil {
+		out.UnixTime = make(map[uint8]uint32)
+	}
+	out.UnixTime[channel] = val
+	return nil
+}
+
+func lppUnixTimeEncode(channel uint8, w io.Writer, data uint32) error {
+	w.Write([]byte{channel, lppUnixTime})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint32 error")
+	}
+	return nil
+}
+
+func lppColourDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return errors.Wrap(err, "read error")
+	}
+	if out.Colour == nil {
+		out.Colour = make(map[uint8]colour)
+	}
+	out.Colour[channel] = colour{
+		R: buf[0],
+		G: buf[1],
+		B: buf[2],
+	}
+	return nil
+}
+
+func lppColourEncode(channel uint8, w io.Writer, data colour) error {
+	w.Write([]byte{channel, lppColour})
+	if _, err := w.Write([]byte{data.R, data.G, data.B}); err != nil {
+		return errors.Wrap(err, "write error")
+	}
+	return nil
+}
+
+func lppSwitchDecode(channel uint8, r io.Reader, out *cayenneLPP) error {
+	var val uint8
+	if err := binary.Read(r, binary.BigEndian, &val); err != nil {
+		return errors.Wrap(err, "read uint8 error")
+	}
+	if out.Switch == nil {
+		out.Switch = make(map[uint8]uint8)
+	}
+	out.Switch[channel] = val
+	return nil
+}
+
+func lppSwitchEncode(channel uint8, w io.Writer, data uint8) error {
+	w.Write([]byte{channel, lppSwitch})
+	if err := binary.Write(w, binary.BigEndian, data); err != nil {
+		return errors.Wrap(err, "write uint8 error")
+	}
+	return nil
+}
+
+// scaleUint returns the given value multiplied by the given scale and
+// rounded. An error is returned when the result is not in the range 0 - max.
+func scaleUint(data float64, scale float64, max uint64) (uint64, error) {
+	val := math.Round(data * scale)
+	if !(val >= 0 && val <= float64(max)) {
+		return 0, fmt.Errorf("value must be in range (0 - %g), got: %g", float64(max)/scale, data)
+	}
+	return uint64(val), nil
+}
@@ -132,6 +132,170 @@ func TestCayenneLPP(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:  "2 generic sensors",
+			Bytes: []byte{3, 100, 0, 0, 0, 10, 5, 100, 0, 1, 226, 64},
+			Struct: cayenneLPP{
+				GenericSensor: map[byte]uint32{
+					3: 10,
+					5: 123456,
+				},
+			},
+		},
+		{
+			Name:  "2 voltage sensors",
+			Bytes: []byte{3, 116, 1, 74, 5, 116, 9, 196},
+			Struct: cayenneLPP{
+				Voltage: map[byte]float64{
+					3: 3.3,
+					5: 25,
+				},
+			},
+		},
+		{
+			Name:  "2 current sensors",
+			Bytes: []byte{3, 117, 0, 123, 5, 117, 39, 16},
+			Struct: cayenneLPP{
+				Current: map[byte]float64{
+					3: 0.123,
+					5: 10,
+				},
+			},
+		},
+		{
+			Name:  "2 frequency sensors",
+			Bytes: []byte{3, 118, 0, 0, 0, 50, 5, 118, 0, 15, 66, 64},
+			Struct: cayenneLPP{
+				Frequency: map[byte]uint32{
+					3: 50,
+					5: 1000000,
+				},
+			},
+		},
+		{
+			Name:  "2 percentage sensors",
+			Bytes: []byte{3, 120, 5, 5, 120, 100},
+			Struct: cayenneLPP{
+				Percentage: map[byte]uint8{
+					3: 5,
+					5: 100,
+				},
+			},
+		},
+		{
+			Name:  "2 altitude sensors",
+			Bytes: []byte{3, 121, 1, 244, 5, 121, 255, 156},
+			Struct: cayenneLPP{
+				Altitude: map[byte]int16{
+					3: 500,
+					5: -100,
+				},
+			},
+		},
+		{
+			Name:  "2 concentration sensors",
+			Bytes: []byte{3, 125, 1, 144, 5, 125, 3, 232},
+			Struct: cayenneLPP{
+				Concentration: map[byte]uint16{
+					3: 400,
+					5: 1000,
+				},
+			},
+		},
+		{
+			Name:  "2 power sensors",
+			Bytes: []byte{3, 128, 0, 60, 5, 128, 7, 208},
+			Struct: cayenneLPP{
+				Power: map[byte]uint16{
+					3: 60,
+					5: 2000,
+				},
+			},
+		},
+		{
+			Name:  "2 load sensors",
+			Bytes: []byte{3, 122, 0, 4, 210, 5, 122, 255, 255, 255},
+			Struct: cayenneLPP{
+				Load: map[byte]float64{
+					3: 1.234,
+					5: 16777.215,
+				},
+			},
+		},
+		{
+			Name:  "2 distance sensors",
+			Bytes: []byte{3, 130, 0, 0, 4, 210, 5, 130, 0, 1, 134, 160},
+			Struct: cayenneLPP{
+				Distance: map[byte]float64{
+					3: 1.234,
+					5: 100,
+				},
+			},
+		},
+		{
+			Name:  "2 energy sensors",
+			Bytes: []byte{3, 131, 0, 0, 0, 1, 5, 131, 0, 18, 214, 135},
+			Struct: cayenneLPP{
+				Energy: map[byte]float64{
+					3: 0.001,
+					5: 1234.567,
+				},
+			},
+		},
+		{
+			Name:  "2 direction sensors",
+			Bytes: []byte{3, 132, 0, 90, 5, 132, 1, 14},
+			Struct: cayenneLPP{
+				Direction: map[byte]uint16{
+					3: 90,
+					5: 270,
+				},
+			},
+		},
+		{
+			Name:  "unix time",
+			Bytes: []byte{1, 133, 96, 61, 24, 128},
+			Struct: cayenneLPP{
+				UnixTime: map[byte]uint32{
+					1: 1614616704,
+				},
+			},
+		},
+		{
+			Name:  "2 colours",
+			Bytes: []byte{3, 135, 255, 0, 0, 5, 135, 0, 128, 255},
+			Struct: cayenneLPP{
+				Colour: map[byte]colour{
+					3: {R: 255, G: 0, B: 0},
+					5: {R: 0, G: 128, B: 255},
+				},
+			},
+		},
+		{
+			Name:  "2 switches",
+			Bytes: []byte{3, 142, 0, 5, 142, 1},
+			Struct: cayenneLPP{
+				Switch: map[byte]uint8{
+					3: 0,
+					5: 1,
+				},
+			},
+		},
+		{
+			Name:  "mixed types",
+			Bytes: []byte{1, 103, 0, 215, 2, 116, 1, 74, 3, 142, 1},
+			Struct: cayenneLPP{
+				TemperatureSensor: map[byte]float64{
+					1: 21.5,
+				},
+				Voltage: map[byte]float64{
+					2: 3.3,
+				},
+				Switch: map[byte]uint8{
+					3: 1,
+				},
+			},
+		},
 	}
 
 	for _, tst := range tests {
@@ -162,3 +326,61 @@ func TestCayenneLPP(t *testing.T) {
 		})
 	}
 }
+
+func TestCayenneLPPOutOfRange(t *testing.T) {
+	tests := []struct {
+		Name          string
+		JSON          string
+		ExpectedError string
+	}{
+		{
+			Name:          "negative voltage",
+			JSON:          `{"voltage":{"1":-1}}`,
+			ExpectedError: "encode voltage error: value must be in range (0 - 655.35), got: -1",
+		},
+		{
+			Name:          "voltage overflow",
+			JSON:          `{"voltage":{"1":655.36}}`,
+			ExpectedError: "encode voltage error: value must be in range (0 - 655.35), got: 655.36",
+		},
+		{
+			Name:          "current overflow",
+			JSON:          `{"current":{"1":100}}`,
+			ExpectedError: "encode current error: value must be in range (0 - 65.535), got: 100",
+		},
+		{
+			Name:          "negative load",
+			JSON:          `{"load":{"1":-1}}`,
+			ExpectedError: "encode load error: value must be in range (0 - 16777.215), got: -1",
+		},
+		{
+			Name:          "load overflow",
+			JSON:          `{"load":{"1":16777.216}}`,
+			ExpectedError: "encode load error: value must be in range (0 - 16777.215), got: 16777.216",
+		},
+		{
+			Name:          "negative distance",
+			JSON:          `{"distance":{"1":-0.5}}`,
+			ExpectedError: "encode distance error: value must be in range (0 - 4.294967295e+06), got: -0.5",
+		},
+		{
+			Name:          "energy overflow",
+			JSON:          `{"energy":{"1":5000000}}`,
+			ExpectedError: "encode energy error: value must be in range (0 - 4.294967295e+06), got: 5e+06",
+		},
+		{
+			Name:          "percentage overflow",
+			JSON:          `{"percentage":{"1":101}}`,
+			ExpectedError: "encode percentage error: value must be in range (0 - 100), got: 101",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := JSONToBinary([]byte(tst.JSON))
+			assert.EqualError(err, tst.ExpectedError)
+		})
+	}
+}
@@ -1,57 +1,217 @@
 package codec
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
 
-	"github.com/brocaar/chirpstack-application-server/internal/codec/cayennelpp"
-	"github.com/brocaar/chirpstack-application-server/internal/codec/js"
 	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
 )
 
-// Type defines the codec type.
+// Type defines the codec type. This is the name under which the codec is
+// registered.
 type Type string
 
 // Available codec types.
 const (
-	None                = ""
-	CayenneLPPType Type = "CAYENNE_LPP"
-	CustomJSType   Type = "CUSTOM_JS"
+	None                  = ""
+	BinaryLayoutType Type = "BINARY_LAYOUT"
+	CayenneLPPType   Type = "CAYENNE_LPP"
+	CustomJSType     Type = "CUSTOM_JS"
 )
 
-// BinaryToJSON encodes the given binary payload to JSON.
-func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, b []byte) ([]byte, error) {
-	vars := make(map[string]string)
-	for k, v := range variables.Map {
-		if v.Valid {
-			vars[k] = v.String
-		}
+// emptySettingsSchema is the settings JSON Schema of codecs which do not
+// have any settings.
+var emptySettingsSchema = json.RawMessage(`{"type":"object","properties":{},"additionalProperties":false}`)
+
+type contextKey int
+
+const logFuncKey contextKey = iota
+
+// LogFunc defines the function which receives the log output of a codec.
+type LogFunc func(msg string)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[Type]Codec)
+)
+
+// Codec defines the interface of a payload codec.
+type Codec interface {
+	// Info returns the codec information.
+	Info() Info
+
+	// Decode decodes the given binary payload to JSON.
+	Decode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, b []byte) ([]byte, error)
+
+	// Encode encodes the given JSON payload to binary.
+	Encode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, jsonB []byte) ([]byte, error)
+
+	// Validate validates the given codec settings.
+	Validate(settings Settings) error
+}
+
+// Info contains the information of a codec.
+type Info struct {
+	// Name holds the name under which the codec is registered.
+	Name Type
+
+	// Description holds a human-readable description of the codec.
+	Description string
+
+	// Scripts is set when the codec uses the encoder and decoder scripts.
+	Scripts bool
+
+	// SettingsSchema holds the JSON Schema of the codec specific settings.
+	SettingsSchema json.RawMessage
+}
+
+// Settings contains the codec settings of a device-profile or application.
+type Settings struct {
+	EncoderScript string
+	DecoderScript string
+
+	// Config holds the codec specific settings (JSON object).
+	Config json.RawMessage
+}
+
+// Register registers the given codec. It panics when a codec with the same
+// name has already been registered.
+func Register(c Codec) {
+	info := c.Info()
+	if info.Name == None {
+		panic("codec: codec name must not be empty")
 	}
 
-	switch t {
-	case CayenneLPPType:
-		return cayennelpp.BinaryToJSON(b)
-	case CustomJSType:
-		return js.BinaryToJSON(fPort, vars, decodeScript, b)
-	default:
-		return nil, fmt.Errorf("unknown codec type: %s", t)
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[info.Name]; ok {
+		panic("codec: codec already registered: " + string(info.Name))
 	}
+	codecs[info.Name] = c
+}
+
+// Get returns the codec for the given type.
+func Get(t Type) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[t]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownCodec, string(t))
+	}
+	return c, nil
+}
+
+// List returns the information of the registered codecs, sorted by name.
+func List() []Info {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	out := make([]Info, 0, len(codecs))
+	for _, c := range codecs {
+		out = append(out, c.Info())
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// Validate validates that the given codec type is registered and that the
+// settings are valid for the codec. No codec (None) is always valid.
+func Validate(t Type, settings Settings) error {
+	if t == None {
+		return nil
+	}
+
+	c, err := Get(t)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Validate(settings); err != nil {
+		return errors.Wrap(ErrInvalidSettings, err.Error())
+	}
+
+	return nil
+}
+
+// BinaryToJSON encodes the given binary payload to JSON.
+func BinaryToJSON(ctx context.Context, t Type, fPort uint8, variables hstore.Hstore, settings Settings, b []byte) ([]byte, error) {
+	c, err := Get(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decode(ctx, fPort, getVariables(variables), settings, b)
 }
 
 // JSONToBinary encodes the given JSON to binary.
-func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, jsonB []byte) ([]byte, error) {
+func JSONToBinary(ctx context.Context, t Type, fPort uint8, variables hstore.Hstore, settings Settings, jsonB []byte) ([]byte, error) {
+	c, err := Get(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Encode(ctx, fPort, getVariables(variables), settings, jsonB)
+}
+
+// WithLogFunc returns a copy of ctx with the given LogFunc. Codecs which
+// support logging (e.g. console.log in the JavaScript codec) pass the log
+// output to this function.
+func WithLogFunc(ctx context.Context, f LogFunc) context.Context {
+	return context.WithValue(ctx, logFuncKey, f)
+}
+
+// LogFuncFromContext returns the LogFunc set by WithLogFunc, or nil when
+// not set.
+func LogFuncFromContext(ctx context.Context) LogFunc {
+	f, _ := ctx.Value(logFuncKey).(LogFunc)
+	return f
+}
+
+// ErrorPosition returns the line and column within the script, when the
+// given codec error contains this information.
+func ErrorPosition(err error) (line, column int, ok bool) {
+	var pErr interface {
+		Position() (int, int)
+	}
+	if !errors.As(err, &pErr) {
+		return 0, 0, false
+	}
+
+	line, column = pErr.Position()
+	return line, column, true
+}
+
+// IsEmptyConfig returns true when the given codec specific settings are
+// not set, null or an empty JSON object.
+func IsEmptyConfig(config json.RawMessage) bool {
+	config = bytes.TrimSpace(config)
+	if len(config) == 0 || bytes.Equal(config, []byte("null")) {
+		return true
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(config, &m); err != nil {
+		return false
+	}
+	return len(m) == 0
+}
+
+func getVariables(variables hstore.Hstore) map[string]string {
 	vars := make(map[string]string)
 	for k, v := range variables.Map {
 		if v.Valid {
 			vars[k] = v.String
 		}
 	}
-
-	switch t {
-	case CayenneLPPType:
-		return cayennelpp.JSONToBinary(jsonB)
-	case CustomJSType:
-		return js.JSONToBinary(fPort, vars, encodeScript, jsonB)
-	default:
-		return nil, fmt.Errorf("unknown codec type: %s", t)
-	}
+	return vars
 }
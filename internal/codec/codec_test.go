@@ -0,0 +1,196 @@
+package codec
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq/hstore"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// testCodec echoes the payload and the settings.
+type testCodec struct{}
+
+func (testCodec) Info() Info {
+	return Info{
+		Name:           "TEST",
+		Description:    "Test codec",
+		SettingsSchema: json.RawMessage(`{"type":"object","properties":{"prefix":{"type":"string"}}}`),
+	}
+}
+
+func (testCodec) Decode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, b []byte) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"fPort":     fPort,
+		"variables": variables,
+		"settings":  settings.Config,
+		"bytes":     b,
+	})
+}
+
+func (testCodec) Encode(ctx context.Context, fPort uint8, variables map[string]string, settings Settings, jsonB []byte) ([]byte, error) {
+	return jsonB, nil
+}
+
+func (testCodec) Validate(settings Settings) error {
+	var s struct {
+		Prefix string `json:"prefix"`
+	}
+	if err := json.Unmarshal(settings.Config, &s); err != nil {
+		return err
+	}
+	if s.Prefix == "" {
+		return errors.New("prefix must be set")
+	}
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register(testCodec{})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, "TEST")
+		codecsMu.Unlock()
+	}()
+
+	t.Run("Register duplicate", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Panics(func() {
+			Register(testCodec{})
+		})
+	})
+
+	t.Run("List", func(t *testing.T) {
+		assert := require.New(t)
+
+		var names []Type
+		for _, info := range List() {
+			names = append(names, info.Name)
+		}
+		assert.Equal([]Type{BinaryLayoutType, CayenneLPPType, CustomJSType, "TEST"}, names)
+	})
+
+	t.Run("Get unknown", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := Get("FOO")
+		assert.Equal(ErrUnknownCodec, pkgerrors.Cause(err))
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		tests := []struct {
+			Name          string
+			Type          Type
+			Settings      Settings
+			ExpectedError error
+		}{
+			{
+				Name: "no codec",
+				Type: None,
+			},
+			{
+				Name:          "unknown codec",
+				Type:          "FOO",
+				ExpectedError: ErrUnknownCodec,
+			},
+			{
+				Name:     "valid settings",
+				Type:     "TEST",
+				Settings: Settings{Config: json.RawMessage(`{"prefix":"foo"}`)},
+			},
+			{
+				Name:          "invalid settings",
+				Type:          "TEST",
+				Settings:      Settings{Config: json.RawMessage(`{}`)},
+				ExpectedError: ErrInvalidSettings,
+			},
+			{
+				Name:     "builtin codec with empty settings",
+				Type:     CayenneLPPType,
+				Settings: Settings{Config: json.RawMessage(`{}`)},
+			},
+			{
+				Name:          "builtin codec with settings",
+				Type:          CustomJSType,
+				Settings:      Settings{Config: json.RawMessage(`{"prefix":"foo"}`)},
+				ExpectedError: ErrInvalidSettings,
+			},
+			{
+				Name:     "binary layout",
+				Type:     BinaryLayoutType,
+				Settings: Settings{Config: json.RawMessage(`{"schema":"fields: [{name: value, length: 1}]"}`)},
+			},
+			{
+				Name:          "binary layout without schema",
+				Type:          BinaryLayoutType,
+				Settings:      Settings{Config: json.RawMessage(`{}`)},
+				ExpectedError: ErrInvalidSettings,
+			},
+			{
+				Name:          "binary layout with invalid schema",
+				Type:          BinaryLayoutType,
+				Settings:      Settings{Config: json.RawMessage(`{"schema":"fields: [{name: value, length: 9}]"}`)},
+				ExpectedError: ErrInvalidSettings,
+			},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.Name, func(t *testing.T) {
+				assert := require.New(t)
+				assert.Equal(tst.ExpectedError, pkgerrors.Cause(Validate(tst.Type, tst.Settings)))
+			})
+		}
+	})
+
+	t.Run("BinaryToJSON", func(t *testing.T) {
+		assert := require.New(t)
+
+		vars := hstore.Hstore{
+			Map: map[string]sql.NullString{
+				"foo": sql.NullString{String: "bar", Valid: true},
+				"baz": sql.NullString{},
+			},
+		}
+
+		b, err := BinaryToJSON(context.Background(), "TEST", 10, vars, Settings{Config: json.RawMessage(`{"prefix":"foo"}`)}, []byte{0x01, 0x02})
+		assert.NoError(err)
+		assert.JSONEq(`{"fPort":10,"variables":{"foo":"bar"},"settings":{"prefix":"foo"},"bytes":"AQI="}`, string(b))
+
+		_, err = BinaryToJSON(context.Background(), "FOO", 10, vars, Settings{}, nil)
+		assert.Equal(ErrUnknownCodec, pkgerrors.Cause(err))
+	})
+
+	t.Run("JSONToBinary", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := JSONToBinary(context.Background(), CustomJSType, 10, hstore.Hstore{}, Settings{
+			EncoderScript: `function Encode(fPort, obj) { return [fPort, obj.value]; }`,
+		}, []byte(`{"value":5}`))
+		assert.NoError(err)
+		assert.Equal([]byte{10, 5}, b)
+	})
+}
+
+func TestIsEmptyConfig(t *testing.T) {
+	tests := []struct {
+		Config   string
+		Expected bool
+	}{
+		{"", true},
+		{"null", true},
+		{" {} ", true},
+		{`{"foo":"bar"}`, false},
+		{`[]`, false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Config, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, IsEmptyConfig(json.RawMessage(tst.Config)))
+		})
+	}
+}
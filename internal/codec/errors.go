@@ -0,0 +1,9 @@
+package codec
+
+import "errors"
+
+// errors
+var (
+	ErrUnknownCodec    = errors.New("unknown payload codec")
+	ErrInvalidSettings = errors.New("invalid payload codec settings")
+)
@@ -1,37 +1,114 @@
 package js
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/dop251/goja"
 	"github.com/pkg/errors"
-	"github.com/robertkrimen/otto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/config"
 )
 
+// maxCachedPrograms defines the max. number of compiled scripts which are
+// cached. When exceeded, the cache is cleared.
+const maxCachedPrograms = 1024
+
+// scriptPrefix wraps the script in a function scope. It is on the same line
+// as the first line of the script.
+const scriptPrefix = `(function() {`
+
 var (
 	maxExecutionTime = 10 * time.Millisecond
+	maxCallStackSize = 1024
+
+	programsMu sync.RWMutex
+	programs   = make(map[[sha256.Size]byte]*goja.Program)
+
+	errExecutionTimeout = errors.New("execution timeout")
+
+	// positionRegexp matches the script position in goja (syntax) error
+	// messages.
+	positionRegexp = regexp.MustCompile(`codec\.js:(?: Line )?(\d+):(\d+)`)
 )
 
+// Console receives the console.log output of a script. When nil, the output
+// is logged at debug level.
+type Console func(msg string)
+
+// Error contains a script error and its position within the script.
+type Error struct {
+	err    error
+	line   int
+	column int
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Position returns the line and column within the script.
+func (e *Error) Position() (int, int) {
+	return e.line, e.column
+}
+
+// functions contains the codec functions defined by a script.
+type functions struct {
+	Decode         goja.Callable
+	Encode         goja.Callable
+	DecodeUplink   goja.Callable
+	EncodeDownlink goja.Callable
+}
+
 // Setup configures the JS codec.
 func Setup(conf config.Config) error {
 	maxExecutionTime = conf.ApplicationServer.Codec.JS.MaxExecutionTime
+	if conf.ApplicationServer.Codec.JS.MaxCallStackSize > 0 {
+		maxCallStackSize = conf.ApplicationServer.Codec.JS.MaxCallStackSize
+	}
 	return nil
 }
 
-// BinaryToJSON encodes the given binary payload to JSON.
-func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, b []byte) ([]byte, error) {
-	decodeScript = decodeScript + "\n\nDecode(fPort, bytes, variables);\n"
+// BinaryToJSON encodes the given binary payload to JSON. The script must
+// either implement Decode(fPort, bytes, variables) or (TTN-style)
+// decodeUplink(input). When both are implemented, decodeUplink is used.
+func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, b []byte, console Console) ([]byte, error) {
+	v, err := executeJS(decodeScript, console, func(vm *goja.Runtime, fns functions) (goja.Value, error) {
+		bytes := bytesToArray(vm, b)
 
-	vars := make(map[string]interface{})
+		if fns.DecodeUplink != nil {
+			input := vm.NewObject()
+			input.Set("bytes", bytes)
+			input.Set("fPort", fPort)
+			input.Set("variables", variables)
 
-	vars["fPort"] = fPort
-	vars["bytes"] = b
-	vars["variables"] = variables
+			res, err := fns.DecodeUplink(goja.Undefined(), input)
+			if err != nil {
+				return nil, err
+			}
+			return getResult(vm, res, "data")
+		}
 
-	v, err := executeJS(decodeScript, vars)
+		if fns.Decode != nil {
+			return fns.Decode(goja.Undefined(), vm.ToValue(fPort), bytes, vm.ToValue(variables))
+		}
+
+		return nil, errors.New("Decode or decodeUplink function must be defined")
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "execute js error")
 	}
@@ -39,22 +116,35 @@ func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string,
 	return json.Marshal(v)
 }
 
-// JSONToBinary encodes the given JSON payload to binary.
-func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte) ([]byte, error) {
-	var v interface{}
-	if err := json.Unmarshal(b, &v); err != nil {
+// JSONToBinary encodes the given JSON payload to binary. The script must
+// either implement Encode(fPort, obj, variables) or (TTN-style)
+// encodeDownlink(input). When both are implemented, encodeDownlink is used.
+func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte, console Console) ([]byte, error) {
+	var obj interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
 		return nil, errors.Wrap(err, "unmarshal json error")
 	}
 
-	encodeScript = encodeScript + "\n\nEncode(fPort, obj, variables);"
+	v, err := executeJS(encodeScript, console, func(vm *goja.Runtime, fns functions) (goja.Value, error) {
+		if fns.EncodeDownlink != nil {
+			input := vm.NewObject()
+			input.Set("data", obj)
+			input.Set("fPort", fPort)
+			input.Set("variables", variables)
 
-	vars := make(map[string]interface{})
+			res, err := fns.EncodeDownlink(goja.Undefined(), input)
+			if err != nil {
+				return nil, err
+			}
+			return getResult(vm, res, "bytes")
+		}
 
-	vars["fPort"] = fPort
-	vars["obj"] = v
-	vars["variables"] = variables
+		if fns.Encode != nil {
+			return fns.Encode(goja.Undefined(), vm.ToValue(fPort), vm.ToValue(obj), vm.ToValue(variables))
+		}
 
-	v, err := executeJS(encodeScript, vars)
+		return nil, errors.New("Encode or encodeDownlink function must be defined")
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "execute js error")
 	}
@@ -62,38 +152,187 @@ func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string,
 	return interfaceToByteSlice(v)
 }
 
-func executeJS(script string, vars map[string]interface{}) (out interface{}, err error) {
+// executeJS runs the given script and calls f with the codec functions
+// defined by the script. Every execution uses a new runtime, so that changes
+// made by a script (e.g. to the globals or the builtin prototypes) do not
+// leak into other executions. Only the compiled program is cached.
+func executeJS(script string, console Console, f func(vm *goja.Runtime, fns functions) (goja.Value, error)) (out interface{}, err error) {
 	defer func() {
 		if caught := recover(); caught != nil {
 			err = fmt.Errorf("%s", caught)
 		}
 	}()
 
-	vm := otto.New()
-	vm.Interrupt = make(chan func(), 1)
-	vm.SetStackDepthLimit(32)
+	prog, err := getProgram(script)
+	if err != nil {
+		return nil, withPosition(errors.Wrap(err, "js vm error"))
+	}
+
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+	timer := time.AfterFunc(maxExecutionTime, func() {
+		vm.Interrupt(errExecutionTimeout)
+	})
+	defer timer.Stop()
+
+	if err := vm.Set("console", newConsole(vm, console)); err != nil {
+		return nil, errors.Wrap(err, "set console error")
+	}
+
+	v, err := vm.RunProgram(prog)
+	if err != nil {
+		return nil, jsError(err)
+	}
+
+	var fns functions
+	obj := v.ToObject(vm)
+	fns.Decode, _ = goja.AssertFunction(obj.Get("Decode"))
+	fns.Encode, _ = goja.AssertFunction(obj.Get("Encode"))
+	fns.DecodeUplink, _ = goja.AssertFunction(obj.Get("decodeUplink"))
+	fns.EncodeDownlink, _ = goja.AssertFunction(obj.Get("encodeDownlink"))
+
+	v, err = f(vm, fns)
+	if err != nil {
+		return nil, jsError(err)
+	}
+	if v == nil {
+		return nil, nil
+	}
+
+	return v.Export(), nil
+}
+
+// getProgram returns the compiled program for the given script. Compiled
+// programs are cached by the hash of the script.
+func getProgram(script string) (*goja.Program, error) {
+	key := sha256.Sum256([]byte(script))
+
+	programsMu.RLock()
+	prog, ok := programs[key]
+	programsMu.RUnlock()
+	if ok {
+		return prog, nil
+	}
+
+	// The script starts on the first line, so that the line numbers in
+	// error messages match the script.
+	prog, err := goja.Compile("codec.js", scriptPrefix+script+`
+;return {
+	Decode: typeof Decode === "function" ? Decode : undefined,
+	Encode: typeof Encode === "function" ? Encode : undefined,
+	decodeUplink: typeof decodeUplink === "function" ? decodeUplink : undefined,
+	encodeDownlink: typeof encodeDownlink === "function" ? encodeDownlink : undefined
+};
+})()`, false)
+	if err != nil {
+		return nil, err
+	}
+
+	programsMu.Lock()
+	if len(programs) >= maxCachedPrograms {
+		programs = make(map[[sha256.Size]byte]*goja.Program)
+	}
+	programs[key] = prog
+	programsMu.Unlock()
+
+	return prog, nil
+}
+
+// getResult returns the given field of the decodeUplink or encodeDownlink
+// result. An error is returned when the result contains errors.
+func getResult(vm *goja.Runtime, res goja.Value, field string) (goja.Value, error) {
+	if res == nil || goja.IsUndefined(res) || goja.IsNull(res) {
+		return nil, errors.New("function must return an object")
+	}
+	obj := res.ToObject(vm)
 
-	for k, v := range vars {
-		if err := vm.Set(k, v); err != nil {
-			return nil, errors.Wrap(err, "set variable error")
+	if errs := obj.Get("errors"); errs != nil && !goja.IsUndefined(errs) && !goja.IsNull(errs) {
+		var list []string
+		if err := vm.ExportTo(errs, &list); err != nil {
+			return nil, errors.Wrap(err, "errors must be an array of strings")
+		}
+		if len(list) != 0 {
+			return nil, errors.New(strings.Join(list, ", "))
 		}
 	}
 
-	go func() {
-		time.Sleep(maxExecutionTime)
-		vm.Interrupt <- func() {
-			panic(errors.New("execution timeout"))
+	return obj.Get(field), nil
+}
+
+// jsError returns the error for the given script error.
+func jsError(err error) error {
+	if _, ok := err.(*goja.InterruptedError); ok {
+		return errExecutionTimeout
+	}
+	if _, ok := err.(*goja.Exception); ok {
+		return withPosition(errors.Wrap(err, "js vm error"))
+	}
+	return err
+}
+
+// withPosition returns the given error as Error when the error message
+// contains the position within the script. The column of the first line is
+// corrected for the scriptPrefix.
+func withPosition(err error) error {
+	m := positionRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	column, _ := strconv.Atoi(m[2])
+	if line == 1 {
+		column -= len(scriptPrefix)
+	}
+
+	return &Error{
+		err:    err,
+		line:   line,
+		column: column,
+	}
+}
+
+// newConsole returns the console object, implementing console.log.
+func newConsole(vm *goja.Runtime, console Console) *goja.Object {
+	obj := vm.NewObject()
+	obj.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]string, 0, len(call.Arguments))
+		for _, arg := range call.Arguments {
+			args = append(args, consoleString(arg))
 		}
-	}()
+		msg := strings.Join(args, " ")
 
-	var val otto.Value
-	val, err = vm.Run(script)
-	if err != nil {
-		fmt.Println(err)
-		return nil, errors.Wrap(err, "js vm error")
+		if console != nil {
+			console(msg)
+		} else {
+			log.WithField("message", msg).Debug("codec/js: console.log")
+		}
+
+		return goja.Undefined()
+	})
+	return obj
+}
+
+// consoleString returns the console.log representation of the given value.
+// Objects are formatted as JSON.
+func consoleString(v goja.Value) string {
+	if obj, ok := v.(*goja.Object); ok {
+		if _, isFunc := goja.AssertFunction(obj); !isFunc {
+			if b, err := json.Marshal(obj.Export()); err == nil {
+				return string(b)
+			}
+		}
 	}
+	return v.String()
+}
 
-	return val.Export()
+// bytesToArray returns the given bytes as JS array of numbers.
+func bytesToArray(vm *goja.Runtime, b []byte) goja.Value {
+	items := make([]interface{}, len(b))
+	for i := range b {
+		items[i] = b[i]
+	}
+	return vm.NewArray(items...)
 }
 
 func interfaceToByteSlice(obj interface{}) ([]byte, error) {
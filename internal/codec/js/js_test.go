@@ -1,8 +1,12 @@
 package js
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
 	"testing"
 
+	"github.com/dop251/goja"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
@@ -41,7 +45,7 @@ func TestJSDecode(t *testing.T) {
 			Script:        ``,
 			Payload:       []byte{1},
 			FPort:         3,
-			ExpectedError: errors.New("execute js error: js vm error: ReferenceError: 'Decode' is not defined"),
+			ExpectedError: errors.New("execute js error: Decode or decodeUplink function must be defined"),
 		},
 		{
 			Name: "function timeout",
@@ -76,13 +80,79 @@ func TestJSDecode(t *testing.T) {
 			},
 			ExpectedJSON: `{"calibration":1.123,"on":true,"port":3}`,
 		},
+		{
+			Name: "es2020",
+			Script: `
+					const decodeTemperature = (bytes) => new DataView(new Uint8Array(bytes).buffer).getInt16(0) / 10;
+
+					function Decode(fPort, bytes, variables) {
+						let { offset = "0" } = variables;
+						return {
+							temperature: decodeTemperature(bytes) + Number(offset),
+							label: ` + "`port ${fPort}`" + `,
+							unit: variables?.unit ?? "C",
+						};
+					}
+				`,
+			Payload: []byte{0xff, 0x38},
+			FPort:   2,
+			Variables: map[string]string{
+				"offset": "1",
+			},
+			ExpectedJSON: `{"label":"port 2","temperature":-19,"unit":"C"}`,
+		},
+		{
+			Name: "decodeUplink",
+			Script: `
+					function decodeUplink(input) {
+						return {
+							data: {
+								port: input.fPort,
+								bytes: input.bytes.slice(1),
+								calibration: input.variables.calibration
+							},
+							warnings: ["ignored"]
+						};
+					}
+				`,
+			Payload: []byte{1, 2, 3},
+			FPort:   3,
+			Variables: map[string]string{
+				"calibration": "1.123",
+			},
+			ExpectedJSON: `{"bytes":[2,3],"calibration":"1.123","port":3}`,
+		},
+		{
+			Name: "decodeUplink errors",
+			Script: `
+					function decodeUplink(input) {
+						return {
+							errors: ["unknown fPort", "invalid length"]
+						};
+					}
+				`,
+			Payload:       []byte{1},
+			FPort:         3,
+			ExpectedError: errors.New("execute js error: unknown fPort, invalid length"),
+		},
+		{
+			Name: "exception",
+			Script: `
+					function Decode(fPort, bytes) {
+						throw new Error("invalid payload");
+					}
+				`,
+			Payload:       []byte{1},
+			FPort:         3,
+			ExpectedError: errors.New("execute js error: js vm error: Error: invalid payload at Decode (codec.js:3:13(3))"),
+		},
 	}
 
 	for _, tst := range tests {
 		t.Run(tst.Name, func(t *testing.T) {
 			assert := require.New(t)
 
-			jsonB, err := BinaryToJSON(tst.FPort, tst.Variables, tst.Script, tst.Payload)
+			jsonB, err := BinaryToJSON(tst.FPort, tst.Variables, tst.Script, tst.Payload, nil)
 			if tst.ExpectedError != nil {
 				assert.Equal(tst.ExpectedError.Error(), err.Error())
 				return
@@ -156,7 +226,7 @@ func TestJSEncode(t *testing.T) {
 			Script:        ``,
 			FPort:         10,
 			JSON:          `{"Temp": 20}`,
-			ExpectedError: errors.New("execute js error: js vm error: ReferenceError: 'Encode' is not defined"),
+			ExpectedError: errors.New("execute js error: Encode or encodeDownlink function must be defined"),
 		},
 		{
 			Name: "function timeout",
@@ -186,13 +256,53 @@ func TestJSEncode(t *testing.T) {
 			JSON:          `{"Temp": 20}`,
 			ExpectedBytes: []byte{15, 10},
 		},
+		{
+			Name: "return Uint8Array",
+			Script: `
+					function Encode(fPort, obj) {
+						return Uint8Array.of(obj.Temp, fPort);
+					}
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedBytes: []byte{20, 10},
+		},
+		{
+			Name: "encodeDownlink",
+			Script: `
+					function encodeDownlink(input) {
+						return {
+							bytes: [input.data.Temp, input.fPort, Number(input.variables.calibration)]
+						};
+					}
+				`,
+			FPort: 10,
+			Variables: map[string]string{
+				"calibration": "5",
+			},
+			JSON:          `{"Temp": 20}`,
+			ExpectedBytes: []byte{20, 10, 5},
+		},
+		{
+			Name: "encodeDownlink errors",
+			Script: `
+					function encodeDownlink(input) {
+						return {
+							errors: ["invalid Temp"]
+						};
+					}
+				`,
+			FPort:         10,
+			JSON:          `{"Temp": 20}`,
+			ExpectedError: errors.New("execute js error: invalid Temp"),
+		},
 	}
 
 	for _, tst := range tests {
 		t.Run(tst.Name, func(t *testing.T) {
 			assert := require.New(t)
 
-			b, err := JSONToBinary(tst.FPort, tst.Variables, tst.Script, []byte(tst.JSON))
+			b, err := JSONToBinary(tst.FPort, tst.Variables, tst.Script, []byte(tst.JSON), nil)
 			if tst.ExpectedError != nil {
 				assert.Equal(tst.ExpectedError.Error(), err.Error())
 				return
@@ -202,3 +312,175 @@ func TestJSEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestJSProgramCache(t *testing.T) {
+	assert := require.New(t)
+	programs = make(map[[sha256.Size]byte]*goja.Program)
+
+	scriptA := `function Decode(fPort, bytes) { return {"script": "a"}; }`
+	scriptB := `var x = 1;`
+
+	// the Decode function of script A must not leak into the execution of
+	// script B
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			b, err := BinaryToJSON(1, nil, scriptA, []byte{1}, nil)
+			assert.NoError(err)
+			assert.Equal(`{"script":"a"}`, string(b))
+
+			_, err = BinaryToJSON(1, nil, scriptB, []byte{1}, nil)
+			assert.Error(err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(programs, 2)
+}
+
+func TestJSRuntimeIsolation(t *testing.T) {
+	assert := require.New(t)
+
+	scriptA := `
+Array.prototype.map = function() { return ["hijacked"]; };
+globalThis.leak = 42;
+
+function Decode(fPort, bytes) {
+	return {"leak": globalThis.leak};
+}`
+
+	scriptB := `
+function Decode(fPort, bytes) {
+	return {
+		"leak": typeof leak,
+		"mapped": bytes.map(function(b) { return b * 2; })
+	};
+}`
+
+	b, err := BinaryToJSON(1, nil, scriptA, []byte{1}, nil)
+	assert.NoError(err)
+	assert.Equal(`{"leak":42}`, string(b))
+
+	// script B must not see the changes made by script A
+	b, err = BinaryToJSON(1, nil, scriptB, []byte{1, 2}, nil)
+	assert.NoError(err)
+	assert.Equal(`{"leak":"undefined","mapped":[2,4]}`, string(b))
+}
+
+func TestJSCallStackSize(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Depth         int
+		ExpectedError bool
+	}{
+		{
+			Name:  "recursive decoder",
+			Depth: 100,
+		},
+		{
+			Name:          "call stack size exceeded",
+			Depth:         100000,
+			ExpectedError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			script := fmt.Sprintf(`
+function depth(n) {
+	return n === 0 ? 0 : 1 + depth(n - 1);
+}
+
+function Decode(fPort, bytes) {
+	return {"depth": depth(%d)};
+}`, tst.Depth)
+
+			b, err := BinaryToJSON(1, nil, script, []byte{1}, nil)
+			if tst.ExpectedError {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(fmt.Sprintf(`{"depth":%d}`, tst.Depth), string(b))
+		})
+	}
+}
+
+func TestJSConsole(t *testing.T) {
+	assert := require.New(t)
+
+	script := `
+function Decode(fPort, bytes) {
+	console.log("fPort:", fPort, {"bytes": bytes});
+	return {};
+}
+`
+
+	var logs []string
+	_, err := BinaryToJSON(10, nil, script, []byte{1, 2}, func(msg string) {
+		logs = append(logs, msg)
+	})
+	assert.NoError(err)
+	assert.Equal([]string{`fPort: 10 {"bytes":[1,2]}`}, logs)
+
+	// without console, the output is logged
+	_, err = BinaryToJSON(10, nil, script, []byte{1, 2}, nil)
+	assert.NoError(err)
+}
+
+func TestJSErrorPosition(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Script         string
+		ExpectedLine   int
+		ExpectedColumn int
+	}{
+		{
+			Name:           "syntax error on first line",
+			Script:         `var x = ;`,
+			ExpectedLine:   1,
+			ExpectedColumn: 9,
+		},
+		{
+			Name: "syntax error",
+			Script: `
+function Decode(fPort, bytes) {
+	return bytes[0] +;
+}
+`,
+			ExpectedLine:   3,
+			ExpectedColumn: 19,
+		},
+		{
+			Name: "exception",
+			Script: `
+function Decode(fPort, bytes) {
+	throw new Error("invalid payload");
+}
+`,
+			ExpectedLine:   3,
+			ExpectedColumn: 8,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := BinaryToJSON(10, nil, tst.Script, []byte{1}, nil)
+			assert.Error(err)
+
+			var jsErr *Error
+			assert.True(errors.As(err, &jsErr))
+
+			line, column := jsErr.Position()
+			assert.Equal(tst.ExpectedLine, line)
+			assert.Equal(tst.ExpectedColumn, column)
+		})
+	}
+}
@@ -54,20 +54,28 @@ type Config struct {
 		Codec struct {
 			JS struct {
 				MaxExecutionTime time.Duration `mapstructure:"max_execution_time"`
+				MaxCallStackSize int           `mapstructure:"max_call_stack_size"`
 			} `mapstructure:"js"`
 		} `mapstructure:"codec"`
 
 		Integration struct {
-			Marshaler       string                      `mapstructure:"marshaler"`
-			Backend         string                      `mapstructure:"backend"` // deprecated
-			Enabled         []string                    `mapstructure:"enabled"`
-			AWSSNS          IntegrationAWSSNSConfig     `mapstructure:"aws_sns"`
-			AzureServiceBus IntegrationAzureConfig      `mapstructure:"azure_service_bus"`
-			MQTT            IntegrationMQTTConfig       `mapstructure:"mqtt"`
-			GCPPubSub       IntegrationGCPConfig        `mapstructure:"gcp_pub_sub"`
-			Kafka           IntegrationKafkaConfig      `mapstructure:"kafka"`
-			PostgreSQL      IntegrationPostgreSQLConfig `mapstructure:"postgresql"`
-			AMQP            IntegrationAMQPConfig       `mapstructure:"amqp"`
+			Marshaler       string                         `mapstructure:"marshaler"`
+			Backend         string                         `mapstructure:"backend"` // deprecated
+			Enabled         []string                       `mapstructure:"enabled"`
+			AWSSNS          IntegrationAWSSNSConfig        `mapstructure:"aws_sns"`
+			AzureServiceBus IntegrationAzureConfig         `mapstructure:"azure_service_bus"`
+			MQTT            IntegrationMQTTConfig          `mapstructure:"mqtt"`
+			GCPPubSub       IntegrationGCPConfig           `mapstructure:"gcp_pub_sub"`
+			Kafka           IntegrationKafkaConfig         `mapstructure:"kafka"`
+			PostgreSQL      IntegrationPostgreSQLConfig    `mapstructure:"postgresql"`
+			AMQP            IntegrationAMQPConfig          `mapstructure:"amqp"`
+			NATS            IntegrationNATSConfig          `mapstructure:"nats"`
+			RedisStreams    IntegrationRedisStreamsConfig  `mapstructure:"redis_streams"`
+			SparkplugB      IntegrationSparkplugBConfig    `mapstructure:"sparkplug_b"`
+			HomeAssistant   IntegrationHomeAssistantConfig `mapstructure:"home_assistant"`
+			S3Archive       IntegrationS3ArchiveConfig     `mapstructure:"s3_archive"`
+			Outbox          IntegrationOutboxConfig        `mapstructure:"outbox"`
+			WorkerPool      IntegrationWorkerPoolConfig    `mapstructure:"worker_pool"`
 		} `mapstructure:"integration"`
 
 		API struct {
@@ -150,6 +158,7 @@ type IntegrationMQTTConfig struct {
 	EventTopicTemplate   string        `mapstructure:"event_topic_template"`
 	CommandTopicTemplate string        `mapstructure:"command_topic_template"`
 	RetainEvents         bool          `mapstructure:"retain_events"`
+	PayloadTemplate      string        `mapstructure:"payload_template"`
 
 	// For backards compatibility
 	UplinkTopicTemplate        string `mapstructure:"uplink_topic_template"`
@@ -185,6 +194,7 @@ type IntegrationAWSSNSConfig struct {
 	AWSAccessKeyID     string `mapstructure:"aws_access_key_id" json:"accessKeyID"`
 	AWSSecretAccessKey string `mapstructure:"aws_secret_access_key" json:"secretAccessKey"`
 	TopicARN           string `mapstructure:"topic_arn" json:"topicARN"`
+	PayloadTemplate    string `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
 }
 
 // IntegrationAzureConfig holds the Azure Service-Bus integration configuration.
@@ -193,6 +203,7 @@ type IntegrationAzureConfig struct {
 	ConnectionString string           `mapstructure:"connection_string" json:"connectionString"`
 	PublishMode      AzurePublishMode `mapstructure:"publish_mode" json:"-"`
 	PublishName      string           `mapstructure:"publish_name" json:"publishName"`
+	PayloadTemplate  string           `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
 }
 
 // IntegrationGCPConfig holds the GCP Pub/Sub integration configuration.
@@ -202,31 +213,132 @@ type IntegrationGCPConfig struct {
 	CredentialsFileBytes []byte `mapstructure:"-" json:"credentialsFile"`
 	ProjectID            string `mapstructure:"project_id" json:"projectID"`
 	TopicName            string `mapstructure:"topic_name" json:"topicName"`
+	PayloadTemplate      string `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
 }
 
 // IntegrationPostgreSQLConfig holds the PostgreSQL integration configuration.
 type IntegrationPostgreSQLConfig struct {
 	DSN                string `json:"dsn"`
-	MaxOpenConnections int    `mapstructure:"max_open_connections"`
-	MaxIdleConnections int    `mapstructure:"max_idle_connections"`
+	MaxOpenConnections int    `mapstructure:"max_open_connections" json:"maxOpenConnections"`
+	MaxIdleConnections int    `mapstructure:"max_idle_connections" json:"maxIdleConnections"`
 }
 
 // IntegrationAMQPConfig holds the AMQP integration configuration.
+// The command queue of a per-application integration is only bound to, and
+// only accepts the downlink commands of its application.
 type IntegrationAMQPConfig struct {
-	URL                     string `mapstructure:"url"`
-	EventRoutingKeyTemplate string `mapstructure:"event_routing_key_template"`
+	URL                       string `mapstructure:"url" json:"url"`
+	EventRoutingKeyTemplate   string `mapstructure:"event_routing_key_template" json:"eventRoutingKeyTemplate"`
+	CommandQueueName          string `mapstructure:"command_queue_name" json:"commandQueueName,omitempty"`
+	CommandBindingKeyTemplate string `mapstructure:"command_binding_key_template" json:"commandBindingKeyTemplate,omitempty"`
+	PayloadTemplate           string `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
+}
+
+// IntegrationNATSConfig holds the NATS integration configuration.
+// A per-application integration only subscribes to, and only accepts the
+// downlink commands of its application.
+type IntegrationNATSConfig struct {
+	Server                 string `mapstructure:"server" json:"server"`
+	Username               string `mapstructure:"username" json:"username"`
+	Password               string `mapstructure:"password" json:"password"`
+	EventSubjectTemplate   string `mapstructure:"event_subject_template" json:"eventSubjectTemplate"`
+	JetStream              bool   `mapstructure:"jetstream" json:"jetStream"`
+	CommandSubjectTemplate string `mapstructure:"command_subject_template" json:"commandSubjectTemplate,omitempty"`
+	CommandQueueGroup      string `mapstructure:"command_queue_group" json:"commandQueueGroup,omitempty"`
+	PayloadTemplate        string `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
+}
+
+// IntegrationRedisStreamsConfig holds the Redis Streams integration
+// configuration. When no server is configured, the Redis client of the
+// application-server is used.
+type IntegrationRedisStreamsConfig struct {
+	Server            string `mapstructure:"server" json:"server"`
+	Password          string `mapstructure:"password" json:"password"`
+	Database          int    `mapstructure:"database" json:"database"`
+	TLSEnabled        bool   `mapstructure:"tls_enabled" json:"tlsEnabled"`
+	StreamKeyTemplate string `mapstructure:"stream_key_template" json:"streamKeyTemplate"`
+	MaxLen            int64  `mapstructure:"max_len" json:"maxLen"`
+	PayloadTemplate   string `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
+
+	// MaxLenLimit is the max. MaxLen of the per-application integrations.
+	// This is only used in the global configuration.
+	MaxLenLimit int64 `mapstructure:"max_len_limit" json:"-"`
+}
+
+// IntegrationSparkplugBConfig holds the MQTT Sparkplug B integration
+// configuration.
+type IntegrationSparkplugBConfig struct {
+	Server               string        `mapstructure:"server"`
+	Username             string        `mapstructure:"username"`
+	Password             string        `mapstructure:"password"`
+	MaxReconnectInterval time.Duration `mapstructure:"max_reconnect_interval"`
+	ClientID             string        `mapstructure:"client_id"`
+	CACert               string        `mapstructure:"ca_cert"`
+	TLSCert              string        `mapstructure:"tls_cert"`
+	TLSKey               string        `mapstructure:"tls_key"`
+	GroupID              string        `mapstructure:"group_id"`
+	DeviceTimeout        time.Duration `mapstructure:"device_timeout"`
+	DownlinkFPort        uint8         `mapstructure:"downlink_fport"`
+}
+
+// IntegrationHomeAssistantConfig holds the Home Assistant MQTT discovery
+// integration configuration. The connection settings of the MQTT
+// integration are used to connect to the MQTT broker.
+type IntegrationHomeAssistantConfig struct {
+	DiscoveryPrefix    string `mapstructure:"discovery_prefix"`
+	StateTopicTemplate string `mapstructure:"state_topic_template"`
+	RetainState        bool   `mapstructure:"retain_state"`
+}
+
+// IntegrationS3ArchiveConfig holds the object-storage (S3 compatible)
+// archive integration configuration.
+type IntegrationS3ArchiveConfig struct {
+	Endpoint        string        `mapstructure:"endpoint"`
+	Region          string        `mapstructure:"region"`
+	AccessKeyID     string        `mapstructure:"access_key_id"`
+	SecretAccessKey string        `mapstructure:"secret_access_key"`
+	Bucket          string        `mapstructure:"bucket"`
+	ForcePathStyle  bool          `mapstructure:"force_path_style"`
+	KeyPrefix       string        `mapstructure:"key_prefix"`
+	FlushInterval   time.Duration `mapstructure:"flush_interval"`
+	MaxBufferSize   int64         `mapstructure:"max_buffer_size"`
+	PartSize        int64         `mapstructure:"part_size"`
+	MaxRetries      int           `mapstructure:"max_retries"`
 }
 
 // IntegrationKafkaConfig holds the Kafka integration configuration.
+// A per-application integration only accepts the downlink commands of its
+// application.
 type IntegrationKafkaConfig struct {
-	Brokers          []string `mapstructure:"brokers"`
-	TLS              bool     `mapstructure:"tls"`
-	Topic            string   `mapstructure:"topic"`
-	EventKeyTemplate string   `mapstructure:"event_key_template"`
-	Username         string   `mapstructure:"username"`
-	Password         string   `mapstructure:"password"`
-	Mechanism        string   `mapstructure:"mechanism"`
-	Algorithm        string   `mapstructure:"algorithm"`
+	Brokers          []string `mapstructure:"brokers" json:"brokers"`
+	TLS              bool     `mapstructure:"tls" json:"tls"`
+	Topic            string   `mapstructure:"topic" json:"topic"`
+	EventKeyTemplate string   `mapstructure:"event_key_template" json:"eventKeyTemplate"`
+	CommandTopic     string   `mapstructure:"command_topic" json:"commandTopic,omitempty"`
+	CommandGroupID   string   `mapstructure:"command_group_id" json:"commandGroupID,omitempty"`
+	ReplyTopic       string   `mapstructure:"reply_topic" json:"replyTopic,omitempty"`
+	Username         string   `mapstructure:"username" json:"username"`
+	Password         string   `mapstructure:"password" json:"password"`
+	Mechanism        string   `mapstructure:"mechanism" json:"mechanism"`
+	Algorithm        string   `mapstructure:"algorithm" json:"algorithm"`
+	PayloadTemplate  string   `mapstructure:"payload_template" json:"payloadTemplate,omitempty"`
+}
+
+// IntegrationOutboxConfig holds the integration outbox configuration.
+type IntegrationOutboxConfig struct {
+	Enabled              bool          `mapstructure:"enabled"`
+	MaxAge               time.Duration `mapstructure:"max_age"`
+	RetryInitialInterval time.Duration `mapstructure:"retry_initial_interval"`
+	RetryMaxInterval     time.Duration `mapstructure:"retry_max_interval"`
+	DeadLetterMaxHistory int64         `mapstructure:"dead_letter_max_history"`
+	Workers              int           `mapstructure:"workers"`
+}
+
+// IntegrationWorkerPoolConfig holds the integration worker-pool configuration.
+type IntegrationWorkerPoolConfig struct {
+	Workers    int    `mapstructure:"workers"`
+	QueueSize  int    `mapstructure:"queue_size"`
+	FullPolicy string `mapstructure:"full_policy"`
 }
 
 // AzurePublishMode defines the publish-mode type.
@@ -32,18 +32,28 @@ func HandleDataDownPayloads(downChan chan models.DataDownPayload) {
 			ctx := context.Background()
 			ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
-			if err := handleDataDownPayload(ctx, pl); err != nil {
+			fCnt, err := handleDataDownPayload(ctx, pl)
+			if err != nil {
 				log.WithFields(log.Fields{
 					"dev_eui":        pl.DevEUI,
 					"application_id": pl.ApplicationID,
 				}).Errorf("handle data-down payload error: %s", err)
 			}
+
+			if pl.ResultChan != nil {
+				pl.ResultChan <- models.DataDownResult{
+					FCnt:  fCnt,
+					Error: err,
+				}
+			}
 		}(pl)
 	}
 }
 
-func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) error {
-	return storage.Transaction(func(tx sqlx.Ext) error {
+func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) (uint32, error) {
+	var fCnt uint32
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
 		// lock the device so that a concurrent Enqueue action will block
 		// until this transaction has been completed
 		d, err := storage.GetDevice(ctx, tx, pl.DevEUI, true, true)
@@ -75,26 +85,29 @@ func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) error
 			// TODO: in the next major release, remove this and always use the
 			// device-profile codec fields.
 			payloadCodec := app.PayloadCodec
-			payloadEncoderScript := app.PayloadEncoderScript
+			codecSettings := app.CodecSettings()
 
 			if dp.PayloadCodec != "" {
 				payloadCodec = dp.PayloadCodec
-				payloadEncoderScript = dp.PayloadEncoderScript
+				codecSettings = dp.CodecSettings()
 			}
 
-			pl.Data, err = codec.JSONToBinary(payloadCodec, pl.FPort, d.Variables, payloadEncoderScript, []byte(pl.Object))
+			pl.Data, err = codec.JSONToBinary(ctx, payloadCodec, pl.FPort, d.Variables, codecSettings, []byte(pl.Object))
 			if err != nil {
 				logCodecError(ctx, app, d, err)
 				return errors.Wrap(err, "encode object error")
 			}
 		}
 
-		if _, err := storage.EnqueueDownlinkPayload(ctx, tx, pl.DevEUI, pl.Confirmed, pl.FPort, pl.Data); err != nil {
+		fCnt, err = storage.EnqueueDownlinkPayload(ctx, tx, pl.DevEUI, pl.Confirmed, pl.FPort, pl.Data)
+		if err != nil {
 			return errors.Wrap(err, "enqueue downlink device-queue item error")
 		}
 
 		return nil
 	})
+
+	return fCnt, err
 }
 
 func logCodecError(ctx context.Context, a storage.Application, d storage.Device, err error) {
@@ -230,7 +230,7 @@ func TestHandleDownlinkQueueItem(t *testing.T) {
 					dp.PayloadEncoderScript = test.PayloadEncoderScript
 					So(storage.UpdateDeviceProfile(context.Background(), storage.DB(), &dp), ShouldBeNil)
 
-					err := handleDataDownPayload(context.Background(), test.Payload)
+					_, err := handleDataDownPayload(context.Background(), test.Payload)
 					if test.ExpectedError != nil {
 						So(err, ShouldNotBeNil)
 						So(err.Error(), ShouldEqual, test.ExpectedError.Error())
@@ -184,11 +184,11 @@ func decryptPayload(ctx *uplinkContext) error {
 
 func handleCodec(ctx *uplinkContext) error {
 	codecType := ctx.application.PayloadCodec
-	decoderScript := ctx.application.PayloadDecoderScript
+	codecSettings := ctx.application.CodecSettings()
 
 	if ctx.deviceProfile.PayloadCodec != "" {
 		codecType = ctx.deviceProfile.PayloadCodec
-		decoderScript = ctx.deviceProfile.PayloadDecoderScript
+		codecSettings = ctx.deviceProfile.CodecSettings()
 	}
 
 	if codecType == codec.None {
@@ -196,7 +196,7 @@ func handleCodec(ctx *uplinkContext) error {
 	}
 
 	start := time.Now()
-	b, err := codec.BinaryToJSON(codecType, uint8(ctx.uplinkDataReq.FPort), ctx.device.Variables, decoderScript, ctx.data)
+	b, err := codec.BinaryToJSON(ctx.ctx, codecType, uint8(ctx.uplinkDataReq.FPort), ctx.device.Variables, codecSettings, ctx.data)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"codec":          codecType,
@@ -292,14 +292,14 @@ func handleIntegrations(ctx *uplinkContext) error {
 	bgCtx := context.Background()
 	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
 
-	// Handle the actual integration handling in a Go-routine so that the
-	// as.HandleUplinkData api can return.
-	go func() {
-		err := integration.ForApplicationID(ctx.device.ApplicationID).HandleUplinkEvent(bgCtx, vars, pl)
-		if err != nil {
-			log.WithError(err).Error("send uplink event error")
-		}
-	}()
+	// The integrations handle the event asynchronously using a bounded
+	// worker-pool. Depending the configured policy, this blocks when the
+	// worker-pool queue is full, which applies backpressure on the
+	// as.HandleUplinkData api.
+	err := integration.ForApplicationID(ctx.device.ApplicationID).HandleUplinkEvent(bgCtx, vars, pl)
+	if err != nil {
+		log.WithError(err).Error("send uplink event error")
+	}
 
 	return nil
 }
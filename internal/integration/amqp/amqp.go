@@ -3,7 +3,10 @@ package amqp
 import (
 	"bytes"
 	"context"
+	"regexp"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -18,23 +21,73 @@ import (
 	"github.com/streadway/amqp"
 )
 
+const (
+	publishAttempts       = 3
+	publishRetryInterval  = time.Second
+	publishConfirmTimeout = 10 * time.Second
+)
+
 // Integration implements an AMQP integration.
 type Integration struct {
 	chPool *pool
 
 	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
 	eventRoutingKey *template.Template
+
+	// Downlink commands. When applicationID is set, only the commands of
+	// this application are consumed.
+	applicationID     int64
+	commandQueueName  string
+	commandBindingKey string
+	commandRegexp     *regexp.Regexp
+	done              chan struct{}
+	wg                sync.WaitGroup
 }
 
-// New creates a new AMQP integration.
+// New creates a new (global) AMQP integration. It blocks until the connection
+// to the broker has been established. When a command queue is configured, the
+// downlink commands of all applications are consumed.
 func New(m marshaler.Type, conf config.IntegrationAMQPConfig) (*Integration, error) {
+	return newIntegration(m, conf, true, 0, conf.CommandQueueName != "")
+}
+
+// NewForApplication creates a new AMQP integration for the given application.
+// Unlike New, it returns an error when the broker is not reachable. It only
+// publishes the events, the downlink commands are consumed by the integration
+// returned by NewCommandConsumer.
+func NewForApplication(m marshaler.Type, conf config.IntegrationAMQPConfig, applicationID int64) (*Integration, error) {
+	return newIntegration(m, conf, false, applicationID, false)
+}
+
+// NewCommandConsumer creates a new AMQP integration, consuming the downlink
+// commands of the given application from the command queue. The queue is
+// only bound to the routing-keys of the given application and commands for
+// other applications are rejected.
+func NewCommandConsumer(m marshaler.Type, conf config.IntegrationAMQPConfig, applicationID int64) (*Integration, error) {
+	if conf.CommandQueueName == "" {
+		return nil, errors.New("command queue name must be set")
+	}
+	return newIntegration(m, conf, false, applicationID, true)
+}
+
+func newIntegration(m marshaler.Type, conf config.IntegrationAMQPConfig, retry bool, applicationID int64, commands bool) (*Integration, error) {
 	var err error
 	i := Integration{
-		marshaler: m,
+		marshaler:     m,
+		applicationID: applicationID,
+	}
+
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
 	}
 
 	log.Info("integration/amqp: connecting to amqp broker")
-	i.chPool, err = newPool(10, conf.URL)
+	i.chPool, err = newPool(10, conf.URL, retry)
 	if err != nil {
 		return nil, errors.Wrap(err, "new amqp channel pool error")
 	}
@@ -44,6 +97,29 @@ func New(m marshaler.Type, conf config.IntegrationAMQPConfig) (*Integration, err
 		return nil, errors.Wrap(err, "parse event routing-key template error")
 	}
 
+	if commands {
+		commandBindingKey, err := template.New("command").Parse(conf.CommandBindingKeyTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse command binding-key template error")
+		}
+
+		i.commandBindingKey, err = getCommandBindingKey(commandBindingKey, applicationID)
+		if err != nil {
+			return nil, errors.Wrap(err, "get command binding-key error")
+		}
+
+		i.commandRegexp, err = getCommandRoutingKeyRegexp(commandBindingKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "get command routing-key regexp error")
+		}
+
+		i.commandQueueName = conf.CommandQueueName
+		i.done = make(chan struct{})
+
+		i.wg.Add(1)
+		go i.consumeCommands()
+	}
+
 	return &i, nil
 }
 
@@ -94,21 +170,21 @@ func (i *Integration) DataDownChan() chan models.DataDownPayload {
 
 // Close closes the integration.
 func (i *Integration) Close() error {
-	return i.chPool.close()
+	if i.done != nil {
+		close(i.done)
+	}
+
+	err := i.chPool.close()
+	i.wg.Wait()
+	return err
 }
 
 func (i *Integration) publishEvent(ctx context.Context, applicationID uint64, devEUIB []byte, typ string, msg proto.Message) error {
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], devEUIB)
 
-	ch, err := i.chPool.get()
-	if err != nil {
-		return errors.Wrap(err, "get amqp channel error")
-	}
-	defer ch.close()
-
 	routingKey := bytes.NewBuffer(nil)
-	err = i.eventRoutingKey.Execute(routingKey, struct {
+	err := i.eventRoutingKey.Execute(routingKey, struct {
 		ApplicationID uint64
 		DevEUI        lorawan.EUI64
 		EventType     string
@@ -117,7 +193,7 @@ func (i *Integration) publishEvent(ctx context.Context, applicationID uint64, de
 		return errors.Wrap(err, "execute template error")
 	}
 
-	b, err := marshaler.Marshal(i.marshaler, msg)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return err
 	}
@@ -135,20 +211,50 @@ func (i *Integration) publishEvent(ctx context.Context, applicationID uint64, de
 		contentType = "application/octet-stream"
 	}
 
-	err = ch.ch.Publish(
-		"amq.topic",
-		routingKey.String(),
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: contentType,
-			Body:        b,
-		},
-	)
+	pub := amqp.Publishing{
+		ContentType: contentType,
+		Body:        b,
+	}
+
+	// The channel might have been dropped by the broker (or the connection
+	// might be re-established), in which case the event is re-published
+	// using a new channel.
+	for attempt := 1; ; attempt++ {
+		err = i.publish(ctx, routingKey.String(), pub)
+		if err == nil || attempt == publishAttempts {
+			break
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"routing_key": routingKey.String(),
+			"attempt":     attempt,
+			"ctx_id":      ctx.Value(logging.ContextIDKey),
+		}).Warning("integration/amqp: publish event error, retrying")
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "publish event error")
+		case <-time.After(publishRetryInterval):
+		}
+	}
 	if err != nil {
-		ch.markUnusable()
 		return errors.Wrap(err, "publish event error")
 	}
 
 	return nil
 }
+
+// publish publishes the given message to the "amq.topic" exchange, using a
+// channel from the pool.
+func (i *Integration) publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	ch, err := i.chPool.get()
+	if err != nil {
+		return errors.Wrap(err, "get amqp channel error")
+	}
+	defer ch.close()
+
+	ctx, cancel := context.WithTimeout(ctx, publishConfirmTimeout)
+	defer cancel()
+
+	return ch.publish(ctx, "amq.topic", routingKey, msg)
+}
@@ -11,6 +11,7 @@ import (
 
 	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
 	"github.com/brocaar/chirpstack-application-server/internal/test"
 	"github.com/brocaar/lorawan"
 )
@@ -72,7 +73,11 @@ func (ts *IntegrationTestSuite) SetupSuite() {
 	)
 	assert.NoError(err)
 
-	ts.integration, err = New(marshaler.Protobuf, conf.ApplicationServer.Integration.AMQP)
+	amqpConf := conf.ApplicationServer.Integration.AMQP
+	amqpConf.CommandQueueName = "test-command-queue"
+	amqpConf.CommandBindingKeyTemplate = "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.command.{{ .CommandType }}"
+
+	ts.integration, err = New(marshaler.Protobuf, amqpConf)
 	assert.NoError(err)
 }
 
@@ -251,6 +256,30 @@ func (ts *IntegrationTestSuite) TestIntegrationEvent() {
 	assert.True(proto.Equal(&pl, &plReceived))
 }
 
+func (ts *IntegrationTestSuite) TestDownlinkCommand() {
+	assert := require.New(ts.T())
+
+	err := ts.amqpChan.Publish(
+		"amq.topic",
+		"application.10.device.0102030405060708.command.down",
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        []byte(`{"confirmed":true,"fPort":10,"data":"AQID"}`),
+		},
+	)
+	assert.NoError(err)
+
+	assert.Equal(models.DataDownPayload{
+		ApplicationID: 10,
+		DevEUI:        ts.devEUI,
+		Confirmed:     true,
+		FPort:         10,
+		Data:          []byte{1, 2, 3},
+	}, <-DownlinkChan())
+}
+
 func TestIntegration(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
@@ -1,6 +1,7 @@
 package amqp
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -11,8 +12,32 @@ import (
 
 var errClosed = errors.New("pool is closed")
 
+// confirmChannel wraps an AMQP channel in confirm mode, together with the
+// channel on which the broker publishes its confirmations.
+type confirmChannel struct {
+	*amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func newConfirmChannel(conn *amqp.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, errors.Wrap(err, "enable publisher confirms error")
+	}
+
+	return &confirmChannel{
+		Channel:  ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}, nil
+}
+
 type poolChannel struct {
-	ch       *amqp.Channel
+	ch       *confirmChannel
 	mu       sync.RWMutex
 	p        *pool
 	unusable bool
@@ -20,21 +45,27 @@ type poolChannel struct {
 
 type pool struct {
 	mu    sync.RWMutex
-	chans chan *amqp.Channel
+	chans chan *confirmChannel
 	url   string
 	conn  *amqp.Connection
 }
 
-func newPool(size int, url string) (*pool, error) {
+// newPool creates a new channel pool. When retry is set, it blocks until the
+// connection to the broker has been established.
+func newPool(size int, url string, retry bool) (*pool, error) {
 	p := &pool{
-		chans: make(chan *amqp.Channel, size),
+		chans: make(chan *confirmChannel, size),
 		url:   url,
 	}
 
-	p.connect()
+	if retry {
+		p.connect()
+	} else if err := p.dial(); err != nil {
+		return nil, errors.Wrap(err, "dial amqp url error")
+	}
 
 	for i := 0; i < size; i++ {
-		ch, err := p.conn.Channel()
+		ch, err := newConfirmChannel(p.conn)
 		if err != nil {
 			p.close()
 			return nil, errors.Wrap(err, "create channel error")
@@ -47,33 +78,41 @@ func newPool(size int, url string) (*pool, error) {
 }
 
 func (p *pool) connect() {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	for {
-		conn, err := amqp.Dial(p.url)
-		if err != nil {
+		if err := p.dial(); err != nil {
 			log.WithError(err).Error("integration/amqp: dial amqp url error")
 			time.Sleep(time.Second)
 			continue
 		}
 
-		p.conn = conn
-
-		closeChan := make(chan *amqp.Error)
-		p.conn.NotifyClose(closeChan)
+		break
+	}
+}
 
-		go func() {
-			for range closeChan {
-				p.connect()
-			}
-		}()
+func (p *pool) dial() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-		break
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return err
 	}
+
+	p.conn = conn
+
+	closeChan := make(chan *amqp.Error)
+	p.conn.NotifyClose(closeChan)
+
+	go func() {
+		for range closeChan {
+			p.connect()
+		}
+	}()
+
+	return nil
 }
 
-func (p *pool) getChansAndConn() (chan *amqp.Channel, *amqp.Connection) {
+func (p *pool) getChansAndConn() (chan *confirmChannel, *amqp.Connection) {
 	p.mu.RLock()
 	chans := p.chans
 	conn := p.conn
@@ -81,7 +120,7 @@ func (p *pool) getChansAndConn() (chan *amqp.Channel, *amqp.Connection) {
 	return chans, conn
 }
 
-func (p *pool) wrapChan(ch *amqp.Channel) *poolChannel {
+func (p *pool) wrapChan(ch *confirmChannel) *poolChannel {
 	return &poolChannel{
 		ch: ch,
 		p:  p,
@@ -102,7 +141,7 @@ func (p *pool) get() (*poolChannel, error) {
 
 		return p.wrapChan(ch), nil
 	default:
-		ch, err := conn.Channel()
+		ch, err := newConfirmChannel(conn)
 		if err != nil {
 			return nil, err
 		}
@@ -111,7 +150,7 @@ func (p *pool) get() (*poolChannel, error) {
 	}
 }
 
-func (p *pool) put(ch *amqp.Channel) error {
+func (p *pool) put(ch *confirmChannel) error {
 	if ch == nil {
 		return errors.New("channel is nil, rejecting")
 	}
@@ -172,3 +211,28 @@ func (pc *poolChannel) markUnusable() {
 	pc.unusable = true
 	pc.mu.Unlock()
 }
+
+// publish publishes the given message and waits until it has been confirmed
+// by the broker. On a failure other than a negative acknowledgement, the
+// channel is marked unusable as its state is unknown.
+func (pc *poolChannel) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if err := pc.ch.Publish(exchange, key, false, false, msg); err != nil {
+		pc.markUnusable()
+		return errors.Wrap(err, "publish error")
+	}
+
+	select {
+	case conf, ok := <-pc.ch.confirms:
+		if !ok {
+			pc.markUnusable()
+			return errors.New("channel closed before publish was confirmed")
+		}
+		if !conf.Ack {
+			return errors.New("publish was not acknowledged by the broker")
+		}
+		return nil
+	case <-ctx.Done():
+		pc.markUnusable()
+		return ctx.Err()
+	}
+}
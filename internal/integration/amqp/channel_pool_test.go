@@ -24,7 +24,7 @@ func (ts *ChannelPoolTestSuite) SetupSuite() {
 func (ts *ChannelPoolTestSuite) TestNew() {
 	assert := require.New(ts.T())
 
-	p, err := newPool(10, ts.url)
+	p, err := newPool(10, ts.url, true)
 	assert.NoError(err)
 	defer p.close()
 	assert.Len(p.chans, 10)
@@ -33,7 +33,7 @@ func (ts *ChannelPoolTestSuite) TestNew() {
 func (ts *ChannelPoolTestSuite) TestGet() {
 	assert := require.New(ts.T())
 
-	p, err := newPool(10, ts.url)
+	p, err := newPool(10, ts.url, true)
 	assert.NoError(err)
 	defer p.close()
 	assert.Len(p.chans, 10)
@@ -56,7 +56,7 @@ func (ts *ChannelPoolTestSuite) TestGet() {
 func (ts *ChannelPoolTestSuite) TestPut() {
 	assert := require.New(ts.T())
 
-	p, err := newPool(10, ts.url)
+	p, err := newPool(10, ts.url, true)
 	assert.NoError(err)
 
 	chans := make([]*poolChannel, 10)
@@ -80,7 +80,7 @@ func (ts *ChannelPoolTestSuite) TestPut() {
 func (ts *ChannelPoolTestSuite) TestPutUnusable() {
 	assert := require.New(ts.T())
 
-	p, err := newPool(10, ts.url)
+	p, err := newPool(10, ts.url, true)
 	assert.NoError(err)
 	defer p.close()
 
@@ -0,0 +1,204 @@
+package amqp
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// downlinkChan contains the downlink commands consumed by all the AMQP
+// integrations.
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlink commands consumed
+// from the AMQP command queue.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+func (i *Integration) consumeCommands() {
+	defer i.wg.Done()
+
+	for {
+		if err := i.consumeCommandQueue(); err != nil {
+			log.WithError(err).WithField("queue", i.commandQueueName).Error("integration/amqp: consume command queue error")
+		}
+
+		select {
+		case <-i.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// consumeCommandQueue consumes the command queue until the channel is closed,
+// e.g. when the connection to the broker was lost.
+func (i *Integration) consumeCommandQueue() error {
+	_, conn := i.chPool.getChansAndConn()
+	if conn == nil {
+		return errClosed
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return errors.Wrap(err, "create channel error")
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(i.commandQueueName, true, false, false, false, nil); err != nil {
+		return errors.Wrap(err, "declare queue error")
+	}
+
+	if err := ch.QueueBind(i.commandQueueName, i.commandBindingKey, "amq.topic", false, nil); err != nil {
+		return errors.Wrap(err, "bind queue error")
+	}
+
+	deliveries, err := ch.Consume(i.commandQueueName, "", false, false, false, false, nil)
+	if err != nil {
+		return errors.Wrap(err, "consume error")
+	}
+
+	log.WithFields(log.Fields{
+		"queue":       i.commandQueueName,
+		"binding_key": i.commandBindingKey,
+	}).Info("integration/amqp: consuming command queue")
+
+	for d := range deliveries {
+		i.handleCommand(d)
+	}
+
+	return nil
+}
+
+func (i *Integration) handleCommand(d amqp.Delivery) {
+	log.WithField("routing_key", d.RoutingKey).Info("integration/amqp: downlink command received")
+
+	pl, err := i.decodeCommand(d.RoutingKey, d.Body)
+	if err != nil {
+		log.WithError(err).WithField("routing_key", d.RoutingKey).Error("integration/amqp: decode downlink command error")
+		if err := d.Reject(false); err != nil {
+			log.WithError(err).Error("integration/amqp: reject command error")
+		}
+		return
+	}
+
+	select {
+	case downlinkChan <- pl:
+	case <-i.done:
+		// The un-acknowledged command will be re-delivered by the broker.
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		log.WithError(err).Error("integration/amqp: ack command error")
+	}
+}
+
+func (i *Integration) decodeCommand(routingKey string, b []byte) (models.DataDownPayload, error) {
+	var pl models.DataDownPayload
+
+	match := i.commandRegexp.FindStringSubmatch(routingKey)
+	if match == nil {
+		return pl, errors.New("routing-key does not match command binding-key template")
+	}
+
+	for n, name := range i.commandRegexp.SubexpNames() {
+		switch name {
+		case "application_id":
+			id, err := strconv.ParseInt(match[n], 10, 64)
+			if err != nil {
+				return pl, errors.Wrap(err, "parse application id error")
+			}
+			pl.ApplicationID = id
+		case "dev_eui":
+			if err := pl.DevEUI.UnmarshalText([]byte(match[n])); err != nil {
+				return pl, errors.Wrap(err, "parse deveui error")
+			}
+		}
+	}
+
+	if i.applicationID != 0 && pl.ApplicationID != i.applicationID {
+		return pl, errors.New("application id does not match the application of the integration")
+	}
+
+	var cmd models.DataDownPayload
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return pl, errors.Wrap(err, "unmarshal json error")
+	}
+
+	pl.Confirmed = cmd.Confirmed
+	pl.FPort = cmd.FPort
+	pl.Data = cmd.Data
+	pl.Object = cmd.Object
+
+	if pl.FPort == 0 || pl.FPort > 224 {
+		return pl, errors.New("fPort must be between 1 - 224")
+	}
+
+	return pl, nil
+}
+
+// getCommandBindingKey returns the binding-key matching the downlink commands
+// of all the devices of the given application, or of all applications when
+// the given application ID is 0.
+func getCommandBindingKey(t *template.Template, applicationID int64) (string, error) {
+	appID := "*"
+	if applicationID != 0 {
+		appID = strconv.FormatInt(applicationID, 10)
+	}
+
+	key := bytes.NewBuffer(nil)
+	err := t.Execute(key, struct {
+		ApplicationID string
+		DevEUI        string
+		CommandType   string
+	}{appID, "*", "down"})
+	if err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+	return key.String(), nil
+}
+
+// getCommandRoutingKeyRegexp returns the regexp for retrieving the
+// application ID and DevEUI from the routing-key of a downlink command.
+func getCommandRoutingKeyRegexp(t *template.Template) (*regexp.Regexp, error) {
+	// The template is executed with placeholders first, so that the
+	// remaining parts of the routing-key (e.g. the dots) can be quoted.
+	key := bytes.NewBuffer(nil)
+	err := t.Execute(key, struct {
+		ApplicationID string
+		DevEUI        string
+		CommandType   string
+	}{"APPLICATIONID", "DEVEUI", "COMMANDTYPE"})
+	if err != nil {
+		return nil, errors.Wrap(err, "execute template error")
+	}
+
+	expr := strings.NewReplacer(
+		"APPLICATIONID", `(?P<application_id>\d+)`,
+		"DEVEUI", `(?P<dev_eui>[0-9a-fA-F]{16})`,
+		"COMMANDTYPE", `down`,
+	).Replace(regexp.QuoteMeta(key.String()))
+
+	r, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, errors.Wrap(err, "compile regexp error")
+	}
+
+	if len(r.SubexpNames()) != 3 {
+		return nil, errors.New("template must contain the application id and deveui")
+	}
+
+	return r, nil
+}
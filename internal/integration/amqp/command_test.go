@@ -0,0 +1,114 @@
+package amqp
+
+import (
+	"encoding/json"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+func TestDecodeCommand(t *testing.T) {
+	assert := require.New(t)
+
+	tmpl, err := template.New("command").Parse("application.{{ .ApplicationID }}.device.{{ .DevEUI }}.command.{{ .CommandType }}")
+	assert.NoError(err)
+
+	bindingKey, err := getCommandBindingKey(tmpl, 0)
+	assert.NoError(err)
+	assert.Equal("application.*.device.*.command.down", bindingKey)
+
+	bindingKey, err = getCommandBindingKey(tmpl, 10)
+	assert.NoError(err)
+	assert.Equal("application.10.device.*.command.down", bindingKey)
+
+	commandRegexp, err := getCommandRoutingKeyRegexp(tmpl)
+	assert.NoError(err)
+
+	tests := []struct {
+		Name            string
+		ApplicationID   int64
+		RoutingKey      string
+		Body            string
+		ExpectedPayload models.DataDownPayload
+		ExpectedError   string
+	}{
+		{
+			Name:       "valid command",
+			RoutingKey: "application.10.device.0102030405060708.command.down",
+			Body:       `{"confirmed":true,"fPort":10,"data":"AQID"}`,
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 10,
+				DevEUI:        lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				Confirmed:     true,
+				FPort:         10,
+				Data:          []byte{1, 2, 3},
+			},
+		},
+		{
+			Name:       "application id and deveui in payload are ignored",
+			RoutingKey: "application.10.device.0102030405060708.command.down",
+			Body:       `{"applicationID":"20","devEUI":"0807060504030201","fPort":10,"object":{"foo":"bar"}}`,
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 10,
+				DevEUI:        lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				FPort:         10,
+				Object:        json.RawMessage(`{"foo":"bar"}`),
+			},
+		},
+		{
+			Name:          "application bound",
+			ApplicationID: 10,
+			RoutingKey:    "application.10.device.0102030405060708.command.down",
+			Body:          `{"fPort":10,"data":"AQID"}`,
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 10,
+				DevEUI:        lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				FPort:         10,
+				Data:          []byte{1, 2, 3},
+			},
+		},
+		{
+			Name:          "application bound, application id mismatch",
+			ApplicationID: 20,
+			RoutingKey:    "application.10.device.0102030405060708.command.down",
+			Body:          `{"applicationID":"20","fPort":10,"data":"AQID"}`,
+			ExpectedError: "application id does not match the application of the integration",
+		},
+		{
+			Name:          "routing-key does not match",
+			RoutingKey:    "application.10.device.0102030405060708.event.up",
+			Body:          `{"fPort":10}`,
+			ExpectedError: "routing-key does not match command binding-key template",
+		},
+		{
+			Name:          "invalid fPort",
+			RoutingKey:    "application.10.device.0102030405060708.command.down",
+			Body:          `{"fPort":0}`,
+			ExpectedError: "fPort must be between 1 - 224",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			i := Integration{
+				applicationID: tst.ApplicationID,
+				commandRegexp: commandRegexp,
+			}
+
+			pl, err := i.decodeCommand(tst.RoutingKey, []byte(tst.Body))
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedPayload, pl)
+		})
+	}
+}
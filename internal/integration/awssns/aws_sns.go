@@ -24,9 +24,10 @@ import (
 
 // Integration implements the AWS SNS integration.
 type Integration struct {
-	marshaler marshaler.Type
-	sns       *sns.SNS
-	topicARN  string
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
+	sns             *sns.SNS
+	topicARN        string
 }
 
 // New creates a new AWS SNS integration.
@@ -47,6 +48,14 @@ func New(m marshaler.Type, conf config.IntegrationAWSSNSConfig) (*Integration, e
 		topicARN:  conf.TopicARN,
 	}
 
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
+	}
+
 	log.Info("integration/awssns: setting up session")
 	sess, err := session.NewSession(&aws.Config{
 		Region:      aws.String(conf.AWSRegion),
@@ -114,7 +123,7 @@ func (i *Integration) publish(ctx context.Context, event string, applicationID u
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], devEUIB)
 
-	b, err := marshaler.Marshal(i.marshaler, msg)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal json error")
 	}
@@ -31,9 +31,10 @@ import (
 type Integration struct {
 	sync.RWMutex
 
-	marshaler   marshaler.Type
-	publishName string
-	publishMode config.AzurePublishMode
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
+	publishName     string
+	publishMode     config.AzurePublishMode
 
 	uri     string
 	keyName string
@@ -67,6 +68,14 @@ func New(m marshaler.Type, conf config.IntegrationAzureConfig) (*Integration, er
 		key:     kv["SharedAccessKey"],
 	}
 
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
+	}
+
 	i.uri = fmt.Sprintf("https://%s%s",
 		strings.Replace(kv["Endpoint"], "sb://", "", 1),
 		conf.PublishName,
@@ -126,7 +135,7 @@ func (i *Integration) Close() error {
 }
 
 func (i *Integration) publishHTTP(ctx context.Context, event string, applicationID uint64, devEUIB []byte, v proto.Message) error {
-	b, err := marshaler.Marshal(i.marshaler, v)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, v)
 	if err != nil {
 		return errors.Wrap(err, "marshal event error")
 	}
@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+const (
+	// cacheInvalidateChannel is the Redis Pub/Sub channel on which the
+	// application IDs are published for which the cached integrations must
+	// be invalidated.
+	cacheInvalidateChannel = "lora:as:integration:cache:invalidate"
+)
+
+var (
+	appIntegrationsMux sync.RWMutex
+	appIntegrations    = make(map[int64]cacheEntry)
+
+	// appLoads contains the loads of the application integrations which are
+	// in progress. Integrations loaded before an invalidation are not stored
+	// in the cache.
+	appLoads = make(map[int64]*appLoad)
+
+	// closeGracePeriod defines the time after which removed integrations are
+	// closed, so that events which are still being handled by these
+	// integrations can complete.
+	closeGracePeriod = 30 * time.Second
+
+	// cacheTTL defines the time after which the cached integrations are
+	// reloaded, so that a missed invalidation does not result in stale
+	// integrations.
+	cacheTTL = 5 * time.Minute
+
+	// cacheRetryInterval defines the (initial) interval after which the
+	// integrations are reloaded when setting up one or more integrations
+	// failed. It is doubled on every consecutive failure, up to cacheTTL.
+	cacheRetryInterval = time.Second
+)
+
+// cacheEntry contains the cached integrations of an application.
+type cacheEntry struct {
+	handlers []handler
+	expires  time.Time
+
+	// failures contains the number of consecutive loads that failed.
+	failures int
+}
+
+// appLoad contains the number of loads in progress and the generation,
+// which is incremented on each invalidation.
+type appLoad struct {
+	count int
+	gen   uint64
+}
+
+// InvalidateApplicationIntegrations invalidates the cached integrations for
+// the given application ID. The invalidation is also published to the other
+// application-server instances using Redis Pub/Sub.
+// This must be called after the integrations of an application have been
+// created, updated or deleted.
+func InvalidateApplicationIntegrations(ctx context.Context, id int64) error {
+	invalidateApplicationIntegrations(id)
+
+	key := storage.GetRedisKey(cacheInvalidateChannel)
+	if err := storage.RedisClient().Publish(ctx, key, strconv.FormatInt(id, 10)).Err(); err != nil {
+		return errors.Wrap(err, "publish cache invalidation error")
+	}
+
+	return nil
+}
+
+// getApplicationIntegrations returns the integrations for the given
+// application ID from the cache. In case of a cache miss or when the cached
+// integrations have expired, the integrations are created and stored in the
+// cache.
+func getApplicationIntegrations(id int64) []handler {
+	appIntegrationsMux.RLock()
+	entry, ok := appIntegrations[id]
+	appIntegrationsMux.RUnlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		cacheHitCounter().Inc()
+		return entry.handlers
+	}
+	cacheMissCounter().Inc()
+
+	gen := beginLoad(id)
+	ints, err := newApplicationIntegrations(id)
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"application_id": id,
+		}).Error("integrations: get application integrations error")
+	}
+
+	return storeApplicationIntegrations(id, gen, ints, err)
+}
+
+// beginLoad registers a load of the integrations of the given application ID
+// and returns the current generation.
+func beginLoad(id int64) uint64 {
+	appIntegrationsMux.Lock()
+	defer appIntegrationsMux.Unlock()
+
+	l, ok := appLoads[id]
+	if !ok {
+		l = &appLoad{}
+		appLoads[id] = l
+	}
+	l.count++
+	return l.gen
+}
+
+// storeApplicationIntegrations stores the given integrations, loaded at the
+// given generation, in the cache and returns the integrations to use. When
+// loading the integrations failed (loadErr), the integrations are reloaded
+// after the retry interval.
+func storeApplicationIntegrations(id int64, gen uint64, ints []handler, loadErr error) []handler {
+	appIntegrationsMux.Lock()
+	defer appIntegrationsMux.Unlock()
+
+	l := appLoads[id]
+	stale := l.gen != gen
+	l.count--
+	if l.count == 0 {
+		delete(appLoads, id)
+	}
+
+	now := time.Now()
+	cached, ok := appIntegrations[id]
+
+	// the integrations might have been created concurrently, in which case
+	// we close the integrations that we just created
+	if ok && now.Before(cached.expires) {
+		closeIntegrationsAfter(id, ints, 0)
+		return cached.handlers
+	}
+
+	// the integrations have been invalidated while loading them, these
+	// might be stale and are only used for the current event
+	if stale {
+		closeIntegrationsAfter(id, ints, closeGracePeriod)
+		return ints
+	}
+
+	entry := cacheEntry{
+		handlers: ints,
+		expires:  now.Add(cacheTTL),
+	}
+
+	if loadErr != nil {
+		entry.failures = cached.failures + 1
+		entry.expires = now.Add(cacheRetryBackoff(entry.failures))
+
+		// the integrations could not be loaded at all (e.g. a database
+		// error), keep using the previous integrations until the retry
+		if ints == nil && ok {
+			cached.failures = entry.failures
+			cached.expires = entry.expires
+			appIntegrations[id] = cached
+			return cached.handlers
+		}
+	}
+
+	appIntegrations[id] = entry
+	if ok {
+		closeIntegrationsAfter(id, cached.handlers, closeGracePeriod)
+	}
+
+	return ints
+}
+
+// cacheRetryBackoff returns the interval after which the integrations are
+// reloaded, given the number of consecutive failures.
+func cacheRetryBackoff(failures int) time.Duration {
+	d := cacheRetryInterval
+	for n := 1; n < failures && d < cacheTTL; n++ {
+		d *= 2
+	}
+	if d > cacheTTL {
+		d = cacheTTL
+	}
+	return d
+}
+
+// invalidateApplicationIntegrations removes the integrations of the given
+// application ID from the cache and closes them after the grace period.
+func invalidateApplicationIntegrations(id int64) {
+	appIntegrationsMux.Lock()
+	entry, ok := appIntegrations[id]
+	delete(appIntegrations, id)
+	if l, loading := appLoads[id]; loading {
+		l.gen++
+	}
+	appIntegrationsMux.Unlock()
+
+	if ok {
+		cacheInvalidationCounter().Inc()
+		closeIntegrationsAfter(id, entry.handlers, closeGracePeriod)
+	}
+}
+
+// closeIntegrationsAfter closes the given integrations after the given
+// duration.
+func closeIntegrationsAfter(id int64, ints []handler, d time.Duration) {
+	time.AfterFunc(d, func() {
+		closeIntegrations(id, ints)
+	})
+}
+
+// closeIntegrations closes the given integrations.
+func closeIntegrations(id int64, ints []handler) {
+	for _, i := range ints {
+		if err := i.handler.Close(); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": id,
+				"integration":    i.name,
+			}).Error("integrations: close integration error")
+		}
+	}
+}
+
+// handleCacheInvalidations subscribes to the cache invalidation channel and
+// invalidates the cached integrations for the received application IDs.
+func handleCacheInvalidations(ctx context.Context) {
+	key := storage.GetRedisKey(cacheInvalidateChannel)
+	sub := storage.RedisClient().Subscribe(ctx, key)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		id, err := strconv.ParseInt(msg.Payload, 10, 64)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"payload": msg.Payload,
+			}).Error("integrations: parse cache invalidation error")
+			continue
+		}
+
+		invalidateApplicationIntegrations(id)
+
+		// the integrations have been created, updated or deleted
+		go syncApplicationCommandConsumers(ctx, id)
+	}
+}
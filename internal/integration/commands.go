@@ -0,0 +1,202 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/amqp"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/kafka"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/nats"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+// commandKinds contains the integration kinds which consume downlink
+// commands.
+var commandKinds = []string{Kafka, AMQP, NATS, ThingsBoard}
+
+var (
+	// commandConsumerSyncInterval defines the interval in which the command
+	// consumers are synced with the stored integrations, e.g. to retry
+	// consumers which could not be started.
+	commandConsumerSyncInterval = time.Minute
+
+	// commandConsumersMux must be held while syncing the consumers, so
+	// that the stored integrations are read and applied in order.
+	commandConsumersMux sync.Mutex
+	commandConsumers    = make(map[int64]commandConsumer)
+
+	// newCommandConsumer creates the command consumer for the given
+	// integration.
+	newCommandConsumer = newApplicationCommandConsumer
+)
+
+// commandConsumer holds the downlink command consumer of an application
+// integration, by integration ID.
+type commandConsumer struct {
+	applicationID int64
+	settings      json.RawMessage
+	consumer      io.Closer
+}
+
+// startCommandConsumers starts the downlink command consumers of the
+// application integrations and keeps them in sync with the stored
+// integrations. The consumers of a single application are synced on
+// invalidation of its integrations (see syncApplicationCommandConsumers).
+// Unlike the cached integrations, the consumers do not depend on events
+// being handled for the application.
+func startCommandConsumers(ctx context.Context) {
+	ticker := time.NewTicker(commandConsumerSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		commandConsumersMux.Lock()
+		appints, err := storage.GetEnabledIntegrationsForKinds(ctx, storage.DB(), commandKinds)
+		if err != nil {
+			log.WithError(err).Error("integration: get command integrations error")
+		} else {
+			syncCommandConsumers(appints, 0)
+		}
+		commandConsumersMux.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncApplicationCommandConsumers syncs the downlink command consumers of the
+// given application with its stored integrations.
+func syncApplicationCommandConsumers(ctx context.Context, id int64) {
+	commandConsumersMux.Lock()
+	defer commandConsumersMux.Unlock()
+
+	appints, err := storage.GetIntegrationsForApplicationID(ctx, storage.DB(), id)
+	if err != nil {
+		log.WithError(err).WithField("application_id", id).Error("integration: get application integrations error")
+		return
+	}
+
+	syncCommandConsumers(appints, id)
+}
+
+// syncCommandConsumers starts the command consumers of the given integrations
+// which are not yet running and stops the consumers of which the integration
+// has been removed, disabled or updated. When the given application ID is
+// not 0, only the consumers of the given application are synced.
+// The caller must hold commandConsumersMux.
+func syncCommandConsumers(appints []storage.Integration, applicationID int64) {
+	enabled := make(map[int64]storage.Integration)
+	for _, appint := range appints {
+		if appint.Enabled && isCommandKind(appint.Kind) {
+			enabled[appint.ID] = appint
+		}
+	}
+
+	for id, c := range commandConsumers {
+		if applicationID != 0 && c.applicationID != applicationID {
+			continue
+		}
+
+		if appint, ok := enabled[id]; ok && bytes.Equal(appint.Settings, c.settings) {
+			delete(enabled, id)
+			continue
+		}
+
+		delete(commandConsumers, id)
+		if err := c.consumer.Close(); err != nil {
+			log.WithError(err).WithField("application_id", c.applicationID).Error("integration: close command consumer error")
+		}
+	}
+
+	for id, appint := range enabled {
+		consumer, err := newCommandConsumer(appint)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": appint.ApplicationID,
+				"kind":           appint.Kind,
+			}).Error("integration: new command consumer error")
+			continue
+		}
+
+		commandConsumers[id] = commandConsumer{
+			applicationID: appint.ApplicationID,
+			settings:      appint.Settings,
+			consumer:      consumer,
+		}
+	}
+}
+
+// newApplicationCommandConsumer creates the downlink command consumer for the
+// given integration. The consumer only accepts the commands of the
+// application of the integration.
+// When no commands are configured, a no-op consumer is returned so that the
+// integration is not checked again on every sync.
+func newApplicationCommandConsumer(appint storage.Integration) (io.Closer, error) {
+	switch appint.Kind {
+	case Kafka:
+		var conf config.IntegrationKafkaConfig
+		if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+			return nil, errors.Wrap(err, "read configuration error")
+		}
+		if conf.CommandTopic == "" {
+			return nopCloser{}, nil
+		}
+		return kafka.NewCommandConsumer(marshalType, conf, appint.ApplicationID)
+	case AMQP:
+		var conf config.IntegrationAMQPConfig
+		if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+			return nil, errors.Wrap(err, "read configuration error")
+		}
+		if conf.CommandQueueName == "" {
+			return nopCloser{}, nil
+		}
+		return amqp.NewCommandConsumer(marshalType, conf, appint.ApplicationID)
+	case NATS:
+		var conf config.IntegrationNATSConfig
+		if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+			return nil, errors.Wrap(err, "read configuration error")
+		}
+		if conf.CommandSubjectTemplate == "" {
+			return nopCloser{}, nil
+		}
+		return nats.NewCommandConsumer(marshalType, conf, appint.ApplicationID)
+	case ThingsBoard:
+		var conf thingsboard.Config
+		if err := json.Unmarshal(appint.Settings, &conf); err != nil {
+			return nil, errors.Wrap(err, "read configuration error")
+		}
+		if !conf.RPCEnabled {
+			return nopCloser{}, nil
+		}
+		return thingsboard.NewRPCConsumer(conf, appint.ApplicationID)
+	default:
+		return nil, errors.Errorf("integration kind %s does not consume commands", appint.Kind)
+	}
+}
+
+func isCommandKind(kind string) bool {
+	for _, k := range commandKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// nopCloser is used for the integrations without commands configured.
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package integration
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+type testCommandConsumer struct {
+	closed bool
+}
+
+func (c *testCommandConsumer) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSyncCommandConsumers(t *testing.T) {
+	assert := require.New(t)
+
+	created := make(map[int64]*testCommandConsumer)
+	newCommandConsumer = func(appint storage.Integration) (io.Closer, error) {
+		c := &testCommandConsumer{}
+		created[appint.ID] = c
+		return c, nil
+	}
+	defer func() {
+		newCommandConsumer = newApplicationCommandConsumer
+		commandConsumers = make(map[int64]commandConsumer)
+	}()
+
+	appints := []storage.Integration{
+		{ID: 1, ApplicationID: 10, Kind: Kafka, Enabled: true, Settings: json.RawMessage(`{"commandTopic":"a"}`)},
+		{ID: 2, ApplicationID: 10, Kind: AMQP, Enabled: false, Settings: json.RawMessage(`{}`)},
+		{ID: 3, ApplicationID: 10, Kind: HTTP, Enabled: true, Settings: json.RawMessage(`{}`)},
+		{ID: 4, ApplicationID: 20, Kind: AMQP, Enabled: true, Settings: json.RawMessage(`{}`)},
+	}
+
+	t.Run("Start", func(t *testing.T) {
+		assert := require.New(t)
+
+		syncCommandConsumers(appints, 0)
+		assert.Len(commandConsumers, 2)
+		assert.Contains(commandConsumers, int64(1))
+		assert.Contains(commandConsumers, int64(4))
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		assert := require.New(t)
+
+		c1 := created[1]
+		syncCommandConsumers(appints[:3], 10)
+		assert.Equal(c1, created[1])
+		assert.False(c1.closed)
+
+		// the consumer of the other application is not affected
+		assert.False(created[4].closed)
+		assert.Len(commandConsumers, 2)
+	})
+
+	t.Run("Updated", func(t *testing.T) {
+		assert := require.New(t)
+
+		c1 := created[1]
+		updated := []storage.Integration{appints[0]}
+		updated[0].Settings = json.RawMessage(`{"commandTopic":"b"}`)
+
+		syncCommandConsumers(updated, 10)
+		assert.True(c1.closed)
+		assert.False(created[1].closed)
+		assert.NotEqual(c1, created[1])
+		assert.Len(commandConsumers, 2)
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		assert := require.New(t)
+
+		c1 := created[1]
+		syncCommandConsumers(nil, 10)
+		assert.True(c1.closed)
+		assert.False(created[4].closed)
+		assert.Len(commandConsumers, 1)
+
+		syncCommandConsumers(nil, 0)
+		assert.True(created[4].closed)
+		assert.Len(commandConsumers, 0)
+	})
+
+	assert.Len(created, 2)
+}
@@ -0,0 +1,45 @@
+package integration
+
+import (
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// dispatcher implements the outbox.Dispatcher interface.
+type dispatcher struct{}
+
+// Integrations returns the names of the global and application integrations
+// for the given application ID.
+func (dispatcher) Integrations(applicationID int64) []string {
+	var names []string
+	for _, h := range globalIntegrations {
+		names = append(names, h.name)
+	}
+
+	if applicationID != 0 {
+		for _, h := range getApplicationIntegrations(applicationID) {
+			names = append(names, h.name)
+		}
+	}
+
+	return names
+}
+
+// Handler returns the integration handler for the given application ID and
+// integration name.
+func (dispatcher) Handler(applicationID int64, name string) models.IntegrationHandler {
+	for _, h := range globalIntegrations {
+		if h.name == name {
+			return h.handler
+		}
+	}
+
+	if applicationID != 0 {
+		for _, h := range getApplicationIntegrations(applicationID) {
+			if h.name == name {
+				return h.handler
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,508 @@
+// Package elasticsearch implements an Elasticsearch / OpenSearch integration.
+// Events are indexed in batches using the _bulk API.
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/lorawan"
+)
+
+// Defaults used when the corresponding option is not set.
+const (
+	defaultIndexTemplate = `chirpstack-{{ .EventType }}-{{ .Time.Format "2006.01.02" }}`
+	defaultBatchSize     = 500
+	defaultFlushInterval = 5 * time.Second
+	defaultMaxRetries    = 3
+
+	// maxBufferedBatches defines the max. number of batches that are
+	// buffered, e.g. when Elasticsearch is not reachable. Once the buffer
+	// is full, new events are dropped.
+	maxBufferedBatches = 10
+)
+
+var (
+	// retryBackoff is the initial backoff before retrying the events
+	// rejected with a 429 (Too Many Requests). It is doubled on every retry.
+	retryBackoff = time.Second
+
+	// requestTimeout defines the timeout of a _bulk request, so that a hung
+	// cluster does not block the flushing of the events.
+	requestTimeout = 30 * time.Second
+)
+
+// Config contains the configuration for the Elasticsearch integration.
+type Config struct {
+	Endpoint             string `json:"endpoint"`
+	Username             string `json:"username"`
+	Password             string `json:"password"`
+	IndexTemplate        string `json:"indexTemplate"`
+	BatchSize            int    `json:"batchSize"`
+	FlushIntervalSeconds int    `json:"flushIntervalSeconds"`
+	MaxRetries           int    `json:"maxRetries"`
+}
+
+// Validate validates the Config data.
+func (c Config) Validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidEndpoint
+	}
+
+	if c.IndexTemplate != "" {
+		if _, err := template.New("index").Parse(c.IndexTemplate); err != nil {
+			return errors.Wrap(ErrInvalidIndexTemplate, err.Error())
+		}
+	}
+
+	if c.BatchSize < 0 || c.FlushIntervalSeconds < 0 || c.MaxRetries < 0 {
+		return ErrInvalidBatchConfig
+	}
+
+	return nil
+}
+
+// item contains a document to index.
+type item struct {
+	Index    string
+	Document []byte
+}
+
+// bulkResponse contains the fields used of the _bulk API response.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Integration implements an Elasticsearch integration.
+type Integration struct {
+	config        Config
+	indexTemplate *template.Template
+	batchSize     int
+	maxItems      int
+	maxRetries    int
+	client        *http.Client
+
+	mu    sync.Mutex
+	items []item
+
+	wg    sync.WaitGroup
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// New creates a new Elasticsearch integration. The buffered events are
+// flushed by a background goroutine when the batch size has been reached or
+// when the flush interval has expired.
+func New(conf Config) (*Integration, error) {
+	var err error
+	i := Integration{
+		config:     conf,
+		batchSize:  conf.BatchSize,
+		maxRetries: conf.MaxRetries,
+		client:     &http.Client{Timeout: requestTimeout},
+		flush:      make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	if i.batchSize == 0 {
+		i.batchSize = defaultBatchSize
+	}
+	i.maxItems = i.batchSize * maxBufferedBatches
+	if i.maxRetries == 0 {
+		i.maxRetries = defaultMaxRetries
+	}
+
+	indexTemplate := conf.IndexTemplate
+	if indexTemplate == "" {
+		indexTemplate = defaultIndexTemplate
+	}
+	i.indexTemplate, err = template.New("index").Parse(indexTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse index template error")
+	}
+
+	flushInterval := time.Duration(conf.FlushIntervalSeconds) * time.Second
+	if flushInterval == 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	i.wg.Add(1)
+	go i.flushLoop(flushInterval)
+
+	return &i, nil
+}
+
+// HandleUplinkEvent indexes an UplinkEvent.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "up", pl.PublishedAt, &pl)
+}
+
+// HandleJoinEvent indexes a JoinEvent.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "join", pl.PublishedAt, &pl)
+}
+
+// HandleAckEvent is not implemented.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return nil
+}
+
+// HandleErrorEvent indexes an ErrorEvent.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "error", pl.PublishedAt, &pl)
+}
+
+// HandleStatusEvent indexes a StatusEvent.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "status", pl.PublishedAt, &pl)
+}
+
+// HandleLocationEvent is not implemented.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return nil
+}
+
+// HandleTxAckEvent is not implemented.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return nil
+}
+
+// HandleIntegrationEvent is not implemented.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return nil
+}
+
+// DataDownChan returns nil.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
+
+// Close flushes the buffered events and closes the integration. A pending
+// flush is cancelled and the events rejected with a 429 are not retried.
+func (i *Integration) Close() error {
+	close(i.done)
+	i.wg.Wait()
+	return i.Flush(context.Background())
+}
+
+// Flush indexes the buffered events. The events which could not be indexed
+// because of a temporary error (e.g. Elasticsearch is not reachable) are
+// re-queued.
+func (i *Integration) Flush(ctx context.Context) error {
+	i.mu.Lock()
+	items := i.items
+	i.items = nil
+	i.mu.Unlock()
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	retry, err := i.send(ctx, items)
+	if len(retry) != 0 {
+		i.requeue(retry)
+	}
+	return err
+}
+
+// requeue adds the given items in front of the buffered items. When the
+// buffer is full, the oldest items are dropped.
+func (i *Integration) requeue(items []item) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.items = append(items, i.items...)
+	if dropped := len(i.items) - i.maxItems; dropped > 0 {
+		i.items = i.items[dropped:]
+		log.WithField("events", dropped).Error("integration/elasticsearch: buffer full, dropping events")
+	}
+}
+
+func (i *Integration) flushLoop(interval time.Duration) {
+	defer i.wg.Done()
+
+	// cancel the pending flush on close
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-i.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-i.flush:
+		case <-i.done:
+			return
+		}
+
+		if err := i.Flush(ctx); err != nil {
+			log.WithError(err).Error("integration/elasticsearch: flush error")
+		}
+	}
+}
+
+func (i *Integration) addEvent(ctx context.Context, applicationID uint64, devEUIB []byte, typ string, publishedAt *timestamp.Timestamp, msg proto.Message) error {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], devEUIB)
+
+	ts := time.Now()
+	if publishedAt != nil {
+		if t, err := ptypes.Timestamp(publishedAt); err == nil {
+			ts = t
+		}
+	}
+
+	index, err := i.getIndex(applicationID, typ, ts)
+	if err != nil {
+		return errors.Wrap(err, "get index error")
+	}
+
+	doc, err := getDocument(typ, ts, msg)
+	if err != nil {
+		return errors.Wrap(err, "get document error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"event":   typ,
+		"index":   index,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("integration/elasticsearch: adding event to batch")
+
+	i.mu.Lock()
+	if len(i.items) >= i.maxItems {
+		i.mu.Unlock()
+		return ErrBufferFull
+	}
+	i.items = append(i.items, item{Index: index, Document: doc})
+	full := len(i.items) >= i.batchSize
+	i.mu.Unlock()
+
+	// signal the flush goroutine, unless it has already been signalled
+	if full {
+		select {
+		case i.flush <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (i *Integration) getIndex(applicationID uint64, typ string, ts time.Time) (string, error) {
+	index := bytes.NewBuffer(nil)
+	err := i.indexTemplate.Execute(index, struct {
+		ApplicationID uint64
+		EventType     string
+		Time          time.Time
+	}{applicationID, typ, ts.UTC()})
+	if err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+	return strings.ToLower(index.String()), nil
+}
+
+// send indexes the given items. Items rejected with a 429 are retried using
+// an exponential backoff. It returns the items which could not be indexed
+// because of a temporary error.
+func (i *Integration) send(ctx context.Context, items []item) ([]item, error) {
+	var itemErr error
+
+	for retry := 0; ; retry++ {
+		rejected, err := i.bulk(ctx, items)
+		if err != nil {
+			return rejected.retry, err
+		}
+
+		// Failures other than 429 can not be resolved by retrying.
+		if rejected.err != nil {
+			itemErr = rejected.err
+		}
+
+		if len(rejected.retry) == 0 {
+			return nil, itemErr
+		}
+
+		if retry >= i.maxRetries {
+			return rejected.retry, fmt.Errorf("%d events rejected with 429 after %d retries", len(rejected.retry), retry)
+		}
+
+		backoff := retryBackoff << retry
+		log.WithFields(log.Fields{
+			"events":  len(rejected.retry),
+			"backoff": backoff,
+		}).Warning("integration/elasticsearch: events rejected with 429, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return rejected.retry, ctx.Err()
+		case <-i.done:
+			return rejected.retry, ErrClosed
+		}
+		items = rejected.retry
+	}
+}
+
+// rejectedItems contains the items rejected by the _bulk API.
+type rejectedItems struct {
+	// retry contains the items rejected with a 429 or because of a
+	// temporary error.
+	retry []item
+
+	// err is set when items were rejected for other reasons.
+	err error
+}
+
+func (i *Integration) bulk(ctx context.Context, items []item) (rejectedItems, error) {
+	var rejected rejectedItems
+
+	body := bytes.NewBuffer(nil)
+	for _, it := range items {
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]string{"_index": it.Index},
+		})
+		if err != nil {
+			return rejected, errors.Wrap(err, "marshal action error")
+		}
+
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(it.Document)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(i.config.Endpoint, "/")+"/_bulk", body)
+	if err != nil {
+		return rejected, errors.Wrap(err, "new request error")
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if i.config.Username != "" || i.config.Password != "" {
+		req.SetBasicAuth(i.config.Username, i.config.Password)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		rejected.retry = items
+		return rejected, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+	models.RecordHTTPStatus(ctx, resp.StatusCode)
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return rejected, errors.Wrap(err, "read response error")
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rejected.retry = items
+		return rejected, nil
+	}
+
+	if resp.StatusCode >= 500 {
+		rejected.retry = items
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return rejected, fmt.Errorf("expected 2xx response, got: %d (%s)", resp.StatusCode, string(b))
+	}
+
+	var bulkResp bulkResponse
+	if err := json.Unmarshal(b, &bulkResp); err != nil {
+		return rejected, errors.Wrap(err, "unmarshal response error")
+	}
+
+	if !bulkResp.Errors {
+		return rejected, nil
+	}
+
+	var failed int
+	var firstErr json.RawMessage
+	for n, res := range bulkResp.Items {
+		if n >= len(items) {
+			break
+		}
+
+		for _, status := range res {
+			switch {
+			case status.Status == http.StatusTooManyRequests:
+				rejected.retry = append(rejected.retry, items[n])
+			case status.Status < 200 || status.Status > 299:
+				if failed == 0 {
+					firstErr = status.Error
+				}
+				failed++
+			}
+		}
+	}
+
+	if failed != 0 {
+		rejected.err = fmt.Errorf("%d events rejected, first error: %s", failed, string(firstErr))
+	}
+
+	return rejected, nil
+}
+
+// getDocument returns the document for the given event. The decoded object
+// is stored as object (instead of the objectJSON string) and the
+// @timestamp and event fields are added.
+func getDocument(typ string, ts time.Time, msg proto.Message) ([]byte, error) {
+	b, err := marshaler.Marshal(marshaler.ProtobufJSON, msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal event error")
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, errors.Wrap(err, "unmarshal event error")
+	}
+
+	if objectJSON, ok := doc["objectJSON"].(string); ok {
+		delete(doc, "objectJSON")
+
+		if objectJSON != "" {
+			var obj interface{}
+			if err := json.Unmarshal([]byte(objectJSON), &obj); err != nil {
+				return nil, errors.Wrap(err, "unmarshal object error")
+			}
+			doc["object"] = obj
+		}
+	}
+
+	doc["@timestamp"] = ts.UTC().Format(time.RFC3339Nano)
+	doc["event"] = typ
+
+	return json.Marshal(doc)
+}
@@ -0,0 +1,431 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+)
+
+type bulkRequest struct {
+	Username string
+	Actions  []map[string]map[string]string
+	Docs     []map[string]interface{}
+}
+
+type IntegrationTestSuite struct {
+	suite.Suite
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	requests  []bulkRequest
+	responses []func(w http.ResponseWriter, req bulkRequest)
+}
+
+func (ts *IntegrationTestSuite) SetupSuite() {
+	retryBackoff = time.Millisecond
+
+	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert := require.New(ts.T())
+		assert.Equal("/_bulk", r.URL.Path)
+		assert.Equal("application/x-ndjson", r.Header.Get("Content-Type"))
+
+		var req bulkRequest
+		req.Username, _, _ = r.BasicAuth()
+
+		scanner := bufio.NewScanner(r.Body)
+		for n := 0; scanner.Scan(); n++ {
+			if n%2 == 0 {
+				var action map[string]map[string]string
+				assert.NoError(json.Unmarshal(scanner.Bytes(), &action))
+				req.Actions = append(req.Actions, action)
+			} else {
+				var doc map[string]interface{}
+				assert.NoError(json.Unmarshal(scanner.Bytes(), &doc))
+				req.Docs = append(req.Docs, doc)
+			}
+		}
+
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, req)
+		var respond func(w http.ResponseWriter, req bulkRequest)
+		if len(ts.responses) != 0 {
+			respond = ts.responses[0]
+			ts.responses = ts.responses[1:]
+		}
+		ts.mu.Unlock()
+
+		if respond != nil {
+			respond(w, req)
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+}
+
+func (ts *IntegrationTestSuite) TearDownSuite() {
+	ts.server.Close()
+}
+
+func (ts *IntegrationTestSuite) SetupTest() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.requests = nil
+	ts.responses = nil
+}
+
+func (ts *IntegrationTestSuite) getRequests() []bulkRequest {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.requests
+}
+
+func (ts *IntegrationTestSuite) TestFlushOnBatchSize() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	i, err := New(Config{
+		Endpoint:  ts.server.URL,
+		Username:  "user",
+		Password:  "secret",
+		BatchSize: 2,
+	})
+	assert.NoError(err)
+	defer i.Close()
+
+	publishedAt, _ := ptypes.TimestampProto(time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC))
+
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+		ApplicationId: 10,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		FPort:         1,
+		ObjectJson:    `{"sensor":{"temperature":21.5}}`,
+		PublishedAt:   publishedAt,
+	}))
+	assert.Len(ts.getRequests(), 0)
+
+	assert.NoError(i.HandleStatusEvent(ctx, nil, nil, pb.StatusEvent{
+		ApplicationId: 10,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Margin:        10,
+		PublishedAt:   publishedAt,
+	}))
+
+	assert.Eventually(func() bool {
+		return len(ts.getRequests()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	requests := ts.getRequests()
+	assert.Equal("user", requests[0].Username)
+	assert.Equal([]map[string]map[string]string{
+		{"index": {"_index": "chirpstack-up-2021.03.01"}},
+		{"index": {"_index": "chirpstack-status-2021.03.01"}},
+	}, requests[0].Actions)
+
+	up := requests[0].Docs[0]
+	assert.Equal("up", up["event"])
+	assert.Equal("2021-03-01T12:00:00Z", up["@timestamp"])
+	assert.Equal("AQIDBAUGBwg=", up["devEUI"])
+	assert.Equal(map[string]interface{}{
+		"sensor": map[string]interface{}{"temperature": 21.5},
+	}, up["object"])
+	assert.NotContains(up, "objectJSON")
+
+	assert.Equal("status", requests[0].Docs[1]["event"])
+	assert.NotContains(requests[0].Docs[1], "object")
+}
+
+func (ts *IntegrationTestSuite) TestFlushOnClose() {
+	assert := require.New(ts.T())
+
+	i, err := New(Config{
+		Endpoint:      ts.server.URL,
+		IndexTemplate: `app-{{ .ApplicationID }}-{{ .EventType }}`,
+	})
+	assert.NoError(err)
+
+	assert.NoError(i.HandleJoinEvent(context.Background(), nil, nil, pb.JoinEvent{
+		ApplicationId: 10,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}))
+	assert.NoError(i.HandleAckEvent(context.Background(), nil, nil, pb.AckEvent{
+		ApplicationId: 10,
+	}))
+	assert.NoError(i.Close())
+
+	requests := ts.getRequests()
+	assert.Len(requests, 1)
+	assert.Equal([]map[string]map[string]string{
+		{"index": {"_index": "app-10-join"}},
+	}, requests[0].Actions)
+}
+
+func (ts *IntegrationTestSuite) TestFlushOnInterval() {
+	assert := require.New(ts.T())
+
+	i, err := New(Config{
+		Endpoint:             ts.server.URL,
+		FlushIntervalSeconds: 1,
+	})
+	assert.NoError(err)
+	defer i.Close()
+
+	assert.NoError(i.HandleErrorEvent(context.Background(), nil, nil, pb.ErrorEvent{
+		ApplicationId: 10,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Error:         "test",
+	}))
+
+	assert.Eventually(func() bool {
+		return len(ts.getRequests()) == 1
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func (ts *IntegrationTestSuite) TestRetry() {
+	ts.T().Run("Request rejected with 429", func(t *testing.T) {
+		assert := require.New(t)
+		ts.SetupTest()
+
+		ts.responses = []func(w http.ResponseWriter, req bulkRequest){
+			func(w http.ResponseWriter, req bulkRequest) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+		}
+
+		i, err := New(Config{Endpoint: ts.server.URL})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.NoError(i.Flush(context.Background()))
+		assert.Len(ts.getRequests(), 2)
+	})
+
+	ts.T().Run("Items rejected with 429", func(t *testing.T) {
+		assert := require.New(t)
+		ts.SetupTest()
+
+		ts.responses = []func(w http.ResponseWriter, req bulkRequest){
+			func(w http.ResponseWriter, req bulkRequest) {
+				w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}]}`))
+			},
+		}
+
+		i, err := New(Config{Endpoint: ts.server.URL})
+		assert.NoError(err)
+		defer i.Close()
+
+		for n := 0; n < 2; n++ {
+			assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10, FCnt: uint32(n)}))
+		}
+		assert.NoError(i.Flush(context.Background()))
+
+		requests := ts.getRequests()
+		assert.Len(requests, 2)
+		assert.Len(requests[1].Docs, 1)
+		assert.EqualValues(1, requests[1].Docs[0]["fCnt"])
+	})
+
+	ts.T().Run("Max retries", func(t *testing.T) {
+		assert := require.New(t)
+		ts.SetupTest()
+
+		for n := 0; n < 3; n++ {
+			ts.responses = append(ts.responses, func(w http.ResponseWriter, req bulkRequest) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+		}
+
+		i, err := New(Config{Endpoint: ts.server.URL, MaxRetries: 2})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.Error(i.Flush(context.Background()))
+		assert.Len(ts.getRequests(), 3)
+	})
+
+	ts.T().Run("Items rejected", func(t *testing.T) {
+		assert := require.New(t)
+		ts.SetupTest()
+
+		ts.responses = []func(w http.ResponseWriter, req bulkRequest){
+			func(w http.ResponseWriter, req bulkRequest) {
+				w.Write([]byte(`{"errors":true,"items":[{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			},
+		}
+
+		i, err := New(Config{Endpoint: ts.server.URL})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		err = i.Flush(context.Background())
+		assert.Error(err)
+		assert.True(strings.Contains(err.Error(), "mapper_parsing_exception"), fmt.Sprintf("unexpected error: %s", err))
+		assert.Len(ts.getRequests(), 1)
+	})
+
+	ts.T().Run("Request failed", func(t *testing.T) {
+		assert := require.New(t)
+		ts.SetupTest()
+
+		ts.responses = []func(w http.ResponseWriter, req bulkRequest){
+			func(w http.ResponseWriter, req bulkRequest) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		}
+
+		i, err := New(Config{Endpoint: ts.server.URL})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.Error(i.Flush(context.Background()))
+
+		// the event is re-queued and indexed by the next flush
+		assert.NoError(i.Flush(context.Background()))
+		requests := ts.getRequests()
+		assert.Len(requests, 2)
+		assert.Len(requests[1].Docs, 1)
+	})
+}
+
+func (ts *IntegrationTestSuite) TestBufferFull() {
+	assert := require.New(ts.T())
+
+	ts.responses = []func(w http.ResponseWriter, req bulkRequest){
+		func(w http.ResponseWriter, req bulkRequest) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		},
+	}
+
+	i, err := New(Config{Endpoint: ts.server.URL, BatchSize: 100})
+	assert.NoError(err)
+	defer i.Close()
+
+	i.mu.Lock()
+	for n := 0; n < i.maxItems; n++ {
+		i.items = append(i.items, item{Index: fmt.Sprintf("index-%d", n), Document: []byte(`{}`)})
+	}
+	i.mu.Unlock()
+
+	assert.Equal(ErrBufferFull, i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+
+	// the failed batch is re-queued
+	assert.Error(i.Flush(context.Background()))
+
+	// re-queued items exceeding the buffer are dropped, oldest first
+	i.requeue([]item{{Index: "old", Document: []byte(`{}`)}})
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	assert.Len(i.items, i.maxItems)
+	assert.Equal("index-0", i.items[0].Index)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Config Config
+		Error  error
+	}{
+		{
+			Name:   "valid",
+			Config: Config{Endpoint: "https://localhost:9200", IndexTemplate: "events-{{ .EventType }}"},
+		},
+		{
+			Name:   "invalid endpoint",
+			Config: Config{Endpoint: "localhost:9200"},
+			Error:  ErrInvalidEndpoint,
+		},
+		{
+			Name:   "invalid index template",
+			Config: Config{Endpoint: "https://localhost:9200", IndexTemplate: "events-{{ .EventType"},
+			Error:  ErrInvalidIndexTemplate,
+		},
+		{
+			Name:   "negative batch size",
+			Config: Config{Endpoint: "https://localhost:9200", BatchSize: -1},
+			Error:  ErrInvalidBatchConfig,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(tst.Error, errors.Cause(tst.Config.Validate()))
+		})
+	}
+}
+
+func TestCloseUnresponsiveCluster(t *testing.T) {
+	defer func(d time.Duration) { requestTimeout = d }(requestTimeout)
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	requestTimeout = 100 * time.Millisecond
+
+	requests := make(chan struct{}, 10)
+
+	t.Run("Hung request", func(t *testing.T) {
+		assert := require.New(t)
+
+		unblock := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- struct{}{}
+			<-unblock
+		}))
+		defer server.Close()
+		defer close(unblock)
+
+		i, err := New(Config{Endpoint: server.URL, BatchSize: 1, FlushIntervalSeconds: 3600})
+		assert.NoError(err)
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		<-requests
+
+		// the pending flush is cancelled and the final flush times out
+		start := time.Now()
+		assert.Error(i.Close())
+		assert.True(time.Since(start) < time.Second)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		assert := require.New(t)
+		retryBackoff = time.Hour
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- struct{}{}
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		i, err := New(Config{Endpoint: server.URL, BatchSize: 1, FlushIntervalSeconds: 3600})
+		assert.NoError(err)
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		<-requests
+
+		start := time.Now()
+		assert.Error(i.Close())
+		assert.True(time.Since(start) < time.Second)
+	})
+}
+
+func TestIntegration(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
+}
@@ -0,0 +1,12 @@
+package elasticsearch
+
+import "errors"
+
+// errors
+var (
+	ErrInvalidEndpoint      = errors.New("invalid endpoint")
+	ErrInvalidIndexTemplate = errors.New("invalid index template")
+	ErrInvalidBatchConfig   = errors.New("batch size, flush interval and max retries must not be negative")
+	ErrBufferFull           = errors.New("buffer full, event dropped")
+	ErrClosed               = errors.New("integration closed")
+)
@@ -34,7 +34,8 @@ type message struct {
 
 // Integration implements a GCP Pub/Sub integration.
 type Integration struct {
-	marshaler marshaler.Type
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
 
 	project             string
 	topic               string
@@ -61,6 +62,14 @@ func New(m marshaler.Type, conf config.IntegrationGCPConfig) (*Integration, erro
 		topic:     conf.TopicName,
 	}
 
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
+	}
+
 	var err error
 	if conf.CredentialsFile != "" {
 		i.jsonCredentialsFile, err = ioutil.ReadFile(conf.CredentialsFile)
@@ -134,7 +143,7 @@ func (i *Integration) publish(ctx context.Context, event string, devEUIB []byte,
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], devEUIB)
 
-	b, err := marshaler.Marshal(i.marshaler, msg)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal event error")
 	}
@@ -168,6 +177,7 @@ func (i *Integration) publish(ctx context.Context, event string, devEUIB []byte,
 		return errors.Wrap(err, "pub/sub request error")
 	}
 	defer resp.Body.Close()
+	models.RecordHTTPStatus(ctx, resp.StatusCode)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		b, _ := ioutil.ReadAll(resp.Body)
 		return fmt.Errorf("exepcted 2xx code, got: %d (%s)", resp.StatusCode, string(b))
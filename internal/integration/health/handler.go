@@ -0,0 +1,82 @@
+package health
+
+import (
+	"context"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// handler wraps an integration handler and records the delivery status of
+// each handled event.
+type handler struct {
+	models.IntegrationHandler
+	kind string
+	name string
+}
+
+// Track returns the given integration handler, recording the delivery
+// status of the handled events under the given integration kind and name.
+// The application ID is taken from the event.
+func Track(kind, name string, h models.IntegrationHandler) models.IntegrationHandler {
+	return &handler{
+		IntegrationHandler: h,
+		kind:               kind,
+		name:               name,
+	}
+}
+
+// IntegrationName returns the name of the integration.
+func (h *handler) IntegrationName() string {
+	return h.name
+}
+
+// Unwrap returns the wrapped integration handler.
+func (h *handler) Unwrap() models.IntegrationHandler {
+	return h.IntegrationHandler
+}
+
+func (h *handler) record(ctx context.Context, applicationID uint64, err error) error {
+	Record(ctx, int64(applicationID), h.kind, h.name, err)
+	return err
+}
+
+// HandleUplinkEvent sends an UplinkEvent.
+func (h *handler) HandleUplinkEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleUplinkEvent(ctx, i, vars, pl))
+}
+
+// HandleJoinEvent sends a JoinEvent.
+func (h *handler) HandleJoinEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleJoinEvent(ctx, i, vars, pl))
+}
+
+// HandleAckEvent sends an AckEvent.
+func (h *handler) HandleAckEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleAckEvent(ctx, i, vars, pl))
+}
+
+// HandleErrorEvent sends an ErrorEvent.
+func (h *handler) HandleErrorEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleErrorEvent(ctx, i, vars, pl))
+}
+
+// HandleStatusEvent sends a StatusEvent.
+func (h *handler) HandleStatusEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleStatusEvent(ctx, i, vars, pl))
+}
+
+// HandleLocationEvent sends a LocationEvent.
+func (h *handler) HandleLocationEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleLocationEvent(ctx, i, vars, pl))
+}
+
+// HandleTxAckEvent sends a TxAckEvent.
+func (h *handler) HandleTxAckEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleTxAckEvent(ctx, i, vars, pl))
+}
+
+// HandleIntegrationEvent sends an IntegrationEvent.
+func (h *handler) HandleIntegrationEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return h.record(ctx, pl.ApplicationId, h.IntegrationHandler.HandleIntegrationEvent(ctx, i, vars, pl))
+}
@@ -0,0 +1,273 @@
+// Package health keeps track of the delivery status of the integrations.
+package health
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+const (
+	statusKey = "lora:as:integration:health:%d:%s"
+	minuteKey = "lora:as:integration:health:%d:%s:%s:m:%d"
+	hourKey   = "lora:as:integration:health:%d:%s:%s:h:%d"
+
+	delivered = "delivered"
+	failed    = "failed"
+
+	statusTTL = 31 * 24 * time.Hour
+	minuteTTL = 61 * time.Minute
+	hourTTL   = 25 * time.Hour
+)
+
+// Status contains the delivery status of an integration for a single
+// application.
+type Status struct {
+	LastSuccessAt       *time.Time `json:"lastSuccessAt"`
+	LastErrorAt         *time.Time `json:"lastErrorAt"`
+	LastError           string     `json:"lastError"`
+	ConsecutiveFailures int64      `json:"consecutiveFailures,string"`
+	Delivered           Counts     `json:"delivered"`
+	Failed              Counts     `json:"failed"`
+}
+
+// Counts contains the number of events within the sliding windows. The
+// windows are based on one-minute buckets, except for the 24 hour window
+// which uses one-hour buckets.
+type Counts struct {
+	Last5m  int64 `json:"last5m,string"`
+	Last1h  int64 `json:"last1h,string"`
+	Last24h int64 `json:"last24h,string"`
+}
+
+// flushInterval defines the interval in which the recorded delivery
+// results are written to Redis.
+const flushInterval = time.Second
+
+var (
+	pendingMux sync.Mutex
+	pending    = make(map[pendingKey]*pendingStatus)
+	flushOnce  sync.Once
+)
+
+// pendingKey identifies the integration of an application.
+type pendingKey struct {
+	applicationID int64
+	name          string
+}
+
+// pendingStatus aggregates the delivery results of a single integration
+// which have not yet been written to Redis.
+type pendingStatus struct {
+	lastSuccessAt time.Time
+	lastErrorAt   time.Time
+	lastError     string
+
+	// reset is set when a delivery succeeded, failures contains the number
+	// of failures since the last successful delivery (or since the last
+	// flush when reset is not set).
+	reset    bool
+	failures int64
+
+	counts map[pendingCount]int64
+}
+
+// pendingCount identifies a minute bucket for the given result.
+type pendingCount struct {
+	result string
+	minute int64
+}
+
+// Record records the result of delivering an event to the given integration.
+// A nil error means that the event was delivered successfully. The results
+// are aggregated in memory and written to Redis every flushInterval.
+func Record(ctx context.Context, applicationID int64, kind, name string, err error) {
+	if err != nil {
+		failedCounter(applicationID, kind).Inc()
+	} else {
+		deliveredCounter(applicationID, kind).Inc()
+	}
+
+	flushOnce.Do(func() {
+		go flushLoop()
+	})
+
+	record(time.Now(), applicationID, name, err)
+}
+
+func record(now time.Time, applicationID int64, name string, err error) {
+	pendingMux.Lock()
+	defer pendingMux.Unlock()
+
+	key := pendingKey{applicationID: applicationID, name: name}
+	ps, ok := pending[key]
+	if !ok {
+		ps = &pendingStatus{
+			counts: make(map[pendingCount]int64),
+		}
+		pending[key] = ps
+	}
+
+	result := delivered
+	if err != nil {
+		result = failed
+		ps.failures++
+		if now.After(ps.lastErrorAt) {
+			ps.lastErrorAt = now
+			ps.lastError = err.Error()
+		}
+	} else {
+		ps.reset = true
+		ps.failures = 0
+		if now.After(ps.lastSuccessAt) {
+			ps.lastSuccessAt = now
+		}
+	}
+
+	ps.counts[pendingCount{result: result, minute: now.Unix() / 60}]++
+}
+
+func flushLoop() {
+	for range time.Tick(flushInterval) {
+		if err := flush(context.Background()); err != nil {
+			log.WithError(err).Error("integration/health: record delivery status error")
+		}
+	}
+}
+
+// flush writes the pending delivery results to Redis.
+func flush(ctx context.Context) error {
+	pendingMux.Lock()
+	ps := pending
+	pending = make(map[pendingKey]*pendingStatus)
+	pendingMux.Unlock()
+
+	if len(ps) == 0 {
+		return nil
+	}
+
+	pipe := storage.RedisClient().TxPipeline()
+	for k, s := range ps {
+		key := storage.GetRedisKey(statusKey, k.applicationID, k.name)
+
+		if !s.lastSuccessAt.IsZero() {
+			pipe.HSet(ctx, key, "last_success_at", s.lastSuccessAt.UnixNano())
+		}
+		if !s.lastErrorAt.IsZero() {
+			pipe.HSet(ctx, key, "last_error_at", s.lastErrorAt.UnixNano(), "last_error", s.lastError)
+		}
+		if s.reset {
+			pipe.HSet(ctx, key, "consecutive_failures", s.failures)
+		} else {
+			pipe.HIncrBy(ctx, key, "consecutive_failures", s.failures)
+		}
+		pipe.Expire(ctx, key, statusTTL)
+
+		for c, n := range s.counts {
+			mKey := storage.GetRedisKey(minuteKey, k.applicationID, k.name, c.result, c.minute)
+			pipe.IncrBy(ctx, mKey, n)
+			pipe.Expire(ctx, mKey, minuteTTL)
+
+			hKey := storage.GetRedisKey(hourKey, k.applicationID, k.name, c.result, c.minute/60)
+			pipe.IncrBy(ctx, hKey, n)
+			pipe.Expire(ctx, hKey, hourTTL)
+		}
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "redis exec error")
+	}
+
+	return nil
+}
+
+// Get returns the delivery status of the given integration for the given
+// application. The pending delivery results are written to Redis first.
+func Get(ctx context.Context, applicationID int64, name string) (Status, error) {
+	if err := flush(ctx); err != nil {
+		return Status{}, err
+	}
+	return get(ctx, time.Now(), applicationID, name)
+}
+
+func get(ctx context.Context, now time.Time, applicationID int64, name string) (Status, error) {
+	var s Status
+
+	vals, err := storage.RedisClient().HGetAll(ctx, storage.GetRedisKey(statusKey, applicationID, name)).Result()
+	if err != nil {
+		return s, errors.Wrap(err, "redis hgetall error")
+	}
+
+	if v, ok := vals["last_success_at"]; ok {
+		s.LastSuccessAt = parseTime(v)
+	}
+	if v, ok := vals["last_error_at"]; ok {
+		s.LastErrorAt = parseTime(v)
+	}
+	s.LastError = vals["last_error"]
+	s.ConsecutiveFailures, _ = strconv.ParseInt(vals["consecutive_failures"], 10, 64)
+
+	if s.Delivered, err = getCounts(ctx, now, applicationID, name, delivered); err != nil {
+		return s, err
+	}
+	if s.Failed, err = getCounts(ctx, now, applicationID, name, failed); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+func getCounts(ctx context.Context, now time.Time, applicationID int64, name, result string) (Counts, error) {
+	var c Counts
+
+	// the last 60 minute buckets (including the current one) followed by
+	// the last 24 hour buckets (including the current one)
+	var keys []string
+	for i := int64(0); i < 60; i++ {
+		keys = append(keys, storage.GetRedisKey(minuteKey, applicationID, name, result, now.Unix()/60-i))
+	}
+	for i := int64(0); i < 24; i++ {
+		keys = append(keys, storage.GetRedisKey(hourKey, applicationID, name, result, now.Unix()/3600-i))
+	}
+
+	vals, err := storage.RedisClient().MGet(ctx, keys...).Result()
+	if err != nil && err != redis.Nil {
+		return c, errors.Wrap(err, "redis mget error")
+	}
+
+	for i, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(str, 10, 64)
+
+		switch {
+		case i < 5:
+			c.Last5m += n
+			c.Last1h += n
+		case i < 60:
+			c.Last1h += n
+		default:
+			c.Last24h += n
+		}
+	}
+
+	return c, nil
+}
+
+func parseTime(s string) *time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(0, n)
+	return &t
+}
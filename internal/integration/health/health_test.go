@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/chirpstack-application-server/internal/test"
+)
+
+type testHandler struct {
+	models.IntegrationHandler
+	err error
+}
+
+func (h *testHandler) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return h.err
+}
+
+type HealthTestSuite struct {
+	suite.Suite
+}
+
+func (ts *HealthTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+}
+
+func (ts *HealthTestSuite) SetupTest() {
+	storage.RedisClient().FlushAll(context.Background())
+}
+
+func (ts *HealthTestSuite) TestRecord() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	now := time.Now()
+
+	// two hours ago, only counted in the 24h window
+	record(now.Add(-2*time.Hour), 1, "HTTP/1", nil)
+
+	// thirty minutes ago, counted in the 1h and 24h window
+	record(now.Add(-30*time.Minute), 1, "HTTP/1", nil)
+	assert.NoError(flush(ctx))
+
+	// just now
+	record(now, 1, "HTTP/1", nil)
+	record(now, 1, "HTTP/1", errors.New("endpoint down"))
+	assert.NoError(flush(ctx))
+	record(now, 1, "HTTP/1", errors.New("endpoint still down"))
+	assert.NoError(flush(ctx))
+
+	s, err := get(ctx, now, 1, "HTTP/1")
+	assert.NoError(err)
+
+	assert.NotNil(s.LastSuccessAt)
+	assert.NotNil(s.LastErrorAt)
+	assert.Equal("endpoint still down", s.LastError)
+	assert.EqualValues(2, s.ConsecutiveFailures)
+	assert.Equal(Counts{Last5m: 1, Last1h: 2, Last24h: 3}, s.Delivered)
+	assert.Equal(Counts{Last5m: 2, Last1h: 2, Last24h: 2}, s.Failed)
+
+	ts.T().Run("Success resets consecutive failures", func(t *testing.T) {
+		assert := require.New(t)
+
+		record(now, 1, "HTTP/1", nil)
+		assert.NoError(flush(ctx))
+		s, err := get(ctx, now, 1, "HTTP/1")
+		assert.NoError(err)
+		assert.EqualValues(0, s.ConsecutiveFailures)
+		assert.Equal("endpoint still down", s.LastError)
+	})
+
+	ts.T().Run("Unknown integration", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := get(ctx, now, 2, "HTTP/2")
+		assert.NoError(err)
+		assert.Equal(Status{}, s)
+	})
+}
+
+func (ts *HealthTestSuite) TestTrack() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	inner := &testHandler{}
+	h := Track("HTTP", "HTTP/3", inner)
+	assert.Equal(inner, h.(*handler).Unwrap())
+
+	assert.NoError(h.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 3}))
+
+	inner.err = errors.New("boom")
+	assert.Equal(inner.err, h.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 3}))
+
+	s, err := Get(ctx, 3, "HTTP/3")
+	assert.NoError(err)
+	assert.EqualValues(1, s.Delivered.Last5m)
+	assert.EqualValues(1, s.Failed.Last5m)
+	assert.Equal("boom", s.LastError)
+}
+
+func TestRecordAggregation(t *testing.T) {
+	assert := require.New(t)
+	now := time.Unix(1600000000, 0)
+
+	record(now, -1, "HTTP/1", errors.New("endpoint down"))
+	record(now.Add(time.Minute), -1, "HTTP/1", nil)
+	record(now.Add(time.Minute), -1, "HTTP/1", errors.New("endpoint still down"))
+
+	pendingMux.Lock()
+	ps := pending[pendingKey{applicationID: -1, name: "HTTP/1"}]
+	delete(pending, pendingKey{applicationID: -1, name: "HTTP/1"})
+	pendingMux.Unlock()
+
+	assert.Equal(&pendingStatus{
+		lastSuccessAt: now.Add(time.Minute),
+		lastErrorAt:   now.Add(time.Minute),
+		lastError:     "endpoint still down",
+		reset:         true,
+		failures:      1,
+		counts: map[pendingCount]int64{
+			{result: failed, minute: now.Unix() / 60}:      1,
+			{result: delivered, minute: now.Unix()/60 + 1}: 1,
+			{result: failed, minute: now.Unix()/60 + 1}:    1,
+		},
+	}, ps)
+}
+
+func TestHealth(t *testing.T) {
+	suite.Run(t, new(HealthTestSuite))
+}
@@ -0,0 +1,28 @@
+package health
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	dc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_event_delivered_count",
+		Help: "The number of events delivered by the integrations (per application and integration kind).",
+	}, []string{"application_id", "kind"})
+
+	fc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_event_failed_count",
+		Help: "The number of events which failed to be delivered by the integrations (per application and integration kind).",
+	}, []string{"application_id", "kind"})
+)
+
+func deliveredCounter(applicationID int64, kind string) prometheus.Counter {
+	return dc.With(prometheus.Labels{"application_id": strconv.FormatInt(applicationID, 10), "kind": kind})
+}
+
+func failedCounter(applicationID int64, kind string) prometheus.Counter {
+	return fc.With(prometheus.Labels{"application_id": strconv.FormatInt(applicationID, 10), "kind": kind})
+}
@@ -0,0 +1,443 @@
+// Package homeassistant implements a Home Assistant MQTT discovery
+// integration.
+//
+// On the first uplink of a device, a sensor discovery message is published
+// for each field of the decoded object. The decoded object is then published
+// to the state topic of the device on every uplink.
+package homeassistant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	mqttint "github.com/brocaar/chirpstack-application-server/internal/integration/mqtt"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+// Device-profile tag prefixes. E.g. the tag "unit.sensor.temperature" sets
+// the unit of the "sensor.temperature" field.
+const (
+	unitTagPrefix        = "unit."
+	deviceClassTagPrefix = "device_class."
+)
+
+// objectIDRegexp matches the characters that are not allowed in an object ID.
+var objectIDRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// Integration implements a Home Assistant MQTT discovery integration.
+type Integration struct {
+	conn               mqtt.Client
+	config             config.IntegrationHomeAssistantConfig
+	qos                byte
+	stateTopicTemplate *template.Template
+
+	publish           func(topic string, retain bool, b []byte) error
+	deviceProfileTags func(ctx context.Context, id uuid.UUID) (map[string]string, error)
+
+	mu      sync.Mutex
+	devices map[lorawan.EUI64]*device
+}
+
+// device holds the discovery state of a device. The mutex is held while
+// publishing the discovery messages of the device, so that the devices are
+// discovered concurrently.
+type device struct {
+	mu sync.Mutex
+
+	// discovered contains the names of the discovered fields.
+	discovered map[string]struct{}
+
+	// objectIDs contains the field name by object ID, to detect the fields
+	// mapping to the same object ID (e.g. "a.b" and "a_b").
+	objectIDs map[string]string
+}
+
+// discoveryConfig implements the Home Assistant MQTT sensor discovery
+// payload.
+type discoveryConfig struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	StateTopic        string          `json:"state_topic"`
+	ValueTemplate     string          `json:"value_template"`
+	UnitOfMeasurement string          `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model,omitempty"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// field defines a field of the decoded object.
+type field struct {
+	// Path contains the (nested) keys of the field.
+	Path []string
+}
+
+// Name returns the dot-separated name of the field.
+func (f field) Name() string {
+	return strings.Join(f.Path, ".")
+}
+
+// ObjectID returns the object ID of the field.
+func (f field) ObjectID() string {
+	return objectIDRegexp.ReplaceAllString(strings.Join(f.Path, "_"), "_")
+}
+
+// ValueTemplate returns the template to get the value of the field from the
+// state payload.
+func (f field) ValueTemplate() string {
+	var tmpl strings.Builder
+	tmpl.WriteString("{{ value_json")
+	for _, key := range f.Path {
+		b, _ := json.Marshal(key)
+		tmpl.WriteString("[")
+		tmpl.Write(b)
+		tmpl.WriteString("]")
+	}
+	tmpl.WriteString(" }}")
+	return tmpl.String()
+}
+
+// New creates a new Home Assistant integration, using the connection
+// settings of the MQTT integration.
+func New(mqttConf config.IntegrationMQTTConfig, conf config.IntegrationHomeAssistantConfig) (*Integration, error) {
+	i, err := newIntegration(conf)
+	if err != nil {
+		return nil, err
+	}
+	i.qos = mqttConf.QOS
+	i.publish = i.mqttPublish
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(mqttConf.Server)
+	opts.SetUsername(mqttConf.Username)
+	opts.SetPassword(mqttConf.Password)
+	opts.SetCleanSession(mqttConf.CleanSession)
+	opts.SetMaxReconnectInterval(mqttConf.MaxReconnectInterval)
+	opts.SetConnectionLostHandler(i.onConnectionLost)
+
+	// The client ID must be unique, as the MQTT integration might be
+	// connected using the same settings.
+	if mqttConf.ClientID != "" {
+		opts.SetClientID(mqttConf.ClientID + "-ha")
+	}
+
+	tlsconfig, err := mqttint.NewTLSConfig(mqttConf.CACert, mqttConf.TLSCert, mqttConf.TLSKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "new tls config error")
+	}
+	if tlsconfig != nil {
+		opts.SetTLSConfig(tlsconfig)
+	}
+
+	log.WithField("server", mqttConf.Server).Info("integration/homeassistant: connecting to mqtt broker")
+	i.conn = mqtt.NewClient(opts)
+	for {
+		if token := i.conn.Connect(); token.Wait() && token.Error() != nil {
+			log.Errorf("integration/homeassistant: connecting to broker error, will retry in 2s: %s", token.Error())
+			time.Sleep(2 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	return i, nil
+}
+
+func newIntegration(conf config.IntegrationHomeAssistantConfig) (*Integration, error) {
+	var err error
+	i := Integration{
+		config:            conf,
+		deviceProfileTags: getDeviceProfileTags,
+		devices:           make(map[lorawan.EUI64]*device),
+	}
+
+	i.stateTopicTemplate, err = template.New("state").Parse(conf.StateTopicTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse state topic template error")
+	}
+
+	return &i, nil
+}
+
+// HandleUplinkEvent publishes the discovery messages for the fields that
+// have not yet been discovered, followed by the state of the device.
+// Uplinks without decoded object are ignored.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	if pl.ObjectJson == "" {
+		return nil
+	}
+
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], pl.DevEui)
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(pl.ObjectJson), &obj); err != nil {
+		return errors.Wrap(err, "unmarshal object error")
+	}
+
+	stateTopic, err := i.getStateTopic(pl.ApplicationId, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get state topic error")
+	}
+
+	if err := i.discover(ctx, pl, devEUI, stateTopic, objectFields(obj)); err != nil {
+		return errors.Wrap(err, "publish discovery error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"topic":   stateTopic,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("integration/homeassistant: publishing state")
+
+	if err := i.publish(stateTopic, i.config.RetainState, []byte(pl.ObjectJson)); err != nil {
+		return errors.Wrap(err, "publish state error")
+	}
+	homeAssistantMessageCounter("state").Inc()
+
+	return nil
+}
+
+// HandleJoinEvent is not implemented.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return nil
+}
+
+// HandleAckEvent is not implemented.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return nil
+}
+
+// HandleErrorEvent is not implemented.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return nil
+}
+
+// HandleStatusEvent is not implemented.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return nil
+}
+
+// HandleLocationEvent is not implemented.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return nil
+}
+
+// HandleTxAckEvent is not implemented.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return nil
+}
+
+// HandleIntegrationEvent is not implemented.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return nil
+}
+
+// DataDownChan returns nil.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
+
+// Close closes the integration.
+func (i *Integration) Close() error {
+	log.Info("integration/homeassistant: closing handler")
+	if i.conn != nil {
+		i.conn.Disconnect(250)
+	}
+	return nil
+}
+
+// discover publishes the discovery messages for the given fields that have
+// not yet been published for the device. Fields of which the object ID is
+// already used by an other field of the device are not published, as these
+// would overwrite the discovery message of the other field.
+func (i *Integration) discover(ctx context.Context, pl pb.UplinkEvent, devEUI lorawan.EUI64, stateTopic string, fields []field) error {
+	d := i.getDevice(devEUI)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var newFields []field
+	for _, f := range fields {
+		if _, ok := d.discovered[f.Name()]; ok {
+			continue
+		}
+
+		if name, ok := d.objectIDs[f.ObjectID()]; ok && name != f.Name() {
+			log.WithFields(log.Fields{
+				"dev_eui":   devEUI,
+				"field":     f.Name(),
+				"other":     name,
+				"object_id": f.ObjectID(),
+				"ctx_id":    ctx.Value(logging.ContextIDKey),
+			}).Warning("integration/homeassistant: object id is already used by other field, field is not discovered")
+			d.discovered[f.Name()] = struct{}{}
+			continue
+		}
+
+		d.objectIDs[f.ObjectID()] = f.Name()
+		newFields = append(newFields, f)
+	}
+	if len(newFields) == 0 {
+		return nil
+	}
+
+	var tags map[string]string
+	if pl.DeviceProfileId != "" {
+		id, err := uuid.FromString(pl.DeviceProfileId)
+		if err != nil {
+			return errors.Wrap(err, "parse device-profile id error")
+		}
+
+		tags, err = i.deviceProfileTags(ctx, id)
+		if err != nil {
+			return errors.Wrap(err, "get device-profile tags error")
+		}
+	}
+
+	deviceName := pl.DeviceName
+	if deviceName == "" {
+		deviceName = devEUI.String()
+	}
+
+	for _, f := range newFields {
+		b, err := json.Marshal(discoveryConfig{
+			Name:              fmt.Sprintf("%s %s", deviceName, f.Name()),
+			UniqueID:          fmt.Sprintf("%s_%s", devEUI, f.ObjectID()),
+			StateTopic:        stateTopic,
+			ValueTemplate:     f.ValueTemplate(),
+			UnitOfMeasurement: tags[unitTagPrefix+f.Name()],
+			DeviceClass:       tags[deviceClassTagPrefix+f.Name()],
+			Device: discoveryDevice{
+				Identifiers:  []string{"chirpstack_" + devEUI.String()},
+				Name:         deviceName,
+				Model:        pl.DeviceProfileName,
+				Manufacturer: "ChirpStack",
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "marshal json error")
+		}
+
+		topic := strings.Join([]string{i.config.DiscoveryPrefix, "sensor", devEUI.String(), f.ObjectID(), "config"}, "/")
+
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"topic":   topic,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Info("integration/homeassistant: publishing discovery")
+
+		// Discovery messages are retained, so that Home Assistant
+		// re-discovers the sensors after a restart.
+		if err := i.publish(topic, true, b); err != nil {
+			return err
+		}
+		homeAssistantMessageCounter("discovery").Inc()
+
+		d.discovered[f.Name()] = struct{}{}
+	}
+
+	return nil
+}
+
+// getDevice returns the discovery state of the given device.
+func (i *Integration) getDevice(devEUI lorawan.EUI64) *device {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	d, ok := i.devices[devEUI]
+	if !ok {
+		d = &device{
+			discovered: make(map[string]struct{}),
+			objectIDs:  make(map[string]string),
+		}
+		i.devices[devEUI] = d
+	}
+	return d
+}
+
+func (i *Integration) getStateTopic(applicationID uint64, devEUI lorawan.EUI64) (string, error) {
+	topic := bytes.NewBuffer(nil)
+	err := i.stateTopicTemplate.Execute(topic, struct {
+		ApplicationID uint64
+		DevEUI        lorawan.EUI64
+	}{applicationID, devEUI})
+	if err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+	return topic.String(), nil
+}
+
+func (i *Integration) mqttPublish(topic string, retain bool, b []byte) error {
+	if token := i.conn.Publish(topic, i.qos, retain, b); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (i *Integration) onConnectionLost(c mqtt.Client, reason error) {
+	log.Errorf("integration/homeassistant: mqtt connection error: %s", reason)
+}
+
+func getDeviceProfileTags(ctx context.Context, id uuid.UUID) (map[string]string, error) {
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), id, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+	for k, v := range dp.Tags.Map {
+		if v.Valid {
+			tags[k] = v.String
+		}
+	}
+	return tags, nil
+}
+
+// objectFields returns the (nested) fields of the given object, sorted by
+// name. Objects are flattened, null values and arrays are ignored.
+func objectFields(obj map[string]interface{}) []field {
+	var fields []field
+	flattenObject(nil, obj, &fields)
+
+	sort.Slice(fields, func(a, b int) bool {
+		return fields[a].Name() < fields[b].Name()
+	})
+
+	return fields
+}
+
+func flattenObject(path []string, obj map[string]interface{}, fields *[]field) {
+	for k, v := range obj {
+		p := append(append([]string(nil), path...), k)
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flattenObject(p, v, fields)
+		case float64, bool, string:
+			*fields = append(*fields, field{Path: p})
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package homeassistant
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+type message struct {
+	Topic   string
+	Retain  bool
+	Payload string
+}
+
+func TestIntegration(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	dpID := uuid.Must(uuid.NewV4())
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	i, err := newIntegration(config.IntegrationHomeAssistantConfig{
+		DiscoveryPrefix:    "homeassistant",
+		StateTopicTemplate: "application/{{ .ApplicationID }}/device/{{ .DevEUI }}/state",
+	})
+	assert.NoError(err)
+
+	var messages []message
+	i.publish = func(topic string, retain bool, b []byte) error {
+		messages = append(messages, message{Topic: topic, Retain: retain, Payload: string(b)})
+		return nil
+	}
+	i.deviceProfileTags = func(ctx context.Context, id uuid.UUID) (map[string]string, error) {
+		assert.Equal(dpID, id)
+		return map[string]string{
+			"unit.sensor.temperature":         "°C",
+			"device_class.sensor.temperature": "temperature",
+		}, nil
+	}
+
+	t.Run("First uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId:     10,
+			DeviceName:        "kitchen",
+			DevEui:            devEUI[:],
+			DeviceProfileId:   dpID.String(),
+			DeviceProfileName: "climate-sensor",
+			ObjectJson:        `{"sensor":{"temperature":21.5},"door open":false,"values":[1,2]}`,
+		}))
+		assert.Len(messages, 3)
+
+		assert.Equal("homeassistant/sensor/0102030405060708/door_open/config", messages[0].Topic)
+		assert.True(messages[0].Retain)
+		assert.JSONEq(`{
+			"name": "kitchen door open",
+			"unique_id": "0102030405060708_door_open",
+			"state_topic": "application/10/device/0102030405060708/state",
+			"value_template": "{{ value_json[\"door open\"] }}",
+			"device": {
+				"identifiers": ["chirpstack_0102030405060708"],
+				"name": "kitchen",
+				"model": "climate-sensor",
+				"manufacturer": "ChirpStack"
+			}
+		}`, messages[0].Payload)
+
+		assert.Equal("homeassistant/sensor/0102030405060708/sensor_temperature/config", messages[1].Topic)
+		assert.JSONEq(`{
+			"name": "kitchen sensor.temperature",
+			"unique_id": "0102030405060708_sensor_temperature",
+			"state_topic": "application/10/device/0102030405060708/state",
+			"value_template": "{{ value_json[\"sensor\"][\"temperature\"] }}",
+			"unit_of_measurement": "°C",
+			"device_class": "temperature",
+			"device": {
+				"identifiers": ["chirpstack_0102030405060708"],
+				"name": "kitchen",
+				"model": "climate-sensor",
+				"manufacturer": "ChirpStack"
+			}
+		}`, messages[1].Payload)
+
+		assert.Equal(message{
+			Topic:   "application/10/device/0102030405060708/state",
+			Payload: `{"sensor":{"temperature":21.5},"door open":false,"values":[1,2]}`,
+		}, messages[2])
+	})
+
+	t.Run("Next uplink", func(t *testing.T) {
+		assert := require.New(t)
+		messages = nil
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId:   10,
+			DevEui:          devEUI[:],
+			DeviceProfileId: dpID.String(),
+			ObjectJson:      `{"sensor":{"temperature":22}}`,
+		}))
+		assert.Len(messages, 1)
+		assert.Equal("application/10/device/0102030405060708/state", messages[0].Topic)
+	})
+
+	t.Run("Uplink with new field", func(t *testing.T) {
+		assert := require.New(t)
+		messages = nil
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId:   10,
+			DevEui:          devEUI[:],
+			DeviceProfileId: dpID.String(),
+			ObjectJson:      `{"sensor":{"temperature":22,"humidity":60}}`,
+		}))
+		assert.Len(messages, 2)
+		assert.Equal("homeassistant/sensor/0102030405060708/sensor_humidity/config", messages[0].Topic)
+		assert.Equal("application/10/device/0102030405060708/state", messages[1].Topic)
+	})
+
+	t.Run("Uplink with colliding field", func(t *testing.T) {
+		assert := require.New(t)
+		messages = nil
+
+		// "door_open" has the same object id as "door open"
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId:   10,
+			DevEui:          devEUI[:],
+			DeviceProfileId: dpID.String(),
+			ObjectJson:      `{"door_open":true,"sensor":{"temperature":22}}`,
+		}))
+		assert.Len(messages, 1)
+		assert.Equal("application/10/device/0102030405060708/state", messages[0].Topic)
+	})
+
+	t.Run("Uplink without object", func(t *testing.T) {
+		assert := require.New(t)
+		messages = nil
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+		}))
+		assert.Len(messages, 0)
+	})
+}
+
+func TestConcurrentDiscovery(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	i, err := newIntegration(config.IntegrationHomeAssistantConfig{
+		DiscoveryPrefix:    "homeassistant",
+		StateTopicTemplate: "device/{{ .DevEUI }}/state",
+	})
+	assert.NoError(err)
+
+	var mu sync.Mutex
+	var topics []string
+	i.publish = func(topic string, retain bool, b []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		topics = append(topics, topic)
+		return nil
+	}
+
+	// the device-profile tags of the first device block until the second
+	// device has been discovered
+	blockedID := uuid.Must(uuid.NewV4())
+	unblock := make(chan struct{})
+	i.deviceProfileTags = func(ctx context.Context, id uuid.UUID) (map[string]string, error) {
+		if id == blockedID {
+			<-unblock
+		}
+		return nil, nil
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			DevEui:          []byte{1, 1, 1, 1, 1, 1, 1, 1},
+			DeviceProfileId: blockedID.String(),
+			ObjectJson:      `{"temperature":21.5}`,
+		})
+	}()
+
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+		DevEui:          []byte{2, 2, 2, 2, 2, 2, 2, 2},
+		DeviceProfileId: uuid.Must(uuid.NewV4()).String(),
+		ObjectJson:      `{"temperature":21.5}`,
+	}))
+	close(unblock)
+	assert.NoError(<-done)
+
+	assert.Equal([]string{
+		"homeassistant/sensor/0202020202020202/temperature/config",
+		"device/0202020202020202/state",
+		"homeassistant/sensor/0101010101010101/temperature/config",
+		"device/0101010101010101/state",
+	}, topics)
+}
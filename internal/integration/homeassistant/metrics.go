@@ -0,0 +1,17 @@
+package homeassistant
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	mc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_homeassistant_message_count",
+		Help: "The number of published messages by the Home Assistant integration (per message type).",
+	}, []string{"type"})
+)
+
+func homeAssistantMessageCounter(t string) prometheus.Counter {
+	return mc.With(prometheus.Labels{"type": t})
+}
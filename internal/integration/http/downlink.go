@@ -0,0 +1,85 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+// DownlinkSignatureHeader defines the header containing the signature of the
+// downlink request, using the DownlinkSecret as key. The header has the
+// same format as the EventSignatureHeader:
+//
+//	t=<unix timestamp>,v1=<hex encoded HMAC-SHA256>
+//
+// The HMAC-SHA256 is calculated over
+// "<unix timestamp>.<method>\n<path>\n<DevEUI>\n<request body>", where the
+// path is the DownlinkPath of the device (e.g.
+// "/api/applications/1/devices/0102030405060708/downlink") and the DevEUI is
+// HEX encoded. A signature can only be used once.
+const DownlinkSignatureHeader = "X-ChirpStack-Signature"
+
+// downlinkChan contains the downlink payloads received through the
+// HTTP integration downlink endpoint of the external API.
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlink payloads received
+// through the HTTP integration downlink endpoint.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+// NewDownlinkSecret returns a new random downlink secret.
+func NewDownlinkSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random bytes error")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// DownlinkPath returns the path of the downlink endpoint of the given device,
+// which is included in the downlink signature.
+func DownlinkPath(applicationID int64, devEUI lorawan.EUI64) string {
+	return fmt.Sprintf("/api/applications/%d/devices/%s/downlink", applicationID, devEUI)
+}
+
+// SignDownlink returns the DownlinkSignatureHeader value for the given
+// downlink request.
+func SignDownlink(secret string, ts time.Time, method string, applicationID int64, devEUI lorawan.EUI64, body []byte) string {
+	return signEvent(secret, ts, downlinkSignedPayload(method, applicationID, devEUI, body))
+}
+
+// ValidateDownlinkSignature validates the given DownlinkSignatureHeader value
+// against the downlink request. The timestamp of the signature may not
+// differ more than DefaultSignatureTolerance from now. On success, it returns
+// the (timestamp and) signature which was validated, which must be used to
+// reject replays of the same request within the tolerance window.
+func (c Config) ValidateDownlinkSignature(method string, applicationID int64, devEUI lorawan.EUI64, body []byte, header string, now time.Time) (string, error) {
+	if c.DownlinkSecret == "" {
+		return "", ErrInvalidDownlinkSignature
+	}
+
+	pl := downlinkSignedPayload(method, applicationID, devEUI, body)
+	if err := ValidateEventSignature(c.DownlinkSecret, header, pl, DefaultSignatureTolerance, now); err != nil {
+		return "", ErrInvalidDownlinkSignature
+	}
+
+	t, _, _, err := parseSignatureHeader(header)
+	if err != nil {
+		return "", ErrInvalidDownlinkSignature
+	}
+
+	return t + "." + hex.EncodeToString(eventMAC(c.DownlinkSecret, t, pl)), nil
+}
+
+func downlinkSignedPayload(method string, applicationID int64, devEUI lorawan.EUI64, body []byte) []byte {
+	return append([]byte(fmt.Sprintf("%s\n%s\n%s\n", method, DownlinkPath(applicationID, devEUI), devEUI)), body...)
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/lorawan"
+)
+
+func TestValidateDownlinkSignature(t *testing.T) {
+	body := []byte(`{"fPort":10,"data":"AQID"}`)
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	now := time.Unix(1600000000, 0)
+	signature := SignDownlink("secret", now, "POST", 1, devEUI, body)
+
+	tests := []struct {
+		Name          string
+		Config        Config
+		Method        string
+		ApplicationID int64
+		DevEUI        lorawan.EUI64
+		Body          []byte
+		Signature     string
+		Now           time.Time
+		ExpectedError error
+	}{
+		{
+			Name:          "valid signature",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+		},
+		{
+			Name:          "valid signature within tolerance",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now.Add(DefaultSignatureTolerance),
+		},
+		{
+			Name:          "invalid signature",
+			Config:        Config{DownlinkSecret: "other-secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "modified body",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          []byte(`{"fPort":10,"data":"AQIE"}`),
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "other method",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "PUT",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "other application",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 2,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "other device",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1},
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "expired signature",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now.Add(DefaultSignatureTolerance + time.Second),
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "invalid header",
+			Config:        Config{DownlinkSecret: "secret"},
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     "zz",
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+		{
+			Name:          "no downlink secret configured",
+			Method:        "POST",
+			ApplicationID: 1,
+			DevEUI:        devEUI,
+			Body:          body,
+			Signature:     signature,
+			Now:           now,
+			ExpectedError: ErrInvalidDownlinkSignature,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			id, err := tst.Config.ValidateDownlinkSignature(tst.Method, tst.ApplicationID, tst.DevEUI, tst.Body, tst.Signature, tst.Now)
+			assert.Equal(tst.ExpectedError, err)
+			if err != nil {
+				return
+			}
+
+			// the same id must be returned when extra signatures are added
+			idExtra, err := tst.Config.ValidateDownlinkSignature(tst.Method, tst.ApplicationID, tst.DevEUI, tst.Body, tst.Signature+",v1=00", tst.Now)
+			assert.NoError(err)
+			assert.Equal(id, idExtra)
+		})
+	}
+}
@@ -4,5 +4,9 @@ import "errors"
 
 // errors
 var (
-	ErrInvalidHeaderName = errors.New("Invalid header name")
+	ErrInvalidHeaderName        = errors.New("Invalid header name")
+	ErrInvalidDownlinkSignature = errors.New("Invalid downlink signature")
+	ErrInvalidPayloadTemplate   = errors.New("Invalid payload template")
+	ErrInvalidEventSignature    = errors.New("Invalid event signature")
+	ErrInvalidTLSConfig         = errors.New("Invalid TLS configuration")
 )
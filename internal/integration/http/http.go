@@ -4,6 +4,8 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -31,6 +33,25 @@ type Config struct {
 	Marshaler        string            `json:"marshaler"`
 	Timeout          time.Duration     `json:"timeout"`
 
+	// DownlinkSecret contains the shared secret used to validate the
+	// signature of downlink requests.
+	DownlinkSecret string `json:"downlinkSecret,omitempty"`
+
+	// PayloadTemplate contains the (optional) template used to render the
+	// request body (see marshaler.Template).
+	PayloadTemplate string `json:"payloadTemplate,omitempty"`
+
+	// SigningSecret contains the (optional) shared secret used to sign the
+	// event requests (see EventSignatureHeader).
+	SigningSecret string `json:"signingSecret,omitempty"`
+
+	// TLSCert and TLSKey contain the (optional) PEM encoded client
+	// certificate and key, CACert the (optional) PEM encoded CA bundle used
+	// to validate the server certificate.
+	TLSCert string `json:"tlsCert,omitempty"`
+	TLSKey  string `json:"tlsKey,omitempty"`
+	CACert  string `json:"caCert,omitempty"`
+
 	// For backwards compatibility.
 	DataUpURL                  string `json:"dataUpURL"`
 	JoinNotificationURL        string `json:"joinNotificationURL"`
@@ -49,13 +70,54 @@ func (c Config) Validate() error {
 			return ErrInvalidHeaderName
 		}
 	}
+
+	if c.PayloadTemplate != "" {
+		if err := marshaler.ValidateTemplate(c.PayloadTemplate); err != nil {
+			return errors.Wrap(ErrInvalidPayloadTemplate, err.Error())
+		}
+	}
+
+	if _, err := c.tlsConfig(); err != nil {
+		return errors.Wrap(ErrInvalidTLSConfig, err.Error())
+	}
+
 	return nil
 }
 
+// tlsConfig returns the TLS configuration for the configured client
+// certificate and CA bundle. It returns nil when neither is configured.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if c.TLSCert == "" && c.TLSKey == "" && c.CACert == "" {
+		return nil, nil
+	}
+
+	tlsConfig := tls.Config{}
+
+	if c.TLSCert != "" || c.TLSKey != "" {
+		cert, err := tls.X509KeyPair([]byte(c.TLSCert), []byte(c.TLSKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate error")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(c.CACert)) {
+			return nil, errors.New("append ca certificate error")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &tlsConfig, nil
+}
+
 // Integration implements a HTTP integration.
 type Integration struct {
-	marshaler marshaler.Type
-	config    Config
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
+	config          Config
+	client          *http.Client
 }
 
 // New creates a new HTTP integration.
@@ -75,24 +137,48 @@ func New(m marshaler.Type, conf Config) (*Integration, error) {
 		conf.Timeout = time.Second * 10
 	}
 
-	return &Integration{
+	tlsConfig, err := conf.tlsConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "tls config error")
+	}
+
+	i := Integration{
 		marshaler: m,
 		config:    conf,
-	}, nil
+		client: &http.Client{
+			Timeout: conf.Timeout,
+		},
+	}
+
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		i.client.Transport = transport
+	}
+
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
+	}
+
+	return &i, nil
 }
 
-func (i *Integration) send(u string, msg proto.Message) error {
-	b, err := marshaler.Marshal(i.marshaler, msg)
+func (i *Integration) send(ctx context.Context, u string, msg proto.Message) error {
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal json error")
 	}
 
-	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(b))
 	if err != nil {
 		return errors.Wrap(err, "new request error")
 	}
 
-	if i.marshaler == marshaler.Protobuf {
+	if i.marshaler == marshaler.Protobuf && i.payloadTemplate == nil {
 		req.Header.Set("Content-Type", "application/octet-stream")
 	} else {
 		req.Header.Set("Content-Type", "application/json")
@@ -102,15 +188,16 @@ func (i *Integration) send(u string, msg proto.Message) error {
 		req.Header.Set(k, v)
 	}
 
-	client := &http.Client{
-		Timeout: i.config.Timeout,
+	if i.config.SigningSecret != "" {
+		req.Header.Set(EventSignatureHeader, signEvent(i.config.SigningSecret, time.Now(), b))
 	}
 
-	resp, err := client.Do(req)
+	resp, err := i.client.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "http request error")
 	}
 	defer resp.Body.Close()
+	models.RecordHTTPStatus(ctx, resp.StatusCode)
 
 	// check that response is in 200 range
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -120,7 +207,15 @@ func (i *Integration) send(u string, msg proto.Message) error {
 	return nil
 }
 
-func (i *Integration) sendEvent(ctx context.Context, eventType, u string, devEUI lorawan.EUI64, msg proto.Message) {
+// sendEvent sends the event to the given URL. A returned error means that
+// the event could not be delivered and might be retried. URLs to which the
+// event was already delivered by a previous attempt are skipped.
+func (i *Integration) sendEvent(ctx context.Context, eventType, u string, devEUI lorawan.EUI64, msg proto.Message) error {
+	if models.Delivered(ctx, u) {
+		return nil
+	}
+	target := u
+
 	uu, err := url.Parse(u)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
@@ -129,7 +224,7 @@ func (i *Integration) sendEvent(ctx context.Context, eventType, u string, devEUI
 			"ctx_id":     ctx.Value(logging.ContextIDKey),
 			"event_type": eventType,
 		}).Error("integration/http: parse url error")
-		return
+		return nil
 	}
 
 	args := uu.Query()
@@ -143,15 +238,18 @@ func (i *Integration) sendEvent(ctx context.Context, eventType, u string, devEUI
 		"event_type": eventType,
 	}).Info("integration/http: publishing event")
 
-	if err := i.send(u, msg); err != nil {
+	if err := i.send(ctx, u, msg); err != nil {
 		log.WithError(err).WithFields(log.Fields{
 			"url":        u,
 			"dev_eui":    devEUI,
 			"ctx_id":     ctx.Value(logging.ContextIDKey),
 			"event_type": eventType,
 		}).Error("integration/http: publish event error")
-		return
+		return errors.Wrap(err, "publish event error")
 	}
+
+	models.RecordDelivered(ctx, target)
+	return nil
 }
 
 func (i *Integration) getEventEndpointURL(eventType string) string {
@@ -184,8 +282,12 @@ func (i *Integration) getEventEndpointURL(eventType string) string {
 	return url
 }
 
-// Close closes the handler.
+// Close closes the handler. The idle connections of the (per integration)
+// TLS transport are closed, the shared default transport is left untouched.
 func (i *Integration) Close() error {
+	if t, ok := i.client.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
 	return nil
 }
 
@@ -194,11 +296,14 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integratio
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("up")) {
-		i.sendEvent(ctx, "up", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "up", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleJoinEvent sends a JoinEvent.
@@ -206,11 +311,14 @@ func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration,
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("join")) {
-		i.sendEvent(ctx, "join", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "join", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleAckEvent sends an AckEvent.
@@ -218,11 +326,14 @@ func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration,
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("ack")) {
-		i.sendEvent(ctx, "ack", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "ack", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleErrorEvent sends an ErrorEvent.
@@ -230,11 +341,14 @@ func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("error")) {
-		i.sendEvent(ctx, "error", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "error", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleStatusEvent sends a StatusEvent.
@@ -242,11 +356,14 @@ func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integratio
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("status")) {
-		i.sendEvent(ctx, "status", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "status", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleLocationEvent sends a LocationEvent.
@@ -254,11 +371,14 @@ func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integrat
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("location")) {
-		i.sendEvent(ctx, "location", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "location", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleTxAckEvent sends a TxAckEvent.
@@ -266,11 +386,14 @@ func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("txack")) {
-		i.sendEvent(ctx, "txack", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "txack", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // HandleIntegrationEvent sends an IntegrationEvent.
@@ -278,11 +401,14 @@ func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integ
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
+	var err error
 	for _, url := range getURLs(i.getEventEndpointURL("integration")) {
-		i.sendEvent(ctx, "integration", url, devEUI, &pl)
+		if e := i.sendEvent(ctx, "integration", url, devEUI, &pl); e != nil {
+			err = e
+		}
 	}
 
-	return nil
+	return err
 }
 
 // DataDownChan return nil.
@@ -3,10 +3,12 @@ package http
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/stretchr/testify/require"
@@ -54,6 +56,21 @@ func TestHandlerConfig(t *testing.T) {
 			},
 			Valid: false,
 		},
+		{
+			Name: "Invalid client certificate",
+			HandlerConfig: Config{
+				TLSCert: "invalid",
+				TLSKey:  "invalid",
+			},
+			Valid: false,
+		},
+		{
+			Name: "Invalid CA certificate",
+			HandlerConfig: Config{
+				CACert: "invalid",
+			},
+			Valid: false,
+		},
 	}
 
 	for _, test := range testTable {
@@ -343,3 +360,67 @@ func TestGetURLs(t *testing.T) {
 		assert.Equal(tst.Out, getURLs(tst.In))
 	}
 }
+
+func TestPartialDelivery(t *testing.T) {
+	assert := require.New(t)
+
+	var fail bool
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/b" && fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	i, err := New(marshaler.Protobuf, Config{
+		EventEndpointURL: server.URL + "/a," + server.URL + "/b",
+	})
+	assert.NoError(err)
+
+	rec := models.NewDeliveryRecorder(nil)
+	ctx := models.WithDeliveryRecorder(context.Background(), rec)
+
+	fail = true
+	assert.Error(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{}))
+	assert.Equal([]string{"/a", "/b"}, paths)
+	assert.Equal([]string{server.URL + "/a"}, rec.Targets())
+
+	// the retry is only sent to the URL that failed
+	paths = nil
+	fail = false
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{}))
+	assert.Equal([]string{"/b"}, paths)
+	assert.Equal([]string{server.URL + "/a", server.URL + "/b"}, rec.Targets())
+}
+
+func TestRequestContext(t *testing.T) {
+	assert := require.New(t)
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(done)
+
+	i, err := New(marshaler.Protobuf, Config{
+		EventEndpointURL: server.URL,
+		Timeout:          time.Minute,
+	})
+	assert.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{})
+	assert.Error(err)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Less(int64(time.Since(start)), int64(10*time.Second))
+	assert.NoError(i.Close())
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventSignatureHeader defines the header containing the signature of the
+// event request body, when a SigningSecret is configured. The header has the
+// following format:
+//
+//	t=<unix timestamp>,v1=<hex encoded HMAC-SHA256>
+//
+// The HMAC-SHA256 is calculated over "<unix timestamp>.<request body>",
+// using the SigningSecret as key. Receivers should validate the signature
+// using a constant-time comparison and reject requests of which the
+// timestamp is outside an acceptable window (e.g. DefaultSignatureTolerance)
+// to protect against replay attacks.
+const EventSignatureHeader = "X-ChirpStack-Event-Signature"
+
+// DefaultSignatureTolerance defines the recommended max. difference between
+// the signature timestamp and the time of validation.
+const DefaultSignatureTolerance = 5 * time.Minute
+
+// signEvent returns the EventSignatureHeader value for the given body.
+func signEvent(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(eventMAC(secret, t, body)))
+}
+
+func eventMAC(secret, t string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// ValidateEventSignature validates the given EventSignatureHeader value
+// against the body and secret. It returns ErrInvalidEventSignature when the
+// signature is invalid or when the timestamp differs more than the given
+// tolerance from now.
+func ValidateEventSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	t, ts, sigs, err := parseSignatureHeader(header)
+	if err != nil {
+		return ErrInvalidEventSignature
+	}
+
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidEventSignature
+	}
+
+	expected := eventMAC(secret, t, body)
+	for _, sig := range sigs {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidEventSignature
+}
+
+// parseSignatureHeader returns the (raw and parsed) timestamp and the
+// signatures of the given signature header.
+func parseSignatureHeader(header string) (string, int64, [][]byte, error) {
+	var t string
+	var sigs [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", 0, nil, ErrInvalidEventSignature
+		}
+
+		switch kv[0] {
+		case "t":
+			t = kv[1]
+		case "v1":
+			sig, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return "", 0, nil, ErrInvalidEventSignature
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return "", 0, nil, ErrInvalidEventSignature
+	}
+
+	return t, ts, sigs, nil
+}
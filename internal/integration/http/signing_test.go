@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+)
+
+func TestValidateEventSignature(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	body := []byte(`{"foo":"bar"}`)
+	header := signEvent("secret", now, body)
+
+	tests := []struct {
+		Name          string
+		Secret        string
+		Header        string
+		Body          []byte
+		Now           time.Time
+		ExpectedError error
+	}{
+		{
+			Name:   "valid signature",
+			Secret: "secret",
+			Header: header,
+			Body:   body,
+			Now:    now.Add(time.Minute),
+		},
+		{
+			Name:          "invalid secret",
+			Secret:        "other-secret",
+			Header:        header,
+			Body:          body,
+			Now:           now,
+			ExpectedError: ErrInvalidEventSignature,
+		},
+		{
+			Name:          "modified body",
+			Secret:        "secret",
+			Header:        header,
+			Body:          []byte(`{"foo":"baz"}`),
+			Now:           now,
+			ExpectedError: ErrInvalidEventSignature,
+		},
+		{
+			Name:          "timestamp outside tolerance",
+			Secret:        "secret",
+			Header:        header,
+			Body:          body,
+			Now:           now.Add(DefaultSignatureTolerance + time.Second),
+			ExpectedError: ErrInvalidEventSignature,
+		},
+		{
+			Name:          "malformed header",
+			Secret:        "secret",
+			Header:        "foo",
+			Body:          body,
+			Now:           now,
+			ExpectedError: ErrInvalidEventSignature,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.ExpectedError, ValidateEventSignature(tst.Secret, tst.Header, tst.Body, DefaultSignatureTolerance, tst.Now))
+		})
+	}
+}
+
+func TestSignedMutualTLSRequest(t *testing.T) {
+	assert := require.New(t)
+
+	caCert, caKey := newTestCertificate(t, nil, nil, true)
+	serverCert, serverKey := newTestCertificate(t, caCert, caKey, false)
+	clientCert, clientKey := newTestCertificate(t, caCert, caKey, false)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	type request struct {
+		signature string
+		body      []byte
+		peerCerts int
+	}
+	requests := make(chan request, 1)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests <- request{
+			signature: r.Header.Get(EventSignatureHeader),
+			body:      b,
+			peerCerts: len(r.TLS.PeerCertificates),
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	i, err := New(marshaler.JSONV3, Config{
+		EventEndpointURL: server.URL,
+		SigningSecret:    "secret",
+		TLSCert:          encodeTestCertificate(clientCert),
+		TLSKey:           encodeTestKey(t, clientKey),
+		CACert:           encodeTestCertificate(caCert),
+	})
+	assert.NoError(err)
+
+	assert.NoError(i.HandleStatusEvent(context.Background(), nil, nil, pb.StatusEvent{
+		DevEui: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}))
+
+	req := <-requests
+	assert.Equal(1, req.peerCerts)
+	assert.NoError(ValidateEventSignature("secret", req.signature, req.body, DefaultSignatureTolerance, time.Now()))
+
+	t.Run("Without client certificate", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(marshaler.JSONV3, Config{
+			EventEndpointURL: server.URL,
+			CACert:           encodeTestCertificate(caCert),
+		})
+		assert.NoError(err)
+
+		assert.Error(i.HandleStatusEvent(context.Background(), nil, nil, pb.StatusEvent{
+			DevEui: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		}))
+	})
+}
+
+func newTestCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		parent = &tmpl
+		parentKey = key
+	}
+
+	b, err := x509.CreateCertificate(rand.Reader, &tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(b)
+	require.NoError(t, err)
+
+	return cert, key
+}
+
+func encodeTestCertificate(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+func encodeTestKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	b, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}))
+}
@@ -124,7 +124,7 @@ func New(conf Config) (*Integration, error) {
 	}, nil
 }
 
-func (i *Integration) send(measurements []measurement) error {
+func (i *Integration) send(ctx context.Context, measurements []measurement) error {
 	var measStr []string
 	for _, m := range measurements {
 		measStr = append(measStr, m.String())
@@ -168,6 +168,7 @@ func (i *Integration) send(measurements []measurement) error {
 		return errors.Wrap(err, "http request error")
 	}
 	defer resp.Body.Close()
+	models.RecordHTTPStatus(ctx, resp.StatusCode)
 
 	// check that response is in 200 range
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -246,7 +247,7 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integratio
 		return nil
 	}
 
-	if err := i.send(measurements); err != nil {
+	if err := i.send(ctx, measurements); err != nil {
 		return errors.Wrap(err, "sending measurements error")
 	}
 
@@ -292,7 +293,7 @@ func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integratio
 		},
 	})
 
-	if err := i.send(measurements); err != nil {
+	if err := i.send(ctx, measurements); err != nil {
 		return errors.Wrap(err, "sending measurements error")
 	}
 
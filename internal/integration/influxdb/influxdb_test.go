@@ -370,7 +370,7 @@ func (ts *IntegrationV2TestSuite) TearDownSuite() {
 // TestSend tests the send method with the InfluxDB v2 parameters.
 func (ts *IntegrationV2TestSuite) TestSend() {
 	assert := require.New(ts.T())
-	assert.NoError(ts.Handler.send([]measurement{
+	assert.NoError(ts.Handler.send(context.Background(), []measurement{
 		{
 			Name:   "test_measurement",
 			Tags:   map[string]string{"foo": "bar"},
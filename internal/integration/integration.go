@@ -13,7 +13,10 @@ import (
 	"github.com/brocaar/chirpstack-application-server/internal/integration/amqp"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/awssns"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/azureservicebus"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/elasticsearch"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/gcppubsub"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/health"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/homeassistant"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/http"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/influxdb"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/kafka"
@@ -24,8 +27,13 @@ import (
 	"github.com/brocaar/chirpstack-application-server/internal/integration/mqtt"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/multi"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/mydevices"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/nats"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/outbox"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/pilotthings"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/postgresql"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/redisstreams"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/s3archive"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/sparkplug"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/thingsboard"
 	"github.com/brocaar/chirpstack-application-server/internal/storage"
 )
@@ -41,14 +49,37 @@ const (
 	AWSSNS          = "AWS_SNS"
 	AzureServiceBus = "AZURE_SERVICE_BUS"
 	PilotThings     = "PILOT_THINGS"
+	Kafka           = "KAFKA"
+	AMQP            = "AMQP"
+	NATS            = "NATS"
+	RedisStreams    = "REDIS_STREAMS"
+	Elasticsearch   = "ELASTICSEARCH"
+	PostgreSQL      = "POSTGRESQL"
 )
 
 var (
 	mockIntegration    models.Integration
 	marshalType        marshaler.Type
-	globalIntegrations []models.IntegrationHandler
+	globalIntegrations []handler
+	outboxEnabled      bool
 )
 
+// handler holds an integration handler together with the name by which it
+// is identified (e.g. by the outbox).
+type handler struct {
+	name    string
+	handler models.IntegrationHandler
+}
+
+// handlers returns the integration handlers of the given slice.
+func handlers(hh []handler) []models.IntegrationHandler {
+	out := make([]models.IntegrationHandler, 0, len(hh))
+	for _, h := range hh {
+		out = append(out, h.handler)
+	}
+	return out
+}
+
 // Setup configures the integration package.
 func Setup(conf config.Config) error {
 	log.Info("integration: configuring global integrations")
@@ -57,7 +88,11 @@ func Setup(conf config.Config) error {
 		return err
 	}
 
-	var ints []models.IntegrationHandler
+	if err := multi.Setup(conf); err != nil {
+		return errors.Wrap(err, "setup multi integration error")
+	}
+
+	var ints []handler
 
 	// setup marshaler
 	switch conf.ApplicationServer.Integration.Marshaler {
@@ -74,7 +109,7 @@ func Setup(conf config.Config) error {
 	if err != nil {
 		return errors.Wrap(err, "new logger integration error")
 	}
-	ints = append(ints, i)
+	ints = append(ints, handler{name: "logger", handler: i})
 
 	// setup global integrations, to be used by all applications
 	for _, name := range conf.ApplicationServer.Integration.Enabled {
@@ -96,6 +131,16 @@ func Setup(conf config.Config) error {
 			i, err = postgresql.New(marshalType, conf.ApplicationServer.Integration.PostgreSQL)
 		case "amqp":
 			i, err = amqp.New(marshalType, conf.ApplicationServer.Integration.AMQP)
+		case "nats":
+			i, err = nats.New(marshalType, conf.ApplicationServer.Integration.NATS)
+		case "redis_streams":
+			i, err = redisstreams.New(marshalType, conf.ApplicationServer.Integration.RedisStreams)
+		case "sparkplug_b":
+			i, err = sparkplug.New(conf.ApplicationServer.Integration.SparkplugB)
+		case "home_assistant":
+			i, err = homeassistant.New(conf.ApplicationServer.Integration.MQTT, conf.ApplicationServer.Integration.HomeAssistant)
+		case "s3_archive":
+			i, err = s3archive.New(conf.ApplicationServer.Integration.S3Archive)
 		default:
 			return fmt.Errorf("unknonwn integration type: %s", name)
 		}
@@ -104,10 +149,29 @@ func Setup(conf config.Config) error {
 			return errors.Wrap(err, "new integration error")
 		}
 
-		ints = append(ints, i)
+		ints = append(ints, handler{name: name, handler: health.Track(name, name, i)})
 	}
 	globalIntegrations = ints
 
+	// handle application integration cache invalidations published by
+	// (other) application-server instances
+	go handleCacheInvalidations(context.Background())
+
+	// consume the downlink commands of the application integrations
+	go startCommandConsumers(context.Background())
+
+	// setup the outbox
+	outboxEnabled = conf.ApplicationServer.Integration.Outbox.Enabled
+	if outboxEnabled {
+		if err := outbox.Setup(conf); err != nil {
+			return errors.Wrap(err, "setup outbox error")
+		}
+
+		if err := outbox.Start(context.Background(), dispatcher{}); err != nil {
+			return errors.Wrap(err, "start outbox error")
+		}
+	}
+
 	return nil
 }
 
@@ -117,159 +181,201 @@ func Setup(conf config.Config) error {
 // application ID.
 // When the given application ID equals 0, only the global integrations are
 // returned.
+// The application integrations are cached until they are invalidated using
+// InvalidateApplicationIntegrations.
+// When the outbox is enabled, the returned integration writes the events to
+// the outbox, from which they are delivered to the integrations.
 func ForApplicationID(id int64) models.Integration {
 	// for testing, return mock integration
 	if mockIntegration != nil {
 		return mockIntegration
 	}
 
-	var appints []storage.Integration
-	var err error
-
-	// retrieve application integrations when ID != 0
-	if id != 0 {
-		appints, err = storage.GetIntegrationsForApplicationID(context.TODO(), storage.DB(), id)
-		if err != nil {
-			log.WithError(err).WithFields(log.Fields{
-				"application_id": id,
-			}).Error("integrations: get application integrations error")
-		}
+	global := multi.New(handlers(globalIntegrations), nil)
+	if outboxEnabled {
+		return outbox.New(id, global.DataDownChan())
 	}
 
-	// parse integration configs and setup integrations
-	var ints []models.IntegrationHandler
-	for _, appint := range appints {
-		var i models.IntegrationHandler
-		var err error
-
-		switch appint.Kind {
-		case HTTP:
-			// read config
-			var conf http.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read http configuration error")
-				continue
-			}
-
-			// create new http integration
-			i, err = http.New(marshalType, conf)
-		case InfluxDB:
-			// read config
-			var conf influxdb.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read influxdb configuration error")
-				continue
-			}
-
-			// create new influxdb integration
-			i, err = influxdb.New(conf)
-		case ThingsBoard:
-			// read config
-			var conf thingsboard.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read thingsboard configuration error")
-				continue
-			}
-
-			// create new thingsboard integration
-			i, err = thingsboard.New(conf)
-		case MyDevices:
-			// read config
-			var conf mydevices.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read mydevices configuration error")
-				continue
-			}
+	// only the global integrations are returned when ID == 0
+	if id == 0 {
+		return global
+	}
 
-			// create new mydevices integration
-			i, err = mydevices.New(conf)
-		case LoRaCloud:
-			// read config
-			var conf loracloud.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read loracloud configuration error")
-				continue
-			}
+	return multi.New(handlers(globalIntegrations), handlers(getApplicationIntegrations(id)))
+}
 
-			// create new loracloud integration
-			i, err = loracloud.New(conf)
-		case GCPPubSub:
-			// read config
-			var conf config.IntegrationGCPConfig
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read gcp pubsub configuration error")
-				continue
-			}
+// newApplicationIntegrations creates the integration handlers configured for
+// the given application ID. When one or more integrations could not be
+// setup, the other integrations are returned together with an error.
+func newApplicationIntegrations(id int64) ([]handler, error) {
+	appints, err := storage.GetIntegrationsForApplicationID(context.TODO(), storage.DB(), id)
+	if err != nil {
+		return nil, errors.Wrap(err, "get application integrations error")
+	}
 
-			// create new gcp pubsub integration
-			i, err = gcppubsub.New(marshalType, conf)
-		case AWSSNS:
-			// read config
-			var conf config.IntegrationAWSSNSConfig
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read aws sns configuration error")
-				continue
-			}
+	// parse integration configs and setup integrations
+	var ints []handler
+	var setupErr error
+	for _, appint := range appints {
+		if !appint.Enabled {
+			continue
+		}
 
-			// create new aws sns integration
-			i, err = awssns.New(marshalType, conf)
-		case AzureServiceBus:
-			// read config
-			var conf config.IntegrationAzureConfig
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&conf); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"application_id": id,
-				}).Error("integrtations: read azure service-bus configuration error")
-				continue
-			}
+		i, err := newIntegration(id, appint.Kind, appint.Settings)
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": id,
+				"kind":           appint.Kind,
+			}).Error("integrations: new integration error")
+			setupErr = errors.Wrapf(err, "new %s integration error", appint.Kind)
+			continue
+		}
 
-			// create new aws sns integration
-			i, err = azureservicebus.New(marshalType, conf)
-		case PilotThings:
-			var config pilotthings.Config
-			if err := json.NewDecoder(bytes.NewReader(appint.Settings)).Decode(&config); err != nil {
+		// apply the event filters configured for the integration
+		var filter multi.Filter
+		if len(appint.Filters) != 0 {
+			if err := json.Unmarshal(appint.Filters, &filter); err != nil {
 				log.WithError(err).WithFields(log.Fields{
 					"application_id": id,
-				}).Error("integrtations: read pilot things configuration error")
+					"kind":           appint.Kind,
+				}).Error("integrations: read integration filters error")
+				i.Close()
 				continue
 			}
-
-			// create new pilot things integration
-			i, err = pilotthings.New(config)
-		default:
-			log.WithFields(log.Fields{
-				"application_id": id,
-				"kind":           appint.Kind,
-			}).Error("integrations: unknown integration type")
-			continue
 		}
 
+		name := ApplicationIntegrationName(appint)
+		fi, err := multi.WithFilter(health.Track(appint.Kind, name, i), filter)
 		if err != nil {
 			log.WithError(err).WithFields(log.Fields{
 				"application_id": id,
 				"kind":           appint.Kind,
-			}).Error("integrations: new integration error")
+			}).Error("integrations: integration filters error")
+			i.Close()
 			continue
 		}
 
-		ints = append(ints, i)
+		ints = append(ints, handler{
+			name:    name,
+			handler: fi,
+		})
+	}
+
+	return ints, setupErr
+}
+
+// newIntegration creates the integration handler of the given kind for the
+// given application ID, using the given (JSON encoded) settings. The returned
+// handler does not consume downlink commands, these are consumed by the
+// command consumers (see startCommandConsumers).
+func newIntegration(applicationID int64, kind string, settings json.RawMessage) (models.IntegrationHandler, error) {
+	decode := func(conf interface{}) error {
+		if err := json.NewDecoder(bytes.NewReader(settings)).Decode(conf); err != nil {
+			return errors.Wrap(err, "read configuration error")
+		}
+		return nil
 	}
 
-	return multi.New(globalIntegrations, ints)
+	switch kind {
+	case HTTP:
+		var conf http.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return http.New(marshalType, conf)
+	case InfluxDB:
+		var conf influxdb.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return influxdb.New(conf)
+	case ThingsBoard:
+		var conf thingsboard.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return thingsboard.New(conf)
+	case MyDevices:
+		var conf mydevices.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return mydevices.New(conf)
+	case LoRaCloud:
+		var conf loracloud.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return loracloud.New(conf)
+	case GCPPubSub:
+		var conf config.IntegrationGCPConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return gcppubsub.New(marshalType, conf)
+	case AWSSNS:
+		var conf config.IntegrationAWSSNSConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return awssns.New(marshalType, conf)
+	case AzureServiceBus:
+		var conf config.IntegrationAzureConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return azureservicebus.New(marshalType, conf)
+	case PilotThings:
+		var conf pilotthings.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return pilotthings.New(conf)
+	case Kafka:
+		var conf config.IntegrationKafkaConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return kafka.NewForApplication(marshalType, conf, applicationID)
+	case AMQP:
+		var conf config.IntegrationAMQPConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return amqp.NewForApplication(marshalType, conf, applicationID)
+	case NATS:
+		var conf config.IntegrationNATSConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return nats.NewForApplication(marshalType, conf, applicationID)
+	case RedisStreams:
+		var conf config.IntegrationRedisStreamsConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return redisstreams.NewForApplication(marshalType, conf)
+	case Elasticsearch:
+		var conf elasticsearch.Config
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return elasticsearch.New(conf)
+	case PostgreSQL:
+		var conf config.IntegrationPostgreSQLConfig
+		if err := decode(&conf); err != nil {
+			return nil, err
+		}
+		return postgresql.NewForApplication(marshalType, conf)
+	default:
+		return nil, fmt.Errorf("unknown integration kind: %s", kind)
+	}
+}
+
+// ApplicationIntegrationName returns the name by which the given application
+// integration is identified (e.g. by the outbox and health status).
+func ApplicationIntegrationName(i storage.Integration) string {
+	return fmt.Sprintf("%s/%d", i.Kind, i.ID)
 }
 
 // SetMockIntegration mocks the integration.
@@ -8,8 +8,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 
@@ -38,9 +40,10 @@ func (h *testHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 type IntegrationTestSuite struct {
 	suite.Suite
 
-	httpServer   *httptest.Server
-	httpRequests chan *http.Request
-	integration  models.Integration
+	httpServer    *httptest.Server
+	httpRequests  chan *http.Request
+	integration   models.Integration
+	applicationID int64
 }
 
 func (ts *IntegrationTestSuite) SetupSuite() {
@@ -106,9 +109,11 @@ func (ts *IntegrationTestSuite) SetupSuite() {
 	assert.NoError(storage.CreateIntegration(context.Background(), storage.DB(), &storage.Integration{
 		ApplicationID: app.ID,
 		Kind:          HTTP,
+		Enabled:       true,
 		Settings:      configJSON,
 	}))
 
+	ts.applicationID = app.ID
 	ts.integration = ForApplicationID(app.ID)
 }
 
@@ -130,6 +135,135 @@ func (ts *IntegrationTestSuite) TestHandleUplinkEvent() {
 	assert.Equal("/rx", req.URL.Path)
 }
 
+func (ts *IntegrationTestSuite) TestApplicationIntegrationsCache() {
+	assert := require.New(ts.T())
+
+	ForApplicationID(ts.applicationID)
+	cached, ok := cachedApplicationIntegrations(ts.applicationID)
+	assert.True(ok)
+	assert.Len(cached, 1)
+
+	ts.T().Run("Cache hit", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(cached, getApplicationIntegrations(ts.applicationID))
+	})
+
+	ts.T().Run("Invalidate", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(InvalidateApplicationIntegrations(context.Background(), ts.applicationID))
+
+		_, ok := cachedApplicationIntegrations(ts.applicationID)
+		assert.False(ok)
+		assert.Len(getApplicationIntegrations(ts.applicationID), 1)
+	})
+}
+
+// closeIntegration implements a no-op Close.
+type closeIntegration struct {
+	models.IntegrationHandler
+}
+
+func (closeIntegration) Close() error {
+	return nil
+}
+
+func TestStoreApplicationIntegrations(t *testing.T) {
+	id := int64(-1)
+	defer invalidateApplicationIntegrations(id)
+
+	t.Run("Invalidated while loading", func(t *testing.T) {
+		assert := require.New(t)
+
+		// an invalidation while loading the integrations must prevent
+		// caching the (stale) integrations
+		gen := beginLoad(id)
+		invalidateApplicationIntegrations(id)
+
+		stale := []handler{{name: "stale", handler: closeIntegration{}}}
+		assert.Equal(stale, storeApplicationIntegrations(id, gen, stale, nil))
+		_, ok := cachedApplicationIntegrations(id)
+		assert.False(ok)
+
+		// the load is no longer tracked
+		appIntegrationsMux.RLock()
+		assert.Len(appLoads, 0)
+		appIntegrationsMux.RUnlock()
+	})
+
+	t.Run("Loaded", func(t *testing.T) {
+		assert := require.New(t)
+
+		ints := []handler{{name: "fresh", handler: closeIntegration{}}}
+		assert.Equal(ints, storeApplicationIntegrations(id, beginLoad(id), ints, nil))
+		cached, ok := cachedApplicationIntegrations(id)
+		assert.True(ok)
+		assert.Equal(ints, cached)
+
+		appIntegrationsMux.RLock()
+		assert.WithinDuration(time.Now().Add(cacheTTL), appIntegrations[id].expires, time.Second)
+		appIntegrationsMux.RUnlock()
+	})
+
+	t.Run("Setup failed", func(t *testing.T) {
+		assert := require.New(t)
+		invalidateApplicationIntegrations(id)
+
+		// the partial set is cached until the retry interval
+		ints := []handler{{name: "partial", handler: closeIntegration{}}}
+		for n := 1; n <= 2; n++ {
+			expireApplicationIntegrations(id)
+			assert.Equal(ints, storeApplicationIntegrations(id, beginLoad(id), ints, errors.New("dial error")))
+
+			appIntegrationsMux.RLock()
+			entry := appIntegrations[id]
+			appIntegrationsMux.RUnlock()
+			assert.Equal(n, entry.failures)
+			assert.WithinDuration(time.Now().Add(cacheRetryBackoff(n)), entry.expires, time.Second)
+		}
+
+		// the previous integrations are kept when loading fails completely
+		expireApplicationIntegrations(id)
+		assert.Equal(ints, storeApplicationIntegrations(id, beginLoad(id), nil, errors.New("db error")))
+
+		// a successful load resets the failures
+		expireApplicationIntegrations(id)
+		fresh := []handler{{name: "fresh", handler: closeIntegration{}}}
+		assert.Equal(fresh, storeApplicationIntegrations(id, beginLoad(id), fresh, nil))
+		appIntegrationsMux.RLock()
+		assert.Equal(0, appIntegrations[id].failures)
+		appIntegrationsMux.RUnlock()
+	})
+}
+
+func TestCacheRetryBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(cacheRetryInterval, cacheRetryBackoff(1))
+	assert.Equal(2*cacheRetryInterval, cacheRetryBackoff(2))
+	assert.Equal(4*cacheRetryInterval, cacheRetryBackoff(3))
+	assert.Equal(cacheTTL, cacheRetryBackoff(100))
+}
+
+// cachedApplicationIntegrations returns the cached integrations for the given
+// application ID.
+func cachedApplicationIntegrations(id int64) ([]handler, bool) {
+	appIntegrationsMux.RLock()
+	defer appIntegrationsMux.RUnlock()
+	entry, ok := appIntegrations[id]
+	return entry.handlers, ok
+}
+
+// expireApplicationIntegrations expires the cached integrations for the
+// given application ID.
+func expireApplicationIntegrations(id int64) {
+	appIntegrationsMux.Lock()
+	defer appIntegrationsMux.Unlock()
+	if entry, ok := appIntegrations[id]; ok {
+		entry.expires = time.Now()
+		appIntegrations[id] = entry
+	}
+}
+
 func TestIntegration(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }
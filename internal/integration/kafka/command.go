@@ -0,0 +1,285 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/segmentio/kafka-go"
+
+	extpb "github.com/brocaar/chirpstack-api/go/v3/as/external/api"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+// Downlink command message headers.
+const (
+	applicationIDHeader = "application_id"
+	devEUIHeader        = "dev_eui"
+	correlationIDHeader = "correlation_id"
+	errorHeader         = "error"
+)
+
+// downlinkChan contains the downlink commands consumed by all the Kafka
+// integrations. As the consumer must wait for the enqueue result, this is
+// not exposed through DataDownChan (which only returns the channel of the
+// first global integration).
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlink commands consumed
+// from the Kafka command topic.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+// DownlinkResult defines the (JSON) reply published to the reply topic.
+type DownlinkResult struct {
+	ApplicationID int64         `json:"applicationID,string"`
+	DevEUI        lorawan.EUI64 `json:"devEUI"`
+	FCnt          uint32        `json:"fCnt"`
+	Error         string        `json:"error,omitempty"`
+}
+
+func (i *Integration) startCommandConsumer(dialer *kafka.Dialer) {
+	log.WithFields(log.Fields{
+		"topic":    i.config.CommandTopic,
+		"group_id": i.config.CommandGroupID,
+	}).Info("integration/kafka: starting downlink command consumer")
+
+	i.commandReader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers: i.config.Brokers,
+		GroupID: i.config.CommandGroupID,
+		Topic:   i.config.CommandTopic,
+		Dialer:  dialer,
+	})
+
+	if i.config.ReplyTopic != "" {
+		i.replyWriter = kafka.NewWriter(kafka.WriterConfig{
+			Brokers:  i.config.Brokers,
+			Topic:    i.config.ReplyTopic,
+			Balancer: &kafka.LeastBytes{},
+			Dialer:   dialer,
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+
+	i.wg.Add(1)
+	go i.consumeCommands(ctx)
+}
+
+func (i *Integration) stopCommandConsumer() error {
+	i.cancel()
+	i.wg.Wait()
+
+	if err := i.commandReader.Close(); err != nil {
+		return errors.Wrap(err, "close command reader error")
+	}
+	i.commandReader = nil
+
+	if i.replyWriter != nil {
+		if err := i.replyWriter.Close(); err != nil {
+			return errors.Wrap(err, "close reply writer error")
+		}
+		i.replyWriter = nil
+	}
+
+	return nil
+}
+
+func (i *Integration) consumeCommands(ctx context.Context) {
+	defer i.wg.Done()
+
+	for {
+		msg, err := i.commandReader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.WithError(err).Error("integration/kafka: fetch command message error")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		i.handleCommand(ctx, msg)
+
+		if err := i.commandReader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.WithError(err).Error("integration/kafka: commit command message error")
+		}
+	}
+}
+
+func (i *Integration) handleCommand(ctx context.Context, msg kafka.Message) {
+	logFields := log.Fields{
+		"topic":     msg.Topic,
+		"partition": msg.Partition,
+		"offset":    msg.Offset,
+	}
+
+	var res models.DataDownResult
+
+	pl, err := decodeCommand(i.marshaler, i.applicationID, msg)
+	if err != nil {
+		log.WithFields(logFields).WithError(err).Error("integration/kafka: decode downlink command error")
+		res.Error = err
+	} else {
+		log.WithFields(logFields).WithFields(log.Fields{
+			"application_id": pl.ApplicationID,
+			"dev_eui":        pl.DevEUI,
+		}).Info("integration/kafka: downlink command received")
+
+		pl.ResultChan = make(chan models.DataDownResult, 1)
+
+		select {
+		case downlinkChan <- pl:
+		case <-ctx.Done():
+			return
+		}
+
+		res = <-pl.ResultChan
+	}
+
+	if err := i.reply(ctx, msg, pl, res); err != nil {
+		log.WithFields(logFields).WithError(err).Error("integration/kafka: publish downlink command reply error")
+	}
+}
+
+// reply publishes the enqueue result of the given command to the reply topic.
+func (i *Integration) reply(ctx context.Context, cmd kafka.Message, pl models.DataDownPayload, res models.DataDownResult) error {
+	if i.replyWriter == nil {
+		return nil
+	}
+
+	msg := kafka.Message{
+		Key: cmd.Key,
+		Headers: []kafka.Header{
+			{Key: applicationIDHeader, Value: []byte(strconv.FormatInt(pl.ApplicationID, 10))},
+			{Key: devEUIHeader, Value: []byte(pl.DevEUI.String())},
+		},
+	}
+
+	for _, h := range cmd.Headers {
+		if h.Key == correlationIDHeader {
+			msg.Headers = append(msg.Headers, h)
+		}
+	}
+
+	if res.Error != nil {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: errorHeader, Value: []byte(res.Error.Error())})
+	}
+
+	var err error
+	switch i.marshaler {
+	case marshaler.Protobuf:
+		msg.Value, err = proto.Marshal(&extpb.EnqueueDeviceQueueItemResponse{
+			FCnt: res.FCnt,
+		})
+	default:
+		result := DownlinkResult{
+			ApplicationID: pl.ApplicationID,
+			DevEUI:        pl.DevEUI,
+			FCnt:          res.FCnt,
+		}
+		if res.Error != nil {
+			result.Error = res.Error.Error()
+		}
+		msg.Value, err = json.Marshal(result)
+	}
+	if err != nil {
+		return errors.Wrap(err, "marshal reply error")
+	}
+
+	if err := i.replyWriter.WriteMessages(ctx, msg); err != nil {
+		return errors.Wrap(err, "writing message to kafka")
+	}
+
+	return nil
+}
+
+// decodeCommand decodes the given downlink command message. When the
+// protobuf marshaler is used, a DeviceQueueItem message is expected, else
+// the JSON payload as used by the MQTT integration. The application ID and
+// DevEUI headers, when set, take precedence over the payload.
+// When applicationID is not 0, the command must be for the given application
+// (it defaults to the given application when omitted).
+func decodeCommand(t marshaler.Type, applicationID int64, msg kafka.Message) (models.DataDownPayload, error) {
+	var pl models.DataDownPayload
+
+	switch t {
+	case marshaler.Protobuf:
+		var item extpb.DeviceQueueItem
+		if err := proto.Unmarshal(msg.Value, &item); err != nil {
+			return pl, errors.Wrap(err, "unmarshal protobuf error")
+		}
+
+		if item.DevEui != "" {
+			if err := pl.DevEUI.UnmarshalText([]byte(item.DevEui)); err != nil {
+				return pl, errors.Wrap(err, "parse dev_eui error")
+			}
+		}
+
+		pl.Confirmed = item.Confirmed
+		pl.FPort = uint8(item.FPort)
+		pl.Data = item.Data
+		if item.JsonObject != "" {
+			pl.Object = json.RawMessage(item.JsonObject)
+		}
+	default:
+		if err := json.Unmarshal(msg.Value, &pl); err != nil {
+			return pl, errors.Wrap(err, "unmarshal json error")
+		}
+	}
+
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case applicationIDHeader:
+			id, err := strconv.ParseInt(string(h.Value), 10, 64)
+			if err != nil {
+				return pl, errors.Wrap(err, "parse application_id header error")
+			}
+			pl.ApplicationID = id
+		case devEUIHeader:
+			if err := pl.DevEUI.UnmarshalText(h.Value); err != nil {
+				return pl, errors.Wrap(err, "parse dev_eui header error")
+			}
+		}
+	}
+
+	if applicationID != 0 {
+		if pl.ApplicationID == 0 {
+			pl.ApplicationID = applicationID
+		}
+		if pl.ApplicationID != applicationID {
+			return pl, errors.New("application id does not match the application of the integration")
+		}
+	}
+
+	if pl.ApplicationID == 0 {
+		return pl, errors.New("application id is missing")
+	}
+
+	if pl.DevEUI == (lorawan.EUI64{}) {
+		return pl, errors.New("dev_eui is missing")
+	}
+
+	if pl.FPort == 0 || pl.FPort > 224 {
+		return pl, errors.New("fPort must be between 1 - 224")
+	}
+
+	return pl, nil
+}
@@ -0,0 +1,185 @@
+package kafka
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/require"
+
+	extpb "github.com/brocaar/chirpstack-api/go/v3/as/external/api"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+func TestDecodeCommand(t *testing.T) {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	pbItem, err := proto.Marshal(&extpb.DeviceQueueItem{
+		DevEui:     devEUI.String(),
+		Confirmed:  true,
+		FPort:      10,
+		Data:       []byte{1, 2, 3},
+		JsonObject: `{"foo":"bar"}`,
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name            string
+		Marshaler       marshaler.Type
+		ApplicationID   int64
+		Message         kafka.Message
+		ExpectedPayload models.DataDownPayload
+		ExpectedError   error
+	}{
+		{
+			Name:      "json payload",
+			Marshaler: marshaler.JSONV3,
+			Message: kafka.Message{
+				Value: []byte(`{"applicationID":"1","devEUI":"0102030405060708","confirmed":true,"fPort":10,"data":"AQID"}`),
+			},
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 1,
+				DevEUI:        devEUI,
+				Confirmed:     true,
+				FPort:         10,
+				Data:          []byte{1, 2, 3},
+			},
+		},
+		{
+			Name:      "json payload with headers",
+			Marshaler: marshaler.ProtobufJSON,
+			Message: kafka.Message{
+				Value: []byte(`{"applicationID":"1","fPort":10,"object":{"foo":"bar"}}`),
+				Headers: []kafka.Header{
+					{Key: "application_id", Value: []byte("2")},
+					{Key: "dev_eui", Value: []byte("0102030405060708")},
+				},
+			},
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 2,
+				DevEUI:        devEUI,
+				FPort:         10,
+				Object:        json.RawMessage(`{"foo":"bar"}`),
+			},
+		},
+		{
+			Name:      "protobuf payload",
+			Marshaler: marshaler.Protobuf,
+			Message: kafka.Message{
+				Value: pbItem,
+				Headers: []kafka.Header{
+					{Key: "application_id", Value: []byte("1")},
+				},
+			},
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 1,
+				DevEUI:        devEUI,
+				Confirmed:     true,
+				FPort:         10,
+				Data:          []byte{1, 2, 3},
+				Object:        json.RawMessage(`{"foo":"bar"}`),
+			},
+		},
+		{
+			Name:      "application id missing",
+			Marshaler: marshaler.Protobuf,
+			Message: kafka.Message{
+				Value: pbItem,
+			},
+			ExpectedError: errors.New("application id is missing"),
+		},
+		{
+			Name:          "application bound, application id omitted",
+			Marshaler:     marshaler.Protobuf,
+			ApplicationID: 1,
+			Message: kafka.Message{
+				Value: pbItem,
+			},
+			ExpectedPayload: models.DataDownPayload{
+				ApplicationID: 1,
+				DevEUI:        devEUI,
+				Confirmed:     true,
+				FPort:         10,
+				Data:          []byte{1, 2, 3},
+				Object:        json.RawMessage(`{"foo":"bar"}`),
+			},
+		},
+		{
+			Name:          "application bound, application id header mismatch",
+			Marshaler:     marshaler.Protobuf,
+			ApplicationID: 1,
+			Message: kafka.Message{
+				Value: pbItem,
+				Headers: []kafka.Header{
+					{Key: "application_id", Value: []byte("2")},
+				},
+			},
+			ExpectedError: errors.New("application id does not match the application of the integration"),
+		},
+		{
+			Name:          "application bound, application id payload mismatch",
+			Marshaler:     marshaler.JSONV3,
+			ApplicationID: 1,
+			Message: kafka.Message{
+				Value: []byte(`{"applicationID":"2","devEUI":"0102030405060708","fPort":10,"data":"AQID"}`),
+			},
+			ExpectedError: errors.New("application id does not match the application of the integration"),
+		},
+		{
+			Name:      "invalid fPort",
+			Marshaler: marshaler.JSONV3,
+			Message: kafka.Message{
+				Value: []byte(`{"applicationID":"1","devEUI":"0102030405060708","fPort":0}`),
+			},
+			ExpectedError: errors.New("fPort must be between 1 - 224"),
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			pl, err := decodeCommand(tst.Marshaler, tst.ApplicationID, tst.Message)
+			if tst.ExpectedError != nil {
+				assert.EqualError(err, tst.ExpectedError.Error())
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedPayload, pl)
+		})
+	}
+}
+
+func TestNewForApplication(t *testing.T) {
+	conf := config.IntegrationKafkaConfig{
+		Brokers:          []string{"localhost:1"},
+		Topic:            "chirpstack_as",
+		EventKeyTemplate: "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}",
+		CommandTopic:     "chirpstack_as_commands",
+		CommandGroupID:   "chirpstack_as",
+	}
+
+	t.Run("Publisher only", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := NewForApplication(marshaler.JSONV3, conf, 10)
+		assert.NoError(err)
+		assert.Nil(i.commandReader)
+		assert.NoError(i.Close())
+	})
+
+	t.Run("Command consumer", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := NewCommandConsumer(marshaler.JSONV3, conf, 10)
+		assert.NoError(err)
+		assert.NotNil(i.commandReader)
+		assert.NoError(i.Close())
+	})
+}
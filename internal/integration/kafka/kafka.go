@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"sync"
 	"text/template"
 	"time"
 
@@ -27,13 +28,44 @@ import (
 // Integration implements an Kafka integration.
 type Integration struct {
 	marshaler        marshaler.Type
+	payloadTemplate  *marshaler.Template
 	writer           *kafka.Writer
 	eventKeyTemplate *template.Template
 	config           config.IntegrationKafkaConfig
+
+	// Downlink commands. When applicationID is set, only the commands of
+	// this application are accepted.
+	applicationID int64
+	commandReader *kafka.Reader
+	replyWriter   *kafka.Writer
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
 }
 
-// New creates a new Kafka integration.
+// New creates a new (global) Kafka integration. When a command topic is
+// configured, the downlink commands of all applications are consumed.
 func New(m marshaler.Type, conf config.IntegrationKafkaConfig) (*Integration, error) {
+	return newIntegration(m, conf, 0, conf.CommandTopic != "")
+}
+
+// NewForApplication creates a new Kafka integration for the given application.
+// It only publishes the events, the downlink commands are consumed by the
+// integration returned by NewCommandConsumer.
+func NewForApplication(m marshaler.Type, conf config.IntegrationKafkaConfig, applicationID int64) (*Integration, error) {
+	return newIntegration(m, conf, applicationID, false)
+}
+
+// NewCommandConsumer creates a new Kafka integration, consuming the downlink
+// commands of the given application from the command topic. Commands for
+// other applications are rejected.
+func NewCommandConsumer(m marshaler.Type, conf config.IntegrationKafkaConfig, applicationID int64) (*Integration, error) {
+	if conf.CommandTopic == "" || conf.CommandGroupID == "" {
+		return nil, errors.New("command topic and group id must be set")
+	}
+	return newIntegration(m, conf, applicationID, true)
+}
+
+func newIntegration(m marshaler.Type, conf config.IntegrationKafkaConfig, applicationID int64, commands bool) (*Integration, error) {
 	wc := kafka.WriterConfig{
 		Brokers:  conf.Brokers,
 		Topic:    conf.Topic,
@@ -102,7 +134,21 @@ func New(m marshaler.Type, conf config.IntegrationKafkaConfig) (*Integration, er
 		writer:           w,
 		eventKeyTemplate: kt,
 		config:           conf,
+		applicationID:    applicationID,
+	}
+
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
 	}
+
+	if commands {
+		i.startCommandConsumer(wc.Dialer)
+	}
+
 	return &i, nil
 }
 
@@ -154,7 +200,7 @@ func (i *Integration) publish(ctx context.Context, applicationID uint64, devEUIB
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], devEUIB)
 
-	b, err := marshaler.Marshal(i.marshaler, msg)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return err
 	}
@@ -199,11 +245,19 @@ func (i *Integration) DataDownChan() chan models.DataDownPayload {
 	return nil
 }
 
-// Close shuts down the integration, closing the Kafka writer.
+// Close shuts down the integration, closing the Kafka writer and the
+// downlink command consumer.
 func (i *Integration) Close() error {
 	if i.writer == nil {
 		return fmt.Errorf("integration already closed")
 	}
+
+	if i.commandReader != nil {
+		if err := i.stopCommandConsumer(); err != nil {
+			return err
+		}
+	}
+
 	err := i.writer.Close()
 	i.writer = nil
 	return err
@@ -0,0 +1,544 @@
+package marshaler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
+)
+
+const (
+	// maxTemplateOutputSize defines the max. size of a rendered payload
+	// template.
+	maxTemplateOutputSize = 1 << 20
+
+	// maxTemplateRangeSteps defines the max. number of range iterations
+	// (including the iterations of nested ranges) of a single execution.
+	maxTemplateRangeSteps = 10000
+
+	// maxTemplateCalls defines the max. number of template calls (including
+	// the nested and recursive calls) of a single execution.
+	maxTemplateCalls = 1000
+
+	// maxTemplateExecutionTime defines the max. duration of a single
+	// execution.
+	maxTemplateExecutionTime = 100 * time.Millisecond
+)
+
+// rangeGuardFunc and callGuardFunc are the names of the functions which are
+// added to the pipeline of every range action and template call, see
+// guardActions.
+const (
+	rangeGuardFunc = "__range"
+	callGuardFunc  = "__call"
+)
+
+// Template implements a payload template, rendering the events using a Go
+// text/template.
+//
+// The template is executed with the following data:
+//
+//	.Type    the event type (up, join, ack, error, status, location, txack or integration)
+//	.Event   a copy of the event fields (e.g. of integration.UplinkEvent), see plainValue
+//	.Object  the decoded objectJSON of the event (if any)
+//
+// Only the text/template builtins and the functions returned by
+// templateFuncs are available to the template. An execution is limited in
+// output size, range iterations, template calls and time. Ranging over an
+// integer is not allowed.
+type Template struct {
+	tmpl *template.Template
+}
+
+// templateData contains the data passed to the payload template.
+type templateData struct {
+	Type   string
+	Event  interface{}
+	Object interface{}
+}
+
+// ParseTemplate parses the given payload template.
+func ParseTemplate(text string) (*Template, error) {
+	funcs := templateFuncs()
+	funcs[rangeGuardFunc] = func(v interface{}) interface{} { return v }
+	funcs[callGuardFunc] = func(v ...interface{}) interface{} { return nil }
+
+	tmpl, err := template.New("payload").Option("missingkey=zero").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse template error")
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			guardActions(t.Tree, t.Tree.Root)
+		}
+	}
+
+	return &Template{tmpl: tmpl}, nil
+}
+
+// ValidateTemplate validates the given payload template. Besides parsing the
+// template, it is executed against a sample of each event type. As a
+// template is often written for a single event type (e.g. uplinks only),
+// it must render at least one of these samples.
+func ValidateTemplate(text string) error {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, msg := range templateSamples() {
+		if _, err := t.Marshal(msg); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+
+	return lastErr
+}
+
+// Marshal renders the given event using the template.
+func (t *Template) Marshal(msg proto.Message) ([]byte, error) {
+	data := templateData{
+		Type:  templateEventType(msg),
+		Event: plainValue(reflect.ValueOf(msg)),
+	}
+
+	if v, ok := msg.(interface{ GetObjectJson() string }); ok && v.GetObjectJson() != "" {
+		if err := json.Unmarshal([]byte(v.GetObjectJson()), &data.Object); err != nil {
+			return nil, errors.Wrap(err, "unmarshal object json error")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxTemplateExecutionTime)
+	defer cancel()
+
+	b := templateBudget{ctx: ctx}
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, errors.Wrap(err, "clone template error")
+	}
+	tmpl.Funcs(template.FuncMap{
+		rangeGuardFunc: b.rangeValue,
+		callGuardFunc:  b.callValue,
+	})
+
+	w := limitedBuffer{ctx: ctx, max: maxTemplateOutputSize}
+	if err := tmpl.Execute(&w, data); err != nil {
+		return nil, errors.Wrap(err, "execute template error")
+	}
+
+	return w.Bytes(), nil
+}
+
+// limitedBuffer is a bytes.Buffer which returns an error once the max. size
+// has been exceeded or the context has been cancelled.
+type limitedBuffer struct {
+	bytes.Buffer
+	ctx context.Context
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.ctx.Err() != nil {
+		return 0, fmt.Errorf("template execution exceeds %s", maxTemplateExecutionTime)
+	}
+	if b.Len()+len(p) > b.max {
+		return 0, fmt.Errorf("template output exceeds %d bytes", b.max)
+	}
+	return b.Buffer.Write(p)
+}
+
+// templateBudget limits the range iterations, the template calls and the
+// duration of a single template execution.
+type templateBudget struct {
+	ctx   context.Context
+	steps int
+	calls int
+}
+
+// rangeValue is called with the value of every range action before it is
+// iterated. It returns an error when the value is not a slice, array or
+// map, or when the budget has been exceeded.
+func (b *templateBudget) rangeValue(v interface{}) (interface{}, error) {
+	if b.ctx.Err() != nil {
+		return nil, fmt.Errorf("template execution exceeds %s", maxTemplateExecutionTime)
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		b.steps += rv.Len()
+		if b.steps > maxTemplateRangeSteps {
+			return nil, fmt.Errorf("template exceeds %d range iterations", maxTemplateRangeSteps)
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("range over %s is not allowed", rv.Kind())
+	}
+}
+
+// callValue is called with the data of every template call. It returns an
+// error when the budget has been exceeded. As a template call without
+// pipeline passes nil, the given value is optional.
+func (b *templateBudget) callValue(v ...interface{}) (interface{}, error) {
+	if b.ctx.Err() != nil {
+		return nil, fmt.Errorf("template execution exceeds %s", maxTemplateExecutionTime)
+	}
+
+	b.calls++
+	if b.calls > maxTemplateCalls {
+		return nil, fmt.Errorf("template exceeds %d template calls", maxTemplateCalls)
+	}
+
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return v[0], nil
+}
+
+// guardActions adds a rangeGuardFunc call to the end of the pipeline of every
+// range action and a callGuardFunc call to the end of the pipeline of every
+// template call within the given node.
+func guardActions(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, nn := range n.Nodes {
+			guardActions(tree, nn)
+		}
+	case *parse.IfNode:
+		guardActions(tree, n.List)
+		guardActions(tree, n.ElseList)
+	case *parse.WithNode:
+		guardActions(tree, n.List)
+		guardActions(tree, n.ElseList)
+	case *parse.RangeNode:
+		n.Pipe.Cmds = append(n.Pipe.Cmds, guardCommand(tree, rangeGuardFunc, n.Pipe.Pos))
+		guardActions(tree, n.List)
+		guardActions(tree, n.ElseList)
+	case *parse.TemplateNode:
+		if n.Pipe == nil {
+			n.Pipe = &parse.PipeNode{
+				NodeType: parse.NodePipe,
+				Pos:      n.Pos,
+				Line:     n.Line,
+			}
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, guardCommand(tree, callGuardFunc, n.Pos))
+	}
+}
+
+// guardCommand returns the command calling the given guard function.
+func guardCommand(tree *parse.Tree, name string, pos parse.Pos) *parse.CommandNode {
+	return &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      pos,
+		Args:     []parse.Node{parse.NewIdentifier(name).SetTree(tree).SetPos(pos)},
+	}
+}
+
+var (
+	timestampType = reflect.TypeOf((*timestamp.Timestamp)(nil))
+	timeType      = reflect.TypeOf(time.Time{})
+	bytesType     = reflect.TypeOf([]byte(nil))
+	stringType    = reflect.TypeOf("")
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	stringerType  = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+	basicTypes = map[reflect.Kind]reflect.Type{
+		reflect.Bool:    reflect.TypeOf(false),
+		reflect.Int32:   reflect.TypeOf(int32(0)),
+		reflect.Int64:   reflect.TypeOf(int64(0)),
+		reflect.Uint32:  reflect.TypeOf(uint32(0)),
+		reflect.Uint64:  reflect.TypeOf(uint64(0)),
+		reflect.Float32: reflect.TypeOf(float32(0)),
+		reflect.Float64: reflect.TypeOf(float64(0)),
+		reflect.String:  stringType,
+	}
+
+	plainTypes sync.Map
+)
+
+// plainValue returns a copy of the given value (e.g. a proto message), of
+// which the types do not have methods. This prevents that the template calls
+// the (mutating) methods of the event, e.g. Reset. The fields keep their
+// names, timestamps are converted to time.Time and enums to their name.
+func plainValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return convertPlainValue(v, plainType(v.Type())).Interface()
+}
+
+// plainType returns the type without methods for the given type.
+func plainType(t reflect.Type) reflect.Type {
+	if pt, ok := plainTypes.Load(t); ok {
+		return pt.(reflect.Type)
+	}
+
+	pt := buildPlainType(t, make(map[reflect.Type]bool))
+	plainTypes.Store(t, pt)
+	return pt
+}
+
+func buildPlainType(t reflect.Type, visiting map[reflect.Type]bool) reflect.Type {
+	switch {
+	case t == timestampType:
+		return timeType
+	case t.Kind() == reflect.Int32 && t.Implements(stringerType):
+		return stringType
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if visiting[t] {
+			// recursive type
+			return interfaceType
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		return reflect.PtrTo(buildPlainType(t.Elem(), visiting))
+	case reflect.Struct:
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || strings.HasPrefix(f.Name, "XXX_") {
+				continue
+			}
+
+			sf := reflect.StructField{
+				Name: f.Name,
+				Type: buildPlainType(f.Type, visiting),
+			}
+			if tag := f.Tag.Get("json"); tag != "" {
+				sf.Tag = reflect.StructTag(`json:"` + tag + `"`)
+			}
+			fields = append(fields, sf)
+		}
+		return reflect.StructOf(fields)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bytesType
+		}
+		return reflect.SliceOf(buildPlainType(t.Elem(), visiting))
+	case reflect.Map:
+		return reflect.MapOf(buildPlainType(t.Key(), visiting), buildPlainType(t.Elem(), visiting))
+	case reflect.Interface:
+		return interfaceType
+	default:
+		if bt, ok := basicTypes[t.Kind()]; ok {
+			return bt
+		}
+		return t
+	}
+}
+
+// convertPlainValue converts the given value to the given type, as returned
+// by plainType.
+func convertPlainValue(v reflect.Value, t reflect.Type) reflect.Value {
+	out := reflect.New(t).Elem()
+
+	if t.Kind() == reflect.Interface {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return out
+		}
+		if v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		out.Set(convertPlainValue(v, plainType(v.Type())))
+		return out
+	}
+
+	switch {
+	case v.Type() == timestampType:
+		if !v.IsNil() {
+			if ts, err := ptypes.Timestamp(v.Interface().(*timestamp.Timestamp)); err == nil {
+				out.Set(reflect.ValueOf(ts))
+			}
+		}
+		return out
+	case v.Kind() == reflect.Int32 && v.Type().Implements(stringerType):
+		out.SetString(v.Interface().(fmt.Stringer).String())
+		return out
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			p := reflect.New(t.Elem())
+			p.Elem().Set(convertPlainValue(v.Elem(), t.Elem()))
+			out.Set(p)
+		}
+	case reflect.Struct:
+		vt := v.Type()
+		j := 0
+		for i := 0; i < v.NumField(); i++ {
+			f := vt.Field(i)
+			if f.PkgPath != "" || strings.HasPrefix(f.Name, "XXX_") {
+				continue
+			}
+			out.Field(j).Set(convertPlainValue(v.Field(i), t.Field(j).Type))
+			j++
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		if t == bytesType {
+			out.SetBytes(append([]byte{}, v.Bytes()...))
+			break
+		}
+		s := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(convertPlainValue(v.Index(i), t.Elem()))
+		}
+		out.Set(s)
+	case reflect.Map:
+		if v.IsNil() {
+			break
+		}
+		m := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m.SetMapIndex(convertPlainValue(iter.Key(), t.Key()), convertPlainValue(iter.Value(), t.Elem()))
+		}
+		out.Set(m)
+	default:
+		out.Set(v.Convert(t))
+	}
+
+	return out
+}
+
+func templateEventType(msg proto.Message) string {
+	switch msg.(type) {
+	case *integration.UplinkEvent:
+		return "up"
+	case *integration.JoinEvent:
+		return "join"
+	case *integration.AckEvent:
+		return "ack"
+	case *integration.ErrorEvent:
+		return "error"
+	case *integration.StatusEvent:
+		return "status"
+	case *integration.LocationEvent:
+		return "location"
+	case *integration.TxAckEvent:
+		return "txack"
+	case *integration.IntegrationEvent:
+		return "integration"
+	default:
+		return ""
+	}
+}
+
+func templateSamples() []proto.Message {
+	tags := map[string]string{"key": "value"}
+	devEUI := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	return []proto.Message{
+		&integration.UplinkEvent{DevEui: devEUI, FPort: 1, Data: []byte{1, 2, 3}, ObjectJson: `{}`, Tags: tags},
+		&integration.JoinEvent{DevEui: devEUI, DevAddr: []byte{1, 2, 3, 4}, Tags: tags},
+		&integration.AckEvent{DevEui: devEUI, Tags: tags},
+		&integration.ErrorEvent{DevEui: devEUI, Tags: tags},
+		&integration.StatusEvent{DevEui: devEUI, Tags: tags},
+		&integration.LocationEvent{DevEui: devEUI, Tags: tags},
+		&integration.TxAckEvent{DevEui: devEUI, Tags: tags},
+		&integration.IntegrationEvent{DevEui: devEUI, ObjectJson: `{}`, Tags: tags},
+	}
+}
+
+// templateFuncs returns the functions available to the payload templates.
+// These functions must not have side-effects.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"base64": func(b []byte) string {
+			return base64.StdEncoding.EncodeToString(b)
+		},
+		"hex": func(b []byte) string {
+			return hex.EncodeToString(b)
+		},
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"timestamp": func(ts time.Time) string {
+			if ts.IsZero() {
+				return ""
+			}
+			return ts.UTC().Format(time.RFC3339Nano)
+		},
+		"unix": func(ts time.Time) int64 {
+			if ts.IsZero() {
+				return 0
+			}
+			return ts.Unix()
+		},
+		"now": func() string {
+			return time.Now().UTC().Format(time.RFC3339Nano)
+		},
+		"field": templateField,
+		"default": func(def, v interface{}) interface{} {
+			if v == nil || v == "" {
+				return def
+			}
+			return v
+		},
+	}
+}
+
+// templateField returns the value of the given (dot separated) path within
+// the given object. It returns nil when the path does not exist.
+func templateField(obj interface{}, path string) interface{} {
+	v := obj
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if v, ok = m[key]; !ok {
+			return nil
+		}
+	}
+	return v
+}
+
+// MarshalEvent marshals the given event using the given payload template.
+// When the template is nil, the event is marshaled using the given
+// marshaler type.
+func MarshalEvent(t Type, tmpl *Template, msg proto.Message) ([]byte, error) {
+	if tmpl == nil {
+		return Marshal(t, msg)
+	}
+	return tmpl.Marshal(msg)
+}
@@ -0,0 +1,209 @@
+package marshaler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+)
+
+func TestTemplate(t *testing.T) {
+	up := &integration.UplinkEvent{
+		ApplicationId: 1,
+		DeviceName:    "test-device",
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		FPort:         10,
+		Data:          []byte{1, 2, 3},
+		ObjectJson:    `{"sensor":{"temperature":21.5}}`,
+		RxInfo: []*gw.UplinkRXInfo{
+			{Time: &timestamp.Timestamp{Seconds: 1600000000}},
+		},
+	}
+
+	tests := []struct {
+		Name          string
+		Template      string
+		Message       proto.Message
+		Expected      string
+		ExpectedError string
+	}{
+		{
+			Name:     "uplink fields",
+			Template: `{"devEUI":"{{ hex .Event.DevEui }}","fPort":{{ .Event.FPort }},"data":"{{ base64 .Event.Data }}","name":{{ json .Event.DeviceName }}}`,
+			Message:  up,
+			Expected: `{"devEUI":"0102030405060708","fPort":10,"data":"AQID","name":"test-device"}`,
+		},
+		{
+			Name:     "object field access",
+			Template: `{{ field .Object "sensor.temperature" }}/{{ default "n/a" (field .Object "sensor.humidity") }}`,
+			Message:  up,
+			Expected: `21.5/n/a`,
+		},
+		{
+			Name:     "timestamps",
+			Template: `{{ range .Event.RxInfo }}{{ timestamp .Time }} {{ unix .Time }}{{ end }}`,
+			Message:  up,
+			Expected: `2020-09-13T12:26:40Z 1600000000`,
+		},
+		{
+			Name:     "event type",
+			Template: `{{ .Type }}`,
+			Message:  &integration.JoinEvent{},
+			Expected: `join`,
+		},
+		{
+			Name:     "enum name",
+			Template: `{{ .Event.Type }}`,
+			Message:  &integration.ErrorEvent{Type: integration.ErrorType_UPLINK_CODEC},
+			Expected: `UPLINK_CODEC`,
+		},
+		{
+			Name:          "unknown field",
+			Template:      `{{ .Event.FPort }}`,
+			Message:       &integration.JoinEvent{},
+			ExpectedError: `can't evaluate field FPort`,
+		},
+		{
+			Name:          "event methods are not exposed",
+			Template:      `{{ .Event.Reset }}`,
+			Message:       up,
+			ExpectedError: `can't evaluate field Reset`,
+		},
+		{
+			Name:          "range over int",
+			Template:      `{{ range 1000000000 }}{{ end }}`,
+			Message:       up,
+			ExpectedError: `range over int is not allowed`,
+		},
+		{
+			Name:          "range over int variable",
+			Template:      `{{ $n := unix (index .Event.RxInfo 0).Time }}{{ range $n }}{{ end }}`,
+			Message:       up,
+			ExpectedError: `range over int64 is not allowed`,
+		},
+		{
+			Name:          "nested ranges exceed budget",
+			Template:      `{{ $d := .Event.Data }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ range $d }}{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}{{ end }}`,
+			Message:       up,
+			ExpectedError: `template exceeds 10000 range iterations`,
+		},
+		{
+			Name:     "template calls",
+			Template: `{{ define "fport" }}{{ .FPort }}{{ end }}{{ define "none" }}{{ . }}{{ end }}{{ template "fport" .Event }}/{{ template "none" }}`,
+			Message:  up,
+			Expected: `10/<no value>`,
+		},
+		{
+			Name:          "template call fan-out exceeds budget",
+			Template:      fanOutTemplate(40),
+			Message:       up,
+			ExpectedError: `template exceeds 1000 template calls`,
+		},
+		{
+			Name:          "recursive template call exceeds budget",
+			Template:      `{{ define "r" }}{{ template "r" . }}{{ end }}{{ template "r" . }}`,
+			Message:       up,
+			ExpectedError: `template exceeds 1000 template calls`,
+		},
+		{
+			Name:          "output size exceeded",
+			Template:      `{{ range .Event.Data }}{{ printf "%0999999d" 0 }}{{ end }}`,
+			Message:       up,
+			ExpectedError: `template output exceeds 1048576 bytes`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			tmpl, err := ParseTemplate(tst.Template)
+			assert.NoError(err)
+
+			b, err := MarshalEvent(JSONV3, tmpl, tst.Message)
+			if tst.ExpectedError != "" {
+				assert.Error(err)
+				assert.Contains(err.Error(), tst.ExpectedError)
+				return
+			}
+
+			assert.NoError(err)
+			assert.Equal(tst.Expected, string(b))
+		})
+	}
+
+	assert := require.New(t)
+	assert.Equal("test-device", up.DeviceName)
+	assert.EqualValues(10, up.FPort)
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Template      string
+		ExpectedError bool
+	}{
+		{
+			Name:     "valid for all event types",
+			Template: `{"type":"{{ .Type }}","devEUI":"{{ hex .Event.DevEui }}"}`,
+		},
+		{
+			Name:     "valid for uplinks only",
+			Template: `{{ .Event.FPort }}`,
+		},
+		{
+			Name:          "syntax error",
+			Template:      `{{ .Event.FPort `,
+			ExpectedError: true,
+		},
+		{
+			Name:          "unknown function",
+			Template:      `{{ exec "ls" }}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "unknown field",
+			Template:      `{{ .Event.Foo }}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "range over int",
+			Template:      `{{ range 10 }}{{ . }}{{ end }}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "template call fan-out",
+			Template:      fanOutTemplate(40),
+			ExpectedError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			err := ValidateTemplate(tst.Template)
+			if tst.ExpectedError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+// fanOutTemplate returns a template of which each nested template calls the
+// next template twice, resulting in 2^n calls without output.
+func fanOutTemplate(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `{{ define "t%d" }}{{ template "t%d" }}{{ template "t%d" }}{{ end }}`, i, i+1, i+1)
+	}
+	fmt.Fprintf(&b, `{{ define "t%d" }}{{ end }}{{ template "t0" }}`, n)
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package integration
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ch = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_cache_hit_count",
+		Help: "The number of application integration cache hits.",
+	})
+
+	cm = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_cache_miss_count",
+		Help: "The number of application integration cache misses.",
+	})
+
+	ci = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "integration_cache_invalidation_count",
+		Help: "The number of application integration cache invalidations.",
+	})
+)
+
+func cacheHitCounter() prometheus.Counter {
+	return ch
+}
+
+func cacheMissCounter() prometheus.Counter {
+	return cm
+}
+
+func cacheInvalidationCounter() prometheus.Counter {
+	return ci
+}
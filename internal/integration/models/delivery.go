@@ -0,0 +1,76 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type deliveryRecorderKey struct{}
+
+// DeliveryRecorder records the targets (e.g. the URLs) to which an event
+// has been delivered by an integration sending to multiple targets. When an
+// event is retried, the targets to which it was already delivered are
+// skipped.
+type DeliveryRecorder struct {
+	mu      sync.Mutex
+	targets map[string]struct{}
+}
+
+// NewDeliveryRecorder creates a new DeliveryRecorder, containing the given
+// targets to which the event was already delivered.
+func NewDeliveryRecorder(delivered []string) *DeliveryRecorder {
+	r := DeliveryRecorder{
+		targets: make(map[string]struct{}),
+	}
+	for _, t := range delivered {
+		r.targets[t] = struct{}{}
+	}
+	return &r
+}
+
+// Targets returns the sorted targets to which the event was delivered.
+func (r *DeliveryRecorder) Targets() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var out []string
+	for t := range r.targets {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// WithDeliveryRecorder returns a copy of the given context, containing the
+// given recorder.
+func WithDeliveryRecorder(ctx context.Context, r *DeliveryRecorder) context.Context {
+	return context.WithValue(ctx, deliveryRecorderKey{}, r)
+}
+
+// Delivered returns true when the context contains a DeliveryRecorder and
+// the event was already delivered to the given target.
+func Delivered(ctx context.Context, target string) bool {
+	r, ok := ctx.Value(deliveryRecorderKey{}).(*DeliveryRecorder)
+	if !ok {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok = r.targets[target]
+	return ok
+}
+
+// RecordDelivered records that the event was delivered to the given target,
+// when the context contains a DeliveryRecorder.
+func RecordDelivered(ctx context.Context, target string) {
+	r, ok := ctx.Value(deliveryRecorderKey{}).(*DeliveryRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	r.targets[target] = struct{}{}
+	r.mu.Unlock()
+}
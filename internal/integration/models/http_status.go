@@ -0,0 +1,42 @@
+package models
+
+import (
+	"context"
+	"sync"
+)
+
+type httpStatusRecorderKey struct{}
+
+// HTTPStatusRecorder records the status code of the last HTTP response
+// received by an integration, e.g. when test-firing an integration.
+type HTTPStatusRecorder struct {
+	mu         sync.Mutex
+	statusCode int
+}
+
+// StatusCode returns the recorded status code, or 0 when no HTTP response
+// was recorded.
+func (r *HTTPStatusRecorder) StatusCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.statusCode
+}
+
+// WithHTTPStatusRecorder returns a copy of the given context, containing the
+// given recorder.
+func WithHTTPStatusRecorder(ctx context.Context, r *HTTPStatusRecorder) context.Context {
+	return context.WithValue(ctx, httpStatusRecorderKey{}, r)
+}
+
+// RecordHTTPStatus records the given status code when the context contains
+// a HTTPStatusRecorder.
+func RecordHTTPStatus(ctx context.Context, statusCode int) {
+	r, ok := ctx.Value(httpStatusRecorderKey{}).(*HTTPStatusRecorder)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	r.statusCode = statusCode
+	r.mu.Unlock()
+}
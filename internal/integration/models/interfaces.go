@@ -36,3 +36,9 @@ type IntegrationHandler interface {
 	DataDownChan() chan DataDownPayload
 	Close() error
 }
+
+// Flusher is implemented by integration handlers which buffer events, e.g.
+// to send them in batches. Flush sends the buffered events.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
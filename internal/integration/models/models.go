@@ -59,6 +59,16 @@ type DataDownPayload struct {
 	FPort         uint8           `json:"fPort"`
 	Data          []byte          `json:"data"`
 	Object        json.RawMessage `json:"object"`
+
+	// ResultChan is an optional channel to which the result of the enqueue
+	// action is sent.
+	ResultChan chan DataDownResult `json:"-"`
+}
+
+// DataDownResult contains the result of enqueueing a DataDownPayload.
+type DataDownResult struct {
+	FCnt  uint32
+	Error error
 }
 
 // JoinNotification defines the payload sent to the application on
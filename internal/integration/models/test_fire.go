@@ -0,0 +1,18 @@
+package models
+
+import "context"
+
+type testFireKey struct{}
+
+// WithTestFire returns a copy of the given context, marking the event as
+// test-fire event. Integrations must not make persistent changes (e.g.
+// provisioning the device) when handling a test-fire event.
+func WithTestFire(ctx context.Context) context.Context {
+	return context.WithValue(ctx, testFireKey{}, true)
+}
+
+// IsTestFire returns true when the context contains a test-fire event.
+func IsTestFire(ctx context.Context) bool {
+	v, _ := ctx.Value(testFireKey{}).(bool)
+	return v
+}
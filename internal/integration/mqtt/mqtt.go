@@ -41,6 +41,7 @@ var (
 // Integration implements a MQTT integration.
 type Integration struct {
 	marshaler            marshaler.Type
+	payloadTemplate      *marshaler.Template
 	conn                 mqtt.Client
 	dataDownChan         chan models.DataDownPayload
 	wg                   sync.WaitGroup
@@ -88,6 +89,14 @@ func New(m marshaler.Type, conf config.IntegrationMQTTConfig) (*Integration, err
 		config:       conf,
 	}
 
+	if conf.PayloadTemplate != "" {
+		pt, err := marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+		i.payloadTemplate = pt
+	}
+
 	i.retainEvents = i.config.RetainEvents
 	i.eventTopicTemplate, err = template.New("event").Parse(i.config.EventTopicTemplate)
 	if err != nil {
@@ -184,7 +193,7 @@ func New(m marshaler.Type, conf config.IntegrationMQTTConfig) (*Integration, err
 	opts.SetConnectionLostHandler(i.onConnectionLost)
 	opts.SetMaxReconnectInterval(i.config.MaxReconnectInterval)
 
-	tlsconfig, err := newTLSConfig(i.config.CACert, i.config.TLSCert, i.config.TLSKey)
+	tlsconfig, err := NewTLSConfig(i.config.CACert, i.config.TLSCert, i.config.TLSKey)
 	if err != nil {
 		log.WithError(err).WithFields(log.Fields{
 			"ca_cert":  i.config.CACert,
@@ -209,7 +218,9 @@ func New(m marshaler.Type, conf config.IntegrationMQTTConfig) (*Integration, err
 	return &i, nil
 }
 
-func newTLSConfig(cafile, certFile, certKeyFile string) (*tls.Config, error) {
+// NewTLSConfig returns the TLS configuration for the given CA certificate and
+// TLS certificate / key files. It returns nil when no files are given.
+func NewTLSConfig(cafile, certFile, certKeyFile string) (*tls.Config, error) {
 	// Here are three valid options:
 	//   - Only CA
 	//   - TLS cert + key
@@ -312,7 +323,7 @@ func (i *Integration) publish(ctx context.Context, applicationID uint64, devEUIB
 
 	retain := i.getRetainEvents(eventType)
 
-	b, err := marshaler.Marshal(i.marshaler, msg)
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,323 @@
+package multi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// Event types which can be used in the Filter event-types.
+const (
+	EventUplink      = "up"
+	EventJoin        = "join"
+	EventAck         = "ack"
+	EventError       = "error"
+	EventStatus      = "status"
+	EventLocation    = "location"
+	EventTxAck       = "txack"
+	EventIntegration = "integration"
+)
+
+var eventTypes = []string{
+	EventUplink,
+	EventJoin,
+	EventAck,
+	EventError,
+	EventStatus,
+	EventLocation,
+	EventTxAck,
+	EventIntegration,
+}
+
+// Filter defines which events are forwarded to an integration. An empty
+// filter forwards all events.
+type Filter struct {
+	// EventTypes contains the event types to forward. When empty, all event
+	// types are forwarded.
+	EventTypes []string `json:"eventTypes,omitempty"`
+
+	// FPorts contains the fPorts to forward. This only applies to event
+	// types containing an fPort (uplink events), including the uplinks with
+	// fPort 0. When empty, all fPorts are forwarded.
+	FPorts []uint32 `json:"fPorts,omitempty"`
+
+	// Tags contains the device-tag match expression. This is a comma
+	// separated list of conditions which must all match:
+	//   key=value   the tag must exist and must equal value
+	//   key!=value  the tag must not equal value (or must not exist)
+	//   key         the tag must exist
+	//   !key        the tag must not exist
+	Tags string `json:"tags,omitempty"`
+}
+
+// tagCondition defines a single condition of the tag match expression.
+type tagCondition struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+func (c tagCondition) match(tags map[string]string) bool {
+	v, ok := tags[c.key]
+	if c.exists {
+		return ok != c.negate
+	}
+	return (ok && v == c.value) != c.negate
+}
+
+// IsEmpty returns true when the filter does not filter any events.
+func (f Filter) IsEmpty() bool {
+	return len(f.EventTypes) == 0 && len(f.FPorts) == 0 && strings.TrimSpace(f.Tags) == ""
+}
+
+// Validate validates the filter.
+func (f Filter) Validate() error {
+	for _, t := range f.EventTypes {
+		var found bool
+		for _, et := range eventTypes {
+			if t == et {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("invalid event type: %s", t)
+		}
+	}
+
+	for _, fPort := range f.FPorts {
+		if fPort > 255 {
+			return fmt.Errorf("invalid fPort: %d", fPort)
+		}
+	}
+
+	if _, err := parseTagExpression(f.Tags); err != nil {
+		return errors.Wrap(err, "parse tags expression error")
+	}
+
+	return nil
+}
+
+// Match returns true when an event of the given type, fPort and device tags
+// must be forwarded. The fPort is ignored for event types which do not
+// contain an fPort.
+func (f Filter) Match(eventType string, fPort uint32, tags map[string]string) bool {
+	conds, err := parseTagExpression(f.Tags)
+	if err != nil {
+		// the expression is validated on save, an invalid expression
+		// does not match anything
+		return false
+	}
+
+	return f.match(conds, eventType, fPort, tags)
+}
+
+// match implements Match, using the already parsed tag conditions.
+func (f Filter) match(conds []tagCondition, eventType string, fPort uint32, tags map[string]string) bool {
+	if len(f.EventTypes) != 0 {
+		var found bool
+		for _, t := range f.EventTypes {
+			if t == eventType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.FPorts) != 0 && hasFPort(eventType) {
+		var found bool
+		for _, p := range f.FPorts {
+			if p == fPort {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, c := range conds {
+		if !c.match(tags) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasFPort returns true when events of the given type contain an fPort.
+func hasFPort(eventType string) bool {
+	return eventType == EventUplink
+}
+
+func parseTagExpression(expr string) ([]tagCondition, error) {
+	var out []tagCondition
+
+	if strings.TrimSpace(expr) == "" {
+		return out, nil
+	}
+
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		var c tagCondition
+
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			c = tagCondition{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1]), negate: true}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			c = tagCondition{key: strings.TrimSpace(kv[0]), value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			c = tagCondition{key: strings.TrimSpace(strings.TrimPrefix(part, "!")), exists: true, negate: true}
+		default:
+			c = tagCondition{key: part, exists: true}
+		}
+
+		if c.key == "" {
+			return nil, fmt.Errorf("tag key is missing in condition: '%s'", part)
+		}
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+// filteredHandler wraps an integration handler together with its filter.
+// Events which do not match the filter are dropped.
+type filteredHandler struct {
+	models.IntegrationHandler
+	filter Filter
+	conds  []tagCondition
+}
+
+// WithFilter returns the given integration handler, filtered by the given
+// filter. When the filter is empty, the handler is returned as-is.
+func WithFilter(h models.IntegrationHandler, f Filter) (models.IntegrationHandler, error) {
+	if f.IsEmpty() {
+		return h, nil
+	}
+
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	conds, err := parseTagExpression(f.Tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse tags expression error")
+	}
+
+	return &filteredHandler{
+		IntegrationHandler: h,
+		filter:             f,
+		conds:              conds,
+	}, nil
+}
+
+func (h *filteredHandler) match(eventType string, fPort uint32, tags map[string]string) bool {
+	return h.filter.match(h.conds, eventType, fPort, tags)
+}
+
+// HandleUplinkEvent sends an UplinkEvent when it matches the filter.
+func (h *filteredHandler) HandleUplinkEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	if !h.match(EventUplink, pl.FPort, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleUplinkEvent(ctx, i, vars, pl)
+}
+
+// HandleJoinEvent sends a JoinEvent when it matches the filter.
+func (h *filteredHandler) HandleJoinEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	if !h.match(EventJoin, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleJoinEvent(ctx, i, vars, pl)
+}
+
+// HandleAckEvent sends an AckEvent when it matches the filter.
+func (h *filteredHandler) HandleAckEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	if !h.match(EventAck, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleAckEvent(ctx, i, vars, pl)
+}
+
+// HandleErrorEvent sends an ErrorEvent when it matches the filter.
+func (h *filteredHandler) HandleErrorEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	if !h.match(EventError, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleErrorEvent(ctx, i, vars, pl)
+}
+
+// HandleStatusEvent sends a StatusEvent when it matches the filter.
+func (h *filteredHandler) HandleStatusEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	if !h.match(EventStatus, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleStatusEvent(ctx, i, vars, pl)
+}
+
+// HandleLocationEvent sends a LocationEvent when it matches the filter.
+func (h *filteredHandler) HandleLocationEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	if !h.match(EventLocation, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleLocationEvent(ctx, i, vars, pl)
+}
+
+// HandleTxAckEvent sends a TxAckEvent when it matches the filter.
+func (h *filteredHandler) HandleTxAckEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	if !h.match(EventTxAck, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleTxAckEvent(ctx, i, vars, pl)
+}
+
+// HandleIntegrationEvent sends an IntegrationEvent when it matches the filter.
+func (h *filteredHandler) HandleIntegrationEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	if !h.match(EventIntegration, 0, pl.Tags) {
+		return nil
+	}
+	return h.IntegrationHandler.HandleIntegrationEvent(ctx, i, vars, pl)
+}
+
+// accepts returns false when the given handler is filtered and the event does
+// not match its filter.
+func accepts(ii models.IntegrationHandler, eventType string, fPort uint32, tags map[string]string) bool {
+	fh, ok := ii.(*filteredHandler)
+	if !ok {
+		return true
+	}
+	return fh.match(eventType, fPort, tags)
+}
+
+// Unwrap returns the wrapped integration handler.
+func (h *filteredHandler) Unwrap() models.IntegrationHandler {
+	return h.IntegrationHandler
+}
+
+// unwrap returns the integration handler wrapped by the filter and other
+// wrappers implementing Unwrap (if any).
+func unwrap(ii models.IntegrationHandler) models.IntegrationHandler {
+	for {
+		w, ok := ii.(interface {
+			Unwrap() models.IntegrationHandler
+		})
+		if !ok {
+			return ii
+		}
+		ii = w.Unwrap()
+	}
+}
@@ -0,0 +1,217 @@
+package multi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+type uplinkRecorder struct {
+	models.IntegrationHandler
+	uplinks chan pb.UplinkEvent
+}
+
+func (r *uplinkRecorder) HandleUplinkEvent(ctx context.Context, i models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	r.uplinks <- pl
+	return nil
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Filter        Filter
+		ExpectedError string
+	}{
+		{
+			Name:   "empty filter",
+			Filter: Filter{},
+		},
+		{
+			Name: "valid filter",
+			Filter: Filter{
+				EventTypes: []string{EventUplink, EventJoin},
+				FPorts:     []uint32{10, 20},
+				Tags:       "billing=true, !test",
+			},
+		},
+		{
+			Name: "invalid event type",
+			Filter: Filter{
+				EventTypes: []string{"foo"},
+			},
+			ExpectedError: "invalid event type: foo",
+		},
+		{
+			Name: "invalid fPort",
+			Filter: Filter{
+				FPorts: []uint32{256},
+			},
+			ExpectedError: "invalid fPort: 256",
+		},
+		{
+			Name: "invalid tags expression",
+			Filter: Filter{
+				Tags: "billing=true,=false",
+			},
+			ExpectedError: "parse tags expression error: tag key is missing in condition: '=false'",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			err := tst.Filter.Validate()
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	billing := Filter{
+		EventTypes: []string{EventUplink},
+		FPorts:     []uint32{10},
+		Tags:       "billing=true",
+	}
+
+	tests := []struct {
+		Name      string
+		Filter    Filter
+		EventType string
+		FPort     uint32
+		Tags      map[string]string
+		Expected  bool
+	}{
+		{
+			Name:      "empty filter",
+			EventType: EventJoin,
+			Expected:  true,
+		},
+		{
+			Name:      "all match",
+			Filter:    billing,
+			EventType: EventUplink,
+			FPort:     10,
+			Tags:      map[string]string{"billing": "true"},
+			Expected:  true,
+		},
+		{
+			Name:      "event type does not match",
+			Filter:    billing,
+			EventType: EventStatus,
+			Tags:      map[string]string{"billing": "true"},
+		},
+		{
+			Name:      "fPort does not match",
+			Filter:    billing,
+			EventType: EventUplink,
+			FPort:     11,
+			Tags:      map[string]string{"billing": "true"},
+		},
+		{
+			Name:      "tag value does not match",
+			Filter:    billing,
+			EventType: EventUplink,
+			FPort:     10,
+			Tags:      map[string]string{"billing": "false"},
+		},
+		{
+			Name:      "tag is missing",
+			Filter:    billing,
+			EventType: EventUplink,
+			FPort:     10,
+		},
+		{
+			Name:      "fPort 0 does not match",
+			Filter:    Filter{FPorts: []uint32{10}},
+			EventType: EventUplink,
+			FPort:     0,
+		},
+		{
+			Name:      "fPort 0 matches",
+			Filter:    Filter{FPorts: []uint32{0}},
+			EventType: EventUplink,
+			FPort:     0,
+			Expected:  true,
+		},
+		{
+			Name:      "fPort does not apply to events without fPort",
+			Filter:    Filter{FPorts: []uint32{10}},
+			EventType: EventJoin,
+			Expected:  true,
+		},
+		{
+			Name:      "not equal",
+			Filter:    Filter{Tags: "env!=test"},
+			EventType: EventUplink,
+			Tags:      map[string]string{"env": "prod"},
+			Expected:  true,
+		},
+		{
+			Name:      "tag exists",
+			Filter:    Filter{Tags: "env"},
+			EventType: EventUplink,
+			Tags:      map[string]string{"env": ""},
+			Expected:  true,
+		},
+		{
+			Name:      "tag must not exist",
+			Filter:    Filter{Tags: "!env"},
+			EventType: EventUplink,
+			Tags:      map[string]string{"env": "prod"},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, tst.Filter.Match(tst.EventType, tst.FPort, tst.Tags))
+		})
+	}
+}
+
+func TestFilteredDispatch(t *testing.T) {
+	assert := require.New(t)
+
+	rec := &uplinkRecorder{uplinks: make(chan pb.UplinkEvent, 10)}
+	h, err := WithFilter(rec, Filter{
+		EventTypes: []string{EventUplink},
+		FPorts:     []uint32{10},
+		Tags:       "billing=true",
+	})
+	assert.NoError(err)
+	assert.Equal("multi", integrationKind(unwrap(h)))
+
+	i := New(nil, []models.IntegrationHandler{h})
+	assert.NoError(i.HandleUplinkEvent(context.Background(), nil, pb.UplinkEvent{FPort: 11, Tags: map[string]string{"billing": "true"}}))
+	assert.NoError(i.HandleUplinkEvent(context.Background(), nil, pb.UplinkEvent{FPort: 10}))
+	assert.NoError(i.HandleUplinkEvent(context.Background(), nil, pb.UplinkEvent{FPort: 10, Tags: map[string]string{"billing": "true"}}))
+
+	select {
+	case pl := <-rec.uplinks:
+		assert.EqualValues(10, pl.FPort)
+		assert.Equal("true", pl.Tags["billing"])
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	select {
+	case <-rec.uplinks:
+		t.Fatal("expected the other uplinks to be filtered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		assert := require.New(t)
+		_, err := WithFilter(rec, Filter{EventTypes: []string{"foo"}})
+		assert.EqualError(err, "invalid event type: foo")
+	})
+}
@@ -0,0 +1,35 @@
+package multi
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	qd = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "integration_worker_pool_queue_depth",
+		Help: "The number of events waiting in the worker-pool queue (per integration kind).",
+	}, []string{"kind"})
+
+	dc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_worker_pool_dropped_count",
+		Help: "The number of events dropped because of a full worker-pool queue (per integration kind).",
+	}, []string{"kind"})
+
+	dd = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "integration_dispatch_duration_seconds",
+		Help: "The duration of handling an event by an integration (per integration kind).",
+	}, []string{"kind"})
+)
+
+func queueDepthGauge(kind string) prometheus.Gauge {
+	return qd.With(prometheus.Labels{"kind": kind})
+}
+
+func droppedCounter(kind string) prometheus.Counter {
+	return dc.With(prometheus.Labels{"kind": kind})
+}
+
+func dispatchDurationObserver(kind string) prometheus.Observer {
+	return dd.With(prometheus.Labels{"kind": kind})
+}
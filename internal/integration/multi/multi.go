@@ -3,13 +3,9 @@ package multi
 
 import (
 	"context"
-	"fmt"
-
-	log "github.com/sirupsen/logrus"
 
 	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
 	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
-	"github.com/brocaar/chirpstack-application-server/internal/logging"
 )
 
 // Integration implements the multi integration.
@@ -29,15 +25,14 @@ func New(global, app []models.IntegrationHandler) *Integration {
 // HandleUplinkEvent sends an UplinkEvent.
 func (i *Integration) HandleUplinkEvent(ctx context.Context, vars map[string]string, pl pb.UplinkEvent) error {
 	for _, ii := range i.integrations() {
+		if !accepts(ii, EventUplink, pl.FPort, pl.Tags) {
+			continue
+		}
 
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleUplinkEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleUplinkEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -46,14 +41,14 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, vars map[string]str
 // HandleJoinEvent sends a JoinEvent.
 func (i *Integration) HandleJoinEvent(ctx context.Context, vars map[string]string, pl pb.JoinEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleJoinEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventJoin, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleJoinEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -62,14 +57,14 @@ func (i *Integration) HandleJoinEvent(ctx context.Context, vars map[string]strin
 // HandleAckEvent sends an AckEvent.
 func (i *Integration) HandleAckEvent(ctx context.Context, vars map[string]string, pl pb.AckEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleAckEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventAck, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleAckEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -77,16 +72,15 @@ func (i *Integration) HandleAckEvent(ctx context.Context, vars map[string]string
 
 // HandleErrorEvent sends an ErrorEvent.
 func (i *Integration) HandleErrorEvent(ctx context.Context, vars map[string]string, pl pb.ErrorEvent) error {
-
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleErrorEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventError, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleErrorEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -95,14 +89,14 @@ func (i *Integration) HandleErrorEvent(ctx context.Context, vars map[string]stri
 // HandleStatusEvent sends a StatusEvent.
 func (i *Integration) HandleStatusEvent(ctx context.Context, vars map[string]string, pl pb.StatusEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleStatusEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventStatus, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleStatusEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -111,14 +105,14 @@ func (i *Integration) HandleStatusEvent(ctx context.Context, vars map[string]str
 // HandleLocationEvent sends a LocationEvent.
 func (i *Integration) HandleLocationEvent(ctx context.Context, vars map[string]string, pl pb.LocationEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleLocationEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventLocation, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleLocationEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -127,14 +121,14 @@ func (i *Integration) HandleLocationEvent(ctx context.Context, vars map[string]s
 // HandleTxAckEvent sends a TxAckEvent.
 func (i *Integration) HandleTxAckEvent(ctx context.Context, vars map[string]string, pl pb.TxAckEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleTxAckEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventTxAck, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleTxAckEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
@@ -143,14 +137,14 @@ func (i *Integration) HandleTxAckEvent(ctx context.Context, vars map[string]stri
 // HandleIntegrationEvent sends an IntegrationEvent.
 func (i *Integration) HandleIntegrationEvent(ctx context.Context, vars map[string]string, pl pb.IntegrationEvent) error {
 	for _, ii := range i.integrations() {
-		go func(ii models.IntegrationHandler) {
-			if err := ii.HandleIntegrationEvent(ctx, i, vars, pl); err != nil {
-				log.WithError(err).WithFields(log.Fields{
-					"integration": fmt.Sprintf("%T", ii),
-					"ctx_id":      ctx.Value(logging.ContextIDKey),
-				}).Error("integration/multi: integration error")
-			}
-		}(ii)
+		if !accepts(ii, EventIntegration, 0, pl.Tags) {
+			continue
+		}
+
+		ii := ii
+		dispatch(ctx, ii, func(ctx context.Context) error {
+			return ii.HandleIntegrationEvent(ctx, i, vars, pl)
+		})
 	}
 
 	return nil
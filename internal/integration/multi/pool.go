@@ -0,0 +1,248 @@
+package multi
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+)
+
+// Queue full policies.
+const (
+	PolicyBlock = "block"
+	PolicyDrop  = "drop"
+)
+
+type workerCtxKey struct{}
+
+var (
+	poolWorkers   int
+	poolQueueSize int
+	poolBlock     bool
+
+	poolsMux sync.Mutex
+	pools    = make(map[string]*pool)
+)
+
+// Setup configures the multi package.
+func Setup(conf config.Config) error {
+	poolWorkers = conf.ApplicationServer.Integration.WorkerPool.Workers
+	poolQueueSize = conf.ApplicationServer.Integration.WorkerPool.QueueSize
+
+	switch conf.ApplicationServer.Integration.WorkerPool.FullPolicy {
+	case PolicyBlock:
+		poolBlock = true
+	case PolicyDrop, "":
+		poolBlock = false
+	default:
+		return fmt.Errorf("invalid worker-pool full policy: %s", conf.ApplicationServer.Integration.WorkerPool.FullPolicy)
+	}
+
+	return nil
+}
+
+// task defines a single integration handler invocation.
+type task struct {
+	ctx     context.Context
+	handler models.IntegrationHandler
+	f       func(context.Context) error
+}
+
+// pool implements a bounded worker-pool for a single integration. The
+// workers are started on demand and stop when the queue is empty. The pool
+// is removed when it has no running workers.
+type pool struct {
+	key  string
+	kind string
+
+	mu      sync.Mutex
+	space   *sync.Cond
+	queue   []task
+	running int
+}
+
+// newPool creates a new pool.
+func newPool(key, kind string) *pool {
+	p := pool{
+		key:  key,
+		kind: kind,
+	}
+	p.space = sync.NewCond(&p.mu)
+	return &p
+}
+
+// enqueue queues the given task, starting a worker when less than the
+// configured number of workers are running. When the queue is full, it
+// blocks until the queue has space when block is true, else it returns
+// false.
+func (p *pool) enqueue(t task, block bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running < poolWorkers {
+		p.running++
+		go p.worker(t)
+		return true
+	}
+
+	for len(p.queue) >= poolQueueSize {
+		if !block {
+			return false
+		}
+		p.space.Wait()
+	}
+
+	p.queue = append(p.queue, t)
+	queueDepthGauge(p.kind).Inc()
+	return true
+}
+
+func (p *pool) worker(t task) {
+	for {
+		run(p.kind, t)
+
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.running--
+			idle := p.running == 0
+			p.mu.Unlock()
+
+			if idle {
+				removePool(p)
+			}
+			return
+		}
+
+		t = p.queue[0]
+		p.queue[0] = task{}
+		p.queue = p.queue[1:]
+		p.space.Signal()
+		p.mu.Unlock()
+
+		queueDepthGauge(p.kind).Dec()
+	}
+}
+
+// getPool returns the pool for the given key. The pool is created when it
+// does not exist.
+func getPool(key, kind string) *pool {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+
+	p, ok := pools[key]
+	if !ok {
+		p = newPool(key, kind)
+		pools[key] = p
+	}
+
+	return p
+}
+
+// removePool removes the given pool when it is idle.
+func removePool(p *pool) {
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running == 0 && len(p.queue) == 0 && pools[p.key] == p {
+		delete(pools, p.key)
+	}
+}
+
+// dispatch invokes f for the given integration handler. When the
+// worker-pool is configured, f is queued to the pool of the integration, so
+// that a slow integration does not delay the other integrations. When the
+// queue is full, dispatch drops f, or blocks when configured. Without
+// worker-pool, f is executed in a new Go-routine.
+func dispatch(ctx context.Context, ii models.IntegrationHandler, f func(context.Context) error) {
+	key := poolKey(ii)
+	ii = unwrap(ii)
+	kind := integrationKind(ii)
+	t := task{
+		ctx:     ctx,
+		handler: ii,
+		f:       f,
+	}
+
+	if poolWorkers == 0 {
+		go run(kind, t)
+		return
+	}
+
+	// Events generated by an integration handler (e.g. a location event
+	// generated while handling an uplink) are dispatched from within a
+	// worker. To avoid a deadlock, these must never block on a full queue.
+	nested := ctx.Value(workerCtxKey{}) != nil
+
+	if getPool(key, kind).enqueue(t, poolBlock && !nested) {
+		return
+	}
+
+	if poolBlock {
+		go run(kind, t)
+		return
+	}
+
+	droppedCounter(kind).Inc()
+	log.WithFields(log.Fields{
+		"integration": key,
+		"ctx_id":      ctx.Value(logging.ContextIDKey),
+	}).Error("integration/multi: worker-pool queue is full, dropping event")
+}
+
+// poolKey returns the key of the worker-pool for the given integration. For
+// integrations wrapped by a handler implementing IntegrationName (e.g. the
+// health tracker), this is the integration name, which is unique per
+// application integration. Else it is the integration kind.
+func poolKey(ii models.IntegrationHandler) string {
+	for {
+		if n, ok := ii.(interface {
+			IntegrationName() string
+		}); ok {
+			return n.IntegrationName()
+		}
+
+		w, ok := ii.(interface {
+			Unwrap() models.IntegrationHandler
+		})
+		if !ok {
+			return integrationKind(ii)
+		}
+		ii = w.Unwrap()
+	}
+}
+
+// run executes the given task.
+func run(kind string, t task) {
+	start := time.Now()
+	err := t.f(context.WithValue(t.ctx, workerCtxKey{}, true))
+	dispatchDurationObserver(kind).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"integration": fmt.Sprintf("%T", t.handler),
+			"ctx_id":      t.ctx.Value(logging.ContextIDKey),
+		}).Error("integration/multi: integration error")
+	}
+}
+
+// integrationKind returns the kind of the given integration, based on its
+// package name (e.g. http, mqtt, ...).
+func integrationKind(ii models.IntegrationHandler) string {
+	t := reflect.TypeOf(ii)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return path.Base(t.PkgPath())
+}
@@ -0,0 +1,214 @@
+package multi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+type testIntegration struct {
+	models.IntegrationHandler
+}
+
+func TestIntegrationKind(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal("multi", integrationKind(&testIntegration{}))
+	assert.Equal("multi", integrationKind(testIntegration{}))
+}
+
+// namedIntegration wraps an integration, implementing IntegrationName.
+type namedIntegration struct {
+	models.IntegrationHandler
+	name string
+}
+
+func (i *namedIntegration) IntegrationName() string {
+	return i.name
+}
+
+func (i *namedIntegration) Unwrap() models.IntegrationHandler {
+	return i.IntegrationHandler
+}
+
+func TestPoolKey(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal("multi", poolKey(&testIntegration{}))
+	assert.Equal("HTTP/1", poolKey(&namedIntegration{IntegrationHandler: &testIntegration{}, name: "HTTP/1"}))
+
+	fi, err := WithFilter(&namedIntegration{IntegrationHandler: &testIntegration{}, name: "HTTP/2"}, Filter{EventTypes: []string{EventUplink}})
+	assert.NoError(err)
+	assert.Equal("HTTP/2", poolKey(fi))
+}
+
+// setupPools waits until the pools of the previous test are idle and then
+// configures the pools.
+func setupPools(workers, queueSize int, block bool) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		poolsMux.Lock()
+		n := len(pools)
+		poolsMux.Unlock()
+		if n == 0 {
+			break
+		}
+	}
+
+	poolsMux.Lock()
+	defer poolsMux.Unlock()
+	poolWorkers = workers
+	poolQueueSize = queueSize
+	poolBlock = block
+	pools = make(map[string]*pool)
+}
+
+func TestDispatch(t *testing.T) {
+	defer setupPools(0, 0, false)
+
+	t.Run("Block", func(t *testing.T) {
+		assert := require.New(t)
+		setupPools(1, 1, true)
+
+		var mu sync.Mutex
+		var count int
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			dispatch(context.Background(), &testIntegration{}, func(ctx context.Context) error {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+
+				mu.Lock()
+				count++
+				mu.Unlock()
+				return nil
+			})
+		}
+
+		wg.Wait()
+		assert.Equal(10, count)
+	})
+
+	t.Run("Drop", func(t *testing.T) {
+		assert := require.New(t)
+		setupPools(1, 1, false)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		handled := make(chan struct{}, 10)
+
+		// block the worker
+		dispatch(context.Background(), &testIntegration{}, func(ctx context.Context) error {
+			close(started)
+			<-release
+			handled <- struct{}{}
+			return nil
+		})
+		<-started
+
+		// the first event is queued, the others are dropped
+		for i := 0; i < 9; i++ {
+			dispatch(context.Background(), &testIntegration{}, func(ctx context.Context) error {
+				handled <- struct{}{}
+				return nil
+			})
+		}
+		close(release)
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		}
+
+		select {
+		case <-handled:
+			t.Fatal("expected events to be dropped")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.Len(handled, 0)
+	})
+
+	t.Run("Pool per integration", func(t *testing.T) {
+		assert := require.New(t)
+		setupPools(1, 1, false)
+
+		slow := &namedIntegration{IntegrationHandler: &testIntegration{}, name: "HTTP/1"}
+		fast := &namedIntegration{IntegrationHandler: &testIntegration{}, name: "HTTP/2"}
+
+		release := make(chan struct{})
+		handled := make(chan struct{}, 10)
+
+		// fill the worker and queue of the slow integration
+		for i := 0; i < 2; i++ {
+			dispatch(context.Background(), slow, func(ctx context.Context) error {
+				<-release
+				return nil
+			})
+		}
+
+		// the other integration is not affected
+		for i := 0; i < 2; i++ {
+			dispatch(context.Background(), fast, func(ctx context.Context) error {
+				handled <- struct{}{}
+				return nil
+			})
+		}
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		}
+		close(release)
+
+		// the pools are removed once idle
+		for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+			poolsMux.Lock()
+			n := len(pools)
+			poolsMux.Unlock()
+			if n == 0 {
+				break
+			}
+		}
+		poolsMux.Lock()
+		assert.Len(pools, 0)
+		poolsMux.Unlock()
+	})
+
+	t.Run("Nested dispatch does not block", func(t *testing.T) {
+		setupPools(1, 1, true)
+
+		m := &testIntegration{}
+		handled := make(chan struct{}, 2)
+
+		dispatch(context.Background(), m, func(ctx context.Context) error {
+			// the worker is busy, fill the queue and dispatch from within
+			// the worker
+			for i := 0; i < 2; i++ {
+				dispatch(ctx, m, func(ctx context.Context) error {
+					handled <- struct{}{}
+					return nil
+				})
+			}
+			return nil
+		})
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("nested dispatch blocked")
+			}
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package nats
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// downlinkChan contains the downlink commands received by all the NATS
+// integrations.
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlink commands received
+// on the NATS command subject.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+func (i *Integration) subscribeCommands(conf config.IntegrationNATSConfig) error {
+	commandSubject, err := template.New("command").Parse(conf.CommandSubjectTemplate)
+	if err != nil {
+		return errors.Wrap(err, "parse command subject template error")
+	}
+
+	subject, err := getCommandSubject(commandSubject, i.applicationID)
+	if err != nil {
+		return errors.Wrap(err, "get command subject error")
+	}
+
+	i.commandRegexp, err = getCommandSubjectRegexp(commandSubject)
+	if err != nil {
+		return errors.Wrap(err, "get command subject regexp error")
+	}
+
+	i.done = make(chan struct{})
+
+	i.commandSub, err = i.conn.QueueSubscribe(subject, conf.CommandQueueGroup, i.handleCommand)
+	if err != nil {
+		return errors.Wrap(err, "subscribe command subject error")
+	}
+
+	log.WithFields(log.Fields{
+		"subject":     subject,
+		"queue_group": conf.CommandQueueGroup,
+	}).Info("integration/nats: subscribed to command subject")
+
+	return nil
+}
+
+func (i *Integration) handleCommand(msg *nats.Msg) {
+	log.WithField("subject", msg.Subject).Info("integration/nats: downlink command received")
+
+	pl, err := i.decodeCommand(msg.Subject, msg.Data)
+	if err != nil {
+		log.WithError(err).WithField("subject", msg.Subject).Error("integration/nats: decode downlink command error")
+		return
+	}
+
+	select {
+	case downlinkChan <- pl:
+	case <-i.done:
+	}
+}
+
+func (i *Integration) decodeCommand(subject string, b []byte) (models.DataDownPayload, error) {
+	var pl models.DataDownPayload
+
+	match := i.commandRegexp.FindStringSubmatch(subject)
+	if match == nil {
+		return pl, errors.New("subject does not match command subject template")
+	}
+
+	for n, name := range i.commandRegexp.SubexpNames() {
+		switch name {
+		case "application_id":
+			id, err := strconv.ParseInt(match[n], 10, 64)
+			if err != nil {
+				return pl, errors.Wrap(err, "parse application id error")
+			}
+			pl.ApplicationID = id
+		case "dev_eui":
+			if err := pl.DevEUI.UnmarshalText([]byte(match[n])); err != nil {
+				return pl, errors.Wrap(err, "parse deveui error")
+			}
+		}
+	}
+
+	if i.applicationID != 0 && pl.ApplicationID != i.applicationID {
+		return pl, errors.New("application id does not match the application of the integration")
+	}
+
+	var cmd models.DataDownPayload
+	if err := json.Unmarshal(b, &cmd); err != nil {
+		return pl, errors.Wrap(err, "unmarshal json error")
+	}
+
+	pl.Confirmed = cmd.Confirmed
+	pl.FPort = cmd.FPort
+	pl.Data = cmd.Data
+	pl.Object = cmd.Object
+
+	if pl.FPort == 0 || pl.FPort > 224 {
+		return pl, errors.New("fPort must be between 1 - 224")
+	}
+
+	return pl, nil
+}
+
+// getCommandSubject returns the (wildcard) subject matching the downlink
+// commands of all the devices of the given application, or of all
+// applications when the given application ID is 0.
+func getCommandSubject(t *template.Template, applicationID int64) (string, error) {
+	appID := "*"
+	if applicationID != 0 {
+		appID = strconv.FormatInt(applicationID, 10)
+	}
+
+	subject := bytes.NewBuffer(nil)
+	err := t.Execute(subject, struct {
+		ApplicationID string
+		DevEUI        string
+		CommandType   string
+	}{appID, "*", "down"})
+	if err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+	return subject.String(), nil
+}
+
+// getCommandSubjectRegexp returns the regexp for retrieving the application
+// ID and DevEUI from the subject of a downlink command.
+func getCommandSubjectRegexp(t *template.Template) (*regexp.Regexp, error) {
+	subject := bytes.NewBuffer(nil)
+	err := t.Execute(subject, struct {
+		ApplicationID string
+		DevEUI        string
+		CommandType   string
+	}{"APPLICATIONID", "DEVEUI", "COMMANDTYPE"})
+	if err != nil {
+		return nil, errors.Wrap(err, "execute template error")
+	}
+
+	expr := strings.NewReplacer(
+		"APPLICATIONID", `(?P<application_id>\d+)`,
+		"DEVEUI", `(?P<dev_eui>[0-9a-fA-F]{16})`,
+		"COMMANDTYPE", `down`,
+	).Replace(regexp.QuoteMeta(subject.String()))
+
+	r, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, errors.Wrap(err, "compile regexp error")
+	}
+
+	if len(r.SubexpNames()) != 3 {
+		return nil, errors.New("template must contain the application id and deveui")
+	}
+
+	return r, nil
+}
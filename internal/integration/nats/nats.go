@@ -0,0 +1,252 @@
+// Package nats implements a NATS / JetStream integration.
+package nats
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"text/template"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/lorawan"
+)
+
+const publishAckTimeout = 10 * time.Second
+
+// Integration implements a NATS integration.
+type Integration struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
+	eventSubject    *template.Template
+
+	// Downlink commands. When applicationID is set, only the commands of
+	// this application are received.
+	applicationID int64
+	commandSub    *nats.Subscription
+	commandRegexp *regexp.Regexp
+	done          chan struct{}
+}
+
+// New creates a new (global) NATS integration. When the server is not
+// reachable, the connection is retried in the background. When a command
+// subject is configured, the downlink commands of all applications are
+// received.
+func New(m marshaler.Type, conf config.IntegrationNATSConfig) (*Integration, error) {
+	return newIntegration(m, conf, true, 0, conf.CommandSubjectTemplate != "")
+}
+
+// NewForApplication creates a new NATS integration for the given application.
+// Unlike New, it returns an error when the server is not reachable. It only
+// publishes the events, the downlink commands are received by the integration
+// returned by NewCommandConsumer.
+func NewForApplication(m marshaler.Type, conf config.IntegrationNATSConfig, applicationID int64) (*Integration, error) {
+	return newIntegration(m, conf, false, applicationID, false)
+}
+
+// NewCommandConsumer creates a new NATS integration, receiving the downlink
+// commands of the given application. It only subscribes to the command
+// subject of the given application and commands for other applications are
+// rejected.
+func NewCommandConsumer(m marshaler.Type, conf config.IntegrationNATSConfig, applicationID int64) (*Integration, error) {
+	if conf.CommandSubjectTemplate == "" {
+		return nil, errors.New("command subject template must be set")
+	}
+	return newIntegration(m, conf, false, applicationID, true)
+}
+
+func newIntegration(m marshaler.Type, conf config.IntegrationNATSConfig, retry bool, applicationID int64, commands bool) (*Integration, error) {
+	var err error
+	i := Integration{
+		marshaler:     m,
+		applicationID: applicationID,
+	}
+
+	if conf.PayloadTemplate != "" {
+		i.payloadTemplate, err = marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+	}
+
+	i.eventSubject, err = template.New("event").Parse(conf.EventSubjectTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse event subject template error")
+	}
+
+	opts := []nats.Option{
+		nats.Name("chirpstack-application-server"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(retry),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.WithError(err).Warning("integration/nats: disconnected from server")
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.WithField("server", nc.ConnectedUrl()).Info("integration/nats: reconnected to server")
+		}),
+	}
+	if conf.Username != "" || conf.Password != "" {
+		opts = append(opts, nats.UserInfo(conf.Username, conf.Password))
+	}
+
+	log.WithField("server", conf.Server).Info("integration/nats: connecting to nats server")
+	i.conn, err = nats.Connect(conf.Server, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to nats server error")
+	}
+
+	if conf.JetStream {
+		i.js, err = i.conn.JetStream()
+		if err != nil {
+			i.conn.Close()
+			return nil, errors.Wrap(err, "get jetstream context error")
+		}
+	}
+
+	if commands {
+		if err := i.subscribeCommands(conf); err != nil {
+			i.conn.Close()
+			return nil, err
+		}
+	}
+
+	return &i, nil
+}
+
+// HandleUplinkEvent sends an UplinkEvent.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "up", &pl)
+}
+
+// HandleJoinEvent sends a JoinEvent.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "join", &pl)
+}
+
+// HandleAckEvent sends an AckEvent.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "ack", &pl)
+}
+
+// HandleErrorEvent sends an ErrorEvent.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "error", &pl)
+}
+
+// HandleStatusEvent sends a StatusEvent.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "status", &pl)
+}
+
+// HandleLocationEvent sends a LocationEvent.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "location", &pl)
+}
+
+// HandleTxAckEvent sends a TxAckEvent.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "txack", &pl)
+}
+
+// HandleIntegrationEvent sends an IntegrationEvent.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "integration", &pl)
+}
+
+// DataDownChan returns the channel containing the downlink commands received
+// on the command subject. It returns nil when no command subject is
+// configured.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	if i.commandSub == nil {
+		return nil
+	}
+	return downlinkChan
+}
+
+// Close closes the integration.
+func (i *Integration) Close() error {
+	if i.done != nil {
+		close(i.done)
+	}
+
+	if i.commandSub != nil {
+		if err := i.commandSub.Unsubscribe(); err != nil && err != nats.ErrConnectionClosed {
+			log.WithError(err).Error("integration/nats: unsubscribe command subject error")
+		}
+	}
+
+	if err := i.conn.Drain(); err != nil && err != nats.ErrConnectionClosed {
+		i.conn.Close()
+		return errors.Wrap(err, "drain connection error")
+	}
+
+	return nil
+}
+
+func (i *Integration) publishEvent(ctx context.Context, applicationID uint64, devEUIB []byte, typ string, msg proto.Message) error {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], devEUIB)
+
+	subject := bytes.NewBuffer(nil)
+	err := i.eventSubject.Execute(subject, struct {
+		ApplicationID uint64
+		DevEUI        lorawan.EUI64
+		EventType     string
+	}{applicationID, devEUI, typ})
+	if err != nil {
+		return errors.Wrap(err, "execute template error")
+	}
+
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"subject":   subject.String(),
+		"jetstream": i.js != nil,
+		"ctx_id":    ctx.Value(logging.ContextIDKey),
+	}).Info("integration/nats: publishing event")
+
+	m := nats.NewMsg(subject.String())
+	m.Data = b
+
+	switch i.marshaler {
+	case marshaler.ProtobufJSON, marshaler.JSONV3:
+		m.Header.Set("Content-Type", "application/json")
+	case marshaler.Protobuf:
+		m.Header.Set("Content-Type", "application/octet-stream")
+	}
+
+	if i.js == nil {
+		if err := i.conn.PublishMsg(m); err != nil {
+			return errors.Wrap(err, "publish event error")
+		}
+		return nil
+	}
+
+	// JetStream publishes are acknowledged by the stream, the publish is
+	// retried by the caller (e.g. the outbox) on error.
+	ctx, cancel := context.WithTimeout(ctx, publishAckTimeout)
+	defer cancel()
+
+	if _, err := i.js.PublishMsg(m, nats.Context(ctx)); err != nil {
+		return errors.Wrap(err, "publish event error")
+	}
+
+	return nil
+}
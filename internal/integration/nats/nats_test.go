@@ -0,0 +1,254 @@
+package nats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+type IntegrationTestSuite struct {
+	suite.Suite
+
+	server *server.Server
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+
+	applicationID uint64
+	devEUI        lorawan.EUI64
+}
+
+func (ts *IntegrationTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+
+	ts.applicationID = 10
+	ts.devEUI = lorawan.EUI64{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+
+	var err error
+	ts.server, err = server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  ts.T().TempDir(),
+	})
+	assert.NoError(err)
+
+	go ts.server.Start()
+	assert.True(ts.server.ReadyForConnections(10 * time.Second))
+
+	ts.conn, err = nats.Connect(ts.server.ClientURL())
+	assert.NoError(err)
+
+	ts.js, err = ts.conn.JetStream()
+	assert.NoError(err)
+
+	_, err = ts.js.AddStream(&nats.StreamConfig{
+		Name:     "EVENTS",
+		Subjects: []string{"js.application.*.device.*.event.*"},
+	})
+	assert.NoError(err)
+}
+
+func (ts *IntegrationTestSuite) TearDownSuite() {
+	ts.conn.Close()
+	ts.server.Shutdown()
+}
+
+func (ts *IntegrationTestSuite) config() config.IntegrationNATSConfig {
+	return config.IntegrationNATSConfig{
+		Server:                 ts.server.ClientURL(),
+		EventSubjectTemplate:   "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}",
+		CommandSubjectTemplate: "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.command.{{ .CommandType }}",
+		CommandQueueGroup:      "test",
+	}
+}
+
+func (ts *IntegrationTestSuite) TestPublishEvent() {
+	assert := require.New(ts.T())
+
+	i, err := New(marshaler.Protobuf, ts.config())
+	assert.NoError(err)
+	defer i.Close()
+
+	sub, err := ts.conn.SubscribeSync("application.*.device.*.event.*")
+	assert.NoError(err)
+	defer sub.Unsubscribe()
+	assert.NoError(ts.conn.Flush())
+
+	pl := pb.UplinkEvent{
+		ApplicationId: ts.applicationID,
+		DevEui:        ts.devEUI[:],
+		FPort:         10,
+		Data:          []byte{1, 2, 3},
+	}
+	assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pl))
+
+	msg, err := sub.NextMsg(time.Second)
+	assert.NoError(err)
+	assert.Equal("application.10.device.0102030405060708.event.up", msg.Subject)
+	assert.Equal("application/octet-stream", msg.Header.Get("Content-Type"))
+
+	var plReceived pb.UplinkEvent
+	assert.NoError(proto.Unmarshal(msg.Data, &plReceived))
+	assert.True(proto.Equal(&pl, &plReceived))
+}
+
+func (ts *IntegrationTestSuite) TestPublishEventJetStream() {
+	assert := require.New(ts.T())
+
+	conf := ts.config()
+	conf.EventSubjectTemplate = "js." + conf.EventSubjectTemplate
+	conf.JetStream = true
+
+	i, err := NewForApplication(marshaler.JSONV3, conf, 10)
+	assert.NoError(err)
+	defer i.Close()
+
+	assert.NoError(i.HandleStatusEvent(context.Background(), nil, nil, pb.StatusEvent{
+		ApplicationId: ts.applicationID,
+		DevEui:        ts.devEUI[:],
+		Margin:        10,
+	}))
+
+	info, err := ts.js.StreamInfo("EVENTS")
+	assert.NoError(err)
+
+	msg, err := ts.js.GetMsg("EVENTS", info.State.LastSeq)
+	assert.NoError(err)
+	assert.Equal("js.application.10.device.0102030405060708.event.status", msg.Subject)
+	assert.Equal("application/json", msg.Header.Get("Content-Type"))
+
+	ts.T().Run("Without stream", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := ts.config()
+		conf.JetStream = true
+
+		i, err := NewForApplication(marshaler.JSONV3, conf, 10)
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.Error(i.HandleStatusEvent(context.Background(), nil, nil, pb.StatusEvent{
+			ApplicationId: ts.applicationID,
+			DevEui:        ts.devEUI[:],
+		}))
+	})
+}
+
+func (ts *IntegrationTestSuite) TestDownlinkCommand() {
+	assert := require.New(ts.T())
+
+	i, err := New(marshaler.Protobuf, ts.config())
+	assert.NoError(err)
+	defer i.Close()
+	assert.Equal(DownlinkChan(), i.DataDownChan())
+	assert.NoError(i.conn.Flush())
+
+	ts.T().Run("Valid command", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.conn.Publish(
+			"application.10.device.0102030405060708.command.down",
+			[]byte(`{"confirmed":true,"fPort":3,"data":"AQID"}`),
+		))
+
+		assert.Equal(models.DataDownPayload{
+			ApplicationID: 10,
+			DevEUI:        ts.devEUI,
+			Confirmed:     true,
+			FPort:         3,
+			Data:          []byte{1, 2, 3},
+		}, <-i.DataDownChan())
+	})
+
+	ts.T().Run("Invalid fPort", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := i.decodeCommand("application.10.device.0102030405060708.command.down", []byte(`{"fPort":0}`))
+		assert.Error(err)
+	})
+
+	ts.T().Run("Application integration", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := NewForApplication(marshaler.Protobuf, ts.config(), 10)
+		assert.NoError(err)
+		defer i.Close()
+		assert.Nil(i.DataDownChan())
+	})
+}
+
+func (ts *IntegrationTestSuite) TestApplicationDownlinkCommand() {
+	assert := require.New(ts.T())
+
+	i, err := NewCommandConsumer(marshaler.Protobuf, ts.config(), 10)
+	assert.NoError(err)
+	defer i.Close()
+	assert.Equal(DownlinkChan(), i.DataDownChan())
+	assert.NoError(i.conn.Flush())
+
+	ts.T().Run("Other application", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(ts.conn.Publish(
+			"application.20.device.0102030405060708.command.down",
+			[]byte(`{"fPort":3,"data":"AQID"}`),
+		))
+		assert.NoError(ts.conn.Publish(
+			"application.10.device.0102030405060708.command.down",
+			[]byte(`{"fPort":4,"data":"AQID"}`),
+		))
+
+		pl := <-i.DataDownChan()
+		assert.EqualValues(10, pl.ApplicationID)
+		assert.EqualValues(4, pl.FPort)
+
+		select {
+		case pl := <-i.DataDownChan():
+			t.Fatalf("unexpected downlink command: %+v", pl)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	ts.T().Run("Application id mismatch", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := i.decodeCommand("application.20.device.0102030405060708.command.down", []byte(`{"fPort":3}`))
+		assert.Error(err)
+	})
+
+	ts.T().Run("Without command subject", func(t *testing.T) {
+		assert := require.New(t)
+
+		conf := ts.config()
+		conf.CommandSubjectTemplate = ""
+
+		_, err := NewCommandConsumer(marshaler.Protobuf, conf, 10)
+		assert.Error(err)
+	})
+}
+
+func TestNewForApplicationUnreachable(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewForApplication(marshaler.Protobuf, config.IntegrationNATSConfig{
+		Server:               "nats://127.0.0.1:1",
+		EventSubjectTemplate: "events",
+	}, 10)
+	assert.Error(err)
+}
+
+func TestIntegration(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
+}
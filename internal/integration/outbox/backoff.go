@@ -0,0 +1,68 @@
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+// backoffKey holds the delivery failures and the time until which the
+// delivery is paused, per application integration.
+const backoffKey = "lora:as:integration:outbox:backoff:%d:%s"
+
+// integrationBackoff returns the time until which the delivery to the given
+// integration is paused. It returns the zero time when the last delivery to
+// the integration did not fail.
+func integrationBackoff(ctx context.Context, applicationID int64, name string) (time.Time, error) {
+	key := storage.GetRedisKey(backoffKey, applicationID, name)
+	s, err := storage.RedisClient().HGet(ctx, key, "until").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "redis hget error")
+	}
+
+	ns, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parse backoff error")
+	}
+
+	return time.Unix(0, ns), nil
+}
+
+// recordFailure records a failed delivery to the given integration and
+// returns the duration for which the delivery to the integration is paused.
+func recordFailure(ctx context.Context, applicationID int64, name string) (time.Duration, error) {
+	key := storage.GetRedisKey(backoffKey, applicationID, name)
+	failures, err := storage.RedisClient().HIncrBy(ctx, key, "failures", 1).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "redis hincrby error")
+	}
+
+	backoff := retryBackoff(int(failures))
+
+	pipe := storage.RedisClient().TxPipeline()
+	pipe.HSet(ctx, key, "until", strconv.FormatInt(time.Now().Add(backoff).UnixNano(), 10))
+	pipe.PExpire(ctx, key, maxAge+retryMaxInterval)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, errors.Wrap(err, "redis exec error")
+	}
+
+	return backoff, nil
+}
+
+// resetBackoff resets the backoff of the given integration, after a
+// successful delivery.
+func resetBackoff(ctx context.Context, applicationID int64, name string) error {
+	key := storage.GetRedisKey(backoffKey, applicationID, name)
+	if err := storage.RedisClient().Del(ctx, key).Err(); err != nil {
+		return errors.Wrap(err, "redis del error")
+	}
+	return nil
+}
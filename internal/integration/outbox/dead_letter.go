@@ -0,0 +1,162 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+const (
+	deadLetterKeyTempl = "lora:as:integration:outbox:application:%d:dead_letter"
+)
+
+// deadLetter moves the given event to the dead-letter store.
+func deadLetter(ctx context.Context, e Event) error {
+	now := time.Now()
+	e.DeadLetteredAt = &now
+
+	s, err := e.marshal()
+	if err != nil {
+		return err
+	}
+
+	err = storage.RedisClient().XAdd(ctx, &redis.XAddArgs{
+		Stream:       deadLetterKey(e.ApplicationID),
+		MaxLenApprox: deadLetterMaxHistory,
+		Values: map[string]interface{}{
+			"event": s,
+		},
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "redis xadd error")
+	}
+
+	outboxEventCounter("dead_lettered").Inc()
+	return nil
+}
+
+// GetDeadLetterEventCount returns the number of dead-lettered events for the
+// given application ID.
+func GetDeadLetterEventCount(ctx context.Context, applicationID int64) (int, error) {
+	n, err := storage.RedisClient().XLen(ctx, deadLetterKey(applicationID)).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "redis xlen error")
+	}
+
+	return int(n), nil
+}
+
+// GetDeadLetterEvents returns the dead-lettered events for the given
+// application ID, most recent first.
+func GetDeadLetterEvents(ctx context.Context, applicationID int64, limit int) ([]Event, error) {
+	msgs, err := storage.RedisClient().XRevRangeN(ctx, deadLetterKey(applicationID), "+", "-", int64(limit)).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "redis xrevrange error")
+	}
+
+	return messagesToEvents(msgs)
+}
+
+// ReplayDeadLetterEvents schedules the dead-lettered events with the given
+// stream IDs for immediate re-delivery to their integration. When no IDs are
+// given, all dead-lettered events of the application are replayed.
+// It returns the number of replayed events.
+func ReplayDeadLetterEvents(ctx context.Context, applicationID int64, ids []string) (int, error) {
+	events, err := getDeadLetterEvents(ctx, applicationID, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for _, e := range events {
+		streamID := e.StreamID
+
+		// reset the event so that the max. age starts again
+		e.StreamID = ""
+		e.Attempts = 0
+		e.Error = ""
+		e.CreatedAt = now
+		e.DeadLetteredAt = nil
+
+		if _, err := scheduleRetry(ctx, e, now); err != nil {
+			return 0, err
+		}
+
+		if err := storage.RedisClient().XDel(ctx, deadLetterKey(applicationID), streamID).Err(); err != nil {
+			return 0, errors.Wrap(err, "redis xdel error")
+		}
+
+		outboxEventCounter("replayed").Inc()
+	}
+
+	return len(events), nil
+}
+
+// PurgeDeadLetterEvents deletes the dead-lettered events with the given stream
+// IDs. When no IDs are given, all dead-lettered events of the application are
+// deleted.
+func PurgeDeadLetterEvents(ctx context.Context, applicationID int64, ids []string) error {
+	var err error
+	if len(ids) == 0 {
+		err = storage.RedisClient().Del(ctx, deadLetterKey(applicationID)).Err()
+	} else {
+		err = storage.RedisClient().XDel(ctx, deadLetterKey(applicationID), ids...).Err()
+	}
+	if err != nil {
+		return errors.Wrap(err, "delete dead-letter events error")
+	}
+
+	return nil
+}
+
+// getDeadLetterEvents returns the dead-lettered events matching the given
+// stream IDs, or all events when no IDs are given.
+func getDeadLetterEvents(ctx context.Context, applicationID int64, ids []string) ([]Event, error) {
+	key := deadLetterKey(applicationID)
+
+	if len(ids) == 0 {
+		msgs, err := storage.RedisClient().XRange(ctx, key, "-", "+").Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "redis xrange error")
+		}
+		return messagesToEvents(msgs)
+	}
+
+	var msgs []redis.XMessage
+	for _, id := range ids {
+		m, err := storage.RedisClient().XRange(ctx, key, id, id).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "redis xrange error")
+		}
+		if len(m) == 0 {
+			return nil, errors.Wrap(storage.ErrDoesNotExist, fmt.Sprintf("dead-letter event %s", id))
+		}
+		msgs = append(msgs, m...)
+	}
+
+	return messagesToEvents(msgs)
+}
+
+func messagesToEvents(msgs []redis.XMessage) ([]Event, error) {
+	out := make([]Event, 0, len(msgs))
+	for _, msg := range msgs {
+		s, _ := msg.Values["event"].(string)
+		e, err := unmarshalEvent(s)
+		if err != nil {
+			return nil, err
+		}
+		e.StreamID = msg.ID
+		out = append(out, e)
+	}
+
+	return out, nil
+}
+
+func deadLetterKey(applicationID int64) string {
+	return storage.GetRedisKey(deadLetterKeyTempl, applicationID)
+}
@@ -0,0 +1,169 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/eventlog"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+// Event holds an event which must be delivered to the integrations.
+type Event struct {
+	// ID contains the unique event ID.
+	ID uuid.UUID `json:"id"`
+
+	// StreamID contains the ID of the dead-letter stream entry.
+	// This is only set when the event was read from the dead-letter store.
+	StreamID string `json:"-"`
+
+	// ApplicationID contains the application ID.
+	ApplicationID int64 `json:"applicationID"`
+
+	// DevEUI contains the device EUI.
+	DevEUI lorawan.EUI64 `json:"devEUI"`
+
+	// Type contains the event type (see the eventlog event types).
+	Type string `json:"type"`
+
+	// Vars contains the (device) variables.
+	Vars map[string]string `json:"vars"`
+
+	// Payload contains the Protobuf encoded event.
+	Payload []byte `json:"payload"`
+
+	// Integration contains the name of the integration to which the event
+	// must be delivered. When empty, it must be delivered to all integrations.
+	Integration string `json:"integration,omitempty"`
+
+	// Delivered contains the targets (e.g. URLs) of the integration to
+	// which the event was delivered by a previous, partially failed attempt.
+	Delivered []string `json:"delivered,omitempty"`
+
+	// Attempts contains the number of failed delivery attempts.
+	Attempts int `json:"attempts"`
+
+	// Error contains the error of the last delivery attempt.
+	Error string `json:"error,omitempty"`
+
+	// CreatedAt contains the time when the event was created.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// DeadLetteredAt contains the time when the event was moved to the
+	// dead-letter store.
+	DeadLetteredAt *time.Time `json:"deadLetteredAt,omitempty"`
+}
+
+type devEUIGetter interface {
+	proto.Message
+	GetDevEui() []byte
+}
+
+// newEvent creates a new Event for the given event type and payload.
+func newEvent(applicationID int64, typ string, vars map[string]string, pl devEUIGetter) (Event, error) {
+	b, err := proto.Marshal(pl)
+	if err != nil {
+		return Event{}, errors.Wrap(err, "marshal protobuf error")
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Event{}, errors.Wrap(err, "new uuid error")
+	}
+
+	e := Event{
+		ID:            id,
+		ApplicationID: applicationID,
+		Type:          typ,
+		Vars:          vars,
+		Payload:       b,
+		CreatedAt:     time.Now(),
+	}
+	copy(e.DevEUI[:], pl.GetDevEui())
+
+	return e, nil
+}
+
+// deliver delivers the event to the given integration handler. The given
+// integration is passed to the handler, so that it is able to generate new
+// events.
+func (e Event) deliver(ctx context.Context, h models.IntegrationHandler, i models.Integration) error {
+	switch e.Type {
+	case eventlog.Uplink:
+		var pl pb.UplinkEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleUplinkEvent(ctx, i, e.Vars, pl)
+	case eventlog.Join:
+		var pl pb.JoinEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleJoinEvent(ctx, i, e.Vars, pl)
+	case eventlog.ACK:
+		var pl pb.AckEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleAckEvent(ctx, i, e.Vars, pl)
+	case eventlog.Error:
+		var pl pb.ErrorEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleErrorEvent(ctx, i, e.Vars, pl)
+	case eventlog.Status:
+		var pl pb.StatusEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleStatusEvent(ctx, i, e.Vars, pl)
+	case eventlog.Location:
+		var pl pb.LocationEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleLocationEvent(ctx, i, e.Vars, pl)
+	case eventlog.TxAck:
+		var pl pb.TxAckEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleTxAckEvent(ctx, i, e.Vars, pl)
+	case eventlog.Integration:
+		var pl pb.IntegrationEvent
+		if err := proto.Unmarshal(e.Payload, &pl); err != nil {
+			return errors.Wrap(err, "unmarshal protobuf error")
+		}
+		return h.HandleIntegrationEvent(ctx, i, e.Vars, pl)
+	default:
+		return fmt.Errorf("unknown event type: %s", e.Type)
+	}
+}
+
+// marshal returns the JSON encoded event.
+func (e Event) marshal() (string, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal json error")
+	}
+	return string(b), nil
+}
+
+// unmarshalEvent decodes the given JSON encoded event.
+func unmarshalEvent(s string) (Event, error) {
+	var e Event
+	if err := json.Unmarshal([]byte(s), &e); err != nil {
+		return e, errors.Wrap(err, "unmarshal json error")
+	}
+	return e, nil
+}
@@ -0,0 +1,79 @@
+package outbox
+
+import (
+	"context"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/eventlog"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+)
+
+// Integration implements an integration which writes the events to the
+// outbox, from which they are delivered to the integrations.
+type Integration struct {
+	applicationID int64
+	dataDownChan  chan models.DataDownPayload
+}
+
+// New creates a new outbox integration for the given application ID.
+// The given data-down channel is returned by DataDownChan.
+func New(applicationID int64, dataDownChan chan models.DataDownPayload) *Integration {
+	return &Integration{
+		applicationID: applicationID,
+		dataDownChan:  dataDownChan,
+	}
+}
+
+// HandleUplinkEvent writes an UplinkEvent to the outbox.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, vars map[string]string, pl pb.UplinkEvent) error {
+	return i.enqueue(ctx, eventlog.Uplink, vars, &pl)
+}
+
+// HandleJoinEvent writes a JoinEvent to the outbox.
+func (i *Integration) HandleJoinEvent(ctx context.Context, vars map[string]string, pl pb.JoinEvent) error {
+	return i.enqueue(ctx, eventlog.Join, vars, &pl)
+}
+
+// HandleAckEvent writes an AckEvent to the outbox.
+func (i *Integration) HandleAckEvent(ctx context.Context, vars map[string]string, pl pb.AckEvent) error {
+	return i.enqueue(ctx, eventlog.ACK, vars, &pl)
+}
+
+// HandleErrorEvent writes an ErrorEvent to the outbox.
+func (i *Integration) HandleErrorEvent(ctx context.Context, vars map[string]string, pl pb.ErrorEvent) error {
+	return i.enqueue(ctx, eventlog.Error, vars, &pl)
+}
+
+// HandleStatusEvent writes a StatusEvent to the outbox.
+func (i *Integration) HandleStatusEvent(ctx context.Context, vars map[string]string, pl pb.StatusEvent) error {
+	return i.enqueue(ctx, eventlog.Status, vars, &pl)
+}
+
+// HandleLocationEvent writes a LocationEvent to the outbox.
+func (i *Integration) HandleLocationEvent(ctx context.Context, vars map[string]string, pl pb.LocationEvent) error {
+	return i.enqueue(ctx, eventlog.Location, vars, &pl)
+}
+
+// HandleTxAckEvent writes a TxAckEvent to the outbox.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, vars map[string]string, pl pb.TxAckEvent) error {
+	return i.enqueue(ctx, eventlog.TxAck, vars, &pl)
+}
+
+// HandleIntegrationEvent writes an IntegrationEvent to the outbox.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, vars map[string]string, pl pb.IntegrationEvent) error {
+	return i.enqueue(ctx, eventlog.Integration, vars, &pl)
+}
+
+// DataDownChan returns the channel containing the received DataDownPayload.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return i.dataDownChan
+}
+
+func (i *Integration) enqueue(ctx context.Context, typ string, vars map[string]string, pl devEUIGetter) error {
+	e, err := newEvent(i.applicationID, typ, vars, pl)
+	if err != nil {
+		return err
+	}
+
+	return Enqueue(ctx, e)
+}
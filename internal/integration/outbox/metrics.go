@@ -0,0 +1,17 @@
+package outbox
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ec = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_outbox_event_count",
+		Help: "The number of outbox events (per state).",
+	}, []string{"state"})
+)
+
+func outboxEventCounter(s string) prometheus.Counter {
+	return ec.With(prometheus.Labels{"state": s})
+}
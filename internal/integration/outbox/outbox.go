@@ -0,0 +1,552 @@
+// Package outbox implements a durable outbox for delivering events to the
+// integrations.
+//
+// Events are written to a Redis Stream, from which they are consumed using
+// a consumer-group. Failed deliveries are retried using an exponential
+// backoff per integration: while an integration is failing, its events are
+// not delivered until the backoff has expired. When an event could not be
+// delivered within the configured max. age, it is moved to the dead-letter
+// store of the application.
+package outbox
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+)
+
+const (
+	streamKey     = "lora:as:integration:outbox"
+	retryKey      = "lora:as:integration:outbox:retry"
+	consumerGroup = "integration"
+
+	readCount    = 100
+	readBlock    = time.Second
+	retryCount   = 100
+	retryPoll    = time.Second
+	claimIdle    = time.Minute
+	claimPoll    = time.Minute
+	eventTimeout = time.Minute
+
+	// retryLease defines the time after which a retry event, which was
+	// claimed for delivery but not removed (e.g. because of a crash), is
+	// retried again.
+	retryLease = 2 * eventTimeout
+
+	defaultWorkers = 100
+)
+
+var (
+	maxAge               time.Duration
+	retryInitialInterval time.Duration
+	retryMaxInterval     time.Duration
+	deadLetterMaxHistory int64
+	workers              int
+
+	// claimRetryScript claims the retry event (ARGV[1]) when it is due
+	// (ARGV[2]), by moving its score to the end of the lease (ARGV[3]).
+	claimRetryScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+	return 1
+end
+return 0
+`)
+)
+
+// Dispatcher provides the integration handlers to which the events are
+// delivered.
+type Dispatcher interface {
+	// Integrations returns the names of the integrations of the given
+	// application ID.
+	Integrations(applicationID int64) []string
+
+	// Handler returns the integration handler for the given application ID
+	// and integration name. It returns nil when the integration does not
+	// exist (anymore).
+	Handler(applicationID int64, name string) models.IntegrationHandler
+}
+
+// Setup configures the outbox package.
+func Setup(conf config.Config) error {
+	maxAge = conf.ApplicationServer.Integration.Outbox.MaxAge
+	retryInitialInterval = conf.ApplicationServer.Integration.Outbox.RetryInitialInterval
+	retryMaxInterval = conf.ApplicationServer.Integration.Outbox.RetryMaxInterval
+	deadLetterMaxHistory = conf.ApplicationServer.Integration.Outbox.DeadLetterMaxHistory
+	workers = conf.ApplicationServer.Integration.Outbox.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+
+	return nil
+}
+
+// Start starts consuming the outbox and delivering the events using the
+// given dispatcher. The consumers are stopped when the given context is
+// cancelled.
+func Start(ctx context.Context, d Dispatcher) error {
+	key := storage.GetRedisKey(streamKey)
+	err := storage.RedisClient().XGroupCreateMkStream(ctx, key, consumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return errors.Wrap(err, "create consumer-group error")
+	}
+
+	consumer, err := uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid error")
+	}
+
+	w := worker{
+		dispatcher: d,
+		consumer:   consumer.String(),
+		sem:        make(chan struct{}, workers),
+	}
+
+	go w.consumeLoop(ctx)
+	go w.claimLoop(ctx)
+	go w.retryLoop(ctx)
+
+	return nil
+}
+
+// Enqueue writes the given event to the outbox.
+func Enqueue(ctx context.Context, e Event) error {
+	s, err := e.marshal()
+	if err != nil {
+		return err
+	}
+
+	err = storage.RedisClient().XAdd(ctx, &redis.XAddArgs{
+		Stream: storage.GetRedisKey(streamKey),
+		Values: map[string]interface{}{
+			"event": s,
+		},
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "redis xadd error")
+	}
+
+	outboxEventCounter("enqueued").Inc()
+	return nil
+}
+
+// retryBackoff returns the backoff duration for the given number of failed
+// attempts.
+func retryBackoff(attempts int) time.Duration {
+	d := retryInitialInterval
+	for i := 1; i < attempts && d < retryMaxInterval; i++ {
+		d = d * 2
+	}
+
+	if d > retryMaxInterval {
+		d = retryMaxInterval
+	}
+
+	return d
+}
+
+// scheduleRetry schedules the given event for retry. It returns the retry
+// set member.
+func scheduleRetry(ctx context.Context, e Event, at time.Time) (string, error) {
+	s, err := e.marshal()
+	if err != nil {
+		return "", err
+	}
+
+	err = storage.RedisClient().ZAdd(ctx, storage.GetRedisKey(retryKey), &redis.Z{
+		Score:  float64(at.UnixNano()),
+		Member: s,
+	}).Err()
+	if err != nil {
+		return "", errors.Wrap(err, "redis zadd error")
+	}
+
+	return s, nil
+}
+
+type worker struct {
+	dispatcher Dispatcher
+	consumer   string
+
+	// sem limits the number of events that are handled concurrently.
+	sem chan struct{}
+}
+
+// run runs the given function in a new goroutine, when a worker is
+// available. It returns false when the context was cancelled while waiting
+// for a worker.
+func (w *worker) run(ctx context.Context, f func()) bool {
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	go func() {
+		defer func() { <-w.sem }()
+		f()
+	}()
+
+	return true
+}
+
+// consumeLoop reads the new events from the outbox stream.
+func (w *worker) consumeLoop(ctx context.Context) {
+	key := storage.GetRedisKey(streamKey)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		resp, err := storage.RedisClient().XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    consumerGroup,
+			Consumer: w.consumer,
+			Streams:  []string{key, ">"},
+			Count:    readCount,
+			Block:    readBlock,
+		}).Result()
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+
+			log.WithError(err).Error("integration/outbox: read stream error")
+			time.Sleep(readBlock)
+			continue
+		}
+
+		for _, stream := range resp {
+			for _, msg := range stream.Messages {
+				msg := msg
+				if !w.run(ctx, func() { w.handleMessage(ctx, msg) }) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// claimLoop claims the events that were read, but never acknowledged by
+// other consumers, e.g. because of an application-server restart.
+func (w *worker) claimLoop(ctx context.Context) {
+	key := storage.GetRedisKey(streamKey)
+	ticker := time.NewTicker(claimPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pending, err := storage.RedisClient().XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: key,
+			Group:  consumerGroup,
+			Start:  "-",
+			End:    "+",
+			Count:  readCount,
+		}).Result()
+		if err != nil {
+			log.WithError(err).Error("integration/outbox: get pending events error")
+			continue
+		}
+
+		var ids []string
+		for _, p := range pending {
+			if p.Idle >= claimIdle {
+				ids = append(ids, p.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		msgs, err := storage.RedisClient().XClaim(ctx, &redis.XClaimArgs{
+			Stream:   key,
+			Group:    consumerGroup,
+			Consumer: w.consumer,
+			MinIdle:  claimIdle,
+			Messages: ids,
+		}).Result()
+		if err != nil {
+			log.WithError(err).Error("integration/outbox: claim pending events error")
+			continue
+		}
+
+		for _, msg := range msgs {
+			msg := msg
+			if !w.run(ctx, func() { w.handleMessage(ctx, msg) }) {
+				return
+			}
+		}
+	}
+}
+
+// handleMessage delivers the event in the given stream message to all the
+// integrations of the application. Failed deliveries are scheduled for retry.
+// The event is only removed from the stream when it was delivered to, or
+// scheduled for retry for all the integrations. Else it stays pending and
+// is claimed again after claimIdle.
+func (w *worker) handleMessage(ctx context.Context, msg redis.XMessage) {
+	s, _ := msg.Values["event"].(string)
+	e, err := unmarshalEvent(s)
+	if err != nil {
+		log.WithError(err).WithField("stream_id", msg.ID).Error("integration/outbox: decode event error")
+		w.ack(ctx, msg.ID)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed bool
+	for _, name := range w.dispatcher.Integrations(e.ApplicationID) {
+		wg.Add(1)
+
+		go func(e Event) {
+			defer wg.Done()
+			if _, err := w.deliverEvent(ctx, e); err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(e.withIntegration(name))
+	}
+	wg.Wait()
+
+	if failed {
+		log.WithField("stream_id", msg.ID).Error("integration/outbox: event could not be delivered or scheduled for retry, keeping it pending")
+		return
+	}
+
+	w.ack(ctx, msg.ID)
+}
+
+// ack acknowledges and removes the given event from the outbox stream.
+func (w *worker) ack(ctx context.Context, id string) {
+	key := storage.GetRedisKey(streamKey)
+
+	pipe := storage.RedisClient().Pipeline()
+	pipe.XAck(ctx, key, consumerGroup, id)
+	pipe.XDel(ctx, key, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.WithError(err).WithField("stream_id", id).Error("integration/outbox: acknowledge event error")
+	}
+}
+
+// retryLoop delivers the events that are scheduled for retry. On every poll,
+// the due events are handled until none are left.
+func (w *worker) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(retryPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := w.retryEvents(ctx)
+			if err != nil {
+				log.WithError(err).Error("integration/outbox: get retry events error")
+				break
+			}
+			if n < retryCount || ctx.Err() != nil {
+				break
+			}
+		}
+	}
+}
+
+// retryEvents claims and delivers (at most retryCount) events that are due
+// for retry. It returns the number of due events.
+func (w *worker) retryEvents(ctx context.Context) (int, error) {
+	key := storage.GetRedisKey(retryKey)
+
+	members, err := storage.RedisClient().ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   formatScore(time.Now()),
+		Count: retryCount,
+	}).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "redis zrangebyscore error")
+	}
+
+	for _, m := range members {
+		// only the instance that claimed the event is allowed to retry
+		// it, the event is removed after it has been handled
+		now := time.Now()
+		n, err := claimRetryScript.Run(ctx, storage.RedisClient(), []string{key}, m, formatScore(now), formatScore(now.Add(retryLease))).Int()
+		if err != nil {
+			log.WithError(err).Error("integration/outbox: claim retry event error")
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+
+		e, err := unmarshalEvent(m)
+		if err != nil {
+			log.WithError(err).Error("integration/outbox: decode event error")
+			storage.RedisClient().ZRem(ctx, key, m)
+			continue
+		}
+
+		m := m
+		ok := w.run(ctx, func() {
+			outboxEventCounter("retried").Inc()
+
+			// The event is removed, unless it was re-scheduled as-is. When
+			// it could not be re-scheduled, it is retried again after the
+			// lease has expired.
+			next, err := w.deliverEvent(ctx, e)
+			if err != nil || next == m {
+				return
+			}
+			if err := storage.RedisClient().ZRem(ctx, key, m).Err(); err != nil {
+				log.WithError(err).Error("integration/outbox: remove retry event error")
+			}
+		})
+		if !ok {
+			return len(members), ctx.Err()
+		}
+	}
+
+	return len(members), nil
+}
+
+// deliverEvent delivers the given event to its integration. When the
+// integration is failing, the event is scheduled for delivery after the
+// backoff of the integration has expired. Failed deliveries are scheduled for
+// retry, or moved to the dead-letter store. It returns the retry set member
+// when the event was scheduled for retry, and an error when the failed event
+// could not be scheduled for retry or moved to the dead-letter store.
+func (w *worker) deliverEvent(ctx context.Context, e Event) (string, error) {
+	logFields := log.Fields{
+		"application_id": e.ApplicationID,
+		"integration":    e.Integration,
+		"event_id":       e.ID,
+	}
+
+	until, err := integrationBackoff(ctx, e.ApplicationID, e.Integration)
+	if err != nil {
+		log.WithError(err).WithFields(logFields).Error("integration/outbox: get integration backoff error")
+	}
+
+	if until.After(time.Now()) {
+		m, err := scheduleRetry(ctx, e, until)
+		if err != nil {
+			log.WithError(err).WithFields(logFields).Error("integration/outbox: schedule retry error")
+			return "", err
+		}
+		return m, nil
+	}
+
+	if err := w.deliver(ctx, &e); err != nil {
+		return w.handleError(ctx, e, err)
+	}
+
+	if !until.IsZero() {
+		if err := resetBackoff(ctx, e.ApplicationID, e.Integration); err != nil {
+			log.WithError(err).WithFields(logFields).Error("integration/outbox: reset integration backoff error")
+		}
+	}
+
+	return "", nil
+}
+
+// deliver delivers the given event to its integration. On error, the targets
+// to which the event was delivered are stored in the event, so that these
+// are skipped on retry.
+func (w *worker) deliver(ctx context.Context, e *Event) error {
+	h := w.dispatcher.Handler(e.ApplicationID, e.Integration)
+	if h == nil {
+		log.WithFields(log.Fields{
+			"application_id": e.ApplicationID,
+			"integration":    e.Integration,
+			"event_id":       e.ID,
+		}).Warning("integration/outbox: integration does not exist, dropping event")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, eventTimeout)
+	defer cancel()
+
+	rec := models.NewDeliveryRecorder(e.Delivered)
+	ctx = models.WithDeliveryRecorder(ctx, rec)
+
+	if err := e.deliver(ctx, h, New(e.ApplicationID, nil)); err != nil {
+		e.Delivered = rec.Targets()
+		return err
+	}
+
+	outboxEventCounter("delivered").Inc()
+	return nil
+}
+
+// handleError schedules the failed event for retry after the backoff of the
+// integration, or moves it to the dead-letter store when it exceeds the max.
+// age. It returns the retry set member when the event was scheduled for
+// retry, and an error when scheduling or dead-lettering the event failed.
+func (w *worker) handleError(ctx context.Context, e Event, err error) (string, error) {
+	e.Attempts++
+	e.Error = err.Error()
+
+	logFields := log.Fields{
+		"application_id": e.ApplicationID,
+		"integration":    e.Integration,
+		"event_id":       e.ID,
+		"attempts":       e.Attempts,
+	}
+
+	if time.Since(e.CreatedAt) > maxAge {
+		log.WithError(err).WithFields(logFields).Error("integration/outbox: max. age exceeded, moving event to dead-letter store")
+
+		if err := deadLetter(ctx, e); err != nil {
+			log.WithError(err).WithFields(logFields).Error("integration/outbox: dead-letter event error")
+			return "", err
+		}
+		return "", nil
+	}
+
+	backoff, bErr := recordFailure(ctx, e.ApplicationID, e.Integration)
+	if bErr != nil {
+		log.WithError(bErr).WithFields(logFields).Error("integration/outbox: record integration failure error")
+		backoff = retryBackoff(e.Attempts)
+	}
+	log.WithError(err).WithFields(logFields).WithField("retry_in", backoff).Warning("integration/outbox: integration error, scheduling retry")
+
+	m, err := scheduleRetry(ctx, e, time.Now().Add(backoff))
+	if err != nil {
+		log.WithError(err).WithFields(logFields).Error("integration/outbox: schedule retry error")
+		return "", err
+	}
+	return m, nil
+}
+
+// withIntegration returns a copy of the event for the given integration.
+func (e Event) withIntegration(name string) Event {
+	e.Integration = name
+	return e
+}
+
+func formatScore(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,222 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/eventlog"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/chirpstack-application-server/internal/test"
+	"github.com/brocaar/lorawan"
+)
+
+type testHandler struct {
+	models.IntegrationHandler
+
+	mu      sync.Mutex
+	err     error
+	uplinks chan pb.UplinkEvent
+}
+
+func (h *testHandler) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	h.mu.Lock()
+	err := h.err
+	h.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	h.uplinks <- pl
+	return nil
+}
+
+func (h *testHandler) setError(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+}
+
+type testDispatcher struct {
+	handler *testHandler
+}
+
+func (d testDispatcher) Integrations(applicationID int64) []string {
+	return []string{"test"}
+}
+
+func (d testDispatcher) Handler(applicationID int64, name string) models.IntegrationHandler {
+	if name == "test" {
+		return d.handler
+	}
+	return nil
+}
+
+type OutboxTestSuite struct {
+	suite.Suite
+
+	handler *testHandler
+	cancel  context.CancelFunc
+}
+
+func (ts *OutboxTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	conf.ApplicationServer.Integration.Outbox.MaxAge = time.Second
+	conf.ApplicationServer.Integration.Outbox.RetryInitialInterval = 100 * time.Millisecond
+	conf.ApplicationServer.Integration.Outbox.RetryMaxInterval = 200 * time.Millisecond
+	conf.ApplicationServer.Integration.Outbox.DeadLetterMaxHistory = 10
+
+	assert.NoError(storage.Setup(conf))
+	assert.NoError(Setup(conf))
+	storage.RedisClient().FlushAll(context.Background())
+
+	ts.handler = &testHandler{
+		uplinks: make(chan pb.UplinkEvent, 10),
+	}
+
+	var ctx context.Context
+	ctx, ts.cancel = context.WithCancel(context.Background())
+	assert.NoError(Start(ctx, testDispatcher{handler: ts.handler}))
+}
+
+func (ts *OutboxTestSuite) TearDownSuite() {
+	ts.cancel()
+}
+
+func (ts *OutboxTestSuite) TestDelivery() {
+	assert := require.New(ts.T())
+	i := New(1, nil)
+
+	assert.NoError(i.HandleUplinkEvent(context.Background(), nil, pb.UplinkEvent{
+		ApplicationId: 1,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		FPort:         10,
+	}))
+
+	select {
+	case pl := <-ts.handler.uplinks:
+		assert.EqualValues(10, pl.FPort)
+	case <-time.After(5 * time.Second):
+		ts.T().Fatal("timeout")
+	}
+}
+
+func (ts *OutboxTestSuite) TestRetryAndDeadLetter() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+	i := New(2, nil)
+
+	ts.handler.setError(errors.New("endpoint down"))
+
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, pb.UplinkEvent{
+		ApplicationId: 2,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		FPort:         20,
+	}))
+
+	// the event is retried until it exceeds the max. age
+	var events []Event
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		var err error
+		events, err = GetDeadLetterEvents(ctx, 2, 10)
+		assert.NoError(err)
+		if len(events) != 0 {
+			break
+		}
+	}
+	assert.Len(events, 1)
+	assert.Equal("test", events[0].Integration)
+	assert.Equal(eventlog.Uplink, events[0].Type)
+	assert.Equal(lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}, events[0].DevEUI)
+	assert.Equal("endpoint down", events[0].Error)
+	assert.True(events[0].Attempts > 1)
+	assert.NotNil(events[0].DeadLetteredAt)
+
+	count, err := GetDeadLetterEventCount(ctx, 2)
+	assert.NoError(err)
+	assert.Equal(1, count)
+
+	// the backoff is tracked per integration
+	until, err := integrationBackoff(ctx, 2, "test")
+	assert.NoError(err)
+	assert.False(until.IsZero())
+
+	ts.T().Run("Replay", func(t *testing.T) {
+		assert := require.New(t)
+		ts.handler.setError(nil)
+
+		n, err := ReplayDeadLetterEvents(ctx, 2, []string{events[0].StreamID})
+		assert.NoError(err)
+		assert.Equal(1, n)
+
+		select {
+		case pl := <-ts.handler.uplinks:
+			assert.EqualValues(20, pl.FPort)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+
+		count, err := GetDeadLetterEventCount(ctx, 2)
+		assert.NoError(err)
+		assert.Equal(0, count)
+
+		// the backoff is reset after a successful delivery
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(100 * time.Millisecond) {
+			until, err = integrationBackoff(ctx, 2, "test")
+			assert.NoError(err)
+			if until.IsZero() {
+				break
+			}
+		}
+		assert.True(until.IsZero())
+	})
+
+	ts.T().Run("Purge", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(deadLetter(ctx, Event{ApplicationID: 2, Type: eventlog.Uplink}))
+		assert.NoError(deadLetter(ctx, Event{ApplicationID: 2, Type: eventlog.Uplink}))
+
+		count, err := GetDeadLetterEventCount(ctx, 2)
+		assert.NoError(err)
+		assert.Equal(2, count)
+
+		assert.NoError(PurgeDeadLetterEvents(ctx, 2, nil))
+
+		count, err = GetDeadLetterEventCount(ctx, 2)
+		assert.NoError(err)
+		assert.Equal(0, count)
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retryInitialInterval = time.Second
+	retryMaxInterval = 5 * time.Second
+
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{100, 5 * time.Second},
+	}
+
+	for _, tst := range tests {
+		require.Equal(t, tst.expected, retryBackoff(tst.attempts))
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	suite.Run(t, new(OutboxTestSuite))
+}
@@ -39,8 +39,20 @@ type Integration struct {
 	marshaler marshaler.Type
 }
 
-// New creates a new PostgreSQL integration.
+// New creates a new PostgreSQL integration. It blocks until the database
+// is reachable.
 func New(m marshaler.Type, conf config.IntegrationPostgreSQLConfig) (*Integration, error) {
+	return newIntegration(m, conf, true)
+}
+
+// NewForApplication creates a new PostgreSQL integration for a single
+// application. Unlike New, it returns an error when the database is not
+// reachable.
+func NewForApplication(m marshaler.Type, conf config.IntegrationPostgreSQLConfig) (*Integration, error) {
+	return newIntegration(m, conf, false)
+}
+
+func newIntegration(m marshaler.Type, conf config.IntegrationPostgreSQLConfig, retry bool) (*Integration, error) {
 	// In case of (binary) Protobuf marshaler, use JSON Protobuf mapping as we
 	// write the output to a jsonb field.
 	if m == marshaler.Protobuf {
@@ -54,6 +66,11 @@ func New(m marshaler.Type, conf config.IntegrationPostgreSQLConfig) (*Integratio
 	}
 	for {
 		if err := d.Ping(); err != nil {
+			if !retry {
+				d.Close()
+				return nil, errors.Wrap(err, "integration/postgresql: ping PostgreSQL database error")
+			}
+
 			log.WithError(err).Warning("integration/postgresql: ping PostgreSQL database error, will retry in 2s")
 			time.Sleep(2 * time.Second)
 		} else {
@@ -0,0 +1,257 @@
+// Package redisstreams implements a Redis Streams integration.
+package redisstreams
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"strconv"
+	"text/template"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+// applicationStreamKey defines the key under which the streams of the
+// application integrations are stored when using the Redis instance of the
+// application-server. This prevents these integrations from writing to keys
+// used by the application-server or by other applications.
+const applicationStreamKey = "lora:as:integration:stream:%d:%s"
+
+// Integration implements a Redis Streams integration.
+type Integration struct {
+	client      redis.UniversalClient
+	ownClient   bool
+	application bool
+
+	marshaler       marshaler.Type
+	payloadTemplate *marshaler.Template
+	streamKey       *template.Template
+	maxLen          int64
+}
+
+// New creates a new Redis Streams integration.
+func New(m marshaler.Type, conf config.IntegrationRedisStreamsConfig) (*Integration, error) {
+	return newIntegration(m, conf, false)
+}
+
+// NewForApplication creates a new Redis Streams integration for a single
+// application. When using the Redis instance of the application-server, the
+// stream keys are prefixed by the application ID. The stream key template
+// must not depend on the DevEUI (see ValidateApplicationStreamKeyTemplate).
+func NewForApplication(m marshaler.Type, conf config.IntegrationRedisStreamsConfig) (*Integration, error) {
+	return newIntegration(m, conf, true)
+}
+
+func newIntegration(m marshaler.Type, conf config.IntegrationRedisStreamsConfig, application bool) (*Integration, error) {
+	var err error
+	i := Integration{
+		application: application,
+		marshaler:   m,
+		maxLen:      conf.MaxLen,
+	}
+
+	if conf.PayloadTemplate != "" {
+		i.payloadTemplate, err = marshaler.ParseTemplate(conf.PayloadTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse payload template error")
+		}
+	}
+
+	i.streamKey, err = template.New("stream").Parse(conf.StreamKeyTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse stream key template error")
+	}
+
+	if application {
+		if err := validateApplicationStreamKey(i.streamKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.Server == "" {
+		i.client = storage.RedisClient()
+		return &i, nil
+	}
+
+	opts := redis.Options{
+		Addr:     conf.Server,
+		Password: conf.Password,
+		DB:       conf.Database,
+	}
+	if conf.TLSEnabled {
+		opts.TLSConfig = &tls.Config{}
+	}
+
+	log.WithField("server", conf.Server).Info("integration/redisstreams: connecting to redis")
+	i.client = redis.NewClient(&opts)
+	i.ownClient = true
+
+	if application {
+		if err := i.client.Ping(context.Background()).Err(); err != nil {
+			i.client.Close()
+			return nil, errors.Wrap(err, "ping redis error")
+		}
+	}
+
+	return &i, nil
+}
+
+// HandleUplinkEvent sends an UplinkEvent.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "up", &pl)
+}
+
+// HandleJoinEvent sends a JoinEvent.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "join", &pl)
+}
+
+// HandleAckEvent sends an AckEvent.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "ack", &pl)
+}
+
+// HandleErrorEvent sends an ErrorEvent.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "error", &pl)
+}
+
+// HandleStatusEvent sends a StatusEvent.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "status", &pl)
+}
+
+// HandleLocationEvent sends a LocationEvent.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "location", &pl)
+}
+
+// HandleTxAckEvent sends a TxAckEvent.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "txack", &pl)
+}
+
+// HandleIntegrationEvent sends an IntegrationEvent.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return i.publishEvent(ctx, pl.ApplicationId, pl.DevEui, "integration", &pl)
+}
+
+// DataDownChan returns nil.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
+
+// Close closes the integration. The Redis client of the application-server
+// is not closed.
+func (i *Integration) Close() error {
+	if i.ownClient {
+		return i.client.Close()
+	}
+	return nil
+}
+
+// ValidateApplicationStreamKeyTemplate validates the stream key template of a
+// per-application integration. As each stream is trimmed to the max. length
+// individually, a key per device would allow the number of devices times the
+// max. length entries per application.
+func ValidateApplicationStreamKeyTemplate(s string) error {
+	t, err := template.New("stream").Parse(s)
+	if err != nil {
+		return errors.Wrap(err, "parse stream key template error")
+	}
+	return validateApplicationStreamKey(t)
+}
+
+// validateApplicationStreamKey returns an error when the key returned by the
+// given template depends on the DevEUI.
+func validateApplicationStreamKey(t *template.Template) error {
+	var keys [2]string
+	for n, devEUI := range []lorawan.EUI64{{}, {1, 2, 3, 4, 5, 6, 7, 8}} {
+		key := bytes.NewBuffer(nil)
+		err := t.Execute(key, struct {
+			ApplicationID uint64
+			DevEUI        lorawan.EUI64
+			EventType     string
+		}{1, devEUI, "up"})
+		if err != nil {
+			return errors.Wrap(err, "execute stream key template error")
+		}
+		keys[n] = key.String()
+	}
+
+	if keys[0] != keys[1] {
+		return errors.New("stream key template must not contain the DevEUI")
+	}
+	return nil
+}
+
+// key returns the stream key for the given event.
+func (i *Integration) key(applicationID uint64, devEUI lorawan.EUI64, typ string) (string, error) {
+	key := bytes.NewBuffer(nil)
+	err := i.streamKey.Execute(key, struct {
+		ApplicationID uint64
+		DevEUI        lorawan.EUI64
+		EventType     string
+	}{applicationID, devEUI, typ})
+	if err != nil {
+		return "", errors.Wrap(err, "execute template error")
+	}
+
+	switch {
+	case i.ownClient:
+		return key.String(), nil
+	case i.application:
+		return storage.GetRedisKey(applicationStreamKey, applicationID, key.String()), nil
+	default:
+		return storage.GetRedisKey("%s", key.String()), nil
+	}
+}
+
+func (i *Integration) publishEvent(ctx context.Context, applicationID uint64, devEUIB []byte, typ string, msg proto.Message) error {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], devEUIB)
+
+	key, err := i.key(applicationID, devEUI, typ)
+	if err != nil {
+		return err
+	}
+
+	b, err := marshaler.MarshalEvent(i.marshaler, i.payloadTemplate, msg)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"key":    key,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("integration/redisstreams: publishing event")
+
+	// Trimming is approximate, which is much more efficient and guarantees
+	// that at least max_len entries are kept.
+	err = i.client.XAdd(ctx, &redis.XAddArgs{
+		Stream:       key,
+		MaxLenApprox: i.maxLen,
+		Values: map[string]interface{}{
+			"event":          typ,
+			"application_id": strconv.FormatUint(applicationID, 10),
+			"dev_eui":        devEUI.String(),
+			"payload":        b,
+		},
+	}).Err()
+	if err != nil {
+		return errors.Wrap(err, "redis xadd error")
+	}
+
+	return nil
+}
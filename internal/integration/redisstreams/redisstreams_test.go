@@ -0,0 +1,161 @@
+package redisstreams
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/chirpstack-application-server/internal/test"
+)
+
+type IntegrationTestSuite struct {
+	suite.Suite
+
+	conf config.Config
+}
+
+func (ts *IntegrationTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	ts.conf = test.GetConfig()
+	assert.NoError(storage.Setup(ts.conf))
+}
+
+func (ts *IntegrationTestSuite) SetupTest() {
+	storage.RedisClient().FlushAll(context.Background())
+}
+
+func (ts *IntegrationTestSuite) TestApplicationServerRedis() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	i, err := NewForApplication(marshaler.Protobuf, config.IntegrationRedisStreamsConfig{
+		StreamKeyTemplate: "events:{{ .EventType }}",
+		MaxLen:            100,
+	})
+	assert.NoError(err)
+	defer i.Close()
+
+	pl := pb.UplinkEvent{
+		ApplicationId: 10,
+		DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		FPort:         10,
+		Data:          []byte{1, 2, 3},
+	}
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pl))
+
+	msgs, err := storage.RedisClient().XRange(ctx, storage.GetRedisKey("lora:as:integration:stream:10:events:up"), "-", "+").Result()
+	assert.NoError(err)
+	assert.Len(msgs, 1)
+	assert.Equal("up", msgs[0].Values["event"])
+	assert.Equal("10", msgs[0].Values["application_id"])
+	assert.Equal("0102030405060708", msgs[0].Values["dev_eui"])
+
+	var plReceived pb.UplinkEvent
+	assert.NoError(proto.Unmarshal([]byte(msgs[0].Values["payload"].(string)), &plReceived))
+	assert.True(proto.Equal(&pl, &plReceived))
+
+	ts.T().Run("Close does not close the shared client", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(i.Close())
+		assert.NoError(storage.RedisClient().Ping(ctx).Err())
+	})
+}
+
+func (ts *IntegrationTestSuite) TestSeparateRedis() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	i, err := NewForApplication(marshaler.JSONV3, config.IntegrationRedisStreamsConfig{
+		Server:            ts.conf.Redis.Servers[0],
+		StreamKeyTemplate: "application:{{ .ApplicationID }}:events",
+		MaxLen:            2,
+	})
+	assert.NoError(err)
+	defer i.Close()
+
+	for n := 0; n < 5; n++ {
+		assert.NoError(i.HandleStatusEvent(ctx, nil, nil, pb.StatusEvent{
+			ApplicationId: 10,
+			DevEui:        []byte{1, 2, 3, 4, 5, 6, 7, 8},
+			Margin:        int32(n),
+		}))
+	}
+
+	msgs, err := storage.RedisClient().XRange(ctx, "application:10:events", "-", "+").Result()
+	assert.NoError(err)
+	assert.True(len(msgs) >= 2 && len(msgs) <= 5, fmt.Sprintf("unexpected stream length %d", len(msgs)))
+	assert.Equal("status", msgs[len(msgs)-1].Values["event"])
+
+	ts.T().Run("Unreachable server", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := NewForApplication(marshaler.JSONV3, config.IntegrationRedisStreamsConfig{
+			Server:            "localhost:1",
+			StreamKeyTemplate: "events",
+		})
+		assert.Error(err)
+	})
+}
+
+func TestValidateApplicationStreamKeyTemplate(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Template      string
+		ExpectedError bool
+	}{
+		{
+			Name:     "application and event type",
+			Template: "application:{{ .ApplicationID }}:{{ .EventType }}",
+		},
+		{
+			Name:          "deveui",
+			Template:      "device:{{ .DevEUI }}",
+			ExpectedError: true,
+		},
+		{
+			Name:          "deveui in condition",
+			Template:      `events{{ if eq .DevEUI.String "0102030405060708" }}:1{{ end }}`,
+			ExpectedError: true,
+		},
+		{
+			Name:          "invalid template",
+			Template:      "{{ .DevEUI",
+			ExpectedError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			err := ValidateApplicationStreamKeyTemplate(tst.Template)
+			if tst.ExpectedError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		})
+	}
+
+	t.Run("NewForApplication", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := NewForApplication(marshaler.JSONV3, config.IntegrationRedisStreamsConfig{
+			Server:            "localhost:1",
+			StreamKeyTemplate: "device:{{ .DevEUI }}",
+			MaxLen:            100,
+		})
+		assert.EqualError(err, "stream key template must not contain the DevEUI")
+	})
+}
+
+func TestIntegration(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
+}
@@ -0,0 +1,506 @@
+// Package s3archive implements an object-storage (S3 compatible) archive
+// integration. Events are buffered per application and hour and written as
+// gzip compressed NDJSON objects.
+package s3archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/health"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/lorawan"
+)
+
+// Defaults used when the corresponding option is not set.
+const (
+	defaultFlushInterval = 5 * time.Minute
+	defaultMaxBufferSize = 64 * 1024 * 1024
+
+	// maxPendingBuffers defines the max. size of the objects waiting to be
+	// uploaded (e.g. when the upload failed), in number of buffers. Once
+	// exceeded, the oldest objects are dropped.
+	maxPendingBuffers = 4
+
+	// maxOpenBuffers defines the max. number of buffers (application and
+	// hour) that are open at the same time. As each buffer holds a gzip
+	// writer, all buffers are written once exceeded.
+	maxOpenBuffers = 64
+
+	// integrationName is the name under which the upload errors are
+	// recorded in the integration health.
+	integrationName = "s3_archive"
+)
+
+// retryBackoff is the initial backoff before retrying a failed upload. It is
+// doubled on every retry.
+var retryBackoff = time.Second
+
+// partition identifies the buffer to which an event is written.
+type partition struct {
+	ApplicationID uint64
+	Hour          time.Time
+}
+
+// buffer contains the gzip compressed events of a partition.
+type buffer struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+}
+
+// object contains an object to upload.
+type object struct {
+	ApplicationID uint64
+	Key           string
+	Body          []byte
+}
+
+// line contains a single (NDJSON) line of an archived object.
+type line struct {
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Integration implements the object-storage archive integration.
+type Integration struct {
+	uploader       s3manageriface.UploaderAPI
+	bucket         string
+	keyPrefix      string
+	maxBufferSize  int64
+	maxPendingSize int64
+	maxRetries     int
+
+	// recordError records the given upload error of the given application
+	// in the integration health.
+	recordError func(applicationID uint64, err error)
+
+	mu      sync.Mutex
+	buffers map[partition]*buffer
+	pending []object
+
+	// bufferedSize and pendingSize contain the (uncompressed) size of the
+	// buffers and the size of the pending objects.
+	bufferedSize int64
+	pendingSize  int64
+
+	// uploadMu makes sure that objects are not uploaded twice by concurrent
+	// flushes.
+	uploadMu sync.Mutex
+
+	wg    sync.WaitGroup
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// New creates a new object-storage archive integration.
+func New(conf config.IntegrationS3ArchiveConfig) (*Integration, error) {
+	if conf.Bucket == "" {
+		return nil, errors.New("bucket must be set")
+	}
+
+	i := Integration{
+		bucket:        conf.Bucket,
+		keyPrefix:     conf.KeyPrefix,
+		maxBufferSize: conf.MaxBufferSize,
+		maxRetries:    conf.MaxRetries,
+		recordError:   recordError,
+		buffers:       make(map[partition]*buffer),
+		flush:         make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+
+	if i.maxBufferSize == 0 {
+		i.maxBufferSize = defaultMaxBufferSize
+	}
+	i.maxPendingSize = i.maxBufferSize * maxPendingBuffers
+
+	awsConf := aws.Config{
+		Region:           aws.String(conf.Region),
+		S3ForcePathStyle: aws.Bool(conf.ForcePathStyle),
+
+		// the failed uploads are retried by uploadObject
+		MaxRetries: aws.Int(0),
+	}
+	if conf.AccessKeyID != "" || conf.SecretAccessKey != "" {
+		awsConf.Credentials = credentials.NewStaticCredentials(conf.AccessKeyID, conf.SecretAccessKey, "")
+	}
+	if conf.Endpoint != "" {
+		awsConf.Endpoint = aws.String(conf.Endpoint)
+	}
+
+	log.WithFields(log.Fields{
+		"endpoint": conf.Endpoint,
+		"bucket":   conf.Bucket,
+	}).Info("integration/s3archive: setting up session")
+	sess, err := session.NewSession(&awsConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "new session error")
+	}
+
+	i.uploader = s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		if conf.PartSize != 0 {
+			u.PartSize = conf.PartSize
+		}
+	})
+
+	flushInterval := conf.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = defaultFlushInterval
+	}
+
+	i.wg.Add(1)
+	go i.flushLoop(flushInterval)
+
+	return &i, nil
+}
+
+// HandleUplinkEvent archives an UplinkEvent.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "up", pl.PublishedAt, &pl)
+}
+
+// HandleJoinEvent archives a JoinEvent.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "join", pl.PublishedAt, &pl)
+}
+
+// HandleAckEvent archives an AckEvent.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "ack", pl.PublishedAt, &pl)
+}
+
+// HandleErrorEvent archives an ErrorEvent.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "error", pl.PublishedAt, &pl)
+}
+
+// HandleStatusEvent archives a StatusEvent.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "status", pl.PublishedAt, &pl)
+}
+
+// HandleLocationEvent archives a LocationEvent.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "location", pl.PublishedAt, &pl)
+}
+
+// HandleTxAckEvent archives a TxAckEvent.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "txack", pl.PublishedAt, &pl)
+}
+
+// HandleIntegrationEvent archives an IntegrationEvent.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return i.addEvent(ctx, pl.ApplicationId, pl.DevEui, "integration", pl.PublishedAt, &pl)
+}
+
+// DataDownChan returns nil.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
+
+// Close writes the buffered events and closes the integration. The objects
+// which still fail to upload are dropped and recorded as failed in the
+// integration health of their application.
+func (i *Integration) Close() error {
+	close(i.done)
+	i.wg.Wait()
+	err := i.Flush(context.Background())
+
+	i.mu.Lock()
+	dropped := i.pending
+	i.pending = nil
+	i.pendingSize = 0
+	i.mu.Unlock()
+
+	for _, obj := range dropped {
+		i.drop(obj, "integration closed")
+	}
+
+	if len(dropped) != 0 {
+		return errors.Wrapf(err, "%d objects dropped", len(dropped))
+	}
+	return err
+}
+
+// Flush writes the buffered events. Objects that could not be uploaded are
+// kept and retried on the next flush.
+func (i *Integration) Flush(ctx context.Context) error {
+	i.mu.Lock()
+	objErr := i.closeBuffers()
+	i.mu.Unlock()
+
+	if err := i.upload(ctx); err != nil {
+		return err
+	}
+	if objErr != nil {
+		return errors.Wrap(objErr, "get object error")
+	}
+	return nil
+}
+
+func (i *Integration) flushLoop(interval time.Duration) {
+	defer i.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := i.Flush(context.Background()); err != nil {
+				log.WithError(err).Error("integration/s3archive: flush error")
+			}
+		case <-i.flush:
+			if err := i.upload(context.Background()); err != nil {
+				log.WithError(err).Error("integration/s3archive: upload error")
+			}
+		case <-i.done:
+			return
+		}
+	}
+}
+
+// closeBuffers closes the open buffers and adds these to the objects waiting
+// to be uploaded. The caller must hold mu.
+func (i *Integration) closeBuffers() error {
+	var objErr error
+	objects := make([]object, 0, len(i.buffers))
+	for p, b := range i.buffers {
+		obj, err := i.getObject(p, b)
+		if err != nil {
+			log.WithError(err).WithField("application_id", p.ApplicationID).Error("integration/s3archive: get object error, dropping buffer")
+			i.recordError(p.ApplicationID, errors.Wrap(err, "get object error"))
+			objErr = err
+			continue
+		}
+		objects = append(objects, obj)
+	}
+
+	i.buffers = make(map[partition]*buffer)
+	i.bufferedSize = 0
+	i.addPending(objects, false)
+
+	return objErr
+}
+
+// drop logs the given dropped object and records it as failed in the
+// integration health of its application.
+func (i *Integration) drop(obj object, reason string) {
+	log.WithFields(log.Fields{
+		"key":    obj.Key,
+		"size":   len(obj.Body),
+		"reason": reason,
+	}).Error("integration/s3archive: dropping object which failed to upload")
+	i.recordError(obj.ApplicationID, errors.Errorf("object %s dropped: %s", obj.Key, reason))
+}
+
+// addPending adds the given objects to the objects waiting to be uploaded,
+// in front when the objects failed to upload before. When the max. pending
+// size is exceeded, the oldest objects are dropped. The caller must hold mu.
+func (i *Integration) addPending(objects []object, front bool) {
+	if front {
+		i.pending = append(objects, i.pending...)
+	} else {
+		i.pending = append(i.pending, objects...)
+	}
+	for _, obj := range objects {
+		i.pendingSize += int64(len(obj.Body))
+	}
+
+	for len(i.pending) > 1 && i.pendingSize > i.maxPendingSize {
+		obj := i.pending[0]
+		i.pending = i.pending[1:]
+		i.pendingSize -= int64(len(obj.Body))
+
+		i.drop(obj, "max. pending size exceeded")
+	}
+}
+
+func (i *Integration) addEvent(ctx context.Context, applicationID uint64, devEUIB []byte, typ string, publishedAt *timestamp.Timestamp, msg proto.Message) error {
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], devEUIB)
+
+	ts := time.Now()
+	if publishedAt != nil {
+		if t, err := ptypes.Timestamp(publishedAt); err == nil {
+			ts = t
+		}
+	}
+
+	payload, err := marshaler.Marshal(marshaler.ProtobufJSON, msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal event error")
+	}
+
+	b, err := json.Marshal(line{Event: typ, Payload: payload})
+	if err != nil {
+		return errors.Wrap(err, "marshal line error")
+	}
+	b = append(b, '\n')
+
+	p := partition{
+		ApplicationID: applicationID,
+		Hour:          ts.UTC().Truncate(time.Hour),
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"event":   typ,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("integration/s3archive: adding event to buffer")
+
+	i.mu.Lock()
+	buf, ok := i.buffers[p]
+	if !ok {
+		buf = &buffer{}
+		buf.gz = gzip.NewWriter(&buf.buf)
+		i.buffers[p] = buf
+	}
+
+	if _, err := buf.gz.Write(b); err != nil {
+		i.mu.Unlock()
+		return errors.Wrap(err, "write buffer error")
+	}
+	i.bufferedSize += int64(len(b))
+
+	if i.bufferedSize < i.maxBufferSize && len(i.buffers) < maxOpenBuffers {
+		i.mu.Unlock()
+		return nil
+	}
+
+	// the buffers are uploaded by the flush goroutine
+	err = i.closeBuffers()
+	i.mu.Unlock()
+
+	select {
+	case i.flush <- struct{}{}:
+	default:
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "get object error")
+	}
+	return nil
+}
+
+// getObject closes the given buffer and returns it as object.
+func (i *Integration) getObject(p partition, b *buffer) (object, error) {
+	if err := b.gz.Close(); err != nil {
+		return object{}, errors.Wrap(err, "close gzip writer error")
+	}
+
+	return object{
+		ApplicationID: p.ApplicationID,
+		Key:           i.getKey(p, time.Now()),
+		Body:          b.buf.Bytes(),
+	}, nil
+}
+
+// getKey returns the object key for the given partition. The key contains
+// the current time and a random ID, so that multiple objects can be written
+// for the same partition.
+func (i *Integration) getKey(p partition, now time.Time) string {
+	return fmt.Sprintf("%sapplication_id=%d/date=%s/hour=%s/%s-%s.ndjson.gz",
+		i.keyPrefix,
+		p.ApplicationID,
+		p.Hour.Format("2006-01-02"),
+		p.Hour.Format("15"),
+		now.UTC().Format("20060102T150405Z"),
+		uuid.Must(uuid.NewV4()),
+	)
+}
+
+// upload uploads the pending objects. Every upload is retried using an
+// exponential backoff, the objects which fail to upload are kept pending.
+func (i *Integration) upload(ctx context.Context) error {
+	i.uploadMu.Lock()
+	defer i.uploadMu.Unlock()
+
+	i.mu.Lock()
+	objects := i.pending
+	i.pending = nil
+	i.pendingSize = 0
+	i.mu.Unlock()
+
+	var failed []object
+	var uploadErr error
+
+	for _, obj := range objects {
+		if err := i.uploadObject(ctx, obj); err != nil {
+			i.recordError(obj.ApplicationID, err)
+			failed = append(failed, obj)
+			uploadErr = err
+		}
+	}
+
+	if len(failed) != 0 {
+		i.mu.Lock()
+		i.addPending(failed, true)
+		i.mu.Unlock()
+
+		return errors.Wrapf(uploadErr, "%d objects failed to upload", len(failed))
+	}
+
+	return nil
+}
+
+func (i *Integration) uploadObject(ctx context.Context, obj object) error {
+	for retry := 0; ; retry++ {
+		_, err := i.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket:      aws.String(i.bucket),
+			Key:         aws.String(obj.Key),
+			Body:        bytes.NewReader(obj.Body),
+			ContentType: aws.String("application/gzip"),
+		})
+		if err == nil {
+			log.WithFields(log.Fields{
+				"key":    obj.Key,
+				"size":   len(obj.Body),
+				"ctx_id": ctx.Value(logging.ContextIDKey),
+			}).Info("integration/s3archive: object uploaded")
+			return nil
+		}
+
+		if retry >= i.maxRetries {
+			return errors.Wrap(err, "upload error")
+		}
+
+		backoff := retryBackoff << retry
+		log.WithError(err).WithFields(log.Fields{
+			"key":     obj.Key,
+			"backoff": backoff,
+		}).Warning("integration/s3archive: upload error, retrying")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "upload error")
+		}
+	}
+}
+
+func recordError(applicationID uint64, err error) {
+	health.Record(context.Background(), int64(applicationID), integrationName, integrationName, err)
+}
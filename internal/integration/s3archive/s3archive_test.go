@@ -0,0 +1,404 @@
+package s3archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/test"
+)
+
+type IntegrationTestSuite struct {
+	suite.Suite
+
+	conf config.IntegrationS3ArchiveConfig
+	s3   *s3.S3
+}
+
+func (ts *IntegrationTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+
+	ts.conf = test.GetConfig().ApplicationServer.Integration.S3Archive
+	ts.conf.KeyPrefix = time.Now().Format("20060102150405") + "/"
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(ts.conf.Endpoint),
+		Region:           aws.String(ts.conf.Region),
+		S3ForcePathStyle: aws.Bool(ts.conf.ForcePathStyle),
+		Credentials:      credentials.NewStaticCredentials(ts.conf.AccessKeyID, ts.conf.SecretAccessKey, ""),
+	})
+	assert.NoError(err)
+	ts.s3 = s3.New(sess)
+
+	_, err = ts.s3.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(ts.conf.Bucket)})
+	if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeBucketAlreadyOwnedByYou || aerr.Code() == s3.ErrCodeBucketAlreadyExists) {
+		err = nil
+	}
+	assert.NoError(err)
+}
+
+// getObjects returns the lines of the archived objects, by object key.
+func (ts *IntegrationTestSuite) getObjects(prefix string) map[string][]line {
+	assert := require.New(ts.T())
+	out := make(map[string][]line)
+
+	list, err := ts.s3.ListObjects(&s3.ListObjectsInput{
+		Bucket: aws.String(ts.conf.Bucket),
+		Prefix: aws.String(prefix),
+	})
+	assert.NoError(err)
+
+	for _, o := range list.Contents {
+		resp, err := ts.s3.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(ts.conf.Bucket),
+			Key:    o.Key,
+		})
+		assert.NoError(err)
+		out[*o.Key] = readLines(ts.T(), resp.Body)
+		resp.Body.Close()
+	}
+
+	return out
+}
+
+func (ts *IntegrationTestSuite) TestPartitions() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	conf := ts.conf
+	conf.KeyPrefix += "partitions/"
+	i, err := New(conf)
+	assert.NoError(err)
+
+	hour1, _ := ptypes.TimestampProto(time.Date(2021, 3, 1, 12, 10, 0, 0, time.UTC))
+	hour2, _ := ptypes.TimestampProto(time.Date(2021, 3, 1, 13, 10, 0, 0, time.UTC))
+
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10, FCnt: 1, PublishedAt: hour1}))
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10, FCnt: 2, PublishedAt: hour1}))
+	assert.NoError(i.HandleJoinEvent(ctx, nil, nil, pb.JoinEvent{ApplicationId: 10, PublishedAt: hour2}))
+	assert.NoError(i.HandleStatusEvent(ctx, nil, nil, pb.StatusEvent{ApplicationId: 11, Margin: 10, PublishedAt: hour1}))
+	assert.NoError(i.Close())
+
+	objects := ts.getObjects(conf.KeyPrefix)
+	assert.Len(objects, 3)
+
+	var keys []string
+	for k := range objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	keyRegexp := func(appID, date, hour string) *regexp.Regexp {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(conf.KeyPrefix) + "application_id=" + appID + "/date=" + date + "/hour=" + hour + "/[0-9]{8}T[0-9]{6}Z-[0-9a-f-]{36}\\.ndjson\\.gz$")
+	}
+
+	assert.Regexp(keyRegexp("10", "2021-03-01", "12"), keys[0])
+	assert.Len(objects[keys[0]], 2)
+	assert.Equal("up", objects[keys[0]][0].Event)
+	assert.JSONEq(`1`, string(getField(ts.T(), objects[keys[0]][0].Payload, "fCnt")))
+	assert.JSONEq(`2`, string(getField(ts.T(), objects[keys[0]][1].Payload, "fCnt")))
+
+	assert.Regexp(keyRegexp("10", "2021-03-01", "13"), keys[1])
+	assert.Len(objects[keys[1]], 1)
+	assert.Equal("join", objects[keys[1]][0].Event)
+
+	assert.Regexp(keyRegexp("11", "2021-03-01", "12"), keys[2])
+	assert.Len(objects[keys[2]], 1)
+	assert.Equal("status", objects[keys[2]][0].Event)
+}
+
+func (ts *IntegrationTestSuite) TestMultipartUpload() {
+	assert := require.New(ts.T())
+	ctx := context.Background()
+
+	conf := ts.conf
+	conf.KeyPrefix += "multipart/"
+	conf.PartSize = s3manager.MinUploadPartSize
+	conf.MaxBufferSize = 8 * 1024 * 1024
+	i, err := New(conf)
+	assert.NoError(err)
+	defer i.Close()
+
+	// random data does not compress, so that the object exceeds the
+	// part size
+	for n := 0; n < 8; n++ {
+		data := make([]byte, 1024*1024)
+		_, err := rand.Read(data)
+		assert.NoError(err)
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10, FCnt: uint32(n), Data: data}))
+	}
+
+	// the full buffer is uploaded by the flush goroutine
+	var objects map[string][]line
+	assert.Eventually(func() bool {
+		objects = ts.getObjects(conf.KeyPrefix)
+		return len(objects) == 1
+	}, 10*time.Second, 100*time.Millisecond)
+	for _, lines := range objects {
+		assert.Len(lines, 6)
+	}
+}
+
+func TestIntegration(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
+}
+
+type uploader struct {
+	s3manageriface.UploaderAPI
+
+	// block, when set, blocks the upload until it is closed
+	block chan struct{}
+
+	mu     sync.Mutex
+	errors []error
+	inputs []*s3manager.UploadInput
+}
+
+func (u *uploader) uploaded() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.inputs)
+}
+
+func (u *uploader) UploadWithContext(ctx aws.Context, in *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if u.block != nil {
+		<-u.block
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.inputs = append(u.inputs, in)
+	if len(u.errors) != 0 {
+		err := u.errors[0]
+		u.errors = u.errors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &s3manager.UploadOutput{}, nil
+}
+
+// recorder records the errors recorded in the integration health.
+type recorder struct {
+	mu     sync.Mutex
+	errors map[uint64][]error
+}
+
+func (r *recorder) record(applicationID uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.errors == nil {
+		r.errors = make(map[uint64][]error)
+	}
+	r.errors[applicationID] = append(r.errors[applicationID], err)
+}
+
+func newTestIntegration(t *testing.T, conf config.IntegrationS3ArchiveConfig) (*Integration, *uploader, *recorder) {
+	conf.Region = "us-east-1"
+	conf.Bucket = "test"
+
+	i, err := New(conf)
+	require.NoError(t, err)
+
+	var u uploader
+	var r recorder
+	i.uploader = &u
+	i.recordError = r.record
+
+	return i, &u, &r
+}
+
+func TestRetry(t *testing.T) {
+	retryBackoff = time.Millisecond
+	ctx := context.Background()
+
+	t.Run("Upload succeeds after retry", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, u, r := newTestIntegration(t, config.IntegrationS3ArchiveConfig{MaxRetries: 2})
+		defer i.Close()
+		u.errors = []error{errors.New("upload error")}
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.NoError(i.Flush(ctx))
+		assert.Len(u.inputs, 2)
+		assert.Equal("test", *u.inputs[1].Bucket)
+		assert.Equal("application/gzip", *u.inputs[1].ContentType)
+
+		lines := readLines(t, u.inputs[1].Body)
+		assert.Len(lines, 1)
+		assert.Equal("up", lines[0].Event)
+		assert.Len(r.errors, 0)
+	})
+
+	t.Run("Failed object is retried on next flush", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, u, r := newTestIntegration(t, config.IntegrationS3ArchiveConfig{MaxRetries: 1})
+		defer i.Close()
+		u.errors = []error{errors.New("upload error"), errors.New("upload error")}
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.Error(i.Flush(ctx))
+		assert.Len(u.inputs, 2)
+		assert.Len(i.pending, 1)
+		assert.Len(r.errors[10], 1)
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 11}))
+		assert.NoError(i.Flush(ctx))
+		assert.Len(u.inputs, 4)
+		assert.Len(i.pending, 0)
+		assert.Equal(*u.inputs[0].Key, *u.inputs[2].Key)
+	})
+
+	t.Run("Failed object is dropped on close", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, u, r := newTestIntegration(t, config.IntegrationS3ArchiveConfig{})
+		u.errors = []error{errors.New("upload error")}
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+		assert.Error(i.Close())
+		assert.Len(u.inputs, 1)
+		assert.Len(i.pending, 0)
+
+		// the failed upload and the dropped object
+		assert.Len(r.errors[10], 2)
+		assert.Contains(r.errors[10][1].Error(), "integration closed")
+	})
+}
+
+func TestFullBuffer(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+
+	i, u, _ := newTestIntegration(t, config.IntegrationS3ArchiveConfig{MaxBufferSize: 1})
+	defer i.Close()
+	u.block = make(chan struct{})
+	i.maxPendingSize = 1024 * 1024
+
+	// the event must not wait for the (blocked) upload
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+	assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10}))
+	assert.Equal(0, u.uploaded())
+
+	close(u.block)
+	assert.Eventually(func() bool {
+		return u.uploaded() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMaxPendingSize(t *testing.T) {
+	assert := require.New(t)
+
+	i, _, r := newTestIntegration(t, config.IntegrationS3ArchiveConfig{})
+	defer i.Close()
+	i.maxPendingSize = 10
+
+	i.mu.Lock()
+	i.addPending([]object{{ApplicationID: 10, Key: "a", Body: make([]byte, 4)}, {ApplicationID: 10, Key: "b", Body: make([]byte, 4)}}, false)
+	i.addPending([]object{{ApplicationID: 10, Key: "c", Body: make([]byte, 4)}}, false)
+	i.mu.Unlock()
+
+	// the oldest object is dropped
+	assert.Len(i.pending, 2)
+	assert.Equal("b", i.pending[0].Key)
+	assert.Equal("c", i.pending[1].Key)
+	assert.EqualValues(8, i.pendingSize)
+	assert.Len(r.errors[10], 1)
+}
+
+func TestMaxBufferedSize(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Combined size", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, u, _ := newTestIntegration(t, config.IntegrationS3ArchiveConfig{MaxBufferSize: 1024})
+		defer i.Close()
+		u.block = make(chan struct{})
+		defer close(u.block)
+
+		// the buffers of all applications count towards the max. size
+		data := make([]byte, 400)
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 10, Data: data}))
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: 11, Data: data}))
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		assert.Len(i.buffers, 0)
+		assert.EqualValues(0, i.bufferedSize)
+	})
+
+	t.Run("Open buffers", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, u, _ := newTestIntegration(t, config.IntegrationS3ArchiveConfig{})
+		defer i.Close()
+		u.block = make(chan struct{})
+		defer close(u.block)
+
+		for n := 1; n < maxOpenBuffers; n++ {
+			assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: uint64(n)}))
+		}
+
+		i.mu.Lock()
+		assert.Len(i.buffers, maxOpenBuffers-1)
+		i.mu.Unlock()
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{ApplicationId: maxOpenBuffers}))
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		assert.Len(i.buffers, 0)
+	})
+}
+
+func readLines(t *testing.T, r io.Reader) []line {
+	assert := require.New(t)
+
+	gz, err := gzip.NewReader(r)
+	assert.NoError(err)
+	defer gz.Close()
+
+	var lines []line
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var l line
+		assert.NoError(json.Unmarshal(scanner.Bytes(), &l))
+		lines = append(lines, l)
+	}
+	assert.NoError(scanner.Err())
+
+	return lines
+}
+
+func getField(t *testing.T, payload json.RawMessage, field string) json.RawMessage {
+	var m map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(payload, &m))
+	return m[field]
+}
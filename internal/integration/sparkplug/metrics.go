@@ -0,0 +1,26 @@
+package sparkplug
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	mc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_sparkplug_message_count",
+		Help: "The number of published messages by the Sparkplug B integration (per message type).",
+	}, []string{"type"})
+
+	cc = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "integration_sparkplug_command_count",
+		Help: "The number of received commands by the Sparkplug B integration (per message type).",
+	}, []string{"type"})
+)
+
+func sparkplugMessageCounter(t string) prometheus.Counter {
+	return mc.With(prometheus.Labels{"type": t})
+}
+
+func sparkplugCommandCounter(t string) prometheus.Counter {
+	return cc.With(prometheus.Labels{"type": t})
+}
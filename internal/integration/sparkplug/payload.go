@@ -0,0 +1,305 @@
+package sparkplug
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DataType defines the Sparkplug B metric data type.
+type DataType uint32
+
+// Sparkplug B metric data types.
+const (
+	Int8     DataType = 1
+	Int16    DataType = 2
+	Int32    DataType = 3
+	Int64    DataType = 4
+	UInt8    DataType = 5
+	UInt16   DataType = 6
+	UInt32   DataType = 7
+	UInt64   DataType = 8
+	Float    DataType = 9
+	Double   DataType = 10
+	Boolean  DataType = 11
+	String   DataType = 12
+	DateTime DataType = 13
+	Text     DataType = 14
+	UUID     DataType = 15
+)
+
+// Field numbers of the Sparkplug B Payload message.
+const (
+	payloadTimestamp protowire.Number = 1
+	payloadMetrics   protowire.Number = 2
+	payloadSeq       protowire.Number = 3
+)
+
+// Field numbers of the Sparkplug B Payload.Metric message.
+const (
+	metricName        protowire.Number = 1
+	metricTimestamp   protowire.Number = 3
+	metricDataType    protowire.Number = 4
+	metricIsNull      protowire.Number = 7
+	metricIntValue    protowire.Number = 10
+	metricLongValue   protowire.Number = 11
+	metricFloatValue  protowire.Number = 12
+	metricDoubleValue protowire.Number = 13
+	metricBoolValue   protowire.Number = 14
+	metricStringValue protowire.Number = 15
+)
+
+// Payload implements the Sparkplug B payload. Only the fields used by this
+// integration are implemented, other fields are ignored on decoding.
+type Payload struct {
+	Timestamp uint64
+	Metrics   []Metric
+
+	// Seq is nil for the NDEATH payload, which does not contain a sequence
+	// number.
+	Seq *uint64
+}
+
+// Metric implements a Sparkplug B metric.
+//
+// Value holds an int64 for the signed integer types, an uint64 for the
+// unsigned integer and DateTime types, a float32 for Float, a float64 for
+// Double, a bool for Boolean and a string for the String, Text and UUID
+// types. It is nil when IsNull is set.
+type Metric struct {
+	Name      string
+	Timestamp uint64
+	DataType  DataType
+	IsNull    bool
+	Value     interface{}
+}
+
+// MarshalBinary encodes the payload using the Protobuf wire format.
+func (p Payload) MarshalBinary() ([]byte, error) {
+	var b []byte
+
+	b = protowire.AppendTag(b, payloadTimestamp, protowire.VarintType)
+	b = protowire.AppendVarint(b, p.Timestamp)
+
+	for _, m := range p.Metrics {
+		mb, err := m.marshal()
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal metric %s error", m.Name)
+		}
+
+		b = protowire.AppendTag(b, payloadMetrics, protowire.BytesType)
+		b = protowire.AppendBytes(b, mb)
+	}
+
+	if p.Seq != nil {
+		b = protowire.AppendTag(b, payloadSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, *p.Seq)
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes the payload from the Protobuf wire format.
+func (p *Payload) UnmarshalBinary(b []byte) error {
+	*p = Payload{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == payloadTimestamp && typ == protowire.VarintType:
+			p.Timestamp, n = protowire.ConsumeVarint(b)
+		case num == payloadSeq && typ == protowire.VarintType:
+			var seq uint64
+			seq, n = protowire.ConsumeVarint(b)
+			p.Seq = &seq
+		case num == payloadMetrics && typ == protowire.BytesType:
+			var mb []byte
+			mb, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				var m Metric
+				if err := m.unmarshal(mb); err != nil {
+					return errors.Wrap(err, "unmarshal metric error")
+				}
+				p.Metrics = append(p.Metrics, m)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	return nil
+}
+
+func (m Metric) marshal() ([]byte, error) {
+	var b []byte
+
+	if m.Name != "" {
+		b = protowire.AppendTag(b, metricName, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+
+	if m.Timestamp != 0 {
+		b = protowire.AppendTag(b, metricTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.Timestamp)
+	}
+
+	b = protowire.AppendTag(b, metricDataType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.DataType))
+
+	if m.IsNull || m.Value == nil {
+		b = protowire.AppendTag(b, metricIsNull, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+		return b, nil
+	}
+
+	switch m.DataType {
+	case Int8, Int16, Int32:
+		v, ok := m.Value.(int64)
+		if !ok {
+			return nil, errors.Errorf("expected int64 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(int32(v))))
+	case UInt8, UInt16, UInt32:
+		v, ok := m.Value.(uint64)
+		if !ok {
+			return nil, errors.Errorf("expected uint64 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricIntValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(uint32(v)))
+	case Int64:
+		v, ok := m.Value.(int64)
+		if !ok {
+			return nil, errors.Errorf("expected int64 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case UInt64, DateTime:
+		v, ok := m.Value.(uint64)
+		if !ok {
+			return nil, errors.Errorf("expected uint64 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricLongValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case Float:
+		v, ok := m.Value.(float32)
+		if !ok {
+			return nil, errors.Errorf("expected float32 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricFloatValue, protowire.Fixed32Type)
+		b = protowire.AppendFixed32(b, math.Float32bits(v))
+	case Double:
+		v, ok := m.Value.(float64)
+		if !ok {
+			return nil, errors.Errorf("expected float64 value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricDoubleValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case Boolean:
+		v, ok := m.Value.(bool)
+		if !ok {
+			return nil, errors.Errorf("expected bool value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricBoolValue, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	case String, Text, UUID:
+		v, ok := m.Value.(string)
+		if !ok {
+			return nil, errors.Errorf("expected string value, got %T", m.Value)
+		}
+		b = protowire.AppendTag(b, metricStringValue, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	default:
+		return nil, errors.Errorf("unsupported datatype: %d", m.DataType)
+	}
+
+	return b, nil
+}
+
+func (m *Metric) unmarshal(b []byte) error {
+	var value interface{}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == metricName && typ == protowire.BytesType:
+			m.Name, n = protowire.ConsumeString(b)
+		case num == metricTimestamp && typ == protowire.VarintType:
+			m.Timestamp, n = protowire.ConsumeVarint(b)
+		case num == metricDataType && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.DataType = DataType(v)
+		case num == metricIsNull && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			m.IsNull = protowire.DecodeBool(v)
+		case (num == metricIntValue || num == metricLongValue || num == metricBoolValue) && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			value = v
+		case num == metricFloatValue && typ == protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			value = math.Float32frombits(v)
+		case num == metricDoubleValue && typ == protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(b)
+			value = math.Float64frombits(v)
+		case num == metricStringValue && typ == protowire.BytesType:
+			value, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+
+	if m.IsNull || value == nil {
+		m.IsNull = true
+		return nil
+	}
+
+	// The wire format does not tell the signedness and width of the integer
+	// types, this is derived from the datatype.
+	switch v := value.(type) {
+	case uint64:
+		switch m.DataType {
+		case Int8:
+			m.Value = int64(int8(v))
+		case Int16:
+			m.Value = int64(int16(v))
+		case Int32:
+			m.Value = int64(int32(v))
+		case Int64:
+			m.Value = int64(v)
+		case Boolean:
+			m.Value = protowire.DecodeBool(v)
+		default:
+			m.Value = v
+		}
+	default:
+		m.Value = v
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package sparkplug
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayload(t *testing.T) {
+	seq := uint64(12)
+
+	tests := []struct {
+		Name    string
+		Payload Payload
+	}{
+		{
+			Name: "all datatypes",
+			Payload: Payload{
+				Timestamp: 1614556800000,
+				Seq:       &seq,
+				Metrics: []Metric{
+					{Name: "int8", DataType: Int8, Value: int64(-8)},
+					{Name: "int16", DataType: Int16, Value: int64(-16)},
+					{Name: "int32", DataType: Int32, Value: int64(-32)},
+					{Name: "int64", DataType: Int64, Value: int64(-64)},
+					{Name: "uint8", DataType: UInt8, Value: uint64(8)},
+					{Name: "uint32", DataType: UInt32, Value: uint64(32)},
+					{Name: "uint64", DataType: UInt64, Value: uint64(64)},
+					{Name: "float", DataType: Float, Value: float32(1.5)},
+					{Name: "double", Timestamp: 1614556800001, DataType: Double, Value: 21.5},
+					{Name: "boolean", DataType: Boolean, Value: true},
+					{Name: "string", DataType: String, Value: "foo"},
+					{Name: "null", DataType: Double, IsNull: true},
+				},
+			},
+		},
+		{
+			Name: "without seq",
+			Payload: Payload{
+				Timestamp: 1614556800000,
+				Metrics: []Metric{
+					{Name: "bdSeq", DataType: Int64, Value: int64(3)},
+				},
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := tst.Payload.MarshalBinary()
+			assert.NoError(err)
+
+			var pl Payload
+			assert.NoError(pl.UnmarshalBinary(b))
+			assert.Equal(tst.Payload, pl)
+		})
+	}
+
+	t.Run("invalid value type", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := Payload{Metrics: []Metric{{Name: "foo", DataType: Double, Value: "bar"}}}.MarshalBinary()
+		assert.Error(err)
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		assert := require.New(t)
+
+		var pl Payload
+		assert.Error(pl.UnmarshalBinary([]byte{0x12, 0x05, 0x01}))
+	})
+}
@@ -0,0 +1,716 @@
+// Package sparkplug implements a MQTT Sparkplug B integration.
+//
+// Each application is published as a Sparkplug edge node (using the
+// application ID as edge node ID) and each device as a Sparkplug device
+// (using the DevEUI as device ID). The device metrics are derived from the
+// decoded object of the uplinks.
+package sparkplug
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	mqttint "github.com/brocaar/chirpstack-application-server/internal/integration/mqtt"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+const (
+	namespace       = "spBv1.0"
+	bdSeqMetric     = "bdSeq"
+	rebirthMetric   = "Node Control/Rebirth"
+	downlinkLockTTL = time.Millisecond * 100
+)
+
+// downlinkChan contains the downlinks received as DCMD messages.
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlinks received as
+// Sparkplug DCMD messages.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+// Integration implements a MQTT Sparkplug B integration.
+type Integration struct {
+	conn    mqtt.Client
+	config  config.IntegrationSparkplugBConfig
+	publish func(topic string, b []byte) error
+
+	mu        sync.Mutex
+	connected bool
+	bdSeq     uint64
+	nodes     map[uint64]*node
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// node holds the state of an edge node (application).
+type node struct {
+	sync.Mutex
+
+	born    bool
+	seq     uint64
+	devices map[lorawan.EUI64]*device
+}
+
+// device holds the state of a device.
+type device struct {
+	metrics  map[string]Metric
+	lastSeen time.Time
+}
+
+// New creates a new MQTT Sparkplug B integration.
+func New(conf config.IntegrationSparkplugBConfig) (*Integration, error) {
+	i := newIntegration(conf)
+	i.publish = i.mqttPublish
+
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(conf.Server)
+	opts.SetUsername(conf.Username)
+	opts.SetPassword(conf.Password)
+	opts.SetCleanSession(true)
+	opts.SetClientID(conf.ClientID)
+	opts.SetOnConnectHandler(i.onConnected)
+	opts.SetConnectionLostHandler(i.onConnectionLost)
+	opts.SetMaxReconnectInterval(conf.MaxReconnectInterval)
+
+	tlsconfig, err := mqttint.NewTLSConfig(conf.CACert, conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "new tls config error")
+	}
+	if tlsconfig != nil {
+		opts.SetTLSConfig(tlsconfig)
+	}
+
+	log.WithField("server", conf.Server).Info("integration/sparkplug: connecting to mqtt broker")
+	i.conn = mqtt.NewClient(opts)
+	for {
+		if token := i.conn.Connect(); token.Wait() && token.Error() != nil {
+			log.Errorf("integration/sparkplug: connecting to broker error, will retry in 2s: %s", token.Error())
+			time.Sleep(2 * time.Second)
+		} else {
+			break
+		}
+	}
+
+	if conf.DeviceTimeout > 0 {
+		i.wg.Add(1)
+		go i.expireDevicesLoop()
+	}
+
+	return i, nil
+}
+
+func newIntegration(conf config.IntegrationSparkplugBConfig) *Integration {
+	return &Integration{
+		config: conf,
+		nodes:  make(map[uint64]*node),
+		done:   make(chan struct{}),
+	}
+}
+
+// HandleUplinkEvent publishes the metrics of the decoded object as DDATA.
+// The DBIRTH is published first when the device has not yet been seen or
+// when the uplink contains new metrics. Uplinks without decoded object are
+// ignored.
+func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.UplinkEvent) error {
+	if pl.ObjectJson == "" {
+		return nil
+	}
+
+	var devEUI lorawan.EUI64
+	copy(devEUI[:], pl.DevEui)
+
+	ts := timestamp(time.Now())
+	metrics, err := objectMetrics([]byte(pl.ObjectJson), ts)
+	if err != nil {
+		return errors.Wrap(err, "get metrics from object error")
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": pl.ApplicationId,
+		"dev_eui":        devEUI,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("integration/sparkplug: publishing device metrics")
+
+	n := i.getNode(pl.ApplicationId)
+	n.Lock()
+	defer n.Unlock()
+
+	if !n.born {
+		if err := i.birth(pl.ApplicationId, n); err != nil {
+			return err
+		}
+	}
+
+	d, ok := n.devices[devEUI]
+	if !ok {
+		d = &device{metrics: make(map[string]Metric)}
+		n.devices[devEUI] = d
+	}
+	d.lastSeen = time.Now()
+
+	rebirth := !ok
+	for _, m := range metrics {
+		if prev, ok := d.metrics[m.Name]; !ok || prev.DataType != m.DataType {
+			rebirth = true
+		}
+		d.metrics[m.Name] = m
+	}
+
+	// A DBIRTH must contain all the metrics the device will report, thus
+	// new metrics require the device to be re-born.
+	if rebirth {
+		return i.publishDeviceBirth(pl.ApplicationId, n, devEUI, d)
+	}
+
+	return i.publishPayload(pl.ApplicationId, n, "DDATA", devEUI.String(), metrics, ts)
+}
+
+// HandleJoinEvent is not implemented.
+func (i *Integration) HandleJoinEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.JoinEvent) error {
+	return nil
+}
+
+// HandleAckEvent is not implemented.
+func (i *Integration) HandleAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.AckEvent) error {
+	return nil
+}
+
+// HandleErrorEvent is not implemented.
+func (i *Integration) HandleErrorEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.ErrorEvent) error {
+	return nil
+}
+
+// HandleStatusEvent is not implemented.
+func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.StatusEvent) error {
+	return nil
+}
+
+// HandleLocationEvent is not implemented.
+func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.LocationEvent) error {
+	return nil
+}
+
+// HandleTxAckEvent is not implemented.
+func (i *Integration) HandleTxAckEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.TxAckEvent) error {
+	return nil
+}
+
+// HandleIntegrationEvent is not implemented.
+func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integration, vars map[string]string, pl pb.IntegrationEvent) error {
+	return nil
+}
+
+// DataDownChan returns nil. The downlinks are consumed through DownlinkChan.
+func (i *Integration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
+
+// Close publishes the NDEATH of all edge nodes and closes the integration.
+func (i *Integration) Close() error {
+	log.Info("integration/sparkplug: closing handler")
+	close(i.done)
+
+	if i.conn != nil {
+		if token := i.conn.Unsubscribe(i.commandTopics()...); token.Wait() && token.Error() != nil {
+			log.WithError(token.Error()).Error("integration/sparkplug: unsubscribe error")
+		}
+	}
+	i.wg.Wait()
+
+	for applicationID, n := range i.getNodes() {
+		n.Lock()
+		if n.born {
+			if err := i.publishNodeDeath(applicationID, n); err != nil {
+				log.WithError(err).WithField("application_id", applicationID).Error("integration/sparkplug: publish NDEATH error")
+			}
+		}
+		n.Unlock()
+	}
+
+	if i.conn != nil {
+		i.conn.Disconnect(250)
+	}
+
+	return nil
+}
+
+func (i *Integration) getNode(applicationID uint64) *node {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	n, ok := i.nodes[applicationID]
+	if !ok {
+		n = &node{devices: make(map[lorawan.EUI64]*device)}
+		i.nodes[applicationID] = n
+	}
+	return n
+}
+
+// getNodes returns a copy of the edge nodes map, so that the nodes can be
+// locked without holding the integration lock.
+func (i *Integration) getNodes() map[uint64]*node {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	nodes := make(map[uint64]*node, len(i.nodes))
+	for applicationID, n := range i.nodes {
+		nodes[applicationID] = n
+	}
+	return nodes
+}
+
+// birth publishes the NBIRTH of the given edge node, followed by the DBIRTH
+// of all its known devices. The node must be locked.
+func (i *Integration) birth(applicationID uint64, n *node) error {
+	i.mu.Lock()
+	bdSeq := i.bdSeq
+	i.mu.Unlock()
+
+	// The NBIRTH resets the sequence number.
+	n.seq = 0
+	err := i.publishPayload(applicationID, n, "NBIRTH", "", []Metric{
+		{Name: bdSeqMetric, DataType: Int64, Value: int64(bdSeq)},
+		{Name: rebirthMetric, DataType: Boolean, Value: false},
+	}, timestamp(time.Now()))
+	if err != nil {
+		return err
+	}
+	n.born = true
+
+	for devEUI, d := range n.devices {
+		if err := i.publishDeviceBirth(applicationID, n, devEUI, d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Integration) publishDeviceBirth(applicationID uint64, n *node, devEUI lorawan.EUI64, d *device) error {
+	metrics := make([]Metric, 0, len(d.metrics))
+	for _, m := range d.metrics {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(a, b int) bool {
+		return metrics[a].Name < metrics[b].Name
+	})
+
+	return i.publishPayload(applicationID, n, "DBIRTH", devEUI.String(), metrics, timestamp(time.Now()))
+}
+
+func (i *Integration) publishNodeDeath(applicationID uint64, n *node) error {
+	i.mu.Lock()
+	bdSeq := i.bdSeq
+	i.mu.Unlock()
+
+	b, err := Payload{
+		Timestamp: timestamp(time.Now()),
+		Metrics: []Metric{
+			{Name: bdSeqMetric, DataType: Int64, Value: int64(bdSeq)},
+		},
+	}.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "marshal payload error")
+	}
+
+	n.born = false
+	return i.publishMessage(i.topic("NDEATH", applicationID, ""), "NDEATH", b)
+}
+
+// publishPayload publishes the given message type and metrics, using the
+// next sequence number of the node. The node must be locked.
+func (i *Integration) publishPayload(applicationID uint64, n *node, typ, deviceID string, metrics []Metric, ts uint64) error {
+	seq := n.seq
+	n.seq = (n.seq + 1) % 256
+
+	b, err := Payload{
+		Timestamp: ts,
+		Metrics:   metrics,
+		Seq:       &seq,
+	}.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "marshal payload error")
+	}
+
+	return i.publishMessage(i.topic(typ, applicationID, deviceID), typ, b)
+}
+
+func (i *Integration) publishMessage(topic, typ string, b []byte) error {
+	log.WithField("topic", topic).Debug("integration/sparkplug: publishing message")
+	if err := i.publish(topic, b); err != nil {
+		return errors.Wrap(err, "publish error")
+	}
+
+	sparkplugMessageCounter(typ).Inc()
+	return nil
+}
+
+func (i *Integration) mqttPublish(topic string, b []byte) error {
+	// Sparkplug B messages are published using QoS 0 and are not retained.
+	if token := i.conn.Publish(topic, 0, false, b); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	return nil
+}
+
+func (i *Integration) topic(typ string, applicationID uint64, deviceID string) string {
+	topic := strings.Join([]string{namespace, i.config.GroupID, typ, strconv.FormatUint(applicationID, 10)}, "/")
+	if deviceID != "" {
+		topic += "/" + deviceID
+	}
+	return topic
+}
+
+func (i *Integration) commandTopics() []string {
+	return []string{
+		strings.Join([]string{namespace, i.config.GroupID, "NCMD", "+"}, "/"),
+		strings.Join([]string{namespace, i.config.GroupID, "DCMD", "+", "+"}, "/"),
+	}
+}
+
+// expireDevicesLoop publishes the DDEATH of the devices that have not been
+// seen within the configured device timeout.
+func (i *Integration) expireDevicesLoop() {
+	defer i.wg.Done()
+
+	interval := time.Minute
+	if i.config.DeviceTimeout < interval {
+		interval = i.config.DeviceTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			i.expireDevices(time.Now().Add(-i.config.DeviceTimeout))
+		case <-i.done:
+			return
+		}
+	}
+}
+
+// expireDevices publishes the DDEATH of the devices that have not been seen
+// since the given time.
+func (i *Integration) expireDevices(since time.Time) {
+	for applicationID, n := range i.getNodes() {
+		n.Lock()
+		for devEUI, d := range n.devices {
+			if !d.lastSeen.Before(since) {
+				continue
+			}
+
+			delete(n.devices, devEUI)
+			if !n.born {
+				continue
+			}
+
+			if err := i.publishPayload(applicationID, n, "DDEATH", devEUI.String(), nil, timestamp(time.Now())); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"application_id": applicationID,
+					"dev_eui":        devEUI,
+				}).Error("integration/sparkplug: publish DDEATH error")
+			}
+		}
+		n.Unlock()
+	}
+}
+
+// rebirth re-publishes the NBIRTH and DBIRTH messages of all known edge
+// nodes.
+func (i *Integration) rebirth() {
+	for applicationID := range i.getNodes() {
+		i.rebirthNode(applicationID)
+	}
+}
+
+func (i *Integration) rebirthNode(applicationID uint64) {
+	n := i.getNode(applicationID)
+	n.Lock()
+	defer n.Unlock()
+
+	if err := i.birth(applicationID, n); err != nil {
+		log.WithError(err).WithField("application_id", applicationID).Error("integration/sparkplug: publish birth error")
+	}
+}
+
+func (i *Integration) onConnected(c mqtt.Client) {
+	log.Info("integration/sparkplug: connected to mqtt broker")
+
+	// Each new MQTT session requires a new birth sequence number and the
+	// edge nodes must be re-born.
+	i.mu.Lock()
+	reconnect := i.connected
+	i.connected = true
+	if reconnect {
+		i.bdSeq = (i.bdSeq + 1) % 256
+	}
+	i.mu.Unlock()
+
+	for {
+		filters := make(map[string]byte)
+		for _, topic := range i.commandTopics() {
+			filters[topic] = 0
+		}
+
+		log.WithField("topics", i.commandTopics()).Info("integration/sparkplug: subscribing to command topics")
+		if token := c.SubscribeMultiple(filters, i.commandHandler); token.Wait() && token.Error() != nil {
+			log.WithError(token.Error()).Error("integration/sparkplug: subscribe error")
+			time.Sleep(time.Second)
+			continue
+		}
+		break
+	}
+
+	if reconnect {
+		go i.rebirth()
+	}
+}
+
+func (i *Integration) onConnectionLost(c mqtt.Client, reason error) {
+	log.Errorf("integration/sparkplug: mqtt connection error: %s", reason)
+}
+
+func (i *Integration) commandHandler(c mqtt.Client, msg mqtt.Message) {
+	i.wg.Add(1)
+	defer i.wg.Done()
+
+	log.WithField("topic", msg.Topic()).Info("integration/sparkplug: command received")
+
+	if err := i.handleCommand(msg.Topic(), msg.Payload()); err != nil {
+		log.WithError(err).WithField("topic", msg.Topic()).Error("integration/sparkplug: handle command error")
+	}
+}
+
+func (i *Integration) handleCommand(topic string, b []byte) error {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 4 || len(parts) > 5 {
+		return errors.New("invalid topic")
+	}
+
+	applicationID, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "parse edge node id error")
+	}
+
+	var pl Payload
+	if err := pl.UnmarshalBinary(b); err != nil {
+		return errors.Wrap(err, "unmarshal payload error")
+	}
+
+	switch {
+	case parts[2] == "NCMD" && len(parts) == 4:
+		sparkplugCommandCounter("NCMD").Inc()
+
+		for _, m := range pl.Metrics {
+			if m.Name == rebirthMetric && m.Value == true {
+				i.rebirthNode(applicationID)
+			}
+		}
+		return nil
+	case parts[2] == "DCMD" && len(parts) == 5:
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(parts[4])); err != nil {
+			return errors.Wrap(err, "parse device id error")
+		}
+
+		down, err := i.decodeDeviceCommand(int64(applicationID), devEUI, pl)
+		if err != nil {
+			return errors.Wrap(err, "decode device command error")
+		}
+
+		// As all instances subscribed to the command topics receive the
+		// command, only the first instance acquiring the lock handles it.
+		key, err := deviceCommandLockKey(applicationID, devEUI, pl)
+		if err != nil {
+			return err
+		}
+		set, err := storage.RedisClient().SetNX(context.Background(), key, "lock", downlinkLockTTL).Result()
+		if err != nil {
+			return errors.Wrap(err, "acquire lock error")
+		}
+		if !set {
+			return nil
+		}
+
+		sparkplugCommandCounter("DCMD").Inc()
+
+		select {
+		case downlinkChan <- down:
+		case <-i.done:
+		}
+		return nil
+	default:
+		return errors.Errorf("unexpected message type: %s", parts[2])
+	}
+}
+
+// deviceCommandLockKey returns the key of the lock for handling the given
+// DCMD payload. The key contains the payload timestamp, which is set by the
+// host application publishing the command, so that repeated commands with
+// the same metrics are not dropped.
+func deviceCommandLockKey(applicationID uint64, devEUI lorawan.EUI64, pl Payload) (string, error) {
+	if pl.Timestamp == 0 {
+		return "", errors.New("command does not contain a timestamp")
+	}
+	return storage.GetRedisKey("lora:as:sparkplug:downlink:lock:%d:%s:%d", applicationID, devEUI, pl.Timestamp), nil
+}
+
+// decodeDeviceCommand returns the downlink for the given DCMD payload. The
+// fPort and confirmed metrics set the fPort and confirmed flag of the
+// downlink, all other metrics are set in the object, which is encoded using
+// the codec of the device-profile.
+func (i *Integration) decodeDeviceCommand(applicationID int64, devEUI lorawan.EUI64, pl Payload) (models.DataDownPayload, error) {
+	down := models.DataDownPayload{
+		ApplicationID: applicationID,
+		DevEUI:        devEUI,
+		FPort:         i.config.DownlinkFPort,
+	}
+
+	obj := make(map[string]interface{})
+	for _, m := range pl.Metrics {
+		switch m.Name {
+		case "fPort":
+			v, ok := metricNumber(m)
+			if !ok {
+				return down, errors.New("fPort metric must be numeric")
+			}
+			// check the range before the conversion, as the conversion
+			// would truncate the value
+			if math.IsNaN(v) || v != math.Trunc(v) || v < 1 || v > 224 {
+				return down, errors.New("fPort must be between 1 - 224")
+			}
+			down.FPort = uint8(v)
+		case "confirmed":
+			v, ok := m.Value.(bool)
+			if !ok {
+				return down, errors.New("confirmed metric must be boolean")
+			}
+			down.Confirmed = v
+		default:
+			if m.IsNull {
+				continue
+			}
+			setObjectValue(obj, strings.Split(m.Name, "/"), m.Value)
+		}
+	}
+
+	if down.FPort == 0 || down.FPort > 224 {
+		return down, errors.New("fPort must be between 1 - 224")
+	}
+
+	if len(obj) == 0 {
+		return down, errors.New("command does not contain any metrics")
+	}
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return down, errors.Wrap(err, "marshal object error")
+	}
+	down.Object = b
+
+	return down, nil
+}
+
+// objectMetrics returns the metrics for the given decoded object. Nested
+// keys are joined using a "/" (e.g. "sensor/temperature"). Numbers are
+// published as Double, null values are ignored.
+func objectMetrics(b []byte, ts uint64) ([]Metric, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, errors.Wrap(err, "unmarshal json error")
+	}
+
+	var metrics []Metric
+	flattenObject("", obj, ts, &metrics)
+
+	sort.Slice(metrics, func(a, b int) bool {
+		return metrics[a].Name < metrics[b].Name
+	})
+
+	return metrics, nil
+}
+
+func flattenObject(name string, v interface{}, ts uint64, metrics *[]Metric) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range v {
+			flattenObject(joinName(name, k), vv, ts, metrics)
+		}
+	case []interface{}:
+		for k, vv := range v {
+			flattenObject(joinName(name, strconv.Itoa(k)), vv, ts, metrics)
+		}
+	case float64:
+		*metrics = append(*metrics, Metric{Name: name, Timestamp: ts, DataType: Double, Value: v})
+	case bool:
+		*metrics = append(*metrics, Metric{Name: name, Timestamp: ts, DataType: Boolean, Value: v})
+	case string:
+		*metrics = append(*metrics, Metric{Name: name, Timestamp: ts, DataType: String, Value: v})
+	}
+}
+
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// setObjectValue sets the value in the given object, creating the nested
+// objects for the given path when needed.
+func setObjectValue(obj map[string]interface{}, path []string, v interface{}) {
+	if len(path) == 1 {
+		obj[path[0]] = v
+		return
+	}
+
+	nested, ok := obj[path[0]].(map[string]interface{})
+	if !ok {
+		nested = make(map[string]interface{})
+		obj[path[0]] = nested
+	}
+	setObjectValue(nested, path[1:], v)
+}
+
+func metricNumber(m Metric) (float64, bool) {
+	switch v := m.Value.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// timestamp returns the Sparkplug timestamp (ms since epoch).
+func timestamp(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
@@ -0,0 +1,234 @@
+package sparkplug
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/config"
+	"github.com/brocaar/lorawan"
+)
+
+type message struct {
+	Topic   string
+	Payload Payload
+}
+
+func newTestIntegration(t *testing.T) (*Integration, *[]message) {
+	var messages []message
+
+	i := newIntegration(config.IntegrationSparkplugBConfig{
+		GroupID:       "chirpstack",
+		DownlinkFPort: 10,
+	})
+	i.publish = func(topic string, b []byte) error {
+		var pl Payload
+		require.NoError(t, pl.UnmarshalBinary(b))
+		messages = append(messages, message{Topic: topic, Payload: pl})
+		return nil
+	}
+
+	return i, &messages
+}
+
+func metricNames(metrics []Metric) []string {
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestIntegration(t *testing.T) {
+	assert := require.New(t)
+	ctx := context.Background()
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	i, messages := newTestIntegration(t)
+
+	t.Run("Uplink without object", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+		}))
+		assert.Len(*messages, 0)
+	})
+
+	t.Run("First uplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+			ObjectJson:    `{"temperature":21.5,"alarm":false,"sensor":{"state":"ok","values":[1,2]},"empty":null}`,
+		}))
+		assert.Len(*messages, 2)
+
+		nbirth := (*messages)[0]
+		assert.Equal("spBv1.0/chirpstack/NBIRTH/10", nbirth.Topic)
+		assert.EqualValues(0, *nbirth.Payload.Seq)
+		assert.Equal([]string{"bdSeq", "Node Control/Rebirth"}, metricNames(nbirth.Payload.Metrics))
+
+		dbirth := (*messages)[1]
+		assert.Equal("spBv1.0/chirpstack/DBIRTH/10/0102030405060708", dbirth.Topic)
+		assert.EqualValues(1, *dbirth.Payload.Seq)
+		assert.Equal([]string{"alarm", "sensor/state", "sensor/values/0", "sensor/values/1", "temperature"}, metricNames(dbirth.Payload.Metrics))
+		assert.Equal(Double, dbirth.Payload.Metrics[4].DataType)
+		assert.Equal(21.5, dbirth.Payload.Metrics[4].Value)
+		assert.Equal(Boolean, dbirth.Payload.Metrics[0].DataType)
+		assert.Equal(String, dbirth.Payload.Metrics[1].DataType)
+	})
+
+	t.Run("Next uplink", func(t *testing.T) {
+		assert := require.New(t)
+		*messages = nil
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+			ObjectJson:    `{"temperature":22}`,
+		}))
+		assert.Len(*messages, 1)
+		assert.Equal("spBv1.0/chirpstack/DDATA/10/0102030405060708", (*messages)[0].Topic)
+		assert.EqualValues(2, *(*messages)[0].Payload.Seq)
+		assert.Equal([]string{"temperature"}, metricNames((*messages)[0].Payload.Metrics))
+	})
+
+	t.Run("Uplink with new metric", func(t *testing.T) {
+		assert := require.New(t)
+		*messages = nil
+
+		assert.NoError(i.HandleUplinkEvent(ctx, nil, nil, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+			ObjectJson:    `{"temperature":22,"humidity":60}`,
+		}))
+		assert.Len(*messages, 1)
+		assert.Equal("spBv1.0/chirpstack/DBIRTH/10/0102030405060708", (*messages)[0].Topic)
+		assert.Equal([]string{"alarm", "humidity", "sensor/state", "sensor/values/0", "sensor/values/1", "temperature"}, metricNames((*messages)[0].Payload.Metrics))
+	})
+
+	t.Run("Rebirth command", func(t *testing.T) {
+		assert := require.New(t)
+		*messages = nil
+
+		b, err := Payload{Metrics: []Metric{{Name: rebirthMetric, DataType: Boolean, Value: true}}}.MarshalBinary()
+		assert.NoError(err)
+		assert.NoError(i.handleCommand("spBv1.0/chirpstack/NCMD/10", b))
+
+		assert.Len(*messages, 2)
+		assert.Equal("spBv1.0/chirpstack/NBIRTH/10", (*messages)[0].Topic)
+		assert.EqualValues(0, *(*messages)[0].Payload.Seq)
+		assert.Equal("spBv1.0/chirpstack/DBIRTH/10/0102030405060708", (*messages)[1].Topic)
+		assert.EqualValues(1, *(*messages)[1].Payload.Seq)
+	})
+
+	t.Run("Device timeout", func(t *testing.T) {
+		assert := require.New(t)
+		*messages = nil
+
+		i.expireDevices(time.Now().Add(-time.Minute))
+		assert.Len(*messages, 0)
+
+		i.expireDevices(time.Now().Add(time.Minute))
+		assert.Len(*messages, 1)
+		assert.Equal("spBv1.0/chirpstack/DDEATH/10/0102030405060708", (*messages)[0].Topic)
+		assert.EqualValues(2, *(*messages)[0].Payload.Seq)
+		assert.Len(i.nodes[10].devices, 0)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		assert := require.New(t)
+		*messages = nil
+
+		assert.NoError(i.Close())
+		assert.Len(*messages, 1)
+		assert.Equal("spBv1.0/chirpstack/NDEATH/10", (*messages)[0].Topic)
+		assert.Nil((*messages)[0].Payload.Seq)
+		assert.Equal([]string{"bdSeq"}, metricNames((*messages)[0].Payload.Metrics))
+	})
+
+	assert.Nil(i.DataDownChan())
+}
+
+func TestDecodeDeviceCommand(t *testing.T) {
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	i, _ := newTestIntegration(t)
+
+	t.Run("Valid command", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl, err := i.decodeDeviceCommand(10, devEUI, Payload{Metrics: []Metric{
+			{Name: "relay/state", DataType: Boolean, Value: true},
+			{Name: "interval", DataType: UInt32, Value: uint64(60)},
+			{Name: "fPort", DataType: Int32, Value: int64(3)},
+			{Name: "confirmed", DataType: Boolean, Value: true},
+		}})
+		assert.NoError(err)
+		assert.EqualValues(10, pl.ApplicationID)
+		assert.Equal(devEUI, pl.DevEUI)
+		assert.EqualValues(3, pl.FPort)
+		assert.True(pl.Confirmed)
+		assert.JSONEq(`{"interval":60,"relay":{"state":true}}`, string(pl.Object))
+	})
+
+	t.Run("Default fPort", func(t *testing.T) {
+		assert := require.New(t)
+
+		pl, err := i.decodeDeviceCommand(10, devEUI, Payload{Metrics: []Metric{
+			{Name: "setpoint", DataType: Double, Value: 21.5},
+		}})
+		assert.NoError(err)
+		assert.EqualValues(10, pl.FPort)
+		assert.False(pl.Confirmed)
+	})
+
+	t.Run("Invalid fPort", func(t *testing.T) {
+		for _, v := range []interface{}{int64(0), int64(-1), int64(225), int64(256 + 3), uint64(1 << 40), 3.5, math.NaN(), math.Inf(1)} {
+			t.Run(fmt.Sprintf("%v", v), func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := i.decodeDeviceCommand(10, devEUI, Payload{Metrics: []Metric{
+					{Name: "fPort", DataType: Double, Value: v},
+					{Name: "setpoint", DataType: Double, Value: 21.5},
+				}})
+				assert.Error(err)
+			})
+		}
+	})
+
+	t.Run("Without metrics", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := i.decodeDeviceCommand(10, devEUI, Payload{})
+		assert.Error(err)
+	})
+}
+
+func TestDeviceCommandLockKey(t *testing.T) {
+	assert := require.New(t)
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	metrics := []Metric{{Name: "setpoint", DataType: Double, Value: 21.5}}
+
+	key1, err := deviceCommandLockKey(10, devEUI, Payload{Timestamp: 1000, Metrics: metrics})
+	assert.NoError(err)
+
+	// a repeated command with the same metrics is not a duplicate
+	key2, err := deviceCommandLockKey(10, devEUI, Payload{Timestamp: 2000, Metrics: metrics})
+	assert.NoError(err)
+	assert.NotEqual(key1, key2)
+
+	key3, err := deviceCommandLockKey(10, devEUI, Payload{Timestamp: 1000})
+	assert.NoError(err)
+	assert.Equal(key1, key3)
+
+	_, err = deviceCommandLockKey(10, devEUI, Payload{Metrics: metrics})
+	assert.Error(err)
+}
@@ -0,0 +1,159 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-api/go/v3/common"
+	"github.com/brocaar/chirpstack-api/go/v3/gw"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+)
+
+// TestFireResult contains the outcome of test-firing an integration.
+type TestFireResult struct {
+	// StatusCode contains the HTTP status code of the last response received
+	// by the integration. It is 0 for integrations which are not HTTP based
+	// or when no response was received.
+	StatusCode int
+
+	// Error contains the error returned by the integration (if any).
+	Error string
+
+	// Latency contains the time it took the integration to handle the event.
+	Latency time.Duration
+}
+
+// TestFire creates the integration of the given kind using the given (not yet
+// stored) settings and sends it a synthetic uplink event for the given
+// application ID. The integration is closed afterwards. It does not consume
+// downlink commands, even when these are configured in the given settings.
+// An error is only returned when the integration could not be created,
+// errors returned when handling the event are part of the result.
+func TestFire(ctx context.Context, applicationID int64, kind string, settings json.RawMessage) (TestFireResult, error) {
+	var res TestFireResult
+
+	i, err := newIntegration(applicationID, kind, settings)
+	if err != nil {
+		return res, err
+	}
+	defer func() {
+		if err := i.Close(); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"application_id": applicationID,
+				"kind":           kind,
+				"ctx_id":         ctx.Value(logging.ContextIDKey),
+			}).Error("integration: close test-fire integration error")
+		}
+	}()
+
+	var rec models.HTTPStatusRecorder
+	ctx = models.WithHTTPStatusRecorder(ctx, &rec)
+	ctx = models.WithTestFire(ctx)
+
+	start := time.Now()
+	err = i.HandleUplinkEvent(ctx, discardIntegration{}, nil, testFireUplinkEvent(applicationID))
+	if f, ok := i.(models.Flusher); ok && err == nil {
+		// Integrations buffering the events must send the event, else
+		// the result would not reflect the delivery.
+		err = f.Flush(ctx)
+	}
+	res.Latency = time.Since(start)
+	res.StatusCode = rec.StatusCode()
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return res, nil
+}
+
+// testFireUplinkEvent returns the synthetic uplink event used to test-fire
+// an integration.
+func testFireUplinkEvent(applicationID int64) integration.UplinkEvent {
+	now, _ := ptypes.TimestampProto(time.Now())
+
+	return integration.UplinkEvent{
+		ApplicationId:   uint64(applicationID),
+		ApplicationName: "test-application",
+		DeviceName:      "test-device",
+		DevEui:          []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		RxInfo: []*gw.UplinkRXInfo{
+			{
+				GatewayId: []byte{8, 7, 6, 5, 4, 3, 2, 1},
+				Time:      now,
+				Rssi:      -60,
+				LoraSnr:   7.5,
+				Location: &common.Location{
+					Latitude:  52.3740364,
+					Longitude: 4.9144401,
+				},
+			},
+		},
+		TxInfo: &gw.UplinkTXInfo{
+			Frequency:  868100000,
+			Modulation: common.Modulation_LORA,
+			ModulationInfo: &gw.UplinkTXInfo_LoraModulationInfo{
+				LoraModulationInfo: &gw.LoRaModulationInfo{
+					Bandwidth:       125,
+					SpreadingFactor: 7,
+					CodeRate:        "4/5",
+				},
+			},
+		},
+		Adr:        true,
+		Dr:         5,
+		FCnt:       10,
+		FPort:      1,
+		Data:       []byte{1, 2, 3, 4},
+		ObjectJson: `{"temperature":21.5,"humidity":60}`,
+		Tags: map[string]string{
+			"test": "true",
+		},
+		PublishedAt: now,
+	}
+}
+
+// discardIntegration implements the models.Integration interface, it
+// discards the events generated by a test-fired integration.
+type discardIntegration struct{}
+
+func (discardIntegration) HandleUplinkEvent(context.Context, map[string]string, integration.UplinkEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleJoinEvent(context.Context, map[string]string, integration.JoinEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleAckEvent(context.Context, map[string]string, integration.AckEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleErrorEvent(context.Context, map[string]string, integration.ErrorEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleStatusEvent(context.Context, map[string]string, integration.StatusEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleLocationEvent(context.Context, map[string]string, integration.LocationEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleTxAckEvent(context.Context, map[string]string, integration.TxAckEvent) error {
+	return nil
+}
+
+func (discardIntegration) HandleIntegrationEvent(context.Context, map[string]string, integration.IntegrationEvent) error {
+	return nil
+}
+
+func (discardIntegration) DataDownChan() chan models.DataDownPayload {
+	return nil
+}
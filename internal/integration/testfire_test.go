@@ -0,0 +1,105 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/marshaler"
+)
+
+func TestTestFire(t *testing.T) {
+	marshalType = marshaler.ProtobufJSON
+
+	bodies := make(chan []byte, 1)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- b
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	settings := json.RawMessage(fmt.Sprintf(`{"eventEndpointURL":"%s"}`, server.URL))
+
+	t.Run("Success", func(t *testing.T) {
+		assert := require.New(t)
+
+		res, err := TestFire(context.Background(), 10, HTTP, settings)
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, res.StatusCode)
+		assert.Equal("", res.Error)
+		assert.True(res.Latency > 0)
+
+		var pl map[string]interface{}
+		assert.NoError(json.Unmarshal(<-bodies, &pl))
+		assert.Equal("10", pl["applicationID"])
+		assert.Equal("test-device", pl["deviceName"])
+	})
+
+	t.Run("Error response", func(t *testing.T) {
+		assert := require.New(t)
+		status = http.StatusInternalServerError
+
+		res, err := TestFire(context.Background(), 10, HTTP, settings)
+		assert.NoError(err)
+		<-bodies
+		assert.Equal(http.StatusInternalServerError, res.StatusCode)
+		assert.NotEqual("", res.Error)
+	})
+
+	t.Run("Buffering integration", func(t *testing.T) {
+		assert := require.New(t)
+		status = http.StatusBadRequest
+
+		res, err := TestFire(context.Background(), 10, Elasticsearch, json.RawMessage(fmt.Sprintf(`{"endpoint":"%s"}`, server.URL)))
+		assert.NoError(err)
+		assert.Contains(string(<-bodies), `"_index"`)
+		assert.Equal(http.StatusBadRequest, res.StatusCode)
+		assert.NotEqual("", res.Error)
+	})
+
+	t.Run("Unknown kind", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := TestFire(context.Background(), 10, "FOO", json.RawMessage(`{}`))
+		assert.Error(err)
+	})
+}
+
+func TestTestFireWithoutCommands(t *testing.T) {
+	assert := require.New(t)
+	marshalType = marshaler.ProtobufJSON
+
+	s, err := server.NewServer(&server.Options{
+		Host: "127.0.0.1",
+		Port: -1,
+	})
+	assert.NoError(err)
+	go s.Start()
+	defer s.Shutdown()
+	assert.True(s.ReadyForConnections(10 * time.Second))
+
+	settings := json.RawMessage(fmt.Sprintf(`{
+		"server": "%s",
+		"eventSubjectTemplate": "application.{{ .ApplicationID }}.event",
+		"commandSubjectTemplate": "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.command.{{ .CommandType }}"
+	}`, s.ClientURL()))
+
+	i, err := newIntegration(10, NATS, settings)
+	assert.NoError(err)
+	defer i.Close()
+	assert.Nil(i.DataDownChan())
+
+	res, err := TestFire(context.Background(), 10, NATS, settings)
+	assert.NoError(err)
+	assert.Equal("", res.Error)
+}
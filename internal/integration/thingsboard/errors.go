@@ -0,0 +1,11 @@
+package thingsboard
+
+import "errors"
+
+// errors
+var (
+	ErrInvalidProvisionConfig = errors.New("Provision device key and secret must both be set")
+	ErrTestFireProvision      = errors.New("Devices are not provisioned by a test-fire event")
+	ErrDeviceApplication      = errors.New("Device does not belong to the application")
+	ErrInvalidAccessToken     = errors.New("Invalid access token")
+)
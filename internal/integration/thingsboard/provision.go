@@ -0,0 +1,185 @@
+package thingsboard
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/logging"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+var (
+	// saveRetries defines the number of times storing the access token of
+	// a provisioned device is retried.
+	saveRetries = 3
+
+	// saveRetryInterval is the interval before retrying to store the access
+	// token.
+	saveRetryInterval = 500 * time.Millisecond
+)
+
+// provisionRequest implements the ThingsBoard device provisioning request.
+type provisionRequest struct {
+	DeviceName            string `json:"deviceName"`
+	ProvisionDeviceKey    string `json:"provisionDeviceKey"`
+	ProvisionDeviceSecret string `json:"provisionDeviceSecret"`
+}
+
+// provisionResponse implements the ThingsBoard device provisioning response.
+type provisionResponse struct {
+	Status           string `json:"status"`
+	CredentialsType  string `json:"credentialsType"`
+	CredentialsValue string `json:"credentialsValue"`
+	ErrorMsg         string `json:"errorMsg"`
+}
+
+// provision provisions the device in ThingsBoard, using the DevEUI as
+// device name. The returned access token is stored as device variable, so
+// that the device is provisioned only once.
+func (i *Integration) provision(ctx context.Context, applicationID int64, devEUI lorawan.EUI64) (string, error) {
+	// Provisioning the same device twice fails, therefore this must not
+	// happen concurrently for the same device.
+	i.mu.Lock()
+	lock, ok := i.provisionLocks[devEUI]
+	if !ok {
+		lock = &sync.Mutex{}
+		i.provisionLocks[devEUI] = lock
+	}
+	i.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	i.mu.Lock()
+	token, ok := i.tokens[devEUI]
+	i.mu.Unlock()
+	if ok {
+		return token, nil
+	}
+
+	b, err := json.Marshal(provisionRequest{
+		DeviceName:            devEUI.String(),
+		ProvisionDeviceKey:    i.config.ProvisionDeviceKey,
+		ProvisionDeviceSecret: i.config.ProvisionDeviceSecret,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshal json error")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", i.config.Server+"/api/v1/provision", bytes.NewReader(b))
+	if err != nil {
+		return "", errors.Wrap(err, "new request error")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+	models.RecordHTTPStatus(ctx, resp.StatusCode)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("expected 2xx response, got: %d", resp.StatusCode)
+	}
+
+	var provResp provisionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&provResp); err != nil {
+		return "", errors.Wrap(err, "decode response error")
+	}
+
+	if provResp.Status != "SUCCESS" {
+		return "", fmt.Errorf("provisioning failed: %s (%s)", provResp.Status, provResp.ErrorMsg)
+	}
+
+	if provResp.CredentialsType != "ACCESS_TOKEN" || provResp.CredentialsValue == "" {
+		return "", fmt.Errorf("expected ACCESS_TOKEN credentials, got: %s", provResp.CredentialsType)
+	}
+
+	// ThingsBoard does not provision the device twice, therefore the token
+	// is cached before storing it, so that it is not lost when this fails.
+	i.mu.Lock()
+	i.tokens[devEUI] = provResp.CredentialsValue
+	i.mu.Unlock()
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("integration/thingsboard: device provisioned")
+
+	i.storeAccessToken(ctx, applicationID, devEUI, provResp.CredentialsValue)
+
+	return provResp.CredentialsValue, nil
+}
+
+// storeAccessToken stores the access token of the provisioned device, with
+// retries. When this fails, the device is marked as unsaved so that storing
+// the token is retried on the next event of the device.
+func (i *Integration) storeAccessToken(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) {
+	var err error
+	for n := 0; n < saveRetries; n++ {
+		if n != 0 {
+			time.Sleep(saveRetryInterval)
+		}
+
+		err = i.saveAccessToken(ctx, applicationID, devEUI, token)
+		if err == nil || errors.Cause(err) == ErrDeviceApplication {
+			break
+		}
+	}
+
+	i.mu.Lock()
+	if err != nil && errors.Cause(err) != ErrDeviceApplication {
+		i.unsaved[devEUI] = struct{}{}
+	} else {
+		delete(i.unsaved, devEUI)
+	}
+	i.mu.Unlock()
+
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("integration/thingsboard: save access token error")
+	}
+}
+
+// saveAccessToken stores the access token as device variable. It returns
+// ErrDeviceApplication when the device does not belong to the given
+// application.
+func saveAccessToken(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) error {
+	return storage.Transaction(func(tx sqlx.Ext) error {
+		d, err := storage.GetDevice(ctx, tx, devEUI, true, true)
+		if err != nil {
+			return errors.Wrap(err, "get device error")
+		}
+
+		if d.ApplicationID != applicationID {
+			return ErrDeviceApplication
+		}
+
+		if d.Variables.Map == nil {
+			d.Variables = hstore.Hstore{Map: make(map[string]sql.NullString)}
+		}
+		d.Variables.Map[accessTokenVariable] = sql.NullString{String: token, Valid: true}
+
+		if err := storage.UpdateDevice(ctx, tx, &d, true); err != nil {
+			return errors.Wrap(err, "update device error")
+		}
+
+		return nil
+	})
+}
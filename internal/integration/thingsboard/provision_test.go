@@ -0,0 +1,199 @@
+package thingsboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+func TestProvision(t *testing.T) {
+	saveRetryInterval = time.Millisecond
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	var provisionRequests []provisionRequest
+	var paths []string
+	provisionResponse := `{"status":"SUCCESS","credentialsType":"ACCESS_TOKEN","credentialsValue":"provisioned"}`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/provision" {
+			var req provisionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			provisionRequests = append(provisionRequests, req)
+			w.Write([]byte(provisionResponse))
+			return
+		}
+
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Run("Device is provisioned once", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "secret",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		saved := make(map[lorawan.EUI64]string)
+		i.saveAccessToken = func(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) error {
+			saved[devEUI] = token
+			return nil
+		}
+
+		for n := 0; n < 2; n++ {
+			assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{DevEui: devEUI[:]}))
+		}
+
+		assert.Equal([]provisionRequest{
+			{DeviceName: "0102030405060708", ProvisionDeviceKey: "key", ProvisionDeviceSecret: "secret"},
+		}, provisionRequests)
+		assert.Equal(map[lorawan.EUI64]string{devEUI: "provisioned"}, saved)
+		assert.Equal([]string{
+			"/api/v1/provisioned/attributes",
+			"/api/v1/provisioned/telemetry",
+			"/api/v1/provisioned/attributes",
+			"/api/v1/provisioned/telemetry",
+		}, paths)
+	})
+
+	t.Run("Access token is cached when saving fails", func(t *testing.T) {
+		assert := require.New(t)
+		provisionRequests = nil
+		paths = nil
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "secret",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		var saves int
+		saveErr := errors.New("save error")
+		i.saveAccessToken = func(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) error {
+			saves++
+			return saveErr
+		}
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10, DevEui: devEUI[:]}))
+		assert.Len(provisionRequests, 1)
+		assert.Equal(saveRetries, saves)
+		assert.Len(i.unsaved, 1)
+
+		// saving is retried on the next event, without provisioning
+		saveErr = nil
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 10, DevEui: devEUI[:]}))
+		assert.Len(provisionRequests, 1)
+		assert.Equal(saveRetries+1, saves)
+		assert.Len(i.unsaved, 0)
+		assert.Len(paths, 4)
+	})
+
+	t.Run("Device of an other application", func(t *testing.T) {
+		assert := require.New(t)
+		provisionRequests = nil
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "secret",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		var saves int
+		i.saveAccessToken = func(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) error {
+			saves++
+			return ErrDeviceApplication
+		}
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{ApplicationId: 11, DevEui: devEUI[:]}))
+		assert.Equal(1, saves)
+		assert.Len(i.unsaved, 0)
+	})
+
+	t.Run("Test-fire event", func(t *testing.T) {
+		assert := require.New(t)
+		provisionRequests = nil
+		paths = nil
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "secret",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		err = i.HandleUplinkEvent(models.WithTestFire(context.Background()), nil, nil, pb.UplinkEvent{DevEui: devEUI[:]})
+		assert.Equal(ErrTestFireProvision, errors.Cause(err))
+		assert.Len(provisionRequests, 0)
+		assert.Len(paths, 0)
+	})
+
+	t.Run("Device with access token", func(t *testing.T) {
+		assert := require.New(t)
+		provisionRequests = nil
+		paths = nil
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "secret",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleStatusEvent(context.Background(), nil, map[string]string{
+			"ThingsBoardAccessToken": "verysecret",
+		}, pb.StatusEvent{DevEui: devEUI[:]}))
+		assert.Len(provisionRequests, 0)
+		assert.Equal([]string{"/api/v1/verysecret/attributes", "/api/v1/verysecret/telemetry"}, paths)
+	})
+
+	t.Run("Provisioning fails", func(t *testing.T) {
+		assert := require.New(t)
+		provisionResponse = `{"status":"NOT_FOUND","errorMsg":"Provision data was not found!"}`
+		paths = nil
+
+		i, err := New(Config{
+			Server:                server.URL,
+			ProvisionDeviceKey:    "key",
+			ProvisionDeviceSecret: "invalid",
+		})
+		assert.NoError(err)
+		defer i.Close()
+
+		err = i.HandleUplinkEvent(context.Background(), nil, nil, pb.UplinkEvent{DevEui: devEUI[:]})
+		assert.Error(err)
+		assert.Contains(err.Error(), "Provision data was not found!")
+		assert.Len(paths, 0)
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(Config{Server: "http://localhost:8080"}.Validate())
+	assert.NoError(Config{ProvisionDeviceKey: "key", ProvisionDeviceSecret: "secret"}.Validate())
+	assert.Equal(ErrInvalidProvisionConfig, Config{ProvisionDeviceKey: "key"}.Validate())
+}
@@ -0,0 +1,397 @@
+package thingsboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/chirpstack-application-server/internal/storage"
+	"github.com/brocaar/lorawan"
+)
+
+const defaultRPCFPort = 1
+
+var (
+	// rpcTimeout is the timeout of the RPC (long-polling) request.
+	rpcTimeout = 30 * time.Second
+
+	// rpcRetryInterval is the interval before retrying a failed RPC
+	// request.
+	rpcRetryInterval = 5 * time.Second
+
+	// rpcSyncInterval defines the interval in which the RPC subscriptions
+	// are synced with the access tokens of the devices, e.g. to subscribe
+	// the newly provisioned devices.
+	rpcSyncInterval = time.Minute
+
+	// maxRPCSubscriptions defines the max. number of RPC subscriptions of an
+	// application. Each subscription holds a (long-polling) connection to
+	// ThingsBoard.
+	maxRPCSubscriptions = 1000
+)
+
+// downlinkChan contains the downlinks received as ThingsBoard RPC request.
+var downlinkChan = make(chan models.DataDownPayload)
+
+// DownlinkChan returns the channel containing the downlinks received as
+// ThingsBoard RPC request.
+func DownlinkChan() chan models.DataDownPayload {
+	return downlinkChan
+}
+
+// rpcRequest implements the ThingsBoard server-side RPC request.
+type rpcRequest struct {
+	ID     int64           `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// rpcObject contains the object which is passed to the device-profile
+// codec to encode the RPC request.
+type rpcObject struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse contains the response sent to ThingsBoard for a RPC request.
+type rpcResponse struct {
+	FCnt  uint32 `json:"fCnt"`
+	Error string `json:"error,omitempty"`
+}
+
+// rpcSubscription contains the running RPC subscription of a device.
+type rpcSubscription struct {
+	token  string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRPCConsumer creates a new Thingsboard integration, receiving the RPC
+// requests of the devices of the given application. The devices are
+// subscribed using the access token stored as device variable, independent
+// of the events of the device.
+func NewRPCConsumer(conf Config, applicationID int64) (*Integration, error) {
+	return newRPCConsumer(conf, applicationID, getAccessTokens)
+}
+
+func newRPCConsumer(conf Config, applicationID int64, getAccessTokens func(ctx context.Context, applicationID int64) (map[lorawan.EUI64]string, error)) (*Integration, error) {
+	if !conf.RPCEnabled {
+		return nil, errors.New("rpc must be enabled")
+	}
+
+	i, err := New(conf)
+	if err != nil {
+		return nil, err
+	}
+	i.applicationID = applicationID
+	i.getAccessTokens = getAccessTokens
+
+	i.wg.Add(1)
+	go i.rpcSyncLoop()
+
+	return i, nil
+}
+
+func (i *Integration) rpcSyncLoop() {
+	defer i.wg.Done()
+
+	ticker := time.NewTicker(rpcSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		tokens, err := i.getAccessTokens(i.ctx, i.applicationID)
+		if err != nil {
+			log.WithError(err).WithField("application_id", i.applicationID).Error("integration/thingsboard: get access tokens error")
+		} else {
+			i.syncRPC(tokens)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-i.ctx.Done():
+			return
+		}
+	}
+}
+
+// syncRPC syncs the RPC subscriptions with the given access tokens, by
+// DevEUI. The subscriptions of removed devices or changed tokens are
+// stopped. Tokens which have been rejected by ThingsBoard are not
+// subscribed again, unless changed.
+func (i *Integration) syncRPC(tokens map[lorawan.EUI64]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for devEUI, sub := range i.rpc {
+		if tokens[devEUI] != sub.token {
+			sub.cancel()
+			delete(i.rpc, devEUI)
+		}
+	}
+
+	for devEUI, token := range i.invalid {
+		if tokens[devEUI] != token {
+			delete(i.invalid, devEUI)
+		}
+	}
+
+	// sort the devices, so that the same devices are subscribed when
+	// exceeding the max. number of subscriptions
+	devEUIs := make([]lorawan.EUI64, 0, len(tokens))
+	for devEUI := range tokens {
+		devEUIs = append(devEUIs, devEUI)
+	}
+	sort.Slice(devEUIs, func(a, b int) bool {
+		return bytes.Compare(devEUIs[a][:], devEUIs[b][:]) < 0
+	})
+
+	var skipped int
+	for _, devEUI := range devEUIs {
+		token := tokens[devEUI]
+		if _, ok := i.rpc[devEUI]; ok || token == "" || i.invalid[devEUI] == token {
+			continue
+		}
+
+		if len(i.rpc) >= maxRPCSubscriptions {
+			skipped++
+			continue
+		}
+
+		i.subscribeRPC(devEUI, token)
+	}
+
+	if skipped != 0 {
+		log.WithFields(log.Fields{
+			"application_id": i.applicationID,
+			"devices":        skipped,
+		}).Warning("integration/thingsboard: max. number of rpc subscriptions exceeded, devices are not subscribed")
+	}
+}
+
+// subscribeRPC starts the RPC subscription for the given device. The caller
+// must hold mu.
+func (i *Integration) subscribeRPC(devEUI lorawan.EUI64, token string) {
+	sub := rpcSubscription{
+		token: token,
+	}
+	sub.ctx, sub.cancel = context.WithCancel(i.ctx)
+	i.rpc[devEUI] = &sub
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+	}).Info("integration/thingsboard: starting rpc subscription")
+
+	i.wg.Add(1)
+	go i.rpcLoop(i.applicationID, devEUI, &sub)
+}
+
+// unsubscribeRPC removes the given RPC subscription, of which the access
+// token has been rejected, unless it has already been replaced by a new
+// subscription.
+func (i *Integration) unsubscribeRPC(devEUI lorawan.EUI64, sub *rpcSubscription) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sub.cancel()
+	if i.rpc[devEUI] == sub {
+		delete(i.rpc, devEUI)
+		i.invalid[devEUI] = sub.token
+	}
+}
+
+func (i *Integration) rpcLoop(applicationID int64, devEUI lorawan.EUI64, sub *rpcSubscription) {
+	defer i.wg.Done()
+
+	ctx := sub.ctx
+	token := sub.token
+
+	for {
+		req, err := i.getRPCRequest(ctx, token)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == ErrInvalidAccessToken {
+			log.WithField("dev_eui", devEUI).Warning("integration/thingsboard: invalid access token, stopping rpc subscription")
+			i.unsubscribeRPC(devEUI, sub)
+			return
+		}
+
+		if err != nil {
+			log.WithError(err).WithField("dev_eui", devEUI).Error("integration/thingsboard: get rpc request error")
+
+			select {
+			case <-time.After(rpcRetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// the request timed out without RPC request
+		if req == nil {
+			continue
+		}
+
+		if err := i.handleRPCRequest(ctx, applicationID, devEUI, token, *req); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui": devEUI,
+				"rpc_id":  req.ID,
+			}).Error("integration/thingsboard: handle rpc request error")
+		}
+	}
+}
+
+// getRPCRequest waits for a RPC request of the device. It returns nil when
+// no RPC request was received before the timeout and ErrInvalidAccessToken
+// when ThingsBoard does not know the access token (e.g. the device has been
+// removed).
+func (i *Integration) getRPCRequest(ctx context.Context, token string) (*rpcRequest, error) {
+	url := fmt.Sprintf("%s/api/v1/%s/rpc?timeout=%d", i.config.Server, token, rpcTimeout/time.Millisecond)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "new request error")
+	}
+
+	resp, err := i.rpcClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read response error")
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if resp.StatusCode == http.StatusRequestTimeout || (resp.StatusCode == http.StatusOK && len(bytes.TrimSpace(b)) == 0) {
+		return nil, nil
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("expected 2xx response, got: %d (%s)", resp.StatusCode, string(b))
+	}
+
+	var rpcReq rpcRequest
+	if err := json.Unmarshal(b, &rpcReq); err != nil {
+		return nil, errors.Wrap(err, "unmarshal json error")
+	}
+
+	return &rpcReq, nil
+}
+
+// handleRPCRequest enqueues the RPC request as downlink and sends the
+// enqueue result as RPC response.
+func (i *Integration) handleRPCRequest(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string, req rpcRequest) error {
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"rpc_id":  req.ID,
+		"method":  req.Method,
+	}).Info("integration/thingsboard: rpc request received")
+
+	obj, err := json.Marshal(rpcObject{
+		Method: req.Method,
+		Params: req.Params,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := models.DataDownPayload{
+		ApplicationID: applicationID,
+		DevEUI:        devEUI,
+		FPort:         i.config.RPCFPort,
+		Object:        obj,
+		ResultChan:    make(chan models.DataDownResult, 1),
+	}
+
+	select {
+	case downlinkChan <- pl:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var res models.DataDownResult
+	select {
+	case res = <-pl.ResultChan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	rpcResp := rpcResponse{
+		FCnt: res.FCnt,
+	}
+	if res.Error != nil {
+		rpcResp.Error = res.Error.Error()
+	}
+
+	b, err := json.Marshal(rpcResp)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	url := fmt.Sprintf("%s/api/v1/%s/rpc/%d", i.config.Server, token, req.ID)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrap(err, "new request error")
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := i.client.Do(httpReq)
+	if err != nil {
+		return errors.Wrap(err, "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("expected 2xx response, got: %d (%s)", resp.StatusCode, string(b))
+	}
+
+	return nil
+}
+
+// getAccessTokens returns the access tokens of the devices of the given
+// application, by DevEUI.
+func getAccessTokens(ctx context.Context, applicationID int64) (map[lorawan.EUI64]string, error) {
+	var devices []struct {
+		DevEUI lorawan.EUI64 `db:"dev_eui"`
+		Token  string        `db:"token"`
+	}
+
+	err := sqlx.Select(storage.DB(), &devices, `
+		select
+			dev_eui,
+			variables -> $2 as token
+		from
+			device
+		where
+			application_id = $1
+			and defined(variables, $2)`,
+		applicationID,
+		accessTokenVariable,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "select error")
+	}
+
+	tokens := make(map[lorawan.EUI64]string, len(devices))
+	for _, d := range devices {
+		tokens[d.DevEUI] = d.Token
+	}
+	return tokens, nil
+}
@@ -0,0 +1,205 @@
+package thingsboard
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/brocaar/chirpstack-api/go/v3/as/integration"
+	"github.com/brocaar/chirpstack-application-server/internal/integration/models"
+	"github.com/brocaar/lorawan"
+)
+
+func TestRPC(t *testing.T) {
+	assert := require.New(t)
+	rpcRetryInterval = 10 * time.Millisecond
+
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	requests := make(chan string, 1)
+	replies := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/verysecret/rpc":
+			select {
+			case req := <-requests:
+				w.Write([]byte(req))
+			case <-r.Context().Done():
+			case <-time.After(50 * time.Millisecond):
+				w.WriteHeader(http.StatusRequestTimeout)
+			}
+		case "/api/v1/verysecret/rpc/1":
+			b, _ := ioutil.ReadAll(r.Body)
+			replies <- string(b)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	_, err := newRPCConsumer(Config{Server: server.URL}, 10, nil)
+	assert.Error(err)
+
+	i, err := newRPCConsumer(Config{
+		Server:     server.URL,
+		RPCEnabled: true,
+	}, 10, func(ctx context.Context, applicationID int64) (map[lorawan.EUI64]string, error) {
+		assert.EqualValues(10, applicationID)
+		return map[lorawan.EUI64]string{devEUI: "verysecret"}, nil
+	})
+	assert.NoError(err)
+	assert.EqualValues(1, i.config.RPCFPort)
+
+	assert.Eventually(func() bool {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		return len(i.rpc) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	requests <- `{"id":1,"method":"setValve","params":{"open":true}}`
+
+	var pl models.DataDownPayload
+	select {
+	case pl = <-DownlinkChan():
+	case <-time.After(time.Second):
+		t.Fatal("expected downlink")
+	}
+
+	assert.EqualValues(10, pl.ApplicationID)
+	assert.Equal(devEUI, pl.DevEUI)
+	assert.EqualValues(1, pl.FPort)
+	assert.JSONEq(`{"method":"setValve","params":{"open":true}}`, string(pl.Object))
+
+	pl.ResultChan <- models.DataDownResult{FCnt: 12}
+
+	select {
+	case reply := <-replies:
+		assert.JSONEq(`{"fCnt":12}`, reply)
+	case <-time.After(time.Second):
+		t.Fatal("expected rpc reply")
+	}
+
+	assert.NoError(i.Close())
+}
+
+func TestRPCSubscription(t *testing.T) {
+	rpcRetryInterval = 10 * time.Millisecond
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/removed/rpc":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/api/v1/verysecret/rpc", "/api/v1/othersecret/rpc":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(50 * time.Millisecond):
+				w.WriteHeader(http.StatusRequestTimeout)
+			}
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	subscription := func(i *Integration) *rpcSubscription {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		return i.rpc[devEUI]
+	}
+
+	t.Run("Uplink event", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(Config{Server: server.URL, RPCEnabled: true})
+		assert.NoError(err)
+		defer i.Close()
+
+		assert.NoError(i.HandleUplinkEvent(context.Background(), nil, map[string]string{"ThingsBoardAccessToken": "verysecret"}, pb.UplinkEvent{
+			ApplicationId: 10,
+			DevEui:        devEUI[:],
+		}))
+		assert.Nil(subscription(i))
+	})
+
+	t.Run("Access token changes", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(Config{Server: server.URL, RPCEnabled: true})
+		assert.NoError(err)
+		defer i.Close()
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "verysecret"})
+		sub := subscription(i)
+		assert.Equal("verysecret", sub.token)
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "verysecret"})
+		assert.Equal(sub, subscription(i))
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "othersecret"})
+		assert.Equal("othersecret", subscription(i).token)
+		assert.Error(sub.ctx.Err())
+	})
+
+	t.Run("Device removed", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(Config{Server: server.URL, RPCEnabled: true})
+		assert.NoError(err)
+		defer i.Close()
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "verysecret"})
+		sub := subscription(i)
+		assert.NotNil(sub)
+
+		i.syncRPC(nil)
+		assert.Nil(subscription(i))
+		assert.Error(sub.ctx.Err())
+	})
+
+	t.Run("Invalid access token", func(t *testing.T) {
+		assert := require.New(t)
+
+		i, err := New(Config{Server: server.URL, RPCEnabled: true})
+		assert.NoError(err)
+		defer i.Close()
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "removed"})
+		assert.Eventually(func() bool {
+			return subscription(i) == nil
+		}, time.Second, 10*time.Millisecond)
+
+		// the rejected token is not subscribed again
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "removed"})
+		assert.Nil(subscription(i))
+
+		i.syncRPC(map[lorawan.EUI64]string{devEUI: "verysecret"})
+		assert.Equal("verysecret", subscription(i).token)
+	})
+
+	t.Run("Max subscriptions", func(t *testing.T) {
+		assert := require.New(t)
+		maxRPCSubscriptions = 1
+		defer func() { maxRPCSubscriptions = 1000 }()
+
+		i, err := New(Config{Server: server.URL, RPCEnabled: true})
+		assert.NoError(err)
+		defer i.Close()
+
+		otherDevEUI := lorawan.EUI64{2, 2, 3, 4, 5, 6, 7, 8}
+		i.syncRPC(map[lorawan.EUI64]string{
+			devEUI:      "verysecret",
+			otherDevEUI: "othersecret",
+		})
+
+		i.mu.Lock()
+		assert.Len(i.rpc, 1)
+		assert.Contains(i.rpc, devEUI)
+		i.mu.Unlock()
+	})
+}
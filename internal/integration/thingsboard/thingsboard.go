@@ -10,6 +10,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -20,26 +22,94 @@ import (
 	"github.com/brocaar/lorawan"
 )
 
+// accessTokenVariable is the device variable containing the ThingsBoard
+// access token.
+const accessTokenVariable = "ThingsBoardAccessToken"
+
+// requestTimeout defines the timeout of the ThingsBoard API requests.
+var requestTimeout = 10 * time.Second
+
 // Config holds the Thingsboard integration configuration.
+//
+// When the provision device key and secret are set, devices without access
+// token are provisioned using the ThingsBoard device provisioning API. When
+// RPC is enabled, the server-side RPC requests of ThingsBoard are enqueued
+// as downlink (using the device-profile codec to encode the request). The
+// RPC requests are received by the integration returned by NewRPCConsumer,
+// which holds a connection per device (up to 1000 devices per application).
+// Newly provisioned devices are subscribed within a minute.
 type Config struct {
-	Server string `json:"server"`
+	Server                string `json:"server"`
+	ProvisionDeviceKey    string `json:"provisionDeviceKey,omitempty"`
+	ProvisionDeviceSecret string `json:"provisionDeviceSecret,omitempty"`
+	RPCEnabled            bool   `json:"rpcEnabled,omitempty"`
+	RPCFPort              uint8  `json:"rpcFPort,omitempty"`
 }
 
 // Validate validates the Config.
 func (c Config) Validate() error {
+	if (c.ProvisionDeviceKey == "") != (c.ProvisionDeviceSecret == "") {
+		return ErrInvalidProvisionConfig
+	}
 	return nil
 }
 
 // Integration implements the Thingsboard integration.
 type Integration struct {
-	server string
+	config Config
+
+	// client is used for the ThingsBoard API requests, rpcClient for the
+	// (long-polling) RPC requests.
+	client    *http.Client
+	rpcClient *http.Client
+
+	// saveAccessToken stores the access token of a provisioned device.
+	saveAccessToken func(ctx context.Context, applicationID int64, devEUI lorawan.EUI64, token string) error
+
+	// applicationID and getAccessTokens are set for the RPC consumer.
+	applicationID   int64
+	getAccessTokens func(ctx context.Context, applicationID int64) (map[lorawan.EUI64]string, error)
+
+	mu     sync.Mutex
+	tokens map[lorawan.EUI64]string
+	rpc    map[lorawan.EUI64]*rpcSubscription
+
+	// provisionLocks contains the lock per device, so that a device is not
+	// provisioned twice by concurrent events.
+	provisionLocks map[lorawan.EUI64]*sync.Mutex
+
+	// unsaved contains the provisioned devices of which the access token
+	// could not be stored.
+	unsaved map[lorawan.EUI64]struct{}
+
+	// invalid contains the access tokens rejected by ThingsBoard, by DevEUI.
+	invalid map[lorawan.EUI64]string
+
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // New creates a new Thingsboard integration.
 func New(conf Config) (*Integration, error) {
-	return &Integration{
-		server: conf.Server,
-	}, nil
+	if conf.RPCEnabled && conf.RPCFPort == 0 {
+		conf.RPCFPort = defaultRPCFPort
+	}
+
+	i := Integration{
+		config:          conf,
+		client:          &http.Client{Timeout: requestTimeout},
+		rpcClient:       &http.Client{Timeout: rpcTimeout + requestTimeout},
+		saveAccessToken: saveAccessToken,
+		tokens:          make(map[lorawan.EUI64]string),
+		rpc:             make(map[lorawan.EUI64]*rpcSubscription),
+		provisionLocks:  make(map[lorawan.EUI64]*sync.Mutex),
+		unsaved:         make(map[lorawan.EUI64]struct{}),
+		invalid:         make(map[lorawan.EUI64]string),
+	}
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+
+	return &i, nil
 }
 
 // HandleUplinkEvent sends the uplink payload to Thingsboard.
@@ -47,12 +117,11 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integratio
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
-	accessToken, ok := vars["ThingsBoardAccessToken"]
-	if !ok {
-		log.WithFields(log.Fields{
-			"dev_eui": devEUI,
-			"ctx_id":  ctx.Value(logging.ContextIDKey),
-		}).Warning("integration/thingsboard: device does not have a 'ThingsBoardAccessToken' variable")
+	accessToken, err := i.getAccessToken(ctx, pl.ApplicationId, devEUI, vars)
+	if err != nil {
+		return errors.Wrap(err, "get access token error")
+	}
+	if accessToken == "" {
 		return nil
 	}
 
@@ -96,7 +165,7 @@ func (i *Integration) HandleUplinkEvent(ctx context.Context, _ models.Integratio
 		telemetry["snr"] = snr
 	}
 
-	if err := i.send(accessToken, attributes, telemetry); err != nil {
+	if err := i.send(ctx, accessToken, attributes, telemetry); err != nil {
 		return errors.Wrap(err, "send event error")
 	}
 
@@ -129,12 +198,11 @@ func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integratio
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
-	accessToken, ok := vars["ThingsBoardAccessToken"]
-	if !ok {
-		log.WithFields(log.Fields{
-			"dev_eui": devEUI,
-			"ctx_id":  ctx.Value(logging.ContextIDKey),
-		}).Warning("integration/thingsboard: device does not have a 'ThingsBoardAccessToken' variable")
+	accessToken, err := i.getAccessToken(ctx, pl.ApplicationId, devEUI, vars)
+	if err != nil {
+		return errors.Wrap(err, "get access token error")
+	}
+	if accessToken == "" {
 		return nil
 	}
 
@@ -153,7 +221,7 @@ func (i *Integration) HandleStatusEvent(ctx context.Context, _ models.Integratio
 		"status_battery_level":             pl.BatteryLevel,
 		"status_battery_level_unavailable": pl.BatteryLevelUnavailable,
 	}
-	if err := i.send(accessToken, attributes, telemetry); err != nil {
+	if err := i.send(ctx, accessToken, attributes, telemetry); err != nil {
 		return errors.Wrap(err, "send event error")
 	}
 
@@ -171,12 +239,11 @@ func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integrat
 	var devEUI lorawan.EUI64
 	copy(devEUI[:], pl.DevEui)
 
-	accessToken, ok := vars["ThingsBoardAccessToken"]
-	if !ok {
-		log.WithFields(log.Fields{
-			"dev_eui": devEUI,
-			"ctx_id":  ctx.Value(logging.ContextIDKey),
-		}).Warning("integration/thingsboard: device does not have a 'ThingsBoardAccessToken' variable")
+	accessToken, err := i.getAccessToken(ctx, pl.ApplicationId, devEUI, vars)
+	if err != nil {
+		return errors.Wrap(err, "get access token error")
+	}
+	if accessToken == "" {
 		return nil
 	}
 
@@ -195,7 +262,7 @@ func (i *Integration) HandleLocationEvent(ctx context.Context, _ models.Integrat
 		"location_altitude":  pl.GetLocation().GetAltitude(),
 	}
 
-	if err := i.send(accessToken, attributes, telemetry); err != nil {
+	if err := i.send(ctx, accessToken, attributes, telemetry); err != nil {
 		return errors.Wrap(err, "send event error")
 	}
 
@@ -218,17 +285,60 @@ func (i *Integration) HandleIntegrationEvent(ctx context.Context, _ models.Integ
 	return nil
 }
 
-// DataDownChan returns nil.
+// DataDownChan returns nil. The downlinks are consumed through DownlinkChan.
 func (i *Integration) DataDownChan() chan models.DataDownPayload {
 	return nil
 }
 
-// Close returns nil.
+// Close stops the RPC subscriptions (if any).
 func (i *Integration) Close() error {
+	i.cancel()
+	i.wg.Wait()
 	return nil
 }
 
-func (i *Integration) send(token string, attributes, telemetry map[string]interface{}) error {
+// getAccessToken returns the access token of the device. If the device does
+// not have an access token, it is provisioned when provisioning has been
+// configured. An empty string is returned when no access token is
+// available. Test-fire events do not provision the device.
+func (i *Integration) getAccessToken(ctx context.Context, applicationID uint64, devEUI lorawan.EUI64, vars map[string]string) (string, error) {
+	token := vars[accessTokenVariable]
+
+	if token == "" {
+		i.mu.Lock()
+		token = i.tokens[devEUI]
+		_, unsaved := i.unsaved[devEUI]
+		i.mu.Unlock()
+
+		if unsaved {
+			i.storeAccessToken(ctx, int64(applicationID), devEUI, token)
+		}
+	}
+
+	if token == "" {
+		if i.config.ProvisionDeviceKey == "" {
+			log.WithFields(log.Fields{
+				"dev_eui": devEUI,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Warning("integration/thingsboard: device does not have a 'ThingsBoardAccessToken' variable")
+			return "", nil
+		}
+
+		if models.IsTestFire(ctx) {
+			return "", ErrTestFireProvision
+		}
+
+		var err error
+		token, err = i.provision(ctx, int64(applicationID), devEUI)
+		if err != nil {
+			return "", errors.Wrap(err, "provision device error")
+		}
+	}
+
+	return token, nil
+}
+
+func (i *Integration) send(ctx context.Context, token string, attributes, telemetry map[string]interface{}) error {
 	calls := []struct {
 		payload  map[string]interface{}
 		endpoint string
@@ -249,18 +359,19 @@ func (i *Integration) send(token string, attributes, telemetry map[string]interf
 			return errors.Wrap(err, "marshal json error")
 		}
 
-		url := fmt.Sprintf(call.endpoint, i.server, token)
-		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+		url := fmt.Sprintf(call.endpoint, i.config.Server, token)
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
 		if err != nil {
 			return errors.Wrap(err, "new request error")
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := i.client.Do(req)
 		if err != nil {
 			return errors.Wrap(err, "http request error")
 		}
 		defer resp.Body.Close()
+		models.RecordHTTPStatus(ctx, resp.StatusCode)
 
 		// check that response is in 200 range
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
@@ -19,15 +20,16 @@ var applicationNameRegexp = regexp.MustCompile(`^[\w-]+$`)
 
 // Application represents an application.
 type Application struct {
-	ID                   int64      `db:"id"`
-	Name                 string     `db:"name"`
-	Description          string     `db:"description"`
-	OrganizationID       int64      `db:"organization_id"`
-	ServiceProfileID     uuid.UUID  `db:"service_profile_id"`
-	PayloadCodec         codec.Type `db:"payload_codec"`
-	PayloadEncoderScript string     `db:"payload_encoder_script"`
-	PayloadDecoderScript string     `db:"payload_decoder_script"`
-	MQTTTLSCert          []byte     `db:"mqtt_tls_cert"`
+	ID                   int64           `db:"id"`
+	Name                 string          `db:"name"`
+	Description          string          `db:"description"`
+	OrganizationID       int64           `db:"organization_id"`
+	ServiceProfileID     uuid.UUID       `db:"service_profile_id"`
+	PayloadCodec         codec.Type      `db:"payload_codec"`
+	PayloadEncoderScript string          `db:"payload_encoder_script"`
+	PayloadDecoderScript string          `db:"payload_decoder_script"`
+	PayloadCodecSettings json.RawMessage `db:"payload_codec_settings"`
+	MQTTTLSCert          []byte          `db:"mqtt_tls_cert"`
 }
 
 // ApplicationListItem devices the application as a list item.
@@ -42,15 +44,39 @@ func (a Application) Validate() error {
 		return ErrApplicationInvalidName
 	}
 
+	if err := codec.Validate(a.PayloadCodec, a.CodecSettings()); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// CodecSettings returns the payload codec settings of the application.
+func (a Application) CodecSettings() codec.Settings {
+	return codec.Settings{
+		EncoderScript: a.PayloadEncoderScript,
+		DecoderScript: a.PayloadDecoderScript,
+		Config:        a.payloadCodecSettings(),
+	}
+}
+
+// payloadCodecSettings returns the codec specific settings, defaulting to
+// an empty JSON object.
+func (a Application) payloadCodecSettings() json.RawMessage {
+	if len(a.PayloadCodecSettings) == 0 {
+		return json.RawMessage("{}")
+	}
+	return a.PayloadCodecSettings
+}
+
 // CreateApplication creates the given Application.
 func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application) error {
 	if err := item.Validate(); err != nil {
 		return errors.Wrap(err, "validate error")
 	}
 
+	item.PayloadCodecSettings = item.payloadCodecSettings()
+
 	err := sqlx.Get(db, &item.ID, `
 		insert into application (
 			name,
@@ -60,8 +86,9 @@ func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application)
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script,
+			payload_codec_settings,
 			mqtt_tls_cert
-		) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`,
 		item.Name,
 		item.Description,
 		item.OrganizationID,
@@ -69,6 +96,7 @@ func CreateApplication(ctx context.Context, db sqlx.Queryer, item *Application)
 		item.PayloadCodec,
 		item.PayloadEncoderScript,
 		item.PayloadDecoderScript,
+		item.PayloadCodecSettings,
 		item.MQTTTLSCert,
 	)
 	if err != nil {
@@ -216,7 +244,8 @@ func UpdateApplication(ctx context.Context, db sqlx.Execer, item Application) er
 			payload_codec = $6,
 			payload_encoder_script = $7,
 			payload_decoder_script = $8,
-			mqtt_tls_cert = $9
+			payload_codec_settings = $9,
+			mqtt_tls_cert = $10
 		where id = $1`,
 		item.ID,
 		item.Name,
@@ -226,6 +255,7 @@ func UpdateApplication(ctx context.Context, db sqlx.Execer, item Application) er
 		item.PayloadCodec,
 		item.PayloadEncoderScript,
 		item.PayloadDecoderScript,
+		item.payloadCodecSettings(),
 		item.MQTTTLSCert,
 	)
 	if err != nil {
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -29,6 +30,7 @@ type DeviceProfile struct {
 	PayloadCodec         codec.Type       `db:"payload_codec"`
 	PayloadEncoderScript string           `db:"payload_encoder_script"`
 	PayloadDecoderScript string           `db:"payload_decoder_script"`
+	PayloadCodecSettings json.RawMessage  `db:"payload_codec_settings"`
 	Tags                 hstore.Hstore    `db:"tags"`
 	UplinkInterval       time.Duration    `db:"uplink_interval"`
 	DeviceProfile        ns.DeviceProfile `db:"-"`
@@ -50,9 +52,30 @@ func (dp DeviceProfile) Validate() error {
 	if strings.TrimSpace(dp.Name) == "" || len(dp.Name) > 100 {
 		return ErrDeviceProfileInvalidName
 	}
+	if err := codec.Validate(dp.PayloadCodec, dp.CodecSettings()); err != nil {
+		return err
+	}
 	return nil
 }
 
+// CodecSettings returns the payload codec settings of the device-profile.
+func (dp DeviceProfile) CodecSettings() codec.Settings {
+	return codec.Settings{
+		EncoderScript: dp.PayloadEncoderScript,
+		DecoderScript: dp.PayloadDecoderScript,
+		Config:        dp.payloadCodecSettings(),
+	}
+}
+
+// payloadCodecSettings returns the codec specific settings, defaulting to
+// an empty JSON object.
+func (dp DeviceProfile) payloadCodecSettings() json.RawMessage {
+	if len(dp.PayloadCodecSettings) == 0 {
+		return json.RawMessage("{}")
+	}
+	return dp.PayloadCodecSettings
+}
+
 // CreateDeviceProfile creates the given device-profile.
 // This will create the device-profile at the network-server side and will
 // create a local reference record.
@@ -70,6 +93,7 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 	dp.DeviceProfile.Id = dpID.Bytes()
 	dp.CreatedAt = now
 	dp.UpdatedAt = now
+	dp.PayloadCodecSettings = dp.payloadCodecSettings()
 
 	_, err = db.Exec(`
         insert into device_profile (
@@ -82,9 +106,10 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script,
+			payload_codec_settings,
 			tags,
 			uplink_interval
-		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
 		dpID,
 		dp.NetworkServerID,
 		dp.OrganizationID,
@@ -94,6 +119,7 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 		dp.PayloadCodec,
 		dp.PayloadEncoderScript,
 		dp.PayloadDecoderScript,
+		dp.PayloadCodecSettings,
 		dp.Tags,
 		dp.UplinkInterval,
 	)
@@ -148,6 +174,7 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script,
+			payload_codec_settings,
 			tags,
 			uplink_interval
 		from device_profile
@@ -168,6 +195,7 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 		&dp.PayloadCodec,
 		&dp.PayloadEncoderScript,
 		&dp.PayloadDecoderScript,
+		&dp.PayloadCodecSettings,
 		&dp.Tags,
 		&dp.UplinkInterval,
 	)
@@ -233,6 +261,7 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 	}
 
 	dp.UpdatedAt = time.Now()
+	dp.PayloadCodecSettings = dp.payloadCodecSettings()
 
 	res, err := db.Exec(`
         update device_profile
@@ -242,8 +271,9 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 			payload_codec = $4,
 			payload_encoder_script = $5,
 			payload_decoder_script = $6,
-			tags = $7,
-			uplink_interval = $8
+			payload_codec_settings = $7,
+			tags = $8,
+			uplink_interval = $9
 		where device_profile_id = $1`,
 		dpID,
 		dp.UpdatedAt,
@@ -251,6 +281,7 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 		dp.PayloadCodec,
 		dp.PayloadEncoderScript,
 		dp.PayloadDecoderScript,
+		dp.PayloadCodecSettings,
 		dp.Tags,
 		dp.UplinkInterval,
 	)
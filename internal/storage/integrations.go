@@ -15,13 +15,28 @@ import (
 )
 
 // Integration represents an integration.
+// An application can have multiple integrations of the same kind, as long
+// as their names are unique. The integration without name is the (default)
+// integration managed through the per-kind API methods.
 type Integration struct {
 	ID            int64           `db:"id"`
 	CreatedAt     time.Time       `db:"created_at"`
 	UpdatedAt     time.Time       `db:"updated_at"`
 	ApplicationID int64           `db:"application_id"`
 	Kind          string          `db:"kind"`
+	Name          string          `db:"name"`
+	Enabled       bool            `db:"enabled"`
 	Settings      json.RawMessage `db:"settings"`
+	Filters       json.RawMessage `db:"filters"`
+}
+
+// filters returns the filters of the integration, defaulting to an empty
+// JSON object.
+func (i Integration) filters() json.RawMessage {
+	if len(i.Filters) == 0 {
+		return json.RawMessage("{}")
+	}
+	return i.Filters
 }
 
 // CreateIntegration creates the given Integration.
@@ -33,13 +48,19 @@ func CreateIntegration(ctx context.Context, db sqlx.Queryer, i *Integration) err
 			updated_at,
 			application_id,
 			kind,
-			settings
-		) values ($1, $2, $3, $4, $5) returning id`,
+			name,
+			enabled,
+			settings,
+			filters
+		) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
 		now,
 		now,
 		i.ApplicationID,
 		i.Kind,
+		i.Name,
+		i.Enabled,
 		i.Settings,
+		i.filters(),
 	)
 	if err != nil {
 		switch err := err.(type) {
@@ -79,11 +100,25 @@ func GetIntegration(ctx context.Context, db sqlx.Queryer, id int64) (Integration
 	return i, nil
 }
 
-// GetIntegrationByApplicationID returns the Integration for the given
-// application id and kind.
+// GetIntegrationByApplicationID returns the (default) Integration without
+// name for the given application id and kind.
 func GetIntegrationByApplicationID(ctx context.Context, db sqlx.Queryer, applicationID int64, kind string) (Integration, error) {
 	var i Integration
-	err := sqlx.Get(db, &i, "select * from integration where application_id = $1 and kind = $2", applicationID, kind)
+	err := sqlx.Get(db, &i, "select * from integration where application_id = $1 and kind = $2 and name = ''", applicationID, kind)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return i, ErrDoesNotExist
+		}
+		return i, errors.Wrap(err, "select error")
+	}
+	return i, nil
+}
+
+// GetIntegrationByName returns the Integration with the given name for the
+// given application id and kind.
+func GetIntegrationByName(ctx context.Context, db sqlx.Queryer, applicationID int64, kind, name string) (Integration, error) {
+	var i Integration
+	err := sqlx.Get(db, &i, "select * from integration where application_id = $1 and kind = $2 and name = $3", applicationID, kind, name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return i, ErrDoesNotExist
@@ -94,14 +129,14 @@ func GetIntegrationByApplicationID(ctx context.Context, db sqlx.Queryer, applica
 }
 
 // GetIntegrationsForApplicationID returns the integrations for the given
-// application id.
+// application id. This includes the disabled integrations.
 func GetIntegrationsForApplicationID(ctx context.Context, db sqlx.Queryer, applicationID int64) ([]Integration, error) {
 	var is []Integration
 	err := sqlx.Select(db, &is, `
 		select *
 		from integration
 		where application_id = $1
-		order by kind`,
+		order by kind, name`,
 		applicationID,
 	)
 	if err != nil {
@@ -110,6 +145,23 @@ func GetIntegrationsForApplicationID(ctx context.Context, db sqlx.Queryer, appli
 	return is, nil
 }
 
+// GetEnabledIntegrationsForKinds returns the enabled integrations of the
+// given kinds, for all applications.
+func GetEnabledIntegrationsForKinds(ctx context.Context, db sqlx.Queryer, kinds []string) ([]Integration, error) {
+	var is []Integration
+	err := sqlx.Select(db, &is, `
+		select *
+		from integration
+		where kind = any($1) and enabled = true
+		order by id`,
+		pq.StringArray(kinds),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "select error")
+	}
+	return is, nil
+}
+
 // UpdateIntegration updates the given Integration.
 func UpdateIntegration(ctx context.Context, db sqlx.Execer, i *Integration) error {
 	now := time.Now()
@@ -119,14 +171,20 @@ func UpdateIntegration(ctx context.Context, db sqlx.Execer, i *Integration) erro
 			updated_at = $2,
 			application_id = $3,
 			kind = $4,
-			settings = $5
+			name = $5,
+			enabled = $6,
+			settings = $7,
+			filters = $8
 		where
 			id = $1`,
 		i.ID,
 		now,
 		i.ApplicationID,
 		i.Kind,
+		i.Name,
+		i.Enabled,
 		i.Settings,
+		i.filters(),
 	)
 
 	if err != nil {
@@ -67,6 +67,7 @@ func TestIntegration(t *testing.T) {
 			intgr := Integration{
 				ApplicationID: app.ID,
 				Kind:          "REST",
+				Enabled:       true,
 			}
 			intgr.Settings, err = json.Marshal(settings)
 			So(err, ShouldBeNil)
@@ -130,6 +131,56 @@ func TestIntegration(t *testing.T) {
 				So(s, ShouldResemble, settings)
 			})
 
+			Convey("Then a second integration of the same kind can be created with a different name", func() {
+				intgr2 := Integration{
+					ApplicationID: app.ID,
+					Kind:          "REST",
+					Name:          "analytics",
+					Settings:      intgr.Settings,
+				}
+				So(CreateIntegration(context.Background(), db, &intgr2), ShouldBeNil)
+
+				i, err := GetIntegration(context.Background(), db, intgr2.ID)
+				So(err, ShouldBeNil)
+				So(i.Name, ShouldEqual, "analytics")
+				So(i.Enabled, ShouldBeFalse)
+
+				ints, err := GetIntegrationsForApplicationID(context.Background(), db, app.ID)
+				So(err, ShouldBeNil)
+				So(ints, ShouldHaveLength, 2)
+
+				Convey("Then GetIntegrationByApplicationID returns the integration without name", func() {
+					i, err := GetIntegrationByApplicationID(context.Background(), db, app.ID, "REST")
+					So(err, ShouldBeNil)
+					So(i.ID, ShouldEqual, intgr.ID)
+				})
+
+				Convey("Then GetIntegrationByName returns the named integration", func() {
+					i, err := GetIntegrationByName(context.Background(), db, app.ID, "REST", "analytics")
+					So(err, ShouldBeNil)
+					So(i.ID, ShouldEqual, intgr2.ID)
+
+					_, err = GetIntegrationByName(context.Background(), db, app.ID, "REST", "foo")
+					So(err, ShouldEqual, ErrDoesNotExist)
+				})
+
+				Convey("Then GetEnabledIntegrationsForKinds only returns the enabled integration", func() {
+					ints, err := GetEnabledIntegrationsForKinds(context.Background(), db, []string{"REST"})
+					So(err, ShouldBeNil)
+					So(ints, ShouldHaveLength, 1)
+					So(ints[0].ID, ShouldEqual, intgr.ID)
+
+					ints, err = GetEnabledIntegrationsForKinds(context.Background(), db, []string{"KAFKA"})
+					So(err, ShouldBeNil)
+					So(ints, ShouldHaveLength, 0)
+				})
+
+				Convey("Then a third integration with the same name can not be created", func() {
+					intgr3 := intgr2
+					So(CreateIntegration(context.Background(), db, &intgr3), ShouldEqual, ErrAlreadyExists)
+				})
+			})
+
 			Convey("Then it can be deleted", func() {
 				So(DeleteIntegration(context.Background(), db, intgr.ID), ShouldBeNil)
 				_, err := GetIntegration(context.Background(), db, intgr.ID)
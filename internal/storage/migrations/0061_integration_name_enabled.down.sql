@@ -0,0 +1,8 @@
+drop index idx_integration_application_id_kind_name;
+
+delete from integration where name <> '';
+
+alter table integration
+	drop column enabled,
+	drop column name,
+	add constraint integration_kind_application_id unique (kind, application_id);
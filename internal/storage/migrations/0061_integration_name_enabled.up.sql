@@ -0,0 +1,9 @@
+alter table integration
+	drop constraint integration_kind_application_id,
+	add column name varchar(100) not null default '',
+	add column enabled boolean not null default true;
+
+alter table integration
+	alter column enabled drop default;
+
+create unique index idx_integration_application_id_kind_name on integration(application_id, kind, name);
@@ -0,0 +1,2 @@
+alter table integration
+	drop column filters;
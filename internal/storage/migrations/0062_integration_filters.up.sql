@@ -0,0 +1,2 @@
+alter table integration
+	add column filters jsonb not null default '{}';
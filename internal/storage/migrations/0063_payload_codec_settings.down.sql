@@ -0,0 +1,5 @@
+alter table device_profile
+	drop column payload_codec_settings;
+
+alter table application
+	drop column payload_codec_settings;
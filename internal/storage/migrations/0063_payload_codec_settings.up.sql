@@ -0,0 +1,5 @@
+alter table application
+	add column payload_codec_settings jsonb not null default '{}';
+
+alter table device_profile
+	add column payload_codec_settings jsonb not null default '{}';
@@ -27,6 +27,13 @@ func GetConfig() config.Config {
 	c.ApplicationServer.Integration.AMQP.EventRoutingKeyTemplate = "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}"
 	c.ApplicationServer.Integration.Kafka.Topic = "chirpstack_as"
 	c.ApplicationServer.Integration.Kafka.EventKeyTemplate = "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}"
+	c.ApplicationServer.Integration.RedisStreams.MaxLenLimit = 1000000
+	c.ApplicationServer.Integration.S3Archive.Endpoint = "http://localhost:9000"
+	c.ApplicationServer.Integration.S3Archive.Region = "us-east-1"
+	c.ApplicationServer.Integration.S3Archive.AccessKeyID = "minioadmin"
+	c.ApplicationServer.Integration.S3Archive.SecretAccessKey = "minioadmin"
+	c.ApplicationServer.Integration.S3Archive.Bucket = "chirpstack-test"
+	c.ApplicationServer.Integration.S3Archive.ForcePathStyle = true
 
 	if v := os.Getenv("TEST_POSTGRES_DSN"); v != "" {
 		c.PostgreSQL.DSN = v
@@ -56,5 +63,9 @@ func GetConfig() config.Config {
 		c.ApplicationServer.Integration.Kafka.Brokers = []string{v}
 	}
 
+	if v := os.Getenv("TEST_S3_ENDPOINT"); v != "" {
+		c.ApplicationServer.Integration.S3Archive.Endpoint = v
+	}
+
 	return c
 }
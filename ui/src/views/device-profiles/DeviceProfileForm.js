@@ -500,7 +500,8 @@ function Decode(fPort, bytes, variables) {
               className={this.props.classes.codeMirror}
             />
             <FormHelperText>
-              The function must have the signature <strong>function Decode(fPort, bytes)</strong> and must return an object.
+              The function must have the signature <strong>function Decode(fPort, bytes, variables)</strong> and must return an object,
+              or the signature <strong>function decodeUplink(input)</strong> and must return an object containing the decoded <strong>data</strong>.
               ChirpStack Application Server will convert this object to JSON.
             </FormHelperText>
           </FormControl>}
@@ -512,8 +513,9 @@ function Decode(fPort, bytes, variables) {
               className={this.props.classes.codeMirror}
             />
             <FormHelperText>
-              The function must have the signature <strong>function Encode(fPort, obj)</strong> and must return an array
-              of bytes.
+              The function must have the signature <strong>function Encode(fPort, obj, variables)</strong> and must return an array
+              of bytes, or the signature <strong>function encodeDownlink(input)</strong> and must return an object containing the
+              encoded <strong>bytes</strong>.
             </FormHelperText>
           </FormControl>}
         </div>}